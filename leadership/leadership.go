@@ -94,6 +94,9 @@ func (l *LeaderElection) createLeadershipTable() error {
 			statement = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s (id VARCHAR(50) PRIMARY KEY, registration BIGINT, heartbeat BIGINT)", l.storage.GetSchemaName(), l.tableName)
 		case string(storage.SQLITE):
 			statement = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id TEXT PRIMARY KEY, registration INTEGER, heartbeat INTEGER)", l.tableName)
+		case string(storage.MSSQL):
+			statement = fmt.Sprintf("IF OBJECT_ID(N'%s.%s', N'U') IS NULL CREATE TABLE %s.%s (id VARCHAR(50) PRIMARY KEY, registration BIGINT, heartbeat BIGINT)",
+				l.storage.GetSchemaName(), l.tableName, l.storage.GetSchemaName(), l.tableName)
 		}
 		return l.storage.Execute(statement)
 
@@ -23,6 +23,13 @@ const (
 	StorageOperationsTotal          = "magic_storage_operations_total"
 	StorageOperationDurationSeconds = "magic_storage_operation_duration_seconds"
 	StorageOperationErrorsTotal     = "magic_storage_operation_errors_total"
+	// Emitted by storage/retry.Adapter.
+	StorageOperationRetriesTotal = "magic_storage_operation_retries_total"
+	// Emitted by the DynamoDB and Cosmos DB adapters, in the units
+	// each store charges in.
+	StorageConsumedCapacityTotal = "magic_storage_consumed_capacity_total"
+	// Emitted by storage/shadow.Adapter.
+	StorageShadowDivergencesTotal = "magic_storage_shadow_divergences_total"
 
 	// PubSub (emitted by instrumented publishers in Phase 3).
 	PubSubMessagesTotal          = "magic_pubsub_messages_total"
@@ -65,6 +72,16 @@ const (
 	LabelStorageProvider  = "provider"
 	LabelStorageOperation = "operation"
 	LabelStorageStatus    = "status"
+	// LabelStorageRetryClass is the class of error a retry followed:
+	// throttled, conflict or transient.
+	LabelStorageRetryClass = "class"
+	// LabelStorageTable is the table or container capacity was
+	// consumed on.
+	LabelStorageTable = "table"
+	// LabelStorageDivergenceKind is how the two stores of a shadow
+	// adapter differed: missing, extra, mismatch, read_failed or
+	// write_failed.
+	LabelStorageDivergenceKind = "kind"
 )
 
 // Values used for the "status" label on storage metrics. Kept
@@ -81,16 +98,18 @@ const (
 // once at startup outside any request context and are
 // deliberately not instrumented.
 const (
-	StorageOpCreate  = "create"
-	StorageOpGet     = "get"
-	StorageOpUpdate  = "update"
-	StorageOpDelete  = "delete"
-	StorageOpList    = "list"
-	StorageOpSearch  = "search"
-	StorageOpCount   = "count"
-	StorageOpQuery   = "query"
-	StorageOpExecute = "execute"
-	StorageOpPing    = "ping"
+	StorageOpCreate    = "create"
+	StorageOpGet       = "get"
+	StorageOpUpdate    = "update"
+	StorageOpDelete    = "delete"
+	StorageOpList      = "list"
+	StorageOpSearch    = "search"
+	StorageOpCount     = "count"
+	StorageOpQuery     = "query"
+	StorageOpExecute   = "execute"
+	StorageOpPing      = "ping"
+	StorageOpAggregate = "aggregate"
+	StorageOpFacets    = "search_facets"
 )
 
 // Labels used by the built-in pubsub metrics. Kept small and
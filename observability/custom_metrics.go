@@ -93,6 +93,9 @@ var builtinMetricNames = map[string]struct{}{
 	StorageOperationsTotal:          {},
 	StorageOperationDurationSeconds: {},
 	StorageOperationErrorsTotal:     {},
+	StorageOperationRetriesTotal:    {},
+	StorageConsumedCapacityTotal:    {},
+	StorageShadowDivergencesTotal:   {},
 	PubSubMessagesTotal:             {},
 	PubSubPublishDurationSeconds:    {},
 	PubSubErrorsTotal:               {},
@@ -75,6 +75,9 @@ type Observer struct {
 	storageOpsTotal   telemetry.Counter
 	storageOpDuration telemetry.Histogram
 	storageOpErrors   telemetry.Counter
+	storageOpRetries  telemetry.Counter
+	storageCapacity   telemetry.Counter
+	storageDivergence telemetry.Counter
 
 	// Built-in pubsub instruments, wired by registerPubSubMetrics
 	// and consumed by the pubsub instrumented publisher wrapper
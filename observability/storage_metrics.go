@@ -56,5 +56,38 @@ func (o *Observer) registerStorageMetrics() error {
 	}
 	o.storageOpErrors = e
 
+	r, err := o.telem.Metrics.Counter(telemetry.MetricDefinition{
+		Name:   StorageOperationRetriesTotal,
+		Help:   "Total storage operation retries, labeled by provider, operation, and the class of the error retried.",
+		Kind:   telemetry.KindCounter,
+		Labels: []string{LabelStorageProvider, LabelStorageOperation, LabelStorageRetryClass},
+	})
+	if err != nil {
+		return fmt.Errorf("observability: register %s: %w", StorageOperationRetriesTotal, err)
+	}
+	o.storageOpRetries = r
+
+	cu, err := o.telem.Metrics.Counter(telemetry.MetricDefinition{
+		Name:   StorageConsumedCapacityTotal,
+		Help:   "Total capacity units consumed by storage operations (DynamoDB capacity units, Cosmos DB request units), labeled by provider, operation, and table.",
+		Kind:   telemetry.KindCounter,
+		Labels: []string{LabelStorageProvider, LabelStorageOperation, LabelStorageTable},
+	})
+	if err != nil {
+		return fmt.Errorf("observability: register %s: %w", StorageConsumedCapacityTotal, err)
+	}
+	o.storageCapacity = cu
+
+	dv, err := o.telem.Metrics.Counter(telemetry.MetricDefinition{
+		Name:   StorageShadowDivergencesTotal,
+		Help:   "Total divergences between the stores of a shadow storage adapter, labeled by operation and kind.",
+		Kind:   telemetry.KindCounter,
+		Labels: []string{LabelStorageOperation, LabelStorageDivergenceKind},
+	})
+	if err != nil {
+		return fmt.Errorf("observability: register %s: %w", StorageShadowDivergencesTotal, err)
+	}
+	o.storageDivergence = dv
+
 	return nil
 }
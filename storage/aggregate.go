@@ -0,0 +1,341 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	serviceErrors "github.com/tink3rlabs/magic/errors"
+	"github.com/tink3rlabs/magic/storage/search/lucene"
+)
+
+// AggregateFunc names an aggregate computed by Aggregator.Aggregate.
+type AggregateFunc string
+
+const (
+	AggCount AggregateFunc = "count"
+	AggSum   AggregateFunc = "sum"
+	AggMin   AggregateFunc = "min"
+	AggMax   AggregateFunc = "max"
+	AggAvg   AggregateFunc = "avg"
+)
+
+// AggregateScanLimitKey caps the number of items an adapter without native
+// aggregation (DynamoDB) reads while folding aggregates or counting facets
+// client-side. Pass it via
+// params; when the scan would exceed the cap Aggregate returns
+// ErrAggregateScanLimit instead of a partial result.
+const AggregateScanLimitKey = "aggregate_scan_limit"
+
+// DefaultAggregateScanLimit is used when AggregateScanLimitKey is not set.
+const DefaultAggregateScanLimit = 10000
+
+// ErrAggregateScanLimit is returned when a client-side aggregation or facet
+// count would have to read more items than AggregateScanLimitKey allows.
+var ErrAggregateScanLimit = fmt.Errorf("aggregation exceeded the scan limit; narrow the filter or raise %s", AggregateScanLimitKey)
+
+// AggSpec describes one aggregate column.
+//
+// Field is the JSON/column name the function applies to. It may be empty only
+// for AggCount, which then counts rows. Alias is the key the value is reported
+// under in AggregateRow.Values; it defaults to "<func>_<field>", or "count"
+// for a row count.
+type AggSpec struct {
+	Func  AggregateFunc
+	Field string
+	Alias string
+}
+
+// alias returns the result key for this spec.
+func (a AggSpec) alias() string {
+	if a.Alias != "" {
+		return a.Alias
+	}
+	if a.Field == "" {
+		return string(a.Func)
+	}
+	return fmt.Sprintf("%s_%s", a.Func, a.Field)
+}
+
+// AggregateRow is one group of an aggregation result. Group holds the value of
+// every groupBy column for this group (empty when no groupBy was requested);
+// Values holds each AggSpec's result keyed by its alias.
+type AggregateRow struct {
+	Group  map[string]any `json:"group"`
+	Values map[string]any `json:"values"`
+}
+
+// Aggregator is an extension interface for adapters that can compute grouped
+// aggregates. It is kept separate from StorageAdapter so third-party adapters
+// keep compiling; callers type-assert for it:
+//
+//	agg, ok := adapter.(storage.Aggregator)
+//
+// filter is either a map[string]any of exact equalities (as in List), a Lucene
+// query string (as in Search), or nil for every row. model identifies the
+// table or container the same way Count's dest does, so either &Order{} or
+// &[]Order{} works.
+//
+// The wrapper returned by StorageAdapterFactory always implements Aggregator;
+// it returns ErrNotSupported when the underlying adapter does not.
+type Aggregator interface {
+	Aggregate(model any, filter any, groupBy []string, metrics []AggSpec, params ...map[string]any) ([]AggregateRow, error)
+	AggregateContext(ctx context.Context, model any, filter any, groupBy []string, metrics []AggSpec, params ...map[string]any) ([]AggregateRow, error)
+}
+
+// validateAggregate checks groupBy and metrics up front so every adapter
+// rejects the same malformed requests, and identifiers are safe to interpolate.
+func validateAggregate(groupBy []string, metrics []AggSpec) error {
+	if len(metrics) == 0 {
+		return fmt.Errorf("at least one aggregate is required")
+	}
+	for _, g := range groupBy {
+		if !validColumnName.MatchString(g) {
+			return fmt.Errorf("invalid group by field %q: must match [a-zA-Z_][a-zA-Z0-9_]*", g)
+		}
+	}
+	seen := map[string]bool{}
+	for _, g := range groupBy {
+		seen[g] = true
+	}
+	for _, m := range metrics {
+		switch m.Func {
+		case AggCount:
+		case AggSum, AggMin, AggMax, AggAvg:
+			if m.Field == "" {
+				return fmt.Errorf("aggregate %s requires a field", m.Func)
+			}
+		default:
+			return fmt.Errorf("unsupported aggregate function %q", m.Func)
+		}
+		if m.Field != "" && !validColumnName.MatchString(m.Field) {
+			return fmt.Errorf("invalid aggregate field %q: must match [a-zA-Z_][a-zA-Z0-9_]*", m.Field)
+		}
+		alias := m.alias()
+		if !validColumnName.MatchString(alias) {
+			return fmt.Errorf("invalid aggregate alias %q: must match [a-zA-Z_][a-zA-Z0-9_]*", alias)
+		}
+		if seen[alias] {
+			return fmt.Errorf("duplicate aggregate alias %q", alias)
+		}
+		seen[alias] = true
+	}
+	return nil
+}
+
+// aggregateScanLimit reads AggregateScanLimitKey from paramMap, falling back
+// to DefaultAggregateScanLimit for a missing or non-positive value.
+func aggregateScanLimit(paramMap map[string]any) int {
+	if v, ok := paramMap[AggregateScanLimitKey]; ok {
+		if n, ok := v.(int); ok && n > 0 {
+			return n
+		}
+	}
+	return DefaultAggregateScanLimit
+}
+
+// aggregateFilter splits the filter argument into its two accepted shapes.
+func aggregateFilter(filter any) (map[string]any, string, error) {
+	switch f := filter.(type) {
+	case nil:
+		return nil, "", nil
+	case map[string]any:
+		return f, "", nil
+	case string:
+		return nil, f, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported aggregate filter type %T: use map[string]any or a Lucene query string", filter)
+	}
+}
+
+// aggregateMatcher compiles a Lucene aggregate filter into an in-process
+// matcher over model's fields, for adapters that read the items they fold
+// but cannot render the query. It returns nil for an empty query.
+func aggregateMatcher(model any, query string) (*lucene.Matcher, error) {
+	if query == "" {
+		return nil, nil
+	}
+	parser, err := lucene.NewParser(reflect.New(modelType(model)).Elem().Interface())
+	if err != nil {
+		return nil, err
+	}
+	matcher, err := parser.ParseToMatcher(query)
+	if err != nil {
+		if _, ok := err.(*lucene.InvalidFieldError); ok {
+			return nil, &serviceErrors.BadRequest{Message: err.Error()}
+		}
+		return nil, err
+	}
+	return matcher, nil
+}
+
+// modelType returns the struct type behind a model argument, stripping any
+// pointer and slice layers (&T, &[]T, []*T all resolve to T).
+func modelType(v any) reflect.Type {
+	t := reflect.TypeOf(v)
+	for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	return t
+}
+
+// splitAggregateRows turns flat result rows (as returned by a SQL or Cosmos
+// query) into AggregateRows, using groupBy to decide which columns are group
+// keys.
+func splitAggregateRows(rows []map[string]any, groupBy []string) []AggregateRow {
+	out := make([]AggregateRow, 0, len(rows))
+	for _, r := range rows {
+		row := AggregateRow{Group: map[string]any{}, Values: map[string]any{}}
+		for k, v := range r {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			if slices.Contains(groupBy, k) {
+				row.Group[k] = v
+			} else {
+				row.Values[k] = v
+			}
+		}
+		out = append(out, row)
+	}
+	return out
+}
+
+// aggregateState accumulates one AggSpec for one group during a client-side
+// fold.
+type aggregateState struct {
+	count int64
+	sum   float64
+	nums  int64
+	min   any
+	max   any
+}
+
+// foldAggregate computes the aggregation client-side for adapters whose query
+// language has no GROUP BY. Items are generic decoded documents. Groups are
+// returned sorted by their rendered key so results are deterministic.
+//
+// sum and avg consider numeric values only, and are nil for a group with
+// none, as SQL's are; min and max compare numbers with numbers and strings
+// with strings, so an ISO-8601 timestamp stored as a string still yields the
+// earliest and latest values. Missing fields are skipped, matching SQL's
+// treatment of NULL. Group values of different types, such as 1 and "1", form
+// different groups.
+func foldAggregate(items []map[string]any, groupBy []string, metrics []AggSpec) []AggregateRow {
+	type group struct {
+		key    map[string]any
+		states []*aggregateState
+	}
+	groups := map[string]*group{}
+
+	for _, item := range items {
+		keyParts := make([]string, len(groupBy))
+		keyVals := make(map[string]any, len(groupBy))
+		for i, g := range groupBy {
+			keyVals[g] = item[g]
+			keyParts[i] = fmt.Sprintf("%T:%#v", item[g], item[g])
+		}
+		k := strings.Join(keyParts, "\x00")
+		grp, ok := groups[k]
+		if !ok {
+			grp = &group{key: keyVals, states: make([]*aggregateState, len(metrics))}
+			for i := range metrics {
+				grp.states[i] = &aggregateState{}
+			}
+			groups[k] = grp
+		}
+		for i, m := range metrics {
+			st := grp.states[i]
+			if m.Field == "" {
+				st.count++
+				continue
+			}
+			v, present := item[m.Field]
+			if !present || v == nil {
+				continue
+			}
+			st.count++
+			if f, ok := toFloat(v); ok {
+				st.sum += f
+				st.nums++
+			}
+			if st.min == nil || compareValues(v, st.min) < 0 {
+				st.min = v
+			}
+			if st.max == nil || compareValues(v, st.max) > 0 {
+				st.max = v
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([]AggregateRow, 0, len(keys))
+	for _, k := range keys {
+		grp := groups[k]
+		row := AggregateRow{Group: grp.key, Values: map[string]any{}}
+		for i, m := range metrics {
+			st := grp.states[i]
+			switch m.Func {
+			case AggCount:
+				row.Values[m.alias()] = st.count
+			case AggSum:
+				if st.nums == 0 {
+					row.Values[m.alias()] = nil
+				} else {
+					row.Values[m.alias()] = st.sum
+				}
+			case AggAvg:
+				if st.nums == 0 {
+					row.Values[m.alias()] = nil
+				} else {
+					row.Values[m.alias()] = st.sum / float64(st.nums)
+				}
+			case AggMin:
+				row.Values[m.alias()] = st.min
+			case AggMax:
+				row.Values[m.alias()] = st.max
+			}
+		}
+		out = append(out, row)
+	}
+	return out
+}
+
+// toFloat converts the numeric shapes a decoder can produce to float64.
+func toFloat(v any) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// compareValues orders two decoded values. Numbers compare numerically,
+// everything else by its string form.
+func compareValues(a, b any) int {
+	fa, okA := toFloat(a)
+	fb, okB := toFloat(b)
+	if okA && okB {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
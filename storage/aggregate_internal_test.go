@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	serviceErrors "github.com/tink3rlabs/magic/errors"
+)
+
+func TestFoldAggregateGroupsAndSkipsMissingFields(t *testing.T) {
+	items := []map[string]any{
+		{"status": "open", "amount": float64(10), "at": "2024-01-02"},
+		{"status": "open", "amount": float64(30), "at": "2024-01-01"},
+		{"status": "open"},
+		{"status": "closed", "amount": float64(5), "at": "2024-03-01"},
+	}
+	rows := foldAggregate(items, []string{"status"}, []AggSpec{
+		{Func: AggCount},
+		{Func: AggCount, Field: "amount"},
+		{Func: AggSum, Field: "amount"},
+		{Func: AggAvg, Field: "amount"},
+		{Func: AggMin, Field: "at"},
+		{Func: AggMax, Field: "amount"},
+	})
+	if len(rows) != 2 {
+		t.Fatalf("len(rows) = %d; want 2", len(rows))
+	}
+	open := rows[1]
+	if open.Group["status"] != "open" {
+		t.Fatalf("rows are not sorted by group key: %+v", rows)
+	}
+	want := map[string]any{
+		"count":        int64(3),
+		"count_amount": int64(2),
+		"sum_amount":   float64(40),
+		"avg_amount":   float64(20),
+		"min_at":       "2024-01-01",
+		"max_amount":   float64(30),
+	}
+	for k, v := range want {
+		if open.Values[k] != v {
+			t.Errorf("%s = %v (%T); want %v (%T)", k, open.Values[k], open.Values[k], v, v)
+		}
+	}
+}
+
+func TestFoldAggregateAvgOfNoNumbersIsNil(t *testing.T) {
+	rows := foldAggregate([]map[string]any{{"name": "x"}}, nil, []AggSpec{{Func: AggAvg, Field: "amount"}})
+	if len(rows) != 1 || rows[0].Values["avg_amount"] != nil {
+		t.Fatalf("rows = %+v; want a single nil avg", rows)
+	}
+}
+
+func TestFoldAggregateSumOfNoNumbersIsNil(t *testing.T) {
+	rows := foldAggregate([]map[string]any{{"amount": "n/a"}}, nil, []AggSpec{{Func: AggSum, Field: "amount"}})
+	if len(rows) != 1 || rows[0].Values["sum_amount"] != nil {
+		t.Fatalf("rows = %+v; want a single nil sum", rows)
+	}
+}
+
+func TestFoldAggregateKeepsValuesOfDifferentTypesApart(t *testing.T) {
+	items := []map[string]any{
+		{"code": nil},
+		{"code": "<nil>"},
+		{"code": float64(1)},
+		{"code": "1"},
+	}
+	rows := foldAggregate(items, []string{"code"}, []AggSpec{{Func: AggCount}})
+	if len(rows) != 4 {
+		t.Fatalf("len(rows) = %d; want 4: %+v", len(rows), rows)
+	}
+}
+
+type aggregatedTicket struct {
+	Status string  `json:"status"`
+	Amount float64 `json:"amount"`
+}
+
+func TestAggregateMatcherFiltersItemsBeforeFolding(t *testing.T) {
+	matcher, err := aggregateMatcher(&aggregatedTicket{}, "status:open")
+	if err != nil {
+		t.Fatalf("aggregateMatcher: %v", err)
+	}
+	items := []map[string]any{
+		{"status": "open", "amount": float64(10)},
+		{"status": "closed", "amount": float64(5)},
+		{"status": "open", "amount": float64(30)},
+	}
+	items = slices.DeleteFunc(items, func(item map[string]any) bool { return !matcher.Match(item) })
+	rows := foldAggregate(items, nil, []AggSpec{{Func: AggSum, Field: "amount"}})
+	if len(rows) != 1 || rows[0].Values["sum_amount"] != float64(40) {
+		t.Fatalf("rows = %+v; want sum_amount 40", rows)
+	}
+
+	if matcher, err := aggregateMatcher(&aggregatedTicket{}, ""); matcher != nil || err != nil {
+		t.Fatalf("empty query = %v, %v; want nil, nil", matcher, err)
+	}
+	var badRequest *serviceErrors.BadRequest
+	if _, err := aggregateMatcher(&aggregatedTicket{}, "owner:bob"); !errors.As(err, &badRequest) {
+		t.Fatalf("unknown field err = %v; want BadRequest", err)
+	}
+}
+
+func TestAggregateScanLimitDefaultsAndOverrides(t *testing.T) {
+	if got := aggregateScanLimit(map[string]any{}); got != DefaultAggregateScanLimit {
+		t.Fatalf("default = %d; want %d", got, DefaultAggregateScanLimit)
+	}
+	if got := aggregateScanLimit(map[string]any{AggregateScanLimitKey: 5}); got != 5 {
+		t.Fatalf("override = %d; want 5", got)
+	}
+	if got := aggregateScanLimit(map[string]any{AggregateScanLimitKey: -1}); got != DefaultAggregateScanLimit {
+		t.Fatalf("negative = %d; want default", got)
+	}
+}
+
+func TestCosmosDBBuildAggregateQuery(t *testing.T) {
+	s := &CosmosDBAdapter{}
+	query, params, err := s.buildAggregateQuery(
+		map[string]any{"status": "open"},
+		[]string{"region"},
+		[]AggSpec{{Func: AggCount}, {Func: AggSum, Field: "amount"}},
+		map[string]any{"pk_field": "tenant", "pk_value": "t1"},
+	)
+	if err != nil {
+		t.Fatalf("buildAggregateQuery: %v", err)
+	}
+	want := "SELECT c.region AS region, COUNT(1) AS count, SUM(c.amount) AS sum_amount FROM c WHERE c.status = @param1 AND c.tenant = @param2 GROUP BY c.region"
+	if query != want {
+		t.Fatalf("query =\n  %s\nwant\n  %s", query, want)
+	}
+	if len(params) != 2 || params[0].Value != "open" || params[1].Value != "t1" {
+		t.Fatalf("params = %+v", params)
+	}
+}
+
+func TestCosmosDBBuildAggregateScan(t *testing.T) {
+	s := &CosmosDBAdapter{}
+	query, params := s.buildAggregateScan(map[string]any{"status": "open"})
+	if query != "SELECT * FROM c WHERE c.status = @param1" {
+		t.Fatalf("query = %s", query)
+	}
+	if len(params) != 1 || params[0].Value != "open" {
+		t.Fatalf("params = %+v", params)
+	}
+	if query, _ := s.buildAggregateScan(nil); query != "SELECT * FROM c" {
+		t.Fatalf("unfiltered query = %s", query)
+	}
+}
+
+func TestAggregateValidationErrorsAreBadRequests(t *testing.T) {
+	invalid := []AggSpec{{Func: "median", Field: "amount"}}
+	for name, agg := range map[string]Aggregator{"dynamodb": &DynamoDBAdapter{}, "cosmosdb": &CosmosDBAdapter{}} {
+		var badRequest *serviceErrors.BadRequest
+		if _, err := agg.AggregateContext(context.Background(), &struct{}{}, nil, nil, invalid); !errors.As(err, &badRequest) {
+			t.Errorf("%s: err = %v; want a BadRequest", name, err)
+		}
+		if _, err := agg.AggregateContext(context.Background(), &struct{}{}, 42, nil, []AggSpec{{Func: AggCount}}); !errors.As(err, &badRequest) {
+			t.Errorf("%s: filter err = %v; want a BadRequest", name, err)
+		}
+	}
+}
+
+func TestInstrumentedAggregateOnLegacyAdapterIsNotSupported(t *testing.T) {
+	wrapped := wrapForTelemetry(&legacyAdapter{provider: "legacy-db"})
+	agg, ok := wrapped.(Aggregator)
+	if !ok {
+		t.Fatalf("wrapper should always implement Aggregator")
+	}
+	_, err := agg.AggregateContext(context.Background(), &struct{}{}, nil, nil, []AggSpec{{Func: AggCount}})
+	if !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("err = %v; want ErrNotSupported", err)
+	}
+}
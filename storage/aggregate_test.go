@@ -0,0 +1,154 @@
+package storage_test
+
+import (
+	"errors"
+	"testing"
+
+	magicerrors "github.com/tink3rlabs/magic/errors"
+	"github.com/tink3rlabs/magic/storage"
+)
+
+type aggregateItem struct {
+	Id     string `json:"id" gorm:"primaryKey;column:id"`
+	Status string `json:"status" gorm:"column:status"`
+	Amount int    `json:"amount" gorm:"column:amount"`
+}
+
+func (aggregateItem) TableName() string { return "aggregate_items" }
+
+// setupAggregate seeds a small table on the sqlite memory adapter: two
+// "open" items and one "closed" item.
+func setupAggregate(t *testing.T) *storage.MemoryAdapter {
+	t.Helper()
+	m := storage.GetMemoryAdapterInstance()
+	if err := m.Execute(`CREATE TABLE IF NOT EXISTS aggregate_items (id TEXT PRIMARY KEY, status TEXT, amount INTEGER)`); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	if err := m.Execute(`DELETE FROM aggregate_items`); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	for _, item := range []aggregateItem{
+		{Id: "1", Status: "open", Amount: 10},
+		{Id: "2", Status: "open", Amount: 30},
+		{Id: "3", Status: "closed", Amount: 5},
+	} {
+		if err := m.Create(&item); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+	return m
+}
+
+func asInt(t *testing.T, v any) int64 {
+	t.Helper()
+	switch n := v.(type) {
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	t.Fatalf("value %v (%T) is not numeric", v, v)
+	return 0
+}
+
+func TestMemoryAdapterAggregateGroupsByField(t *testing.T) {
+	m := setupAggregate(t)
+
+	rows, err := m.Aggregate(&aggregateItem{}, nil, []string{"status"}, []storage.AggSpec{
+		{Func: storage.AggCount},
+		{Func: storage.AggSum, Field: "amount"},
+		{Func: storage.AggMin, Field: "amount"},
+		{Func: storage.AggMax, Field: "amount", Alias: "largest"},
+	})
+	if err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("len(rows) = %d; want 2", len(rows))
+	}
+
+	// Groups are ordered by the group-by columns.
+	closed, open := rows[0], rows[1]
+	if closed.Group["status"] != "closed" || open.Group["status"] != "open" {
+		t.Fatalf("groups = %v, %v; want closed, open", closed.Group, open.Group)
+	}
+	if got := asInt(t, open.Values["count"]); got != 2 {
+		t.Fatalf("open count = %d; want 2", got)
+	}
+	if got := asInt(t, open.Values["sum_amount"]); got != 40 {
+		t.Fatalf("open sum = %d; want 40", got)
+	}
+	if got := asInt(t, open.Values["min_amount"]); got != 10 {
+		t.Fatalf("open min = %d; want 10", got)
+	}
+	if got := asInt(t, open.Values["largest"]); got != 30 {
+		t.Fatalf("open max = %d; want 30", got)
+	}
+	if got := asInt(t, closed.Values["count"]); got != 1 {
+		t.Fatalf("closed count = %d; want 1", got)
+	}
+}
+
+func TestMemoryAdapterAggregateWithoutGroupByReturnsOneRow(t *testing.T) {
+	m := setupAggregate(t)
+
+	rows, err := m.Aggregate(&aggregateItem{}, map[string]any{"status": "open"}, nil, []storage.AggSpec{
+		{Func: storage.AggSum, Field: "amount"},
+	})
+	if err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("len(rows) = %d; want 1", len(rows))
+	}
+	if len(rows[0].Group) != 0 {
+		t.Fatalf("Group = %v; want empty", rows[0].Group)
+	}
+	if got := asInt(t, rows[0].Values["sum_amount"]); got != 40 {
+		t.Fatalf("sum = %d; want 40", got)
+	}
+}
+
+func TestMemoryAdapterAggregateAcceptsLuceneFilter(t *testing.T) {
+	m := setupAggregate(t)
+
+	rows, err := m.Aggregate(&aggregateItem{}, "status:closed", nil, []storage.AggSpec{
+		{Func: storage.AggCount},
+	})
+	if err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	if len(rows) != 1 || asInt(t, rows[0].Values["count"]) != 1 {
+		t.Fatalf("rows = %+v; want a single count of 1", rows)
+	}
+}
+
+func TestMemoryAdapterAggregateRejectsInvalidRequests(t *testing.T) {
+	m := setupAggregate(t)
+
+	cases := []struct {
+		name    string
+		filter  any
+		groupBy []string
+		metrics []storage.AggSpec
+	}{
+		{"no metrics", nil, nil, nil},
+		{"sum without field", nil, nil, []storage.AggSpec{{Func: storage.AggSum}}},
+		{"unknown func", nil, nil, []storage.AggSpec{{Func: "median", Field: "amount"}}},
+		{"injected group by", nil, []string{"status; DROP TABLE x"}, []storage.AggSpec{{Func: storage.AggCount}}},
+		{"duplicate alias", nil, []string{"status"}, []storage.AggSpec{{Func: storage.AggCount, Alias: "status"}}},
+		{"unknown lucene field", "bogus:1", nil, []storage.AggSpec{{Func: storage.AggCount}}},
+		{"unsupported filter type", 42, nil, []storage.AggSpec{{Func: storage.AggCount}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := m.Aggregate(&aggregateItem{}, tc.filter, tc.groupBy, tc.metrics)
+			var bad *magicerrors.BadRequest
+			if !errors.As(err, &bad) {
+				t.Fatalf("err = %v; want *errors.BadRequest", err)
+			}
+		})
+	}
+}
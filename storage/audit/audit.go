@@ -0,0 +1,408 @@
+// Package audit records who changed what through a storage adapter. The
+// Adapter decorator captures Create, Update, Delete and Patch with the state
+// before and after the change, a field-level diff, the actor and tenant from
+// the request context (see middlewares.UserRequestContext and
+// middlewares.TenantRequestContext), the trace ID and a timestamp, and hands
+// the resulting Record to a Sink.
+//
+//	sink := audit.NewStorageSink(auditStore)
+//	adapter := audit.New(storage.StorageAdapterFactory{}.GetInstance(storage.SQL, cfg), audit.Options{Sink: sink})
+//
+//	history, cursor, err := adapter.History(ctx, &Order{}, "order-1", 50, "")
+//
+// Only the Context variants know the actor: the non-Context methods audit
+// with an empty actor and tenant.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tink3rlabs/magic/middlewares"
+	"github.com/tink3rlabs/magic/storage"
+)
+
+// Action is the kind of mutation a Record describes.
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+	ActionPatch  Action = "patch"
+)
+
+// Record is one audited mutation. Before is nil for a create and After is nil
+// for a delete. Redacted fields carry the Redacted marker in Before, After
+// and Changes.
+type Record struct {
+	Id        string         `json:"id"`
+	Entity    string         `json:"entity"`
+	EntityId  string         `json:"entity_id"`
+	Action    Action         `json:"action"`
+	Actor     string         `json:"actor"`
+	Tenant    string         `json:"tenant"`
+	TraceId   string         `json:"trace_id,omitempty"`
+	Timestamp time.Time      `json:"timestamp"`
+	Before    map[string]any `json:"before,omitempty"`
+	After     map[string]any `json:"after,omitempty"`
+	Changes   []Change       `json:"changes"`
+}
+
+// Options configures an Adapter.
+type Options struct {
+	// Sink receives every record. Required.
+	Sink Sink
+	// IDField is the JSON name of the entity's identifier, read from the
+	// filter (Update, Delete, Patch) or the item (Create). Defaults to "id".
+	IDField string
+	// Redact lists JSON field names to mask in every record, in addition to
+	// fields tagged `magic:"redact"` or `magic:"encrypted"`.
+	Redact []string
+	// SkipBefore disables the read of the current state ahead of Update and
+	// Delete. Records then carry no Before and Changes lists every field of
+	// After; the saving is one read per mutation.
+	SkipBefore bool
+	// Strict makes a failure to capture the before state or to write the
+	// record an error returned to the caller. By default such failures are
+	// logged and the mutation result is returned unchanged: the mutation has
+	// already happened and reporting it as failed would invite a retry.
+	Strict bool
+	// Now overrides the clock, for tests.
+	Now func() time.Time
+}
+
+// Adapter is a storage.ContextualStorageAdapter decorator that audits
+// mutations. Reads pass through untouched.
+type Adapter struct {
+	inner    storage.StorageAdapter
+	ctxInner storage.ContextualStorageAdapter
+	opts     Options
+}
+
+var _ storage.ContextualStorageAdapter = (*Adapter)(nil)
+
+// New wraps inner. It panics when opts.Sink is nil, since an audit decorator
+// that records nothing is a configuration error best caught at startup.
+func New(inner storage.StorageAdapter, opts Options) *Adapter {
+	if opts.Sink == nil {
+		panic("audit: Options.Sink is required")
+	}
+	if opts.IDField == "" {
+		opts.IDField = "id"
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	a := &Adapter{inner: inner, opts: opts}
+	if c, ok := inner.(storage.ContextualStorageAdapter); ok {
+		a.ctxInner = c
+	}
+	return a
+}
+
+// UnwrapStorageAdapter returns the wrapped adapter.
+func (a *Adapter) UnwrapStorageAdapter() storage.StorageAdapter {
+	return a.inner
+}
+
+// History returns the audit records of one entity, oldest first. model
+// identifies the entity type the same way it does for Get. It returns
+// storage.ErrNotSupported when the sink cannot be queried (PublisherSink,
+// LogSink).
+func (a *Adapter) History(ctx context.Context, model any, entityID string, limit int, cursor string) ([]Record, string, error) {
+	reader, ok := a.opts.Sink.(HistoryReader)
+	if !ok {
+		return nil, "", storage.ErrNotSupported
+	}
+	return reader.History(ctx, entityName(model), entityID, limit, cursor)
+}
+
+func (a *Adapter) Create(item any, params ...map[string]any) error {
+	return a.CreateContext(context.Background(), item, params...)
+}
+
+func (a *Adapter) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	var err error
+	if a.ctxInner != nil {
+		err = a.ctxInner.CreateContext(ctx, item, params...)
+	} else {
+		err = a.inner.Create(item, params...)
+	}
+	if err != nil {
+		return err
+	}
+	return a.record(ctx, ActionCreate, item, nil, nil, item)
+}
+
+func (a *Adapter) Update(item any, filter map[string]any, params ...map[string]any) error {
+	return a.UpdateContext(context.Background(), item, filter, params...)
+}
+
+func (a *Adapter) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	before, err := a.before(ctx, item, filter, params...)
+	if err != nil {
+		return err
+	}
+	if a.ctxInner != nil {
+		err = a.ctxInner.UpdateContext(ctx, item, filter, params...)
+	} else {
+		err = a.inner.Update(item, filter, params...)
+	}
+	if err != nil {
+		return err
+	}
+	return a.record(ctx, ActionUpdate, item, filter, before, item)
+}
+
+func (a *Adapter) Delete(item any, filter map[string]any, params ...map[string]any) error {
+	return a.DeleteContext(context.Background(), item, filter, params...)
+}
+
+func (a *Adapter) DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	before, err := a.before(ctx, item, filter, params...)
+	if err != nil {
+		return err
+	}
+	if a.ctxInner != nil {
+		err = a.ctxInner.DeleteContext(ctx, item, filter, params...)
+	} else {
+		err = a.inner.Delete(item, filter, params...)
+	}
+	if err != nil {
+		return err
+	}
+	return a.record(ctx, ActionDelete, item, filter, before, nil)
+}
+
+// Patch applies a partial update: it reads the entity matching filter into
+// dest, overlays changes (keyed by JSON field name), and writes the result
+// back with Update. StorageAdapter has no native partial update, so this is a
+// read-merge-write and is not atomic against concurrent writers. On return
+// dest holds the patched entity.
+func (a *Adapter) Patch(ctx context.Context, dest any, filter map[string]any, changes map[string]any, params ...map[string]any) error {
+	if err := a.GetContext(ctx, dest, filter, params...); err != nil {
+		return err
+	}
+	before, err := toMap(dest)
+	if err != nil {
+		return fmt.Errorf("failed to read current state: %w", err)
+	}
+	merged := make(map[string]any, len(before)+len(changes))
+	for k, v := range before {
+		merged[k] = v
+	}
+	for k, v := range changes {
+		merged[k] = v
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return fmt.Errorf("failed to apply patch: %w", err)
+	}
+	if err := json.Unmarshal(data, dest); err != nil {
+		return fmt.Errorf("failed to apply patch: %w", err)
+	}
+
+	if a.ctxInner != nil {
+		err = a.ctxInner.UpdateContext(ctx, dest, filter, params...)
+	} else {
+		err = a.inner.Update(dest, filter, params...)
+	}
+	if err != nil {
+		return err
+	}
+	return a.record(ctx, ActionPatch, dest, filter, before, dest)
+}
+
+// before captures the current state ahead of a mutation. A missing entity is
+// not an error: the mutation itself decides how to handle it.
+func (a *Adapter) before(ctx context.Context, item any, filter map[string]any, params ...map[string]any) (map[string]any, error) {
+	if a.opts.SkipBefore {
+		return nil, nil
+	}
+	t := reflect.TypeOf(item)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, nil
+	}
+	current := reflect.New(t).Interface()
+	err := a.GetContext(ctx, current, filter, params...)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		if a.opts.Strict {
+			return nil, fmt.Errorf("audit: failed to read state before mutation: %w", err)
+		}
+		slog.WarnContext(ctx, "audit: failed to read state before mutation", "entity", t.Name(), "error", err)
+		return nil, nil
+	}
+	return toMap(current)
+}
+
+// record builds the Record and writes it to the sink.
+func (a *Adapter) record(ctx context.Context, action Action, item any, filter map[string]any, before map[string]any, afterItem any) error {
+	after, err := toMap(afterItem)
+	if err != nil {
+		return a.sinkFailure(ctx, fmt.Errorf("failed to encode state: %w", err))
+	}
+	changes := diff(before, after)
+	redact(append(redactedFields(item), a.opts.Redact...), before, after, changes)
+
+	rec := Record{
+		Id:        newRecordID(a.opts.Now()),
+		Entity:    entityName(item),
+		EntityId:  a.entityID(filter, before, after),
+		Action:    action,
+		Actor:     middlewares.GetUserIDFromContext(ctx),
+		Tenant:    middlewares.GetTenantFromContext(ctx),
+		Timestamp: a.opts.Now().UTC(),
+		Before:    before,
+		After:     after,
+		Changes:   changes,
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		rec.TraceId = sc.TraceID().String()
+	}
+	if err := a.opts.Sink.Write(ctx, rec); err != nil {
+		return a.sinkFailure(ctx, fmt.Errorf("failed to write audit record: %w", err))
+	}
+	return nil
+}
+
+func (a *Adapter) sinkFailure(ctx context.Context, err error) error {
+	if a.opts.Strict {
+		return fmt.Errorf("audit: %w", err)
+	}
+	slog.ErrorContext(ctx, "audit: record lost", "error", err)
+	return nil
+}
+
+// entityID prefers the filter, which names the entity even on delete, and
+// falls back to the item state.
+func (a *Adapter) entityID(filter, before, after map[string]any) string {
+	for _, m := range []map[string]any{filter, after, before} {
+		if v, ok := m[a.opts.IDField]; ok && v != nil {
+			return fmt.Sprintf("%v", v)
+		}
+	}
+	return ""
+}
+
+// entityName is the model's struct type name, e.g. "Order".
+func entityName(model any) string {
+	t := reflect.TypeOf(model)
+	for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	if t == nil {
+		return ""
+	}
+	return t.Name()
+}
+
+// newRecordID returns an ID that sorts chronologically as a string, so it can
+// double as the history sort key on every adapter.
+func newRecordID(now time.Time) string {
+	return fmt.Sprintf("%019d-%s", now.UnixNano(), uuid.NewString()[:8])
+}
+
+// Pass-through methods.
+
+func (a *Adapter) Execute(statement string) error {
+	return a.ExecuteContext(context.Background(), statement)
+}
+
+func (a *Adapter) ExecuteContext(ctx context.Context, statement string) error {
+	if a.ctxInner != nil {
+		return a.ctxInner.ExecuteContext(ctx, statement)
+	}
+	return a.inner.Execute(statement)
+}
+
+func (a *Adapter) Ping() error {
+	return a.PingContext(context.Background())
+}
+
+func (a *Adapter) PingContext(ctx context.Context) error {
+	if a.ctxInner != nil {
+		return a.ctxInner.PingContext(ctx)
+	}
+	return a.inner.Ping()
+}
+
+func (a *Adapter) GetType() storage.StorageAdapterType   { return a.inner.GetType() }
+func (a *Adapter) GetProvider() storage.StorageProviders { return a.inner.GetProvider() }
+func (a *Adapter) GetSchemaName() string                 { return a.inner.GetSchemaName() }
+func (a *Adapter) CreateSchema() error                   { return a.inner.CreateSchema() }
+func (a *Adapter) CreateMigrationTable() error           { return a.inner.CreateMigrationTable() }
+func (a *Adapter) GetLatestMigration() (int, error)      { return a.inner.GetLatestMigration() }
+
+func (a *Adapter) UpdateMigrationTable(id int, name string, desc string) error {
+	return a.inner.UpdateMigrationTable(id, name, desc)
+}
+
+func (a *Adapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
+	return a.GetContext(context.Background(), dest, filter, params...)
+}
+
+func (a *Adapter) GetContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error {
+	if a.ctxInner != nil {
+		return a.ctxInner.GetContext(ctx, dest, filter, params...)
+	}
+	return a.inner.Get(dest, filter, params...)
+}
+
+func (a *Adapter) List(dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.ListContext(context.Background(), dest, sortKey, filter, limit, cursor, params...)
+}
+
+func (a *Adapter) ListContext(ctx context.Context, dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	if a.ctxInner != nil {
+		return a.ctxInner.ListContext(ctx, dest, sortKey, filter, limit, cursor, params...)
+	}
+	return a.inner.List(dest, sortKey, filter, limit, cursor, params...)
+}
+
+func (a *Adapter) Search(dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.SearchContext(context.Background(), dest, sortKey, query, limit, cursor, params...)
+}
+
+func (a *Adapter) SearchContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	if a.ctxInner != nil {
+		return a.ctxInner.SearchContext(ctx, dest, sortKey, query, limit, cursor, params...)
+	}
+	return a.inner.Search(dest, sortKey, query, limit, cursor, params...)
+}
+
+func (a *Adapter) Count(dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	return a.CountContext(context.Background(), dest, filter, params...)
+}
+
+func (a *Adapter) CountContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	if a.ctxInner != nil {
+		return a.ctxInner.CountContext(ctx, dest, filter, params...)
+	}
+	return a.inner.Count(dest, filter, params...)
+}
+
+func (a *Adapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.QueryContext(context.Background(), dest, statement, limit, cursor, params...)
+}
+
+func (a *Adapter) QueryContext(ctx context.Context, dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	if a.ctxInner != nil {
+		return a.ctxInner.QueryContext(ctx, dest, statement, limit, cursor, params...)
+	}
+	return a.inner.Query(dest, statement, limit, cursor, params...)
+}
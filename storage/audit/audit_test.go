@@ -0,0 +1,333 @@
+package audit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+
+	"github.com/tink3rlabs/magic/middlewares"
+	"github.com/tink3rlabs/magic/storage"
+	"github.com/tink3rlabs/magic/storage/audit"
+)
+
+type account struct {
+	Id       string `json:"id" gorm:"primaryKey;column:id"`
+	Name     string `json:"name" gorm:"column:name"`
+	Balance  int    `json:"balance" gorm:"column:balance"`
+	Password string `json:"password" gorm:"column:password" magic:"redact"`
+}
+
+func (account) TableName() string { return "audited_accounts" }
+
+func setup(t *testing.T, opts audit.Options) (*storage.MemoryAdapter, *audit.Adapter) {
+	t.Helper()
+	m := storage.GetMemoryAdapterInstance()
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS audited_accounts (id TEXT PRIMARY KEY, name TEXT, balance INTEGER, password TEXT)`,
+		`CREATE TABLE IF NOT EXISTS audit_records (id TEXT PRIMARY KEY, entity TEXT, entity_id TEXT, action TEXT, actor TEXT, tenant TEXT, trace_id TEXT, occurred_at BIGINT, state_before TEXT, state_after TEXT, changes TEXT)`,
+		`DELETE FROM audited_accounts`,
+		`DELETE FROM audit_records`,
+	} {
+		if err := m.Execute(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	if opts.Sink == nil {
+		opts.Sink = audit.NewStorageSink(m)
+	}
+	return m, audit.New(m, opts)
+}
+
+func userContext(user, tenant string) context.Context {
+	ctx := context.WithValue(context.Background(), middlewares.DefaultContextKeys.UserId, user)
+	return context.WithValue(ctx, middlewares.DefaultContextKeys.Tenant, tenant)
+}
+
+func history(t *testing.T, a *audit.Adapter, id string) []audit.Record {
+	t.Helper()
+	records, _, err := a.History(context.Background(), &account{}, id, 100, "")
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	return records
+}
+
+func TestMutationsAreRecordedWithActorAndDiff(t *testing.T) {
+	_, a := setup(t, audit.Options{})
+	ctx := userContext("alice", "acme")
+
+	acct := &account{Id: "1", Name: "Main", Balance: 10, Password: "secret"}
+	if err := a.CreateContext(ctx, acct); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	acct.Balance = 25
+	if err := a.UpdateContext(ctx, acct, map[string]any{"id": "1"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if err := a.DeleteContext(ctx, &account{}, map[string]any{"id": "1"}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	records := history(t, a, "1")
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3", len(records))
+	}
+	wantActions := []audit.Action{audit.ActionCreate, audit.ActionUpdate, audit.ActionDelete}
+	for i, r := range records {
+		if r.Action != wantActions[i] {
+			t.Errorf("record %d action = %s, want %s", i, r.Action, wantActions[i])
+		}
+		if r.Actor != "alice" || r.Tenant != "acme" {
+			t.Errorf("record %d actor/tenant = %q/%q", i, r.Actor, r.Tenant)
+		}
+		if r.Entity != "account" || r.EntityId != "1" {
+			t.Errorf("record %d entity = %s/%s", i, r.Entity, r.EntityId)
+		}
+		if r.Timestamp.IsZero() {
+			t.Errorf("record %d has no timestamp", i)
+		}
+	}
+
+	if records[0].Before != nil {
+		t.Errorf("create should have no before state, got %v", records[0].Before)
+	}
+	update := records[1]
+	if len(update.Changes) != 1 || update.Changes[0].Field != "balance" {
+		t.Fatalf("update changes = %+v, want balance only", update.Changes)
+	}
+	if update.Changes[0].Old != float64(10) || update.Changes[0].New != float64(25) {
+		t.Errorf("balance change = %v -> %v", update.Changes[0].Old, update.Changes[0].New)
+	}
+	if records[2].After != nil || records[2].Before["balance"] != float64(25) {
+		t.Errorf("delete record = before %v after %v", records[2].Before, records[2].After)
+	}
+}
+
+func TestRedactedFieldsNeverReachTheSink(t *testing.T) {
+	_, a := setup(t, audit.Options{Redact: []string{"name"}})
+	ctx := context.Background()
+
+	acct := &account{Id: "1", Name: "Main", Password: "secret"}
+	if err := a.CreateContext(ctx, acct); err != nil {
+		t.Fatal(err)
+	}
+	acct.Password = "rotated"
+	if err := a.UpdateContext(ctx, acct, map[string]any{"id": "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	records := history(t, a, "1")
+	raw, _ := json.Marshal(records)
+	for _, plaintext := range []string{"secret", "rotated", "Main"} {
+		if strings.Contains(string(raw), plaintext) {
+			t.Errorf("audit trail contains %q: %s", plaintext, raw)
+		}
+	}
+	// The change is still visible even though its values are not.
+	if len(records[1].Changes) != 1 || records[1].Changes[0].Field != "password" {
+		t.Errorf("update changes = %+v", records[1].Changes)
+	}
+	if records[1].After["password"] != audit.Redacted {
+		t.Errorf("password after = %v", records[1].After["password"])
+	}
+
+	// The stored entity itself is untouched.
+	var got account
+	if err := a.Get(&got, map[string]any{"id": "1"}); err != nil || got.Password != "rotated" {
+		t.Errorf("Get = %+v, %v", got, err)
+	}
+}
+
+func TestPatchMergesAndRecords(t *testing.T) {
+	_, a := setup(t, audit.Options{})
+	ctx := userContext("bob", "acme")
+	if err := a.CreateContext(ctx, &account{Id: "1", Name: "Main", Balance: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	var patched account
+	if err := a.Patch(ctx, &patched, map[string]any{"id": "1"}, map[string]any{"name": "Savings"}); err != nil {
+		t.Fatalf("Patch: %v", err)
+	}
+	if patched.Name != "Savings" || patched.Balance != 10 {
+		t.Errorf("patched = %+v", patched)
+	}
+	var got account
+	if err := a.Get(&got, map[string]any{"id": "1"}); err != nil || got.Name != "Savings" {
+		t.Errorf("stored = %+v, %v", got, err)
+	}
+
+	records := history(t, a, "1")
+	last := records[len(records)-1]
+	if last.Action != audit.ActionPatch || len(last.Changes) != 1 || last.Changes[0].Field != "name" {
+		t.Errorf("patch record = %+v", last)
+	}
+
+	if err := a.Patch(ctx, &account{}, map[string]any{"id": "missing"}, map[string]any{"name": "x"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Patch on missing entity = %v, want ErrNotFound", err)
+	}
+}
+
+func TestHistoryPaginates(t *testing.T) {
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, a := setup(t, audit.Options{Now: func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}})
+	acct := &account{Id: "1"}
+	if err := a.Create(acct); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		acct.Balance = i
+		if err := a.Update(acct, map[string]any{"id": "1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Another entity's records must not leak into the history.
+	if err := a.Create(&account{Id: "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var all []audit.Record
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		records, next, err := a.History(context.Background(), &account{}, "1", 2, cursor)
+		if err != nil {
+			t.Fatalf("History: %v", err)
+		}
+		all = append(all, records...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(all) != 5 {
+		t.Fatalf("got %d records across pages, want 5", len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if !all[i].Timestamp.After(all[i-1].Timestamp) {
+			t.Errorf("records out of order at %d: %v then %v", i, all[i-1].Timestamp, all[i].Timestamp)
+		}
+	}
+}
+
+func TestFailedMutationIsNotRecorded(t *testing.T) {
+	_, a := setup(t, audit.Options{})
+	if err := a.Create(&account{Id: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Create(&account{Id: "1"}); err == nil {
+		t.Fatal("expected duplicate key error")
+	}
+	if n := len(history(t, a, "1")); n != 1 {
+		t.Errorf("got %d records, want 1", n)
+	}
+}
+
+type failingSink struct{}
+
+func (failingSink) Write(context.Context, audit.Record) error { return errors.New("sink down") }
+
+func TestSinkFailureOnlyFailsTheCallWhenStrict(t *testing.T) {
+	_, a := setup(t, audit.Options{Sink: failingSink{}})
+	if err := a.Create(&account{Id: "1"}); err != nil {
+		t.Errorf("non-strict Create = %v, want nil", err)
+	}
+
+	_, strict := setup(t, audit.Options{Sink: failingSink{}, Strict: true})
+	if err := strict.Create(&account{Id: "1"}); err == nil || !strings.Contains(err.Error(), "sink down") {
+		t.Errorf("strict Create = %v, want sink error", err)
+	}
+	if _, _, err := strict.History(context.Background(), &account{}, "1", 10, ""); !errors.Is(err, storage.ErrNotSupported) {
+		t.Errorf("History on write-only sink = %v, want ErrNotSupported", err)
+	}
+}
+
+type fakePublisher struct {
+	topic    string
+	messages []string
+}
+
+func (p *fakePublisher) Publish(topic string, message string, params map[string]any) error {
+	p.topic = topic
+	p.messages = append(p.messages, message)
+	return nil
+}
+
+func TestPublisherSink(t *testing.T) {
+	pub := &fakePublisher{}
+	_, a := setup(t, audit.Options{Sink: audit.NewPublisherSink(pub, "audit-events", nil)})
+	if err := a.CreateContext(userContext("alice", "acme"), &account{Id: "1", Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if pub.topic != "audit-events" || len(pub.messages) != 1 {
+		t.Fatalf("published %d messages to %q", len(pub.messages), pub.topic)
+	}
+	var rec audit.Record
+	if err := json.Unmarshal([]byte(pub.messages[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Action != audit.ActionCreate || rec.Actor != "alice" || rec.After["password"] != audit.Redacted {
+		t.Errorf("published record = %+v", rec)
+	}
+}
+
+func TestLogSink(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	_, a := setup(t, audit.Options{Sink: audit.NewLogSink(logger)})
+	if err := a.CreateContext(userContext("alice", "acme"), &account{Id: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("log line %q: %v", buf.String(), err)
+	}
+	if line["msg"] != "audit" || line["actor"] != "alice" || line["entity_id"] != "1" || line["action"] != "create" {
+		t.Errorf("log line = %v", line)
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	m, a := setup(t, audit.Options{})
+	if storage.UnwrapAdapter(a) != storage.StorageAdapter(m) {
+		t.Error("UnwrapAdapter should reach the memory adapter")
+	}
+}
+
+func TestStorageSinkWritesToTheAdaptersSchema(t *testing.T) {
+	// A dry run on PostgreSQL, whose tables the SQL adapter prefixes with the
+	// configured schema.
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		NamingStrategy:         schema.NamingStrategy{TablePrefix: "app."},
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inserts []string
+	record := func(tx *gorm.DB) { inserts = append(inserts, tx.Statement.SQL.String()) }
+	if err := db.Callback().Create().After("gorm:create").Register("test:record", record); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := audit.NewStorageSink(&storage.SQLAdapter{DB: db})
+	if err := sink.Write(context.Background(), audit.Record{Id: "r1", Entity: "account", EntityId: "1", Action: audit.ActionCreate}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if len(inserts) != 1 || !strings.HasPrefix(inserts[0], `INSERT INTO "app"."audit_records"`) {
+		t.Fatalf("inserts = %q; want the record in app.audit_records", inserts)
+	}
+}
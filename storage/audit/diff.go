@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Redacted replaces the value of a redacted field in audit records.
+const Redacted = "[REDACTED]"
+
+// Change is one field that differs between the before and after state.
+type Change struct {
+	Field string `json:"field"`
+	Old   any    `json:"old,omitempty"`
+	New   any    `json:"new,omitempty"`
+}
+
+// toMap renders an item the way it is stored: through its JSON encoding, so
+// field names match the filter and column names callers already use.
+func toMap(item any) (map[string]any, error) {
+	if item == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// diff lists every field whose value differs between before and after, in
+// field name order. A nil before (create) or after (delete) reports every
+// field of the other side.
+func diff(before, after map[string]any) []Change {
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	fields := make([]string, 0, len(keys))
+	for k := range keys {
+		fields = append(fields, k)
+	}
+	slices.Sort(fields)
+
+	changes := []Change{}
+	for _, f := range fields {
+		o, n := before[f], after[f]
+		if reflect.DeepEqual(o, n) {
+			continue
+		}
+		changes = append(changes, Change{Field: f, Old: o, New: n})
+	}
+	return changes
+}
+
+// redactedFields returns the JSON names of fields tagged `magic:"redact"` or
+// `magic:"encrypted"` on the item's struct type. Encrypted fields are
+// redacted automatically: an audit record holding their plaintext would
+// defeat the encryption.
+func redactedFields(item any) []string {
+	t := reflect.TypeOf(item)
+	for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		for _, opt := range strings.Split(f.Tag.Get("magic"), ",") {
+			opt = strings.TrimSpace(opt)
+			if opt == "redact" || opt == "encrypted" {
+				name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+				if name == "" {
+					name = f.Name
+				}
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// redact masks fields in a state map and the change list in place. The change
+// entry itself is kept so the record still shows that the field changed.
+func redact(fields []string, before, after map[string]any, changes []Change) {
+	for _, f := range fields {
+		if _, ok := before[f]; ok {
+			before[f] = Redacted
+		}
+		if _, ok := after[f]; ok {
+			after[f] = Redacted
+		}
+	}
+	for i := range changes {
+		if slices.Contains(fields, changes[i].Field) {
+			if changes[i].Old != nil {
+				changes[i].Old = Redacted
+			}
+			if changes[i].New != nil {
+				changes[i].New = Redacted
+			}
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/tink3rlabs/magic/pubsub"
+	"github.com/tink3rlabs/magic/storage"
+)
+
+// Sink receives audit records. Write is called after the mutation succeeded,
+// once per record.
+type Sink interface {
+	Write(ctx context.Context, record Record) error
+}
+
+// HistoryReader is implemented by sinks that can answer history queries.
+// Records are returned oldest first, paginated with the same cursor
+// conventions as storage.StorageAdapter.List.
+type HistoryReader interface {
+	History(ctx context.Context, entity string, entityID string, limit int, cursor string) ([]Record, string, error)
+}
+
+// auditRecord is the stored shape of a Record. States and changes are kept as
+// JSON text so every adapter can store them in a plain string column or
+// attribute. The type name maps to the "audit_records" table or container on
+// every adapter, in the configured schema on SQL.
+type auditRecord struct {
+	Id        string `json:"id" gorm:"primaryKey;column:id"`
+	Entity    string `json:"entity" gorm:"column:entity"`
+	EntityId  string `json:"entity_id" gorm:"column:entity_id"`
+	Action    string `json:"action" gorm:"column:action"`
+	Actor     string `json:"actor" gorm:"column:actor"`
+	Tenant    string `json:"tenant" gorm:"column:tenant"`
+	TraceId   string `json:"trace_id" gorm:"column:trace_id"`
+	Timestamp int64  `json:"occurred_at" gorm:"column:occurred_at"`
+	Before    string `json:"state_before" gorm:"column:state_before"`
+	After     string `json:"state_after" gorm:"column:state_after"`
+	Changes   string `json:"changes" gorm:"column:changes"`
+}
+
+// StorageSink writes records to the audit_records table (or DynamoDB table,
+// or Cosmos container) of a storage adapter, and answers history queries from
+// it. The table must exist; on SQL it is, in the adapter's configured schema:
+//
+//	CREATE TABLE audit_records (
+//	    id TEXT PRIMARY KEY, entity TEXT, entity_id TEXT, action TEXT,
+//	    actor TEXT, tenant TEXT, trace_id TEXT, occurred_at BIGINT,
+//	    state_before TEXT, state_after TEXT, changes TEXT
+//	);
+//
+// with an index on (entity, entity_id, id) for history queries. Record IDs
+// sort chronologically, which is what makes id the history sort key.
+//
+// Use a separate adapter instance from the audited one, or at least not the
+// audit decorator itself, so writing a record is not audited in turn.
+type StorageSink struct {
+	adapter storage.StorageAdapter
+}
+
+var _ HistoryReader = (*StorageSink)(nil)
+
+func NewStorageSink(adapter storage.StorageAdapter) *StorageSink {
+	return &StorageSink{adapter: adapter}
+}
+
+func (s *StorageSink) Write(ctx context.Context, record Record) error {
+	row, err := toRow(record)
+	if err != nil {
+		return err
+	}
+	if c, ok := s.adapter.(storage.ContextualStorageAdapter); ok {
+		return c.CreateContext(ctx, row)
+	}
+	return s.adapter.Create(row)
+}
+
+func (s *StorageSink) History(ctx context.Context, entity string, entityID string, limit int, cursor string) ([]Record, string, error) {
+	var rows []auditRecord
+	filter := map[string]any{"entity": entity, "entity_id": entityID}
+	var next string
+	var err error
+	if c, ok := s.adapter.(storage.ContextualStorageAdapter); ok {
+		next, err = c.ListContext(ctx, &rows, "id", filter, limit, cursor)
+	} else {
+		next, err = s.adapter.List(&rows, "id", filter, limit, cursor)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	records := make([]Record, 0, len(rows))
+	for _, row := range rows {
+		r, err := fromRow(row)
+		if err != nil {
+			return nil, "", err
+		}
+		records = append(records, r)
+	}
+	return records, next, nil
+}
+
+func toRow(r Record) (*auditRecord, error) {
+	row := &auditRecord{
+		Id:        r.Id,
+		Entity:    r.Entity,
+		EntityId:  r.EntityId,
+		Action:    string(r.Action),
+		Actor:     r.Actor,
+		Tenant:    r.Tenant,
+		TraceId:   r.TraceId,
+		Timestamp: r.Timestamp.UnixMilli(),
+	}
+	fields := []struct {
+		dst *string
+		src any
+	}{{&row.Before, r.Before}, {&row.After, r.After}, {&row.Changes, r.Changes}}
+	for _, f := range fields {
+		data, err := json.Marshal(f.src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode audit record: %w", err)
+		}
+		*f.dst = string(data)
+	}
+	return row, nil
+}
+
+func fromRow(row auditRecord) (Record, error) {
+	r := Record{
+		Id:        row.Id,
+		Entity:    row.Entity,
+		EntityId:  row.EntityId,
+		Action:    Action(row.Action),
+		Actor:     row.Actor,
+		Tenant:    row.Tenant,
+		TraceId:   row.TraceId,
+		Timestamp: time.UnixMilli(row.Timestamp).UTC(),
+	}
+	fields := []struct {
+		src string
+		dst any
+	}{{row.Before, &r.Before}, {row.After, &r.After}, {row.Changes, &r.Changes}}
+	for _, f := range fields {
+		if f.src == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(f.src), f.dst); err != nil {
+			return Record{}, fmt.Errorf("failed to decode audit record %s: %w", row.Id, err)
+		}
+	}
+	return r, nil
+}
+
+// PublisherSink publishes each record as JSON to a pubsub topic, for
+// consumers such as a SIEM or a long-term archive.
+type PublisherSink struct {
+	publisher pubsub.Publisher
+	topic     string
+	params    map[string]any
+}
+
+// NewPublisherSink publishes to topic; params is passed through to
+// Publisher.Publish unchanged.
+func NewPublisherSink(publisher pubsub.Publisher, topic string, params map[string]any) *PublisherSink {
+	return &PublisherSink{publisher: publisher, topic: topic, params: params}
+}
+
+func (s *PublisherSink) Write(ctx context.Context, record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	return s.publisher.Publish(s.topic, string(data), s.params)
+}
+
+// LogSink writes each record as a structured log line. It is the simplest
+// sink to start with, but log pipelines are rarely immutable; prefer a
+// StorageSink or PublisherSink where the trail must be tamper-evident.
+type LogSink struct {
+	logger *slog.Logger
+}
+
+// NewLogSink logs to logger, or to slog.Default when logger is nil.
+func NewLogSink(logger *slog.Logger) *LogSink {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &LogSink{logger: logger}
+}
+
+func (s *LogSink) Write(ctx context.Context, record Record) error {
+	s.logger.LogAttrs(ctx, slog.LevelInfo, "audit",
+		slog.String("audit_id", record.Id),
+		slog.String("action", string(record.Action)),
+		slog.String("entity", record.Entity),
+		slog.String("entity_id", record.EntityId),
+		slog.String("actor", record.Actor),
+		slog.String("tenant", record.Tenant),
+		slog.String("trace_id", record.TraceId),
+		slog.Time("timestamp", record.Timestamp),
+		slog.Any("changes", record.Changes),
+	)
+	return nil
+}
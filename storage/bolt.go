@@ -0,0 +1,1065 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/tink3rlabs/magic/logger"
+	"github.com/tink3rlabs/magic/storage/search/lucene"
+)
+
+// BoltAdapter stores items in a bbolt database: a single file, pure Go (no
+// cgo) and persistent across restarts, for CLI tools and edge agents that
+// cannot run a database server.
+//
+// bbolt is a key-value engine, so the adapter supplies the rest itself. Each
+// table is a bucket holding the items as JSON under their key field, plus one
+// ordered index per indexed field. Tables and indexes are created with a small
+// statement language through Execute, so they can live in migration files
+// under config/migrations/bbolt:
+//
+//	CREATE TABLE [IF NOT EXISTS] tasks [(key_field)]
+//	DROP TABLE [IF EXISTS] tasks
+//	CREATE INDEX [IF NOT EXISTS] ON tasks (field)
+//	DROP INDEX [IF EXISTS] ON tasks (field)
+//
+// The key field defaults to id. List and Count use an index for an equality
+// filter on an indexed field and List walks an index when sorting by an
+// indexed field; anything else, and every Search, reads the whole table and
+// evaluates the filter in process (see lucene.Matcher).
+type BoltAdapter struct {
+	DB     *bolt.DB
+	config map[string]string
+	// migrationNamespace names the migrations table, see MigrationNamespace.
+	migrationNamespace string
+}
+
+var _ ContextualStorageAdapter = (*BoltAdapter)(nil)
+
+var boltAdapterLock = &sync.Mutex{}
+var boltAdapterInstance *BoltAdapter
+
+func GetBoltAdapterInstance(config map[string]string) *BoltAdapter {
+	if boltAdapterInstance == nil {
+		boltAdapterLock.Lock()
+		defer boltAdapterLock.Unlock()
+		if boltAdapterInstance == nil {
+			adapter, err := NewBoltAdapter(config)
+			if err != nil {
+				logger.Fatal("failed to open bbolt database", slog.Any("error", err))
+			}
+			boltAdapterInstance = adapter
+		}
+	}
+	return boltAdapterInstance
+}
+
+// NewBoltAdapter opens (creating if needed) the database file at
+// config["path"]. bbolt holds an exclusive lock on the file, so a second
+// process opening it waits up to config["timeout"] (default 1s) and then
+// fails. Most applications use StorageAdapterFactory instead; this is for
+// tools that manage several files or need to Close one.
+func NewBoltAdapter(config map[string]string) (*BoltAdapter, error) {
+	path := config["path"]
+	if path == "" {
+		return nil, errors.New("a path is required for the bbolt storage adapter")
+	}
+	timeout := time.Second
+	if v := config["timeout"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bbolt timeout %q: %w", v, err)
+		}
+		timeout = d
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltAdapter{DB: db, config: config}, nil
+}
+
+// Close releases the database file.
+func (b *BoltAdapter) Close() error {
+	return b.DB.Close()
+}
+
+var (
+	boltBucketItems   = []byte("items")
+	boltBucketIndexes = []byte("indexes")
+	boltKeyMeta       = []byte("meta")
+)
+
+// boltTable is the metadata stored with each table bucket.
+type boltTable struct {
+	Key     string   `json:"key"`
+	Indexes []string `json:"indexes"`
+}
+
+func (b *BoltAdapter) Execute(statement string) error {
+	return b.ExecuteContext(context.Background(), statement)
+}
+
+var (
+	boltCreateTable = regexp.MustCompile(`(?i)^CREATE\s+TABLE\s+(IF\s+NOT\s+EXISTS\s+)?(\w+)\s*(?:\(\s*(\w+)\s*\))?$`)
+	boltDropTable   = regexp.MustCompile(`(?i)^DROP\s+TABLE\s+(IF\s+EXISTS\s+)?(\w+)$`)
+	boltCreateIndex = regexp.MustCompile(`(?i)^CREATE\s+INDEX\s+(IF\s+NOT\s+EXISTS\s+)?ON\s+(\w+)\s*\(\s*(\w+)\s*\)$`)
+	boltDropIndex   = regexp.MustCompile(`(?i)^DROP\s+INDEX\s+(IF\s+EXISTS\s+)?ON\s+(\w+)\s*\(\s*(\w+)\s*\)$`)
+)
+
+// ExecuteContext runs one or more ;-separated statements of the language
+// described on BoltAdapter, all in one transaction.
+func (b *BoltAdapter) ExecuteContext(ctx context.Context, statement string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		for _, stmt := range strings.Split(statement, ";") {
+			stmt = strings.TrimSpace(stmt)
+			if stmt == "" {
+				continue
+			}
+			if err := b.execute(tx, stmt); err != nil {
+				return fmt.Errorf("failed to execute statement %s: %w", stmt, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltAdapter) execute(tx *bolt.Tx, stmt string) error {
+	if m := boltCreateTable.FindStringSubmatch(stmt); m != nil {
+		name, key := m[2], m[3]
+		if tx.Bucket([]byte(name)) != nil {
+			if m[1] != "" {
+				return nil
+			}
+			return fmt.Errorf("table %s already exists", name)
+		}
+		if key == "" {
+			key = "id"
+		}
+		bucket, err := tx.CreateBucket([]byte(name))
+		if err != nil {
+			return err
+		}
+		if _, err := bucket.CreateBucket(boltBucketItems); err != nil {
+			return err
+		}
+		if _, err := bucket.CreateBucket(boltBucketIndexes); err != nil {
+			return err
+		}
+		return putBoltTable(bucket, &boltTable{Key: key})
+	}
+	if m := boltDropTable.FindStringSubmatch(stmt); m != nil {
+		err := tx.DeleteBucket([]byte(m[2]))
+		if errors.Is(err, bolt.ErrBucketNotFound) && m[1] != "" {
+			return nil
+		}
+		return err
+	}
+	if m := boltCreateIndex.FindStringSubmatch(stmt); m != nil {
+		bucket, table, err := boltTableBucket(tx, m[2])
+		if err != nil {
+			return err
+		}
+		field := m[3]
+		if field == table.Key || slices.Contains(table.Indexes, field) {
+			if m[1] != "" {
+				return nil
+			}
+			return fmt.Errorf("index on %s (%s) already exists", m[2], field)
+		}
+		index, err := bucket.Bucket(boltBucketIndexes).CreateBucket([]byte(field))
+		if err != nil {
+			return err
+		}
+		// Backfill from the items already in the table.
+		err = bucket.Bucket(boltBucketItems).ForEach(func(key, raw []byte) error {
+			item, err := decodeJSONItem(raw)
+			if err != nil {
+				return err
+			}
+			return index.Put(boltIndexKey(item[field], key), key)
+		})
+		if err != nil {
+			return err
+		}
+		table.Indexes = append(table.Indexes, field)
+		return putBoltTable(bucket, table)
+	}
+	if m := boltDropIndex.FindStringSubmatch(stmt); m != nil {
+		bucket, table, err := boltTableBucket(tx, m[2])
+		if err != nil {
+			return err
+		}
+		field := m[3]
+		if !slices.Contains(table.Indexes, field) {
+			if m[1] != "" {
+				return nil
+			}
+			return fmt.Errorf("index on %s (%s) does not exist", m[2], field)
+		}
+		if err := bucket.Bucket(boltBucketIndexes).DeleteBucket([]byte(field)); err != nil {
+			return err
+		}
+		table.Indexes = slices.DeleteFunc(table.Indexes, func(f string) bool { return f == field })
+		return putBoltTable(bucket, table)
+	}
+	return errors.New("unsupported statement")
+}
+
+func (b *BoltAdapter) Ping() error {
+	return b.PingContext(context.Background())
+}
+
+func (b *BoltAdapter) PingContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// View fails once the database has been closed.
+	return b.DB.View(func(tx *bolt.Tx) error { return nil })
+}
+
+func (b *BoltAdapter) GetType() StorageAdapterType {
+	return BBOLT
+}
+
+func (b *BoltAdapter) GetProvider() StorageProviders {
+	return BBOLT_PROVIDER
+}
+
+func (b *BoltAdapter) GetSchemaName() string {
+	return ""
+}
+
+// CreateSchema is a no-op: the database file is the schema.
+func (b *BoltAdapter) CreateSchema() error {
+	return nil
+}
+
+func (b *BoltAdapter) CreateMigrationTable() error {
+	return b.Execute(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id)", b.migrationTable()))
+}
+
+// migrationTable is the table migrations are recorded in.
+func (b *BoltAdapter) migrationTable() string {
+	return namespacedMigrationTable("migrations", b.migrationNamespace)
+}
+
+// MigrationNamespace returns a copy of the adapter that records migrations in
+// the namespace's migrations table.
+func (b *BoltAdapter) MigrationNamespace(namespace string) StorageAdapter {
+	return &BoltAdapter{DB: b.DB, config: b.config, migrationNamespace: namespace}
+}
+
+func (b *BoltAdapter) UpdateMigrationTable(id int, name string, desc string) error {
+	return b.RecordMigration(AppliedMigration{ID: id, Name: name, Description: desc})
+}
+
+func (b *BoltAdapter) RecordMigration(applied AppliedMigration) error {
+	if applied.AppliedAt.IsZero() {
+		applied.AppliedAt = time.Now()
+	}
+	item := map[string]any{
+		"id":          applied.ID,
+		"name":        applied.Name,
+		"description": applied.Description,
+		"timestamp":   applied.AppliedAt.UnixMilli(),
+		"checksum":    applied.Checksum,
+	}
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		return b.put(tx, b.migrationTable(), item, true)
+	})
+}
+
+// AppliedMigrations reads the migrations table, reporting none when it does
+// not exist yet.
+func (b *BoltAdapter) AppliedMigrations() ([]AppliedMigration, error) {
+	var applied []AppliedMigration
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(b.migrationTable())) == nil {
+			return nil
+		}
+		bucket, _, err := boltTableBucket(tx, b.migrationTable())
+		if err != nil {
+			return err
+		}
+		return bucket.Bucket(boltBucketItems).ForEach(func(_, raw []byte) error {
+			var row struct {
+				Id          int    `json:"id"`
+				Name        string `json:"name"`
+				Description string `json:"description"`
+				Timestamp   int64  `json:"timestamp"`
+				Checksum    string `json:"checksum"`
+			}
+			if err := json.Unmarshal(raw, &row); err != nil {
+				return err
+			}
+			applied = append(applied, AppliedMigration{
+				ID:          row.Id,
+				Name:        row.Name,
+				Description: row.Description,
+				Checksum:    row.Checksum,
+				AppliedAt:   time.UnixMilli(row.Timestamp),
+			})
+			return nil
+		})
+	})
+	return applied, err
+}
+
+func (b *BoltAdapter) DeleteMigration(id int) error {
+	return b.deleteMatching(context.Background(), b.migrationTable(), map[string]any{"id": id})
+}
+
+// GetLatestMigration returns the highest applied migration id, or 0 when none
+// has been applied. Numeric keys are stored in numeric order, so that is the
+// last item in the table.
+func (b *BoltAdapter) GetLatestMigration() (int, error) {
+	latest := 0
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		bucket, _, err := boltTableBucket(tx, b.migrationTable())
+		if err != nil {
+			return err
+		}
+		_, raw := bucket.Bucket(boltBucketItems).Cursor().Last()
+		if raw == nil {
+			return nil
+		}
+		var row struct {
+			Id int `json:"id"`
+		}
+		if err := json.Unmarshal(raw, &row); err != nil {
+			return err
+		}
+		latest = row.Id
+		return nil
+	})
+	return latest, err
+}
+
+func (b *BoltAdapter) Create(item any, params ...map[string]any) error {
+	return b.CreateContext(context.Background(), item, params...)
+}
+
+// CreateContext inserts item, failing if an item with the same key exists.
+func (b *BoltAdapter) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		return b.put(tx, b.getTableName(item), item, false)
+	})
+}
+
+// CreateIfAbsentContext is CreateContext, which never overwrites.
+func (b *BoltAdapter) CreateIfAbsentContext(ctx context.Context, item any, params ...map[string]any) error {
+	return b.CreateContext(ctx, item, params...)
+}
+
+func (b *BoltAdapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
+	return b.GetContext(context.Background(), dest, filter, params...)
+}
+
+func (b *BoltAdapter) GetContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error {
+	if len(filter) == 0 {
+		return errors.New("filtering is required when getting a resource")
+	}
+	var raw []byte
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		bucket, table, err := boltTableBucket(tx, b.getTableName(dest))
+		if err != nil {
+			return err
+		}
+		return b.matching(ctx, bucket, table, filter, func(_ []byte, v []byte, _ map[string]any) (bool, error) {
+			raw = slices.Clone(v)
+			return false, nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if raw == nil {
+		return ErrNotFound
+	}
+	return json.Unmarshal(raw, dest)
+}
+
+func (b *BoltAdapter) Update(item any, filter map[string]any, params ...map[string]any) error {
+	return b.UpdateContext(context.Background(), item, filter, params...)
+}
+
+// UpdateContext replaces the item stored under item's key. It fails with
+// ErrNotFound, and writes nothing, unless that item exists and matches filter.
+func (b *BoltAdapter) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	if len(filter) == 0 {
+		return errors.New("filtering is required when updating a resource")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		tableName := b.getTableName(item)
+		if err := b.stored(tx, tableName, item, filter); err != nil {
+			return err
+		}
+		return b.put(tx, tableName, item, true)
+	})
+}
+
+// stored returns ErrNotFound unless an item is stored under item's key and
+// matches filter.
+func (b *BoltAdapter) stored(tx *bolt.Tx, tableName string, item any, filter map[string]any) error {
+	bucket, table, err := boltTableBucket(tx, tableName)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("failed to encode item: %w", err)
+	}
+	fields, err := decodeJSONItem(raw)
+	if err != nil {
+		return err
+	}
+	existing := bucket.Bucket(boltBucketItems).Get(encodeBoltValue(fields[table.Key]))
+	if existing == nil {
+		return ErrNotFound
+	}
+	previous, err := decodeJSONItem(existing)
+	if err != nil {
+		return err
+	}
+	matches, err := boltFilterPredicate(filter)
+	if err != nil {
+		return err
+	}
+	if !matches(previous) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (b *BoltAdapter) Delete(item any, filter map[string]any, params ...map[string]any) error {
+	return b.DeleteContext(context.Background(), item, filter, params...)
+}
+
+// DeleteContext removes every item matching filter.
+func (b *BoltAdapter) DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	if len(filter) == 0 {
+		return errors.New("filtering is required when deleting a resource")
+	}
+	return b.deleteMatching(ctx, b.getTableName(item), filter)
+}
+
+func (b *BoltAdapter) deleteMatching(ctx context.Context, tableName string, filter map[string]any) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		bucket, table, err := boltTableBucket(tx, tableName)
+		if err != nil {
+			return err
+		}
+		type match struct {
+			key  []byte
+			item map[string]any
+		}
+		// Collect first: bbolt cursors must not be used across deletes.
+		var matches []match
+		err = b.matching(ctx, bucket, table, filter, func(k, _ []byte, item map[string]any) (bool, error) {
+			matches = append(matches, match{slices.Clone(k), item})
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, m := range matches {
+			if err := removeBoltItem(bucket, table, m.key, m.item); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltAdapter) List(dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	return b.ListContext(context.Background(), dest, sortKey, filter, limit, cursor, params...)
+}
+
+func (b *BoltAdapter) ListContext(ctx context.Context, dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	predicate, err := boltFilterPredicate(filter)
+	if err != nil {
+		return "", err
+	}
+	return b.executePaginatedQuery(ctx, dest, sortKey, filter, predicate, limit, cursor, params...)
+}
+
+func (b *BoltAdapter) Search(dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return b.SearchContext(context.Background(), dest, sortKey, query, limit, cursor, params...)
+}
+
+// SearchContext evaluates the Lucene query against every item in the table;
+// see lucene.Matcher for the semantics.
+func (b *BoltAdapter) SearchContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	destType := reflect.TypeOf(dest).Elem().Elem()
+	model := reflect.New(destType).Elem().Interface()
+	parser, err := lucene.NewParser(model)
+	if err != nil {
+		return "", err
+	}
+	matcher, err := parser.ParseToMatcher(query)
+	if err != nil {
+		return "", err
+	}
+	return b.executePaginatedQuery(ctx, dest, sortKey, nil, matcher.Match, limit, cursor, params...)
+}
+
+// executePaginatedQuery returns the items accepted by predicate in sortKey
+// order, limit at a time. The cursor is the encoded position of the last
+// item returned, so it is only meaningful for the same sortKey.
+//
+// Sorting by the key field walks the items in key order and sorting by an
+// indexed field walks that index, stopping once the page is full. Any other
+// sortKey reads every candidate and sorts in memory.
+func (b *BoltAdapter) executePaginatedQuery(
+	ctx context.Context,
+	dest any,
+	sortKey string,
+	filter map[string]any,
+	predicate func(map[string]any) bool,
+	limit int,
+	cursor string,
+	params ...map[string]any,
+) (string, error) {
+	if err := validateSortKey(sortKey); err != nil {
+		return "", err
+	}
+	direction, err := extractSortDirection(extractParams(params...))
+	if err != nil {
+		return "", err
+	}
+	var after []byte
+	if cursor != "" {
+		if after, err = base64.StdEncoding.DecodeString(cursor); err != nil {
+			return "", fmt.Errorf("invalid cursor: %w", err)
+		}
+	}
+
+	var rows []json.RawMessage
+	var last []byte
+	more := false
+	collect := func(position, raw []byte) bool {
+		if limit > 0 && len(rows) == limit {
+			more = true
+			return false
+		}
+		rows = append(rows, slices.Clone(raw))
+		last = slices.Clone(position)
+		return true
+	}
+
+	err = b.DB.View(func(tx *bolt.Tx) error {
+		bucket, table, err := boltTableBucket(tx, b.getTableName(dest))
+		if err != nil {
+			return err
+		}
+		items := bucket.Bucket(boltBucketItems)
+
+		var ordered *bolt.Bucket
+		switch {
+		case sortKey == table.Key:
+			ordered = items
+		case slices.Contains(table.Indexes, sortKey):
+			ordered = bucket.Bucket(boltBucketIndexes).Bucket([]byte(sortKey))
+		}
+		if ordered != nil {
+			return walkBolt(ordered.Cursor(), direction, after, func(position, v []byte) (bool, error) {
+				if err := ctx.Err(); err != nil {
+					return false, err
+				}
+				raw := v
+				if ordered != items {
+					raw = items.Get(v)
+				}
+				item, err := decodeJSONItem(raw)
+				if err != nil {
+					return false, err
+				}
+				if !predicate(item) {
+					return true, nil
+				}
+				return collect(position, raw), nil
+			})
+		}
+
+		type candidate struct {
+			position []byte
+			raw      []byte
+		}
+		var candidates []candidate
+		err = b.matching(ctx, bucket, table, filter, func(k, v []byte, item map[string]any) (bool, error) {
+			if !predicate(item) {
+				return true, nil
+			}
+			position := boltIndexKey(item[sortKey], k)
+			if after != nil {
+				c := bytes.Compare(position, after)
+				if (direction == Ascending && c <= 0) || (direction == Descending && c >= 0) {
+					return true, nil
+				}
+			}
+			candidates = append(candidates, candidate{position, slices.Clone(v)})
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			c := bytes.Compare(candidates[i].position, candidates[j].position)
+			if direction == Descending {
+				return c > 0
+			}
+			return c < 0
+		})
+		for _, c := range candidates {
+			if !collect(c.position, c.raw) {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := decodeBoltRows(rows, dest); err != nil {
+		return "", err
+	}
+	if !more {
+		return "", nil
+	}
+	return base64.StdEncoding.EncodeToString(last), nil
+}
+
+func (b *BoltAdapter) Count(dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	return b.CountContext(context.Background(), dest, filter, params...)
+}
+
+func (b *BoltAdapter) CountContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var count int64
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		bucket, table, err := boltTableBucket(tx, b.getTableName(dest))
+		if err != nil {
+			return err
+		}
+		if len(filter) == 0 {
+			count = int64(bucket.Bucket(boltBucketItems).Stats().KeyN)
+			return nil
+		}
+		return b.matching(ctx, bucket, table, filter, func(_, _ []byte, _ map[string]any) (bool, error) {
+			count++
+			return true, nil
+		})
+	})
+	return count, err
+}
+
+func (b *BoltAdapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return b.QueryContext(context.Background(), dest, statement, limit, cursor, params...)
+}
+
+// QueryContext is not supported: bbolt has no query language. Use Search.
+func (b *BoltAdapter) QueryContext(ctx context.Context, dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return "", fmt.Errorf("%w: bbolt has no query language, use Search", ErrNotSupported)
+}
+
+// put writes an item (a struct or a map) to table and maintains its indexes.
+// Without replace an existing item with the same key is an error.
+func (b *BoltAdapter) put(tx *bolt.Tx, tableName string, item any, replace bool) error {
+	bucket, table, err := boltTableBucket(tx, tableName)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("failed to encode item: %w", err)
+	}
+	fields, err := decodeJSONItem(raw)
+	if err != nil {
+		return err
+	}
+	keyValue, ok := fields[table.Key]
+	if !ok || keyValue == nil {
+		return fmt.Errorf("item has no value for key field %q", table.Key)
+	}
+	key := encodeBoltValue(keyValue)
+
+	items := bucket.Bucket(boltBucketItems)
+	if existing := items.Get(key); existing != nil {
+		if !replace {
+			return fmt.Errorf("%w: %s %v in %s", ErrAlreadyExists, table.Key, keyValue, tableName)
+		}
+		previous, err := decodeJSONItem(existing)
+		if err != nil {
+			return err
+		}
+		if err := removeBoltItem(bucket, table, key, previous); err != nil {
+			return err
+		}
+	}
+	if err := items.Put(key, raw); err != nil {
+		return err
+	}
+	indexes := bucket.Bucket(boltBucketIndexes)
+	for _, field := range table.Indexes {
+		if err := indexes.Bucket([]byte(field)).Put(boltIndexKey(fields[field], key), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matching calls fn for every item matching an equality filter, stopping when
+// fn returns false. A filter on the key field is a direct lookup and one on an
+// indexed field scans that index's entries for the value; otherwise every
+// item is read.
+func (b *BoltAdapter) matching(
+	ctx context.Context,
+	bucket *bolt.Bucket,
+	table *boltTable,
+	filter map[string]any,
+	fn func(key, raw []byte, item map[string]any) (bool, error),
+) error {
+	predicate, err := boltFilterPredicate(filter)
+	if err != nil {
+		return err
+	}
+	items := bucket.Bucket(boltBucketItems)
+	visit := func(key, raw []byte) (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		item, err := decodeJSONItem(raw)
+		if err != nil {
+			return false, err
+		}
+		if !predicate(item) {
+			return true, nil
+		}
+		return fn(key, raw, item)
+	}
+
+	if value, ok := filter[table.Key]; ok && !isFilterList(value) {
+		normalized, err := normalizeFilterValue(value)
+		if err != nil {
+			return err
+		}
+		key := encodeBoltValue(normalized)
+		if raw := items.Get(key); raw != nil {
+			_, err := visit(key, raw)
+			return err
+		}
+		return nil
+	}
+	for _, field := range table.Indexes {
+		value, ok := filter[field]
+		if !ok || isFilterList(value) {
+			continue
+		}
+		normalized, err := normalizeFilterValue(value)
+		if err != nil {
+			return err
+		}
+		prefix := encodeBoltValue(normalized)
+		c := bucket.Bucket(boltBucketIndexes).Bucket([]byte(field)).Cursor()
+		for k, key := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, key = c.Next() {
+			cont, err := visit(key, items.Get(key))
+			if err != nil || !cont {
+				return err
+			}
+		}
+		return nil
+	}
+	c := items.Cursor()
+	for key, raw := c.First(); key != nil; key, raw = c.Next() {
+		cont, err := visit(key, raw)
+		if err != nil || !cont {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BoltAdapter) getTableName(obj any) string {
+	// Get the type of obj
+	tableName := ""
+	tableName = reflect.TypeOf(obj).String()
+	tableName = tableName[strings.LastIndex(tableName, ".")+1:]
+
+	// Convert the table name to snake case
+	matchFirstCap := regexp.MustCompile("(.)([A-Z][a-z]+)")
+	matchAllCap := regexp.MustCompile("([a-z0-9])([A-Z])")
+	tableName = matchFirstCap.ReplaceAllString(tableName, "${1}_${2}")
+	tableName = matchAllCap.ReplaceAllString(tableName, "${1}_${2}")
+
+	tableName = strings.ToLower(tableName)
+	tableName += "s"
+	return tableName
+}
+
+func boltTableBucket(tx *bolt.Tx, name string) (*bolt.Bucket, *boltTable, error) {
+	bucket := tx.Bucket([]byte(name))
+	if bucket == nil {
+		return nil, nil, fmt.Errorf("table %s does not exist", name)
+	}
+	var table boltTable
+	if err := json.Unmarshal(bucket.Get(boltKeyMeta), &table); err != nil {
+		return nil, nil, fmt.Errorf("failed to read metadata of table %s: %w", name, err)
+	}
+	return bucket, &table, nil
+}
+
+func putBoltTable(bucket *bolt.Bucket, table *boltTable) error {
+	meta, err := json.Marshal(table)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(boltKeyMeta, meta)
+}
+
+func removeBoltItem(bucket *bolt.Bucket, table *boltTable, key []byte, item map[string]any) error {
+	indexes := bucket.Bucket(boltBucketIndexes)
+	for _, field := range table.Indexes {
+		if err := indexes.Bucket([]byte(field)).Delete(boltIndexKey(item[field], key)); err != nil {
+			return err
+		}
+	}
+	return bucket.Bucket(boltBucketItems).Delete(key)
+}
+
+// walkBolt iterates a bucket in direction, starting after the position
+// after (nil for the start), until fn returns false.
+func walkBolt(c *bolt.Cursor, direction SortingDirection, after []byte, fn func(k, v []byte) (bool, error)) error {
+	var k, v []byte
+	switch {
+	case direction == Descending && after == nil:
+		k, v = c.Last()
+	case direction == Descending:
+		if k, _ = c.Seek(after); k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+	case after == nil:
+		k, v = c.First()
+	default:
+		if k, v = c.Seek(after); bytes.Equal(k, after) {
+			k, v = c.Next()
+		}
+	}
+	for k != nil {
+		cont, err := fn(k, v)
+		if err != nil || !cont {
+			return err
+		}
+		if direction == Descending {
+			k, v = c.Prev()
+		} else {
+			k, v = c.Next()
+		}
+	}
+	return nil
+}
+
+// boltFilterPredicate compiles an equality filter into a test on decoded
+// items. A slice value matches any of its elements, like IN.
+func boltFilterPredicate(filter map[string]any) (func(map[string]any) bool, error) {
+	accepted := make(map[string][][]byte, len(filter))
+	for field, value := range filter {
+		for _, v := range filterValues(value) {
+			normalized, err := normalizeFilterValue(v)
+			if err != nil {
+				return nil, err
+			}
+			accepted[field] = append(accepted[field], encodeBoltValue(normalized))
+		}
+	}
+	return func(item map[string]any) bool {
+		for field, values := range accepted {
+			encoded := encodeBoltValue(item[field])
+			if !slices.ContainsFunc(values, func(v []byte) bool { return bytes.Equal(v, encoded) }) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func isFilterList(v any) bool {
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8
+}
+
+// filterValues lists the values a filter entry accepts: the elements of a
+// slice, or the value itself.
+func filterValues(v any) []any {
+	if !isFilterList(v) {
+		return []any{v}
+	}
+	rv := reflect.ValueOf(v)
+	values := make([]any, rv.Len())
+	for i := range values {
+		values[i] = rv.Index(i).Interface()
+	}
+	return values
+}
+
+// normalizeFilterValue converts a Go filter value to the form it takes in a
+// decoded item, so a time.Time or an int compares equal to what was stored.
+func normalizeFilterValue(v any) (any, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter value %v: %w", v, err)
+	}
+	var normalized any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+func decodeJSONItem(raw []byte) (map[string]any, error) {
+	var item map[string]any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&item); err != nil {
+		return nil, fmt.Errorf("failed to decode stored item: %w", err)
+	}
+	return item, nil
+}
+
+func decodeBoltRows(rows []json.RawMessage, dest any) error {
+	if rows == nil {
+		rows = []json.RawMessage{}
+	}
+	data, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, dest); err != nil {
+		return fmt.Errorf("failed to unmarshal items into dest: %w", err)
+	}
+	return nil
+}
+
+// boltIndexKey is the index entry of an item: its field value followed by
+// its key, so entries for equal values stay distinct and ordered by key.
+func boltIndexKey(value any, key []byte) []byte {
+	return append(encodeBoltValue(value), key...)
+}
+
+// Type tags of encodeBoltValue, in the order values of different types sort.
+const (
+	boltTagNull byte = iota
+	boltTagFalse
+	boltTagTrue
+	boltTagNumber
+	boltTagTime
+	boltTagString
+	boltTagOther
+)
+
+// encodeBoltValue encodes a decoded JSON value so that byte order is value
+// order: numbers numerically, RFC 3339 timestamps chronologically (whatever
+// their offset or precision) and strings lexically. Every encoding is
+// self-delimiting, which lets an encoded value serve as a prefix and be
+// followed by a key.
+//
+// Timestamps are recognised by their text rather than the model's field
+// type, because CREATE INDEX backfills without knowing the model.
+func encodeBoltValue(v any) []byte {
+	switch t := v.(type) {
+	case nil:
+		return []byte{boltTagNull}
+	case bool:
+		if t {
+			return []byte{boltTagTrue}
+		}
+		return []byte{boltTagFalse}
+	case json.Number:
+		f, err := t.Float64()
+		if err != nil {
+			return encodeBoltText(boltTagOther, t.String())
+		}
+		return encodeBoltFloat(f)
+	case float64:
+		return encodeBoltFloat(t)
+	case string:
+		if ts, ok := parseBoltTime(t); ok {
+			out := make([]byte, 9)
+			out[0] = boltTagTime
+			binary.BigEndian.PutUint64(out[1:], uint64(ts.UnixNano())^(1<<63))
+			return out
+		}
+		return encodeBoltText(boltTagString, t)
+	}
+	raw, _ := json.Marshal(v)
+	return encodeBoltText(boltTagOther, string(raw))
+}
+
+func encodeBoltFloat(f float64) []byte {
+	bits := math.Float64bits(f)
+	if f >= 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	out := make([]byte, 9)
+	out[0] = boltTagNumber
+	binary.BigEndian.PutUint64(out[1:], bits)
+	return out
+}
+
+// encodeBoltText escapes 0x00 as 0x00 0xFF and terminates with 0x00 0x01,
+// which keeps the order of the text and ends it unambiguously.
+func encodeBoltText(tag byte, s string) []byte {
+	out := make([]byte, 0, len(s)+3)
+	out = append(out, tag)
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0 {
+			out = append(out, 0, 0xFF)
+			continue
+		}
+		out = append(out, s[i])
+	}
+	return append(out, 0, 1)
+}
+
+func parseBoltTime(s string) (time.Time, bool) {
+	// Cheap shape check before parsing: YYYY-MM-DDThh:mm:ss...
+	if len(s) < 20 || s[4] != '-' || s[7] != '-' || s[10] != 'T' {
+		return time.Time{}, false
+	}
+	ts, err := time.Parse(time.RFC3339Nano, s)
+	return ts, err == nil
+}
@@ -0,0 +1,306 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tink3rlabs/magic/storage"
+)
+
+type BoltTask struct {
+	Id       string    `json:"id"`
+	Title    string    `json:"title"`
+	Status   string    `json:"status"`
+	Priority int       `json:"priority"`
+	Due      time.Time `json:"due"`
+	Tags     []string  `json:"tags"`
+}
+
+func newBoltAdapter(t *testing.T, path string) *storage.BoltAdapter {
+	t.Helper()
+	adapter, err := storage.NewBoltAdapter(map[string]string{"path": path})
+	if err != nil {
+		t.Fatalf("NewBoltAdapter: %v", err)
+	}
+	t.Cleanup(func() { _ = adapter.Close() })
+	return adapter
+}
+
+// seedBoltTasks creates the bolt_tasks table, indexed on status, with n tasks
+// whose priority cycles through 1-3 and whose due dates are a day apart.
+func seedBoltTasks(t *testing.T, adapter *storage.BoltAdapter, n int) {
+	t.Helper()
+	if err := adapter.Execute("CREATE TABLE bolt_tasks; CREATE INDEX ON bolt_tasks (status)"); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= n; i++ {
+		status := "open"
+		if i%2 == 0 {
+			status = "done"
+		}
+		task := BoltTask{
+			Id:       fmt.Sprintf("t%02d", i),
+			Title:    fmt.Sprintf("Task %d", i),
+			Status:   status,
+			Priority: i%3 + 1,
+			Due:      start.AddDate(0, 0, n-i),
+			Tags:     []string{"batch"},
+		}
+		if err := adapter.Create(&task); err != nil {
+			t.Fatalf("Create(%s): %v", task.Id, err)
+		}
+	}
+}
+
+func boltIDs(tasks []BoltTask) []string {
+	var ids []string
+	for _, task := range tasks {
+		ids = append(ids, task.Id)
+	}
+	return ids
+}
+
+func TestBoltAdapterCRUD(t *testing.T) {
+	adapter := newBoltAdapter(t, filepath.Join(t.TempDir(), "crud.db"))
+	if err := adapter.Execute("CREATE TABLE bolt_tasks"); err != nil {
+		t.Fatal(err)
+	}
+
+	task := BoltTask{Id: "a", Title: "Paint", Status: "open"}
+	if err := adapter.Create(&task); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.Create(&task); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("duplicate Create error = %v, want already exists", err)
+	}
+
+	var got BoltTask
+	if err := adapter.Get(&got, map[string]any{"id": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Paint" {
+		t.Fatalf("Get = %+v", got)
+	}
+
+	task.Status = "done"
+	if err := adapter.Update(&task, map[string]any{"id": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	got = BoltTask{}
+	if err := adapter.Get(&got, map[string]any{"status": "done"}); err != nil || got.Id != "a" {
+		t.Fatalf("Get by status after Update = %+v, %v", got, err)
+	}
+
+	if err := adapter.Delete(&BoltTask{}, map[string]any{"id": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.Get(&got, map[string]any{"id": "a"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Get after Delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestBoltAdapterPersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "persist.db")
+	adapter, err := storage.NewBoltAdapter(map[string]string{"path": path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedBoltTasks(t, adapter, 3)
+	if err := adapter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := newBoltAdapter(t, path)
+	count, err := reopened.Count(&BoltTask{}, map[string]any{"status": "open"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("Count after reopen = %d, want 2", count)
+	}
+}
+
+func TestBoltAdapterListPaginates(t *testing.T) {
+	adapter := newBoltAdapter(t, filepath.Join(t.TempDir(), "list.db"))
+	seedBoltTasks(t, adapter, 7)
+
+	tests := []struct {
+		name    string
+		sortKey string
+		filter  map[string]any
+		params  map[string]any
+		want    []string
+	}{
+		{"key order", "id", nil, nil, []string{"t01", "t02", "t03", "t04", "t05", "t06", "t07"}},
+		{"key order descending", "id", nil, map[string]any{storage.SortDirectionKey: "DESC"},
+			[]string{"t07", "t06", "t05", "t04", "t03", "t02", "t01"}},
+		{"indexed sort with filter", "status", map[string]any{"status": "open"}, nil, []string{"t01", "t03", "t05", "t07"}},
+		{"unindexed sort", "due", nil, nil, []string{"t07", "t06", "t05", "t04", "t03", "t02", "t01"}},
+		{"unindexed sort descending", "priority", map[string]any{"status": "done"}, map[string]any{storage.SortDirectionKey: "DESC"},
+			[]string{"t02", "t04", "t06"}},
+		{"filter on a list of values", "id", map[string]any{"id": []string{"t02", "t05"}}, nil, []string{"t02", "t05"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var all []string
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > len(tt.want) {
+					t.Fatal("pagination did not terminate")
+				}
+				var page []BoltTask
+				next, err := adapter.List(&page, tt.sortKey, tt.filter, 2, cursor, tt.params)
+				if err != nil {
+					t.Fatal(err)
+				}
+				all = append(all, boltIDs(page)...)
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+			if !slices.Equal(all, tt.want) {
+				t.Errorf("listed %v, want %v", all, tt.want)
+			}
+		})
+	}
+}
+
+func TestBoltAdapterSearch(t *testing.T) {
+	adapter := newBoltAdapter(t, filepath.Join(t.TempDir(), "search.db"))
+	seedBoltTasks(t, adapter, 6)
+
+	var page []BoltTask
+	cursor, err := adapter.Search(&page, "id", `status:open AND priority:[2 TO 3]`, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(boltIDs(page), []string{"t01"}) || cursor == "" {
+		t.Fatalf("first page = %v, cursor %q", boltIDs(page), cursor)
+	}
+	page = nil
+	if cursor, err = adapter.Search(&page, "id", `status:open AND priority:[2 TO 3]`, 1, cursor); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(boltIDs(page), []string{"t05"}) || cursor != "" {
+		t.Fatalf("second page = %v, cursor %q", boltIDs(page), cursor)
+	}
+
+	page = nil
+	if _, err := adapter.Search(&page, "due", `task*`, 10, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 6 || page[0].Id != "t06" {
+		t.Fatalf("implicit search sorted by due = %v", boltIDs(page))
+	}
+
+	if _, err := adapter.Search(&page, "id", `title:task~2`, 10, ""); err == nil {
+		t.Fatal("expected fuzzy search to be rejected")
+	}
+}
+
+func TestBoltAdapterCreateIndexBackfills(t *testing.T) {
+	adapter := newBoltAdapter(t, filepath.Join(t.TempDir(), "index.db"))
+	seedBoltTasks(t, adapter, 4)
+	if err := adapter.Execute("CREATE INDEX ON bolt_tasks (priority)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.Execute("CREATE INDEX IF NOT EXISTS ON bolt_tasks (priority)"); err != nil {
+		t.Fatalf("IF NOT EXISTS: %v", err)
+	}
+	if err := adapter.Execute("CREATE INDEX ON bolt_tasks (priority)"); err == nil {
+		t.Fatal("expected duplicate index to fail")
+	}
+
+	var page []BoltTask
+	if _, err := adapter.List(&page, "priority", nil, 10, ""); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"t03", "t01", "t04", "t02"}; !slices.Equal(boltIDs(page), want) {
+		t.Fatalf("listed by backfilled index %v, want %v", boltIDs(page), want)
+	}
+
+	// Updating an item moves its index entry rather than duplicating it.
+	task := BoltTask{Id: "t03", Title: "Task 3", Status: "open", Priority: 9}
+	if err := adapter.Update(&task, map[string]any{"id": "t03"}); err != nil {
+		t.Fatal(err)
+	}
+	page = nil
+	if _, err := adapter.List(&page, "priority", map[string]any{"priority": 1}, 10, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 0 {
+		t.Fatalf("stale index entry: %v", boltIDs(page))
+	}
+
+	if err := adapter.Execute("DROP INDEX ON bolt_tasks (priority)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.Execute("DROP TABLE bolt_tasks"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := adapter.Count(&BoltTask{}, nil); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("Count on dropped table error = %v", err)
+	}
+}
+
+func TestBoltAdapterMigrationBookkeeping(t *testing.T) {
+	adapter := newBoltAdapter(t, filepath.Join(t.TempDir(), "migrations.db"))
+	if applied, err := adapter.AppliedMigrations(); err != nil || len(applied) != 0 {
+		t.Fatalf("AppliedMigrations before the table exists = %+v, %v", applied, err)
+	}
+	if err := adapter.CreateMigrationTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.CreateMigrationTable(); err != nil {
+		t.Fatalf("CreateMigrationTable is not idempotent: %v", err)
+	}
+	latest, err := adapter.GetLatestMigration()
+	if err != nil || latest != 0 {
+		t.Fatalf("GetLatestMigration on empty table = %d, %v", latest, err)
+	}
+	for _, id := range []int{2, 10, 9} {
+		if err := adapter.UpdateMigrationTable(id, fmt.Sprintf("%d__m.yaml", id), "m"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if latest, err = adapter.GetLatestMigration(); err != nil || latest != 10 {
+		t.Fatalf("GetLatestMigration = %d, %v, want 10", latest, err)
+	}
+	if err := adapter.DeleteMigration(10); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err = adapter.GetLatestMigration(); err != nil || latest != 9 {
+		t.Fatalf("GetLatestMigration after deleting 10 = %d, %v, want 9", latest, err)
+	}
+	if err := adapter.RecordMigration(storage.AppliedMigration{ID: 11, Name: "11__m.yaml", Checksum: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	applied, err := adapter.AppliedMigrations()
+	if err != nil || len(applied) != 3 || applied[1].ID != 9 || applied[2].Checksum != "abc" || applied[2].AppliedAt.IsZero() {
+		t.Fatalf("AppliedMigrations = %+v, %v", applied, err)
+	}
+}
+
+func TestBoltAdapterHonoursContextAndRejectsQuery(t *testing.T) {
+	adapter := newBoltAdapter(t, filepath.Join(t.TempDir(), "ctx.db"))
+	seedBoltTasks(t, adapter, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var page []BoltTask
+	if _, err := adapter.ListContext(ctx, &page, "id", nil, 10, ""); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ListContext with cancelled ctx error = %v", err)
+	}
+	if _, err := adapter.Query(&page, "anything", 10, ""); !errors.Is(err, storage.ErrNotSupported) {
+		t.Fatalf("Query error = %v, want ErrNotSupported", err)
+	}
+}
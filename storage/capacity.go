@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/tink3rlabs/magic/telemetry"
+)
+
+// metricStorageConsumedCapacityTotal is kept in sync with
+// observability.StorageConsumedCapacityTotal.
+const (
+	metricStorageConsumedCapacityTotal = "magic_storage_consumed_capacity_total"
+
+	labelTable = "table"
+)
+
+// CapacityReporter is implemented by adapters whose store charges each call
+// in capacity units and reports the charge: DynamoDB (read and write capacity
+// units) and Cosmos DB (request units). They report every charge to the
+// CapacityObserver in the call's context and to the
+// magic_storage_consumed_capacity_total metric.
+type CapacityReporter interface {
+	// TableName returns the table or container model is stored in, which is
+	// the name its charges are reported against.
+	TableName(model any) string
+}
+
+// CapacityObserver receives the capacity a call consumed on a table. A call
+// can report several charges, one per request it makes to the store.
+type CapacityObserver func(table string, units float64)
+
+type capacityObserverKey struct{}
+
+// WithCapacityObserver returns a context whose calls report the capacity
+// they consume to observe, as well as to any observer ctx already carries.
+func WithCapacityObserver(ctx context.Context, observe CapacityObserver) context.Context {
+	if outer, ok := ctx.Value(capacityObserverKey{}).(CapacityObserver); ok {
+		inner := observe
+		observe = func(table string, units float64) {
+			inner(table, units)
+			outer(table, units)
+		}
+	}
+	return context.WithValue(ctx, capacityObserverKey{}, observe)
+}
+
+// capacityCounter caches the consumed-capacity counter for the telemetry it
+// was registered with, so reporting does not register on every call.
+var capacityCounter struct {
+	sync.Mutex
+	telem   *telemetry.Telemetry
+	counter telemetry.Counter
+}
+
+func consumedCapacityCounter() telemetry.Counter {
+	t := telemetry.Global()
+	capacityCounter.Lock()
+	defer capacityCounter.Unlock()
+	if capacityCounter.telem == t {
+		return capacityCounter.counter
+	}
+	c, err := t.Metrics.Counter(telemetry.MetricDefinition{
+		Name:   metricStorageConsumedCapacityTotal,
+		Help:   "Total capacity units consumed by storage operations (DynamoDB capacity units, Cosmos DB request units), labeled by provider, operation, and table.",
+		Kind:   telemetry.KindCounter,
+		Labels: []string{labelProvider, labelOperation, labelTable},
+	})
+	if err != nil {
+		slog.Warn("storage: failed to register consumed capacity counter", "error", err)
+	}
+	capacityCounter.telem, capacityCounter.counter = t, c
+	return c
+}
+
+// ReportCapacity records a charge the store reported for a call made with
+// ctx: units consumed on table by op, in the store's own units. Adapters that
+// implement CapacityReporter call it once per request they make.
+func ReportCapacity(ctx context.Context, provider string, op string, table string, units float64) {
+	if units <= 0 {
+		return
+	}
+	if observe, ok := ctx.Value(capacityObserverKey{}).(CapacityObserver); ok {
+		observe(table, units)
+	}
+	if c := consumedCapacityCounter(); c != nil {
+		c.Add(units,
+			telemetry.Label{Key: labelProvider, Value: provider},
+			telemetry.Label{Key: labelOperation, Value: op},
+			telemetry.Label{Key: labelTable, Value: table},
+		)
+	}
+}
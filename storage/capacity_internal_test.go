@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCapacityObserversChainToTheOutermost(t *testing.T) {
+	var got []string
+	ctx := WithCapacityObserver(context.Background(), func(table string, units float64) {
+		got = append(got, "outer:"+table)
+	})
+	ctx = WithCapacityObserver(ctx, func(table string, units float64) {
+		got = append(got, "inner:"+table)
+	})
+
+	ReportCapacity(ctx, "dynamodb", "get", "orders", 0.5)
+	ReportCapacity(ctx, "dynamodb", "get", "orders", 0)
+
+	if len(got) != 2 || got[0] != "inner:orders" || got[1] != "outer:orders" {
+		t.Fatalf("observers saw %v; want the inner then the outer once, and no zero charge", got)
+	}
+}
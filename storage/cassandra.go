@@ -0,0 +1,865 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+
+	"github.com/tink3rlabs/magic/logger"
+	"github.com/tink3rlabs/magic/storage/search/lucene"
+)
+
+// CassandraAllowFilteringKey, set to true in params, appends ALLOW FILTERING
+// to List, Search and Count. Cassandra refuses a query that restricts a
+// non-key column, or a clustering column without the partition key, unless
+// the caller accepts that it may scan; this is that acceptance.
+const CassandraAllowFilteringKey = "allow_filtering"
+
+// CassandraSession is the part of a CQL session the adapter uses. The
+// default implementation wraps a *gocql.Session; supply another through
+// NewCassandraAdapter to add instrumentation, or a fake in tests.
+type CassandraSession interface {
+	// Exec runs a statement that returns no rows.
+	Exec(ctx context.Context, statement string, values ...any) error
+	// Iter runs a query and returns one page of rows, keyed by column name,
+	// and the paging state of the next page (empty on the last page). A nil
+	// pageState requests the first page; pageSize 0 leaves the driver
+	// default.
+	Iter(ctx context.Context, statement string, values []any, pageSize int, pageState []byte) ([]map[string]any, []byte, error)
+	Close()
+}
+
+type CassandraAdapter struct {
+	Session  CassandraSession
+	config   map[string]string
+	keyspace string
+	provider StorageProviders
+
+	tablesLock sync.RWMutex
+	tables     map[string]*cassandraTable
+
+	// migrationNamespace names the migrations table, see MigrationNamespace.
+	migrationNamespace string
+}
+
+// cassandraTable is the schema metadata the adapter needs for a table: which
+// columns form the primary key and which carry a secondary index. It is read
+// from system_schema on first use and cached until the next Execute, which
+// may have changed it.
+type cassandraTable struct {
+	partitionKey []string
+	clustering   []string
+	indexed      []string
+}
+
+func (t *cassandraTable) isKey(col string) bool {
+	return slices.Contains(t.partitionKey, col) || slices.Contains(t.clustering, col)
+}
+
+// searchable lists the columns CQL can restrict without ALLOW FILTERING.
+func (t *cassandraTable) searchable() []string {
+	return slices.Concat(t.partitionKey, t.clustering, t.indexed)
+}
+
+var cassandraAdapterLock = &sync.Mutex{}
+var cassandraAdapterInstance *CassandraAdapter
+
+func GetCassandraAdapterInstance(config map[string]string) *CassandraAdapter {
+	if cassandraAdapterInstance == nil {
+		cassandraAdapterLock.Lock()
+		defer cassandraAdapterLock.Unlock()
+		if cassandraAdapterInstance == nil {
+			cassandraAdapterInstance = NewCassandraAdapter(nil, config)
+			cassandraAdapterInstance.OpenConnection()
+		}
+	}
+	return cassandraAdapterInstance
+}
+
+// NewCassandraAdapter returns an adapter over an existing session, for
+// callers that build their own cluster configuration (TLS, host selection
+// policies) or need a fake in tests. config supplies keyspace, provider and
+// the replication settings CreateSchema uses. Most applications use
+// StorageAdapterFactory instead.
+func NewCassandraAdapter(session CassandraSession, config map[string]string) *CassandraAdapter {
+	provider := CASSANDRA_PROVIDER
+	if StorageProviders(config["provider"]) == SCYLLADB {
+		provider = SCYLLADB
+	}
+	return &CassandraAdapter{
+		Session:  session,
+		config:   config,
+		keyspace: config["keyspace"],
+		provider: provider,
+		tables:   map[string]*cassandraTable{},
+	}
+}
+
+// OpenConnection connects to the cluster. The session is not bound to the
+// keyspace, so it works before CreateSchema has created it; every statement
+// the adapter builds names the keyspace explicitly.
+func (c *CassandraAdapter) OpenConnection() {
+	if !validColumnName.MatchString(c.keyspace) {
+		logger.Fatal("a valid keyspace is required for the cassandra storage adapter", slog.String("keyspace", c.keyspace))
+	}
+	cluster := gocql.NewCluster(strings.Split(c.config["hosts"], ",")...)
+	if c.config["username"] != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{
+			Username: c.config["username"],
+			Password: c.config["password"],
+		}
+	}
+	if v := c.config["port"]; v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			logger.Fatal("failed to parse cassandra port", slog.String("port", v))
+		}
+		cluster.Port = port
+	}
+	if v := c.config["protocol_version"]; v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			logger.Fatal("failed to parse cassandra protocol version", slog.String("protocol_version", v))
+		}
+		cluster.ProtoVersion = version
+	}
+	if v := c.config["consistency"]; v != "" {
+		consistency, err := gocql.ParseConsistencyWrapper(v)
+		if err != nil {
+			logger.Fatal("failed to parse cassandra consistency", slog.String("consistency", v))
+		}
+		cluster.Consistency = consistency
+	}
+	if v := c.config["timeout"]; v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			logger.Fatal("failed to parse cassandra timeout", slog.String("timeout", v))
+		}
+		cluster.Timeout = timeout
+	}
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		logger.Fatal("failed to open a database connection", slog.Any("error", err.Error()))
+	}
+	c.Session = gocqlSession{session}
+}
+
+func (c *CassandraAdapter) Execute(statement string) error {
+	return c.ExecuteContext(context.Background(), statement)
+}
+
+// ExecuteContext runs a statement and drops cached table metadata, since the
+// statement may be DDL that changed keys or indexes.
+func (c *CassandraAdapter) ExecuteContext(ctx context.Context, statement string) error {
+	err := c.Session.Exec(ctx, statement)
+	c.tablesLock.Lock()
+	c.tables = map[string]*cassandraTable{}
+	c.tablesLock.Unlock()
+	return err
+}
+
+func (c *CassandraAdapter) Ping() error {
+	return c.PingContext(context.Background())
+}
+
+func (c *CassandraAdapter) PingContext(ctx context.Context) error {
+	_, _, err := c.Session.Iter(ctx, "SELECT release_version FROM system.local", nil, 1, nil)
+	return err
+}
+
+func (c *CassandraAdapter) GetType() StorageAdapterType {
+	return CASSANDRA
+}
+
+func (c *CassandraAdapter) GetProvider() StorageProviders {
+	return c.provider
+}
+
+func (c *CassandraAdapter) GetSchemaName() string {
+	return c.keyspace
+}
+
+// CreateSchema creates the keyspace. Replication is configured with
+// replication_class (default SimpleStrategy) and either replication_factor
+// (default 1) or, for NetworkTopologyStrategy, datacenters as a list of
+// name:factor pairs, e.g. "dc1:3,dc2:3".
+func (c *CassandraAdapter) CreateSchema() error {
+	replication, err := c.replication()
+	if err != nil {
+		return err
+	}
+	return c.Execute(fmt.Sprintf("CREATE KEYSPACE IF NOT EXISTS %s WITH replication = %s", c.keyspace, replication))
+}
+
+func (c *CassandraAdapter) replication() (string, error) {
+	class := c.config["replication_class"]
+	if class == "" {
+		class = "SimpleStrategy"
+	}
+	if class == "NetworkTopologyStrategy" {
+		parts := []string{"'class': 'NetworkTopologyStrategy'"}
+		for _, dc := range strings.Split(c.config["datacenters"], ",") {
+			name, factor, ok := strings.Cut(strings.TrimSpace(dc), ":")
+			if _, err := strconv.Atoi(factor); !ok || err != nil || !validColumnName.MatchString(name) {
+				return "", fmt.Errorf("invalid datacenters entry %q, expected name:replication_factor", dc)
+			}
+			parts = append(parts, fmt.Sprintf("'%s': %s", name, factor))
+		}
+		return "{" + strings.Join(parts, ", ") + "}", nil
+	}
+	if class != "SimpleStrategy" {
+		return "", fmt.Errorf("unsupported replication_class %q", class)
+	}
+	factor := c.config["replication_factor"]
+	if factor == "" {
+		factor = "1"
+	}
+	if _, err := strconv.Atoi(factor); err != nil {
+		return "", fmt.Errorf("invalid replication_factor %q", factor)
+	}
+	return fmt.Sprintf("{'class': 'SimpleStrategy', 'replication_factor': %s}", factor), nil
+}
+
+// migrationTable is the unqualified name of the migrations table.
+func (c *CassandraAdapter) migrationTable() string {
+	return namespacedMigrationTable("migrations", c.migrationNamespace)
+}
+
+// MigrationNamespace returns a copy of the adapter, sharing its session, that
+// records migrations in the namespace's migrations table.
+func (c *CassandraAdapter) MigrationNamespace(namespace string) StorageAdapter {
+	return &CassandraAdapter{
+		Session:            c.Session,
+		config:             c.config,
+		keyspace:           c.keyspace,
+		provider:           c.provider,
+		tables:             map[string]*cassandraTable{},
+		migrationNamespace: namespace,
+	}
+}
+
+func (c *CassandraAdapter) CreateMigrationTable() error {
+	err := c.Execute(fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s.%s (id int PRIMARY KEY, name text, description text, timestamp bigint, checksum text)",
+		c.keyspace, c.migrationTable()))
+	if err != nil {
+		return err
+	}
+	// Tables created before migrations were checksummed lack the column,
+	// and CQL has no ADD IF NOT EXISTS.
+	columns, err := c.migrationColumns()
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 || slices.Contains(columns, "checksum") {
+		return nil
+	}
+	return c.Execute(fmt.Sprintf("ALTER TABLE %s.%s ADD checksum text", c.keyspace, c.migrationTable()))
+}
+
+// migrationColumns returns the columns of the migrations table, none when
+// the table does not exist.
+func (c *CassandraAdapter) migrationColumns() ([]string, error) {
+	rows, _, err := c.Session.Iter(context.Background(),
+		"SELECT column_name FROM system_schema.columns WHERE keyspace_name = ? AND table_name = ?",
+		[]any{c.keyspace, c.migrationTable()}, 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema of table %s.%s: %w", c.keyspace, c.migrationTable(), err)
+	}
+	columns := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if name, ok := row["column_name"].(string); ok {
+			columns = append(columns, name)
+		}
+	}
+	return columns, nil
+}
+
+func (c *CassandraAdapter) UpdateMigrationTable(id int, name string, desc string) error {
+	return c.RecordMigration(AppliedMigration{ID: id, Name: name, Description: desc})
+}
+
+func (c *CassandraAdapter) RecordMigration(applied AppliedMigration) error {
+	if applied.AppliedAt.IsZero() {
+		applied.AppliedAt = time.Now()
+	}
+	return c.Session.Exec(context.Background(),
+		fmt.Sprintf("INSERT INTO %s.%s (id, name, description, timestamp, checksum) VALUES (?, ?, ?, ?, ?)", c.keyspace, c.migrationTable()),
+		applied.ID, applied.Name, applied.Description, applied.AppliedAt.UnixMilli(), applied.Checksum)
+}
+
+// AppliedMigrations reads the whole migrations table, which is small, and
+// orders it by id, which CQL cannot across partitions. A missing table has no
+// rows, and one without the checksum column has empty checksums.
+func (c *CassandraAdapter) AppliedMigrations() ([]AppliedMigration, error) {
+	columns, err := c.migrationColumns()
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return []AppliedMigration{}, nil
+	}
+	selected := "id, name, description, timestamp"
+	if slices.Contains(columns, "checksum") {
+		selected += ", checksum"
+	}
+	rows, _, err := c.Session.Iter(context.Background(),
+		fmt.Sprintf("SELECT %s FROM %s.%s", selected, c.keyspace, c.migrationTable()), nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	applied := make([]AppliedMigration, len(rows))
+	for i, row := range rows {
+		applied[i] = AppliedMigration{ID: cassandraInt(row["id"])}
+		applied[i].Name, _ = row["name"].(string)
+		applied[i].Description, _ = row["description"].(string)
+		applied[i].Checksum, _ = row["checksum"].(string)
+		if ts, ok := row["timestamp"].(int64); ok {
+			applied[i].AppliedAt = time.UnixMilli(ts)
+		}
+	}
+	slices.SortFunc(applied, func(a, b AppliedMigration) int { return a.ID - b.ID })
+	return applied, nil
+}
+
+func (c *CassandraAdapter) DeleteMigration(id int) error {
+	return c.Session.Exec(context.Background(),
+		fmt.Sprintf("DELETE FROM %s.%s WHERE id = ?", c.keyspace, c.migrationTable()), id)
+}
+
+// GetLatestMigration returns the highest applied migration id, or 0 when none
+// has been applied. The migrations table is small, so the cross-partition
+// aggregate is cheap.
+func (c *CassandraAdapter) GetLatestMigration() (int, error) {
+	rows, _, err := c.Session.Iter(context.Background(),
+		fmt.Sprintf("SELECT MAX(id) AS id FROM %s.%s", c.keyspace, c.migrationTable()), nil, 0, nil)
+	if err != nil || len(rows) == 0 || rows[0]["id"] == nil {
+		return 0, err
+	}
+	switch v := rows[0]["id"].(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case int32:
+		return int(v), nil
+	}
+	return 0, fmt.Errorf("unexpected migration id type %T", rows[0]["id"])
+}
+
+func (c *CassandraAdapter) Create(item any, params ...map[string]any) error {
+	return c.CreateContext(context.Background(), item, params...)
+}
+
+// CreateContext inserts item. CQL inserts are upserts: an existing row with
+// the same primary key is overwritten rather than reported as a conflict.
+func (c *CassandraAdapter) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	columns, values, err := cassandraColumns(item)
+	if err != nil {
+		return err
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	statement := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		c.tableRef(item), strings.Join(columns, ", "), placeholders)
+	if err := c.Session.Exec(ctx, statement, values...); err != nil {
+		return fmt.Errorf("failed to create item: %w", err)
+	}
+	return nil
+}
+
+func (c *CassandraAdapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
+	return c.GetContext(context.Background(), dest, filter, params...)
+}
+
+func (c *CassandraAdapter) GetContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error {
+	if len(filter) == 0 {
+		return errors.New("filtering is required when getting a resource")
+	}
+	where, values, err := cassandraWhere(filter)
+	if err != nil {
+		return err
+	}
+	statement := fmt.Sprintf("SELECT * FROM %s WHERE %s LIMIT 1", c.tableRef(dest), where)
+	rows, _, err := c.Session.Iter(ctx, statement, values, 1, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get item: %w", err)
+	}
+	if len(rows) == 0 {
+		return ErrNotFound
+	}
+	return decodeCassandraRows(rows[0], dest)
+}
+
+func (c *CassandraAdapter) Update(item any, filter map[string]any, params ...map[string]any) error {
+	return c.UpdateContext(context.Background(), item, filter, params...)
+}
+
+// UpdateContext sets every non-key column of item on the row matching
+// filter, which must name the full primary key. The update is conditional on
+// the row existing, so it fails with ErrNotFound rather than inserting one.
+func (c *CassandraAdapter) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	if len(filter) == 0 {
+		return errors.New("filtering is required when updating a resource")
+	}
+	table, err := c.tableMeta(ctx, c.getTableName(item))
+	if err != nil {
+		return err
+	}
+	columns, values, err := cassandraColumns(item)
+	if err != nil {
+		return err
+	}
+	var assignments []string
+	var bindings []any
+	for i, col := range columns {
+		if table.isKey(col) {
+			continue
+		}
+		if _, inFilter := filter[col]; inFilter {
+			continue
+		}
+		assignments = append(assignments, col+" = ?")
+		bindings = append(bindings, values[i])
+	}
+	if len(assignments) == 0 {
+		return errors.New("nothing to update: item has no non-key columns")
+	}
+	where, whereValues, err := cassandraWhere(filter)
+	if err != nil {
+		return err
+	}
+	statement := fmt.Sprintf("UPDATE %s SET %s WHERE %s IF EXISTS", c.tableRef(item), strings.Join(assignments, ", "), where)
+	rows, _, err := c.Session.Iter(ctx, statement, append(bindings, whereValues...), 0, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update item: %w", err)
+	}
+	if len(rows) == 0 || rows[0]["[applied]"] != true {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (c *CassandraAdapter) Delete(item any, filter map[string]any, params ...map[string]any) error {
+	return c.DeleteContext(context.Background(), item, filter, params...)
+}
+
+func (c *CassandraAdapter) DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	if len(filter) == 0 {
+		return errors.New("filtering is required when deleting a resource")
+	}
+	where, values, err := cassandraWhere(filter)
+	if err != nil {
+		return err
+	}
+	statement := fmt.Sprintf("DELETE FROM %s WHERE %s", c.tableRef(item), where)
+	if err := c.Session.Exec(ctx, statement, values...); err != nil {
+		return fmt.Errorf("failed to delete item: %w", err)
+	}
+	return nil
+}
+
+func (c *CassandraAdapter) List(dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	return c.ListContext(context.Background(), dest, sortKey, filter, limit, cursor, params...)
+}
+
+// ListContext pages with Cassandra's paging state, so the cursor is opaque
+// and only valid for the same query. Rows come back in token order unless
+// sortKey is a clustering column, and ordering by it is only meaningful
+// within one partition (filter on the partition key).
+func (c *CassandraAdapter) ListContext(ctx context.Context, dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	where, values, err := cassandraWhere(filter)
+	if err != nil {
+		return "", err
+	}
+	return c.executePaginatedQuery(ctx, dest, sortKey, where, values, limit, cursor, params...)
+}
+
+func (c *CassandraAdapter) Search(dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return c.SearchContext(context.Background(), dest, sortKey, query, limit, cursor, params...)
+}
+
+// SearchContext supports the subset of Lucene CQL can express, on primary key
+// and indexed columns only; see lucene.CQLDriver.
+func (c *CassandraAdapter) SearchContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	var where string
+	var values []any
+	if query != "" {
+		table, err := c.tableMeta(ctx, c.getTableName(dest))
+		if err != nil {
+			return "", err
+		}
+		destType := reflect.TypeOf(dest).Elem().Elem()
+		model := reflect.New(destType).Elem().Interface()
+		parser, err := lucene.NewParser(model)
+		if err != nil {
+			return "", err
+		}
+		where, values, err = parser.ParseToCQL(query, table.searchable())
+		if err != nil {
+			return "", err
+		}
+	}
+	return c.executePaginatedQuery(ctx, dest, sortKey, where, values, limit, cursor, params...)
+}
+
+func (c *CassandraAdapter) executePaginatedQuery(
+	ctx context.Context,
+	dest any,
+	sortKey string,
+	where string,
+	values []any,
+	limit int,
+	cursor string,
+	params ...map[string]any,
+) (string, error) {
+	paramMap := extractParams(params...)
+	sortDirection, err := extractSortDirection(paramMap)
+	if err != nil {
+		return "", fmt.Errorf("failed to list: %w", err)
+	}
+
+	statement := "SELECT * FROM " + c.tableRef(dest)
+	if where != "" {
+		statement += " WHERE " + where
+	}
+	orderBy, err := c.orderBy(ctx, dest, sortKey, sortDirection)
+	if err != nil {
+		return "", err
+	}
+	statement += orderBy
+	if allow, _ := paramMap[CassandraAllowFilteringKey].(bool); allow {
+		statement += " ALLOW FILTERING"
+	}
+
+	var pageState []byte
+	if cursor != "" {
+		pageState, err = base64.StdEncoding.DecodeString(cursor)
+		if err != nil {
+			return "", fmt.Errorf("invalid cursor: %w", err)
+		}
+	}
+
+	rows, next, err := c.Session.Iter(ctx, statement, values, limit, pageState)
+	if err != nil {
+		slog.Error("Query execution failed", "error", err)
+		return "", err
+	}
+	if rows == nil {
+		rows = []map[string]any{}
+	}
+	if err := decodeCassandraRows(rows, dest); err != nil {
+		return "", err
+	}
+	if len(next) == 0 {
+		return "", nil
+	}
+	return base64.StdEncoding.EncodeToString(next), nil
+}
+
+// orderBy renders ORDER BY for a clustering column. Sorting by a partition
+// key column (commonly "id") is accepted and ignored, since partitions have
+// no order beyond their token; any other column is an error because CQL
+// cannot sort by it.
+func (c *CassandraAdapter) orderBy(ctx context.Context, dest any, sortKey string, direction SortingDirection) (string, error) {
+	if sortKey == "" {
+		return "", nil
+	}
+	if err := validateSortKey(sortKey); err != nil {
+		return "", err
+	}
+	table, err := c.tableMeta(ctx, c.getTableName(dest))
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case slices.Contains(table.clustering, sortKey):
+		return fmt.Sprintf(" ORDER BY %s %s", sortKey, direction), nil
+	case slices.Contains(table.partitionKey, sortKey):
+		return "", nil
+	}
+	return "", fmt.Errorf("cannot sort by %q: CQL can only order by clustering columns (%s)",
+		sortKey, strings.Join(table.clustering, ", "))
+}
+
+func (c *CassandraAdapter) Count(dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	return c.CountContext(context.Background(), dest, filter, params...)
+}
+
+func (c *CassandraAdapter) CountContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	statement := "SELECT COUNT(*) AS count FROM " + c.tableRef(dest)
+	where, values, err := cassandraWhere(filter)
+	if err != nil {
+		return 0, err
+	}
+	if where != "" {
+		statement += " WHERE " + where
+	}
+	if allow, _ := extractParams(params...)[CassandraAllowFilteringKey].(bool); allow {
+		statement += " ALLOW FILTERING"
+	}
+	rows, _, err := c.Session.Iter(ctx, statement, values, 0, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count items: %w", err)
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	count, ok := rows[0]["count"].(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected count type %T", rows[0]["count"])
+	}
+	return count, nil
+}
+
+func (c *CassandraAdapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return c.QueryContext(context.Background(), dest, statement, limit, cursor, params...)
+}
+
+// QueryContext runs a raw CQL SELECT with the same paging as List. The
+// statement is passed through unchanged.
+func (c *CassandraAdapter) QueryContext(ctx context.Context, dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	var pageState []byte
+	if cursor != "" {
+		var err error
+		if pageState, err = base64.StdEncoding.DecodeString(cursor); err != nil {
+			return "", fmt.Errorf("invalid cursor: %w", err)
+		}
+	}
+	rows, next, err := c.Session.Iter(ctx, statement, nil, limit, pageState)
+	if err != nil {
+		return "", err
+	}
+	if rows == nil {
+		rows = []map[string]any{}
+	}
+	if err := decodeCassandraRows(rows, dest); err != nil {
+		return "", err
+	}
+	if len(next) == 0 {
+		return "", nil
+	}
+	return base64.StdEncoding.EncodeToString(next), nil
+}
+
+// tableMeta returns the cached key and index metadata of a table, reading it
+// from system_schema on first use.
+func (c *CassandraAdapter) tableMeta(ctx context.Context, table string) (*cassandraTable, error) {
+	c.tablesLock.RLock()
+	meta, ok := c.tables[table]
+	c.tablesLock.RUnlock()
+	if ok {
+		return meta, nil
+	}
+
+	rows, _, err := c.Session.Iter(ctx,
+		"SELECT column_name, kind, position FROM system_schema.columns WHERE keyspace_name = ? AND table_name = ?",
+		[]any{c.keyspace, table}, 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema of table %s.%s: %w", c.keyspace, table, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("table %s.%s does not exist", c.keyspace, table)
+	}
+	slices.SortFunc(rows, func(a, b map[string]any) int {
+		return cassandraInt(a["position"]) - cassandraInt(b["position"])
+	})
+	meta = &cassandraTable{}
+	for _, row := range rows {
+		name, _ := row["column_name"].(string)
+		switch row["kind"] {
+		case "partition_key":
+			meta.partitionKey = append(meta.partitionKey, name)
+		case "clustering":
+			meta.clustering = append(meta.clustering, name)
+		}
+	}
+
+	indexes, _, err := c.Session.Iter(ctx,
+		"SELECT options FROM system_schema.indexes WHERE keyspace_name = ? AND table_name = ?",
+		[]any{c.keyspace, table}, 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read indexes of table %s.%s: %w", c.keyspace, table, err)
+	}
+	for _, row := range indexes {
+		options, _ := row["options"].(map[string]string)
+		if col := indexTarget(options["target"]); col != "" && !meta.isKey(col) {
+			meta.indexed = append(meta.indexed, col)
+		}
+	}
+
+	c.tablesLock.Lock()
+	c.tables[table] = meta
+	c.tablesLock.Unlock()
+	return meta, nil
+}
+
+// indexTargetPattern matches the collection forms of an index target:
+// values(col), keys(col), entries(col), full(col).
+var indexTargetPattern = regexp.MustCompile(`^[a-z]+\((.+)\)$`)
+
+// indexTarget extracts the column from an index target. Collection indexes
+// are skipped: the Lucene driver cannot search collection columns.
+func indexTarget(target string) string {
+	if indexTargetPattern.MatchString(target) {
+		return ""
+	}
+	return strings.Trim(target, `"`)
+}
+
+func cassandraInt(v any) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	}
+	return 0
+}
+
+func (c *CassandraAdapter) tableRef(obj any) string {
+	return c.keyspace + "." + c.getTableName(obj)
+}
+
+func (c *CassandraAdapter) getTableName(obj any) string {
+	// Get the type of obj
+	tableName := ""
+	tableName = reflect.TypeOf(obj).String()
+	tableName = tableName[strings.LastIndex(tableName, ".")+1:]
+
+	// Convert the table name to snake case
+	matchFirstCap := regexp.MustCompile("(.)([A-Z][a-z]+)")
+	matchAllCap := regexp.MustCompile("([a-z0-9])([A-Z])")
+	tableName = matchFirstCap.ReplaceAllString(tableName, "${1}_${2}")
+	tableName = matchAllCap.ReplaceAllString(tableName, "${1}_${2}")
+
+	tableName = strings.ToLower(tableName)
+	tableName += "s"
+	return tableName
+}
+
+// cassandraWhere renders a filter as CQL relations in sorted key order; a
+// slice value becomes IN.
+func cassandraWhere(filter map[string]any) (string, []any, error) {
+	var relations []string
+	var values []any
+	for _, key := range slices.Sorted(maps.Keys(filter)) {
+		if !validColumnName.MatchString(key) {
+			return "", nil, fmt.Errorf("invalid filter column %q", key)
+		}
+		value := filter[key]
+		rv := reflect.ValueOf(value)
+		if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+			placeholders := make([]string, rv.Len())
+			for i := 0; i < rv.Len(); i++ {
+				placeholders[i] = "?"
+				values = append(values, rv.Index(i).Interface())
+			}
+			relations = append(relations, fmt.Sprintf("%s IN (%s)", key, strings.Join(placeholders, ", ")))
+			continue
+		}
+		relations = append(relations, key+" = ?")
+		values = append(values, value)
+	}
+	return strings.Join(relations, " AND "), values, nil
+}
+
+// cassandraColumns lists the columns and typed values of a struct item, named
+// by their json tags. Values keep their Go types so gocql can marshal them to
+// the column types; a JSON round trip would turn every number into a
+// float64, which CQL will not bind to an int column.
+func cassandraColumns(item any) ([]string, []any, error) {
+	v := reflect.ValueOf(item)
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("item must be a struct, got %T", item)
+	}
+	var columns []string
+	var values []any
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || name == "" {
+			continue
+		}
+		if !validColumnName.MatchString(name) {
+			return nil, nil, fmt.Errorf("invalid column name %q", name)
+		}
+		columns = append(columns, name)
+		values = append(values, v.Field(i).Interface())
+	}
+	if len(columns) == 0 {
+		return nil, nil, fmt.Errorf("item %T has no json-tagged fields", item)
+	}
+	return columns, values, nil
+}
+
+// decodeCassandraRows copies a row (or slice of rows) into dest through JSON,
+// so struct fields are matched by their json tags as on the other adapters.
+func decodeCassandraRows(rows any, dest any) error {
+	data, err := json.Marshal(rows)
+	if err != nil {
+		return fmt.Errorf("failed to encode cassandra rows: %w", err)
+	}
+	if err := json.Unmarshal(data, dest); err != nil {
+		return fmt.Errorf("failed to unmarshal cassandra rows into dest: %w", err)
+	}
+	return nil
+}
+
+// gocqlSession adapts *gocql.Session to CassandraSession.
+type gocqlSession struct {
+	session *gocql.Session
+}
+
+func (g gocqlSession) Exec(ctx context.Context, statement string, values ...any) error {
+	return g.session.Query(statement, values...).WithContext(ctx).Exec()
+}
+
+// Iter fetches exactly one page: setting a page state, even a nil one,
+// disables gocql's automatic paging.
+func (g gocqlSession) Iter(ctx context.Context, statement string, values []any, pageSize int, pageState []byte) ([]map[string]any, []byte, error) {
+	q := g.session.Query(statement, values...).WithContext(ctx).PageState(pageState)
+	if pageSize > 0 {
+		q = q.PageSize(pageSize)
+	}
+	iter := q.Iter()
+	next := iter.PageState()
+	var rows []map[string]any
+	for {
+		row := map[string]any{}
+		if !iter.MapScan(row) {
+			break
+		}
+		rows = append(rows, row)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, nil, err
+	}
+	return rows, next, nil
+}
+
+func (g gocqlSession) Close() {
+	g.session.Close()
+}
@@ -0,0 +1,373 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeCassandraSession records every statement and answers queries from a
+// handler, so the adapter's CQL generation can be checked without a
+// cluster. Schema lookups are answered from the tables map.
+type fakeCassandraSession struct {
+	execs []fakeCQL
+	iters []fakeCQL
+
+	// tables maps a table name to its system_schema.columns rows and
+	// system_schema.indexes rows.
+	tables  map[string][2][]map[string]any
+	respond func(statement string, values []any, pageState []byte) ([]map[string]any, []byte, error)
+}
+
+type fakeCQL struct {
+	statement string
+	values    []any
+	pageSize  int
+	pageState []byte
+}
+
+func (f *fakeCassandraSession) Exec(_ context.Context, statement string, values ...any) error {
+	f.execs = append(f.execs, fakeCQL{statement: statement, values: values})
+	return nil
+}
+
+func (f *fakeCassandraSession) Iter(_ context.Context, statement string, values []any, pageSize int, pageState []byte) ([]map[string]any, []byte, error) {
+	switch {
+	case strings.Contains(statement, "system_schema.columns"):
+		return f.tables[values[1].(string)][0], nil, nil
+	case strings.Contains(statement, "system_schema.indexes"):
+		return f.tables[values[1].(string)][1], nil, nil
+	}
+	f.iters = append(f.iters, fakeCQL{statement: statement, values: values, pageSize: pageSize, pageState: pageState})
+	if f.respond == nil {
+		return nil, nil, nil
+	}
+	return f.respond(statement, values, pageState)
+}
+
+func (f *fakeCassandraSession) Close() {}
+
+// reading is a time-series row: partitioned by sensor and day, clustered by
+// time, with a secondary index on status.
+type reading struct {
+	SensorId string    `json:"sensor_id"`
+	Day      string    `json:"day"`
+	At       time.Time `json:"at"`
+	Value    float64   `json:"value"`
+	Status   string    `json:"status"`
+	Note     string    `json:"note"`
+}
+
+func newFakeCassandra() (*CassandraAdapter, *fakeCassandraSession) {
+	session := &fakeCassandraSession{tables: map[string][2][]map[string]any{
+		"readings": {
+			{
+				{"column_name": "sensor_id", "kind": "partition_key", "position": 0},
+				{"column_name": "day", "kind": "partition_key", "position": 1},
+				{"column_name": "at", "kind": "clustering", "position": 0},
+				{"column_name": "value", "kind": "regular", "position": -1},
+				{"column_name": "status", "kind": "regular", "position": -1},
+				{"column_name": "note", "kind": "regular", "position": -1},
+			},
+			{
+				{"options": map[string]string{"target": "status"}},
+				{"options": map[string]string{"target": "values(tags)"}},
+			},
+		},
+	}}
+	return NewCassandraAdapter(session, map[string]string{"keyspace": "metrics"}), session
+}
+
+func assertCQL(t *testing.T, got fakeCQL, wantStatement string, wantValues ...any) {
+	t.Helper()
+	if got.statement != wantStatement {
+		t.Errorf("statement = %q\n           want %q", got.statement, wantStatement)
+	}
+	if len(wantValues) == 0 && len(got.values) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got.values, wantValues) {
+		t.Errorf("values = %#v, want %#v", got.values, wantValues)
+	}
+}
+
+func TestCassandraCreateInsertsTypedValues(t *testing.T) {
+	c, session := newFakeCassandra()
+	at := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := c.Create(&reading{SensorId: "s1", Day: "2026-05-01", At: at, Value: 1.5, Status: "ok"}); err != nil {
+		t.Fatal(err)
+	}
+	assertCQL(t, session.execs[0],
+		"INSERT INTO metrics.readings (sensor_id, day, at, value, status, note) VALUES (?, ?, ?, ?, ?, ?)",
+		"s1", "2026-05-01", at, 1.5, "ok", "")
+}
+
+func TestCassandraGet(t *testing.T) {
+	c, session := newFakeCassandra()
+	session.respond = func(string, []any, []byte) ([]map[string]any, []byte, error) {
+		return []map[string]any{{"sensor_id": "s1", "day": "d1", "value": 2.5, "status": "ok"}}, nil, nil
+	}
+	var got reading
+	if err := c.Get(&got, map[string]any{"sensor_id": "s1", "day": "d1"}); err != nil {
+		t.Fatal(err)
+	}
+	assertCQL(t, session.iters[0], "SELECT * FROM metrics.readings WHERE day = ? AND sensor_id = ? LIMIT 1", "d1", "s1")
+	if got.Value != 2.5 || got.Status != "ok" {
+		t.Errorf("got %+v", got)
+	}
+
+	session.respond = nil
+	if err := c.Get(&got, map[string]any{"sensor_id": "missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing row = %v, want ErrNotFound", err)
+	}
+	if err := c.Get(&got, nil); err == nil {
+		t.Error("Get without a filter should fail")
+	}
+}
+
+func TestCassandraUpdateSetsOnlyNonKeyColumns(t *testing.T) {
+	c, session := newFakeCassandra()
+	at := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	item := &reading{SensorId: "s1", Day: "d1", At: at, Value: 3, Status: "late"}
+	filter := map[string]any{"sensor_id": "s1", "day": "d1", "at": at}
+	session.respond = func(string, []any, []byte) ([]map[string]any, []byte, error) {
+		return []map[string]any{{"[applied]": true}}, nil, nil
+	}
+	if err := c.Update(item, filter); err != nil {
+		t.Fatal(err)
+	}
+	assertCQL(t, session.iters[0],
+		"UPDATE metrics.readings SET value = ?, status = ?, note = ? WHERE at = ? AND day = ? AND sensor_id = ? IF EXISTS",
+		float64(3), "late", "", at, "d1", "s1")
+
+	session.respond = func(string, []any, []byte) ([]map[string]any, []byte, error) {
+		return []map[string]any{{"[applied]": false}}, nil, nil
+	}
+	if err := c.Update(item, filter); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update of a missing row = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCassandraDeleteWithIn(t *testing.T) {
+	c, session := newFakeCassandra()
+	if err := c.Delete(&reading{}, map[string]any{"sensor_id": "s1", "day": []string{"d1", "d2"}}); err != nil {
+		t.Fatal(err)
+	}
+	assertCQL(t, session.execs[0], "DELETE FROM metrics.readings WHERE day IN (?, ?) AND sensor_id = ?", "d1", "d2", "s1")
+}
+
+func TestCassandraListPagesWithPagingState(t *testing.T) {
+	c, session := newFakeCassandra()
+	session.respond = func(_ string, _ []any, pageState []byte) ([]map[string]any, []byte, error) {
+		if pageState == nil {
+			return []map[string]any{{"sensor_id": "s1", "status": "a"}}, []byte{0xde, 0xad}, nil
+		}
+		return []map[string]any{{"sensor_id": "s1", "status": "b"}}, nil, nil
+	}
+	filter := map[string]any{"sensor_id": "s1", "day": "d1"}
+
+	var page []reading
+	cursor, err := c.List(&page, "at", filter, 1, "", map[string]any{SortDirectionKey: "desc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCQL(t, session.iters[0], "SELECT * FROM metrics.readings WHERE day = ? AND sensor_id = ? ORDER BY at DESC", "d1", "s1")
+	if session.iters[0].pageSize != 1 || cursor != base64.StdEncoding.EncodeToString([]byte{0xde, 0xad}) {
+		t.Fatalf("page size %d, cursor %q", session.iters[0].pageSize, cursor)
+	}
+	if len(page) != 1 || page[0].Status != "a" {
+		t.Fatalf("first page = %+v", page)
+	}
+
+	page = nil
+	cursor, err = c.List(&page, "at", filter, 1, cursor, map[string]any{SortDirectionKey: "desc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(session.iters[1].pageState, []byte{0xde, 0xad}) {
+		t.Errorf("second page state = %v", session.iters[1].pageState)
+	}
+	if cursor != "" || len(page) != 1 || page[0].Status != "b" {
+		t.Errorf("last page = %+v, cursor %q", page, cursor)
+	}
+}
+
+func TestCassandraListSortKeys(t *testing.T) {
+	c, session := newFakeCassandra()
+	var page []reading
+
+	// Partition key columns have no order beyond their token.
+	if _, err := c.List(&page, "sensor_id", nil, 10, ""); err != nil {
+		t.Fatal(err)
+	}
+	assertCQL(t, session.iters[0], "SELECT * FROM metrics.readings")
+
+	if _, err := c.List(&page, "value", nil, 10, ""); err == nil || !strings.Contains(err.Error(), "clustering columns") {
+		t.Errorf("sorting by a regular column = %v, want an error", err)
+	}
+	if _, err := c.List(&page, "at; DROP", nil, 10, ""); err == nil {
+		t.Error("invalid sort key should be rejected")
+	}
+	if _, err := c.List(&page, "", map[string]any{"status": "ok"}, 10, "", map[string]any{CassandraAllowFilteringKey: true}); err != nil {
+		t.Fatal(err)
+	}
+	assertCQL(t, session.iters[1], "SELECT * FROM metrics.readings WHERE status = ? ALLOW FILTERING", "ok")
+}
+
+func TestCassandraSearchIsLimitedToKeyAndIndexedColumns(t *testing.T) {
+	c, session := newFakeCassandra()
+	var page []reading
+	if _, err := c.Search(&page, "at", "sensor_id:s1 AND day:d1 AND status:(ok OR late)", 50, ""); err != nil {
+		t.Fatal(err)
+	}
+	assertCQL(t, session.iters[0],
+		"SELECT * FROM metrics.readings WHERE sensor_id = ? AND day = ? AND status IN (?, ?) ORDER BY at ASC",
+		"s1", "d1", "ok", "late")
+
+	_, err := c.Search(&page, "", "note:hello", 50, "")
+	if err == nil || !strings.Contains(err.Error(), "primary key and indexed columns (at, day, sensor_id, status)") {
+		t.Errorf("searching a non-indexed column = %v, want an error", err)
+	}
+}
+
+func TestCassandraCount(t *testing.T) {
+	c, session := newFakeCassandra()
+	session.respond = func(string, []any, []byte) ([]map[string]any, []byte, error) {
+		return []map[string]any{{"count": int64(7)}}, nil, nil
+	}
+	n, err := c.Count(&reading{}, map[string]any{"sensor_id": "s1", "day": "d1"})
+	if err != nil || n != 7 {
+		t.Fatalf("Count = %d, %v", n, err)
+	}
+	assertCQL(t, session.iters[0], "SELECT COUNT(*) AS count FROM metrics.readings WHERE day = ? AND sensor_id = ?", "d1", "s1")
+}
+
+func TestCassandraUnknownTable(t *testing.T) {
+	c, _ := newFakeCassandra()
+	type unknownThing struct {
+		Id string `json:"id"`
+	}
+	var page []unknownThing
+	if _, err := c.List(&page, "id", nil, 10, ""); err == nil || !strings.Contains(err.Error(), "metrics.unknown_things does not exist") {
+		t.Errorf("List on a missing table = %v", err)
+	}
+}
+
+func TestCassandraCreateSchemaReplication(t *testing.T) {
+	cases := []struct {
+		name   string
+		config map[string]string
+		want   string
+	}{
+		{"default", map[string]string{}, "{'class': 'SimpleStrategy', 'replication_factor': 1}"},
+		{"simple", map[string]string{"replication_factor": "3"}, "{'class': 'SimpleStrategy', 'replication_factor': 3}"},
+		{
+			"network topology",
+			map[string]string{"replication_class": "NetworkTopologyStrategy", "datacenters": "dc1:3, dc2:2"},
+			"{'class': 'NetworkTopologyStrategy', 'dc1': 3, 'dc2': 2}",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			session := &fakeCassandraSession{}
+			tc.config["keyspace"] = "metrics"
+			c := NewCassandraAdapter(session, tc.config)
+			if err := c.CreateSchema(); err != nil {
+				t.Fatal(err)
+			}
+			assertCQL(t, session.execs[0], "CREATE KEYSPACE IF NOT EXISTS metrics WITH replication = "+tc.want)
+		})
+	}
+
+	c := NewCassandraAdapter(&fakeCassandraSession{}, map[string]string{
+		"keyspace": "metrics", "replication_class": "NetworkTopologyStrategy", "datacenters": "dc1:x",
+	})
+	if err := c.CreateSchema(); err == nil {
+		t.Error("invalid datacenters should be rejected")
+	}
+}
+
+func TestCassandraMigrationBookkeeping(t *testing.T) {
+	c, session := newFakeCassandra()
+	if c.GetType() != CASSANDRA || c.GetProvider() != CASSANDRA_PROVIDER {
+		t.Errorf("type/provider = %s/%s", c.GetType(), c.GetProvider())
+	}
+	if scylla := NewCassandraAdapter(session, map[string]string{"provider": "scylladb"}); scylla.GetProvider() != SCYLLADB {
+		t.Errorf("provider = %s, want scylladb", scylla.GetProvider())
+	}
+
+	if err := c.CreateMigrationTable(); err != nil {
+		t.Fatal(err)
+	}
+	assertCQL(t, session.execs[0],
+		"CREATE TABLE IF NOT EXISTS metrics.migrations (id int PRIMARY KEY, name text, description text, timestamp bigint, checksum text)")
+
+	// A table from before checksums gains the column.
+	session.tables["migrations"] = [2][]map[string]any{{{"column_name": "id"}, {"column_name": "name"}}}
+	if err := c.CreateMigrationTable(); err != nil {
+		t.Fatal(err)
+	}
+	assertCQL(t, session.execs[len(session.execs)-1], "ALTER TABLE metrics.migrations ADD checksum text")
+
+	// MAX over an empty table is a single null row.
+	session.respond = func(string, []any, []byte) ([]map[string]any, []byte, error) {
+		return []map[string]any{{"id": nil}}, nil, nil
+	}
+	if latest, err := c.GetLatestMigration(); err != nil || latest != 0 {
+		t.Errorf("GetLatestMigration on empty table = %d, %v", latest, err)
+	}
+	session.respond = func(string, []any, []byte) ([]map[string]any, []byte, error) {
+		return []map[string]any{{"id": 4}}, nil, nil
+	}
+	if latest, err := c.GetLatestMigration(); err != nil || latest != 4 {
+		t.Errorf("GetLatestMigration = %d, %v", latest, err)
+	}
+
+	if err := c.UpdateMigrationTable(5, "5__add_index.yaml", "add index"); err != nil {
+		t.Fatal(err)
+	}
+	last := session.execs[len(session.execs)-1]
+	if last.statement != "INSERT INTO metrics.migrations (id, name, description, timestamp, checksum) VALUES (?, ?, ?, ?, ?)" ||
+		last.values[0] != 5 || last.values[1] != "5__add_index.yaml" {
+		t.Errorf("UpdateMigrationTable = %+v", last)
+	}
+
+	session.respond = func(string, []any, []byte) ([]map[string]any, []byte, error) {
+		return []map[string]any{
+			{"id": 5, "name": "5__add_index.yaml", "timestamp": int64(1700000000000), "checksum": "abc"},
+			{"id": 2, "name": "2__create.yaml", "timestamp": int64(1600000000000), "checksum": nil},
+		}, nil, nil
+	}
+	applied, err := c.AppliedMigrations()
+	if err != nil || len(applied) != 2 || applied[0].ID != 2 || applied[1].Checksum != "abc" ||
+		!applied[1].AppliedAt.Equal(time.UnixMilli(1700000000000)) {
+		t.Errorf("AppliedMigrations = %+v, %v; want them ordered by id", applied, err)
+	}
+	// The table above has no checksum column to select.
+	assertCQL(t, session.iters[len(session.iters)-1], "SELECT id, name, description, timestamp FROM metrics.migrations")
+
+	// A database never migrated has no table and nothing applied.
+	delete(session.tables, "migrations")
+	if applied, err := c.AppliedMigrations(); err != nil || len(applied) != 0 {
+		t.Errorf("AppliedMigrations without a table = %+v, %v", applied, err)
+	}
+}
+
+func TestCassandraExecuteInvalidatesTableMetadata(t *testing.T) {
+	c, session := newFakeCassandra()
+	var page []reading
+	if _, err := c.Search(&page, "", "note:x", 10, ""); err == nil {
+		t.Fatal("note is not indexed yet")
+	}
+	session.tables["readings"][1][1] = map[string]any{"options": map[string]string{"target": "note"}}
+	if err := c.Execute("CREATE INDEX ON metrics.readings (note)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Search(&page, "", "note:x", 10, ""); err != nil {
+		t.Errorf("Search after the index was created = %v", err)
+	}
+}
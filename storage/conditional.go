@@ -0,0 +1,20 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrAlreadyExists is returned by CreateIfAbsentContext, and by the Create of
+// adapters that refuse to overwrite, when an item with the same key is stored.
+var ErrAlreadyExists = errors.New("an item with the same key already exists")
+
+// ConditionalCreator is implemented by adapters that can create an item only
+// when no item with its key exists, in one round trip, which a writer racing
+// others for the same key needs. The SQL, memory, Bolt, DynamoDB and Cosmos DB
+// adapters implement it.
+type ConditionalCreator interface {
+	// CreateIfAbsentContext creates item, or fails with ErrAlreadyExists
+	// and leaves the stored item as it was.
+	CreateIfAbsentContext(ctx context.Context, item any, params ...map[string]any) error
+}
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type conditionalWidget struct {
+	Id   string `json:"id" gorm:"primaryKey;column:id"`
+	Name string `json:"name" gorm:"column:name"`
+}
+
+func (conditionalWidget) TableName() string { return "conditional_widgets" }
+
+func TestMemoryCreateIfAbsentKeepsTheStoredItem(t *testing.T) {
+	m := GetMemoryAdapterInstance()
+	for _, stmt := range []string{"CREATE TABLE IF NOT EXISTS conditional_widgets (id TEXT PRIMARY KEY, name TEXT)", "DELETE FROM conditional_widgets"} {
+		if err := m.Execute(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	if err := m.CreateIfAbsentContext(ctx, &conditionalWidget{Id: "w1", Name: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CreateIfAbsentContext(ctx, &conditionalWidget{Id: "w1", Name: "second"}); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("second create = %v; want ErrAlreadyExists", err)
+	}
+	var got conditionalWidget
+	if err := m.Get(&got, map[string]any{"id": "w1"}); err != nil || got.Name != "first" {
+		t.Fatalf("stored = %+v, %v; want the first item", got, err)
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
 	"reflect"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -17,6 +20,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	serviceErrors "github.com/tink3rlabs/magic/errors"
 	"github.com/tink3rlabs/magic/logger"
 )
 
@@ -25,6 +29,9 @@ type CosmosDBAdapter struct {
 	databaseClient *azcosmos.DatabaseClient
 	config         map[string]string
 	databaseName   string
+	// migrationNamespace names the migrations container, see
+	// MigrationNamespace.
+	migrationNamespace string
 }
 
 var cosmosDBAdapterLock = &sync.Mutex{}
@@ -156,18 +163,440 @@ func (s *CosmosDBAdapter) CreateSchema() error {
 	return nil
 }
 
+// migrationContainer returns the container migrations are recorded in,
+// migrations unless the migration_container setting names another.
+func (s *CosmosDBAdapter) migrationContainer() (*azcosmos.ContainerClient, error) {
+	name := s.config["migration_container"]
+	if name == "" {
+		name = "migrations"
+	}
+	container, err := s.databaseClient.NewContainer(namespacedMigrationTable(name, s.migrationNamespace))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container client: %w", err)
+	}
+	return container, nil
+}
+
+// MigrationNamespace returns a copy of the adapter, sharing its client, that
+// records migrations in the namespace's migrations container.
+func (s *CosmosDBAdapter) MigrationNamespace(namespace string) StorageAdapter {
+	return &CosmosDBAdapter{
+		client:             s.client,
+		databaseClient:     s.databaseClient,
+		config:             s.config,
+		databaseName:       s.databaseName,
+		migrationNamespace: namespace,
+	}
+}
+
+// CreateMigrationTable creates the migrations container, partitioned by the
+// migration id, when it does not exist.
 func (s *CosmosDBAdapter) CreateMigrationTable() error {
-	// CosmosDB doesn't have traditional tables, containers are created dynamically
-	// Migration tracking would need to be implemented differently
-	return fmt.Errorf("CosmosDB CreateMigrationTable is not supported - migrations should be handled at application level")
+	container, err := s.migrationContainer()
+	if err != nil {
+		return err
+	}
+	_, err = s.databaseClient.CreateContainer(context.Background(), azcosmos.ContainerProperties{
+		ID:                     container.ID(),
+		PartitionKeyDefinition: azcosmos.PartitionKeyDefinition{Paths: []string{"/id"}},
+	}, nil)
+	if err != nil && cosmosStatus(err) != http.StatusConflict {
+		return fmt.Errorf("failed to create migrations container %s: %w", container.ID(), err)
+	}
+	return nil
 }
 
 func (s *CosmosDBAdapter) UpdateMigrationTable(id int, name string, desc string) error {
-	return fmt.Errorf("CosmosDB UpdateMigrationTable is not supported")
+	return s.RecordMigration(AppliedMigration{ID: id, Name: name, Description: desc})
+}
+
+// cosmosMigration is an item of the migrations container. Items of migrations
+// that only saved a checkpoint have no timestamp.
+type cosmosMigration struct {
+	Id          string `json:"id"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Checksum    string `json:"checksum,omitempty"`
+	Timestamp   int64  `json:"timestamp,omitempty"`
+	Checkpoint  string `json:"checkpoint,omitempty"`
+}
+
+// RecordMigration upserts the migration's item, replacing the checkpoint item
+// a data migration saved while it ran.
+func (s *CosmosDBAdapter) RecordMigration(applied AppliedMigration) error {
+	container, err := s.migrationContainer()
+	if err != nil {
+		return err
+	}
+	if applied.AppliedAt.IsZero() {
+		applied.AppliedAt = time.Now()
+	}
+	id := strconv.Itoa(applied.ID)
+	body, err := json.Marshal(cosmosMigration{
+		Id:          id,
+		Name:        applied.Name,
+		Description: applied.Description,
+		Checksum:    applied.Checksum,
+		Timestamp:   applied.AppliedAt.UnixMilli(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = container.UpsertItem(context.Background(), azcosmos.NewPartitionKeyString(id), body, nil)
+	if err != nil {
+		return fmt.Errorf("failed to record migration %s: %w", applied.Name, err)
+	}
+	return nil
 }
 
+// AppliedMigrations queries the migrations container, reporting none when
+// it does not exist yet.
+func (s *CosmosDBAdapter) AppliedMigrations() ([]AppliedMigration, error) {
+	container, err := s.migrationContainer()
+	if err != nil {
+		return nil, err
+	}
+	enableCrossPartition := true
+	pager := container.NewQueryItemsPager("SELECT * FROM c WHERE IS_DEFINED(c.timestamp)", azcosmos.NewPartitionKeyString(""),
+		&azcosmos.QueryOptions{EnableCrossPartitionQuery: &enableCrossPartition})
+	applied := []AppliedMigration{}
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if cosmosStatus(err) == http.StatusNotFound {
+			return applied, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to query the migrations container: %w", err)
+		}
+		for _, raw := range page.Items {
+			var row cosmosMigration
+			if err := json.Unmarshal(raw, &row); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal migration: %w", err)
+			}
+			id, err := strconv.Atoi(row.Id)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse migration id %s: %w", row.Id, err)
+			}
+			applied = append(applied, AppliedMigration{
+				ID:          id,
+				Name:        row.Name,
+				Description: row.Description,
+				Checksum:    row.Checksum,
+				AppliedAt:   time.UnixMilli(row.Timestamp),
+			})
+		}
+	}
+	slices.SortFunc(applied, func(a, b AppliedMigration) int { return a.ID - b.ID })
+	return applied, nil
+}
+
+// GetLatestMigration returns the highest applied migration id, or 0 when none
+// has been applied.
 func (s *CosmosDBAdapter) GetLatestMigration() (int, error) {
-	return -1, fmt.Errorf("CosmosDB GetLatestMigration is not supported")
+	applied, err := s.AppliedMigrations()
+	if err != nil || len(applied) == 0 {
+		return 0, err
+	}
+	return applied[len(applied)-1].ID, nil
+}
+
+func (s *CosmosDBAdapter) DeleteMigration(id int) error {
+	container, err := s.migrationContainer()
+	if err != nil {
+		return err
+	}
+	key := strconv.Itoa(id)
+	_, err = container.DeleteItem(context.Background(), azcosmos.NewPartitionKeyString(key), key, nil)
+	if cosmosStatus(err) == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// MigrationCheckpoint reads the checkpoint of the migration's item.
+func (s *CosmosDBAdapter) MigrationCheckpoint(id int) (string, error) {
+	container, err := s.migrationContainer()
+	if err != nil {
+		return "", err
+	}
+	key := strconv.Itoa(id)
+	response, err := container.ReadItem(context.Background(), azcosmos.NewPartitionKeyString(key), key, nil)
+	if cosmosStatus(err) == http.StatusNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var row cosmosMigration
+	if err := json.Unmarshal(response.Value, &row); err != nil {
+		return "", err
+	}
+	return row.Checkpoint, nil
+}
+
+// SaveMigrationCheckpoint patches the checkpoint of the migration's item,
+// creating the item when the migration is not applied yet.
+func (s *CosmosDBAdapter) SaveMigrationCheckpoint(id int, checkpoint string) error {
+	container, err := s.migrationContainer()
+	if err != nil {
+		return err
+	}
+	key := strconv.Itoa(id)
+	pk := azcosmos.NewPartitionKeyString(key)
+	ops := azcosmos.PatchOperations{}
+	ops.AppendSet("/checkpoint", checkpoint)
+	_, err = container.PatchItem(context.Background(), pk, key, ops, nil)
+	if cosmosStatus(err) == http.StatusNotFound {
+		body, _ := json.Marshal(cosmosMigration{Id: key, Checkpoint: checkpoint})
+		_, err = container.CreateItem(context.Background(), pk, body, nil)
+	}
+	return err
+}
+
+// cosmosBatchSize is the most operations a transactional batch takes.
+const cosmosBatchSize = 100
+
+// MigrateItems backfills the items of a container that a query selects, for a
+// Go migration's Up or Down given ctx. It runs the query across partitions,
+// page by page, and passes each item to patch, which returns the item's
+// partition key and the operations to apply to it, or nil operations to leave
+// it alone. The patches of a page run in transactional batches of up to 100
+// items sharing a partition key. After each page it saves the query's
+// continuation token as the migration's checkpoint, so a run that fails
+// resumes from the page it failed on: patch must be safe to repeat for the
+// items of that page. Call it once per migration, since the next call would
+// resume from where the last one finished.
+func (s *CosmosDBAdapter) MigrateItems(ctx context.Context, containerName string, query string, params []azcosmos.QueryParameter,
+	patch func(item map[string]any) (partitionKey string, ops *azcosmos.PatchOperations, err error)) error {
+	container, err := s.databaseClient.NewContainer(containerName)
+	if err != nil {
+		return fmt.Errorf("failed to create container client: %w", err)
+	}
+	checkpoint := CheckpointFromContext(ctx)
+	token, err := checkpoint.Load()
+	if err != nil {
+		return err
+	}
+	enableCrossPartition := true
+	options := &azcosmos.QueryOptions{QueryParameters: params, EnableCrossPartitionQuery: &enableCrossPartition}
+	if token != "" {
+		options.ContinuationToken = &token
+	}
+	pager := container.NewQueryItemsPager(query, azcosmos.NewPartitionKeyString(""), options)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to query container %s: %w", containerName, err)
+		}
+		s.reportCharge(ctx, opQuery, containerName, page.RequestCharge)
+
+		// Patches are grouped by partition key, since a batch only spans
+		// one, and a batch is executed once it is full.
+		batches := newPartitionBatches(cosmosBatchSize, func(pk string, patches []cosmosPatch) error {
+			batch := container.NewTransactionalBatch(azcosmos.NewPartitionKeyString(pk))
+			for _, p := range patches {
+				batch.PatchItem(p.id, p.ops, nil)
+			}
+			return s.executeBatch(ctx, container, batch)
+		})
+		for _, raw := range page.Items {
+			var item map[string]any
+			if err := json.Unmarshal(raw, &item); err != nil {
+				return fmt.Errorf("failed to unmarshal item: %w", err)
+			}
+			pk, ops, err := patch(item)
+			if err != nil {
+				return err
+			}
+			if ops == nil {
+				continue
+			}
+			if err := batches.add(pk, cosmosPatch{id: fmt.Sprint(item["id"]), ops: *ops}); err != nil {
+				return err
+			}
+		}
+		if err := batches.flush(); err != nil {
+			return err
+		}
+		next := ""
+		if page.ContinuationToken != nil {
+			next = *page.ContinuationToken
+		}
+		if err := checkpoint.Save(next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cosmosPatch is a patch of one item.
+type cosmosPatch struct {
+	id  string
+	ops azcosmos.PatchOperations
+}
+
+// partitionBatches groups values by partition key into batches of at most
+// size, executing each batch once: as it fills, or on flush.
+type partitionBatches[T any] struct {
+	size    int
+	execute func(pk string, batch []T) error
+	batches map[string][]T
+	keys    []string
+}
+
+func newPartitionBatches[T any](size int, execute func(pk string, batch []T) error) *partitionBatches[T] {
+	return &partitionBatches[T]{size: size, execute: execute, batches: map[string][]T{}}
+}
+
+// add adds v to the batch of pk, executing the batch when it is full.
+func (p *partitionBatches[T]) add(pk string, v T) error {
+	batch, ok := p.batches[pk]
+	if !ok {
+		p.keys = append(p.keys, pk)
+	}
+	batch = append(batch, v)
+	if len(batch) < p.size {
+		p.batches[pk] = batch
+		return nil
+	}
+	// The key stays in keys, so a later value of pk starts a new batch
+	// without being listed twice.
+	p.batches[pk] = nil
+	return p.execute(pk, batch)
+}
+
+// flush executes the batches that are not full, in the order their keys
+// were first added.
+func (p *partitionBatches[T]) flush() error {
+	for _, pk := range p.keys {
+		if batch := p.batches[pk]; len(batch) > 0 {
+			if err := p.execute(pk, batch); err != nil {
+				return err
+			}
+		}
+	}
+	p.batches = map[string][]T{}
+	p.keys = nil
+	return nil
+}
+
+// executeBatch executes a transactional batch, failing with the status of the
+// operation that made it roll back.
+func (s *CosmosDBAdapter) executeBatch(ctx context.Context, container *azcosmos.ContainerClient, batch azcosmos.TransactionalBatch) error {
+	response, err := container.ExecuteTransactionalBatch(ctx, batch, nil)
+	if err != nil {
+		return fmt.Errorf("failed to execute batch: %w", err)
+	}
+	s.reportCharge(ctx, opUpdate, container.ID(), response.RequestCharge)
+	if response.Success {
+		return nil
+	}
+	for i, result := range response.OperationResults {
+		if result.StatusCode != http.StatusFailedDependency {
+			return fmt.Errorf("failed to execute batch: operation %d failed with status %d", i, result.StatusCode)
+		}
+	}
+	return fmt.Errorf("failed to execute batch")
+}
+
+// cosmosMigrationLock is the lock item of the migration lock container.
+type cosmosMigrationLock struct {
+	Id        string `json:"id"`
+	Owner     string `json:"owner"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// TryLockMigrations keeps the lock as an item of the migration_lock
+// container, or of the container named by the migration_lock_container
+// setting, which it creates on first use. The item is created when missing,
+// replaced only when its lease expired, and refreshed and deleted only by its
+// owner; replacements are conditional on the item's ETag.
+func (s *CosmosDBAdapter) TryLockMigrations(ctx context.Context, owner string, lease time.Duration) (MigrationLock, error) {
+	name := s.config["migration_lock_container"]
+	if name == "" {
+		name = "migration_lock"
+	}
+	container, err := s.databaseClient.NewContainer(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container client: %w", err)
+	}
+	pk := azcosmos.NewPartitionKeyString(migrationLockID)
+	lockItem := func() []byte {
+		body, _ := json.Marshal(cosmosMigrationLock{Id: migrationLockID, Owner: owner, ExpiresAt: time.Now().Add(lease).UnixMilli()})
+		return body
+	}
+	// read returns the lock item and its ETag, or a nil item when there is
+	// none.
+	read := func(ctx context.Context) (*cosmosMigrationLock, *azcore.ETag, error) {
+		response, err := container.ReadItem(ctx, pk, migrationLockID, nil)
+		if cosmosStatus(err) == http.StatusNotFound {
+			return nil, nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		var current cosmosMigrationLock
+		if err := json.Unmarshal(response.Value, &current); err != nil {
+			return nil, nil, err
+		}
+		return &current, &response.ETag, nil
+	}
+
+	_, err = container.CreateItem(ctx, pk, lockItem(), nil)
+	if cosmosStatus(err) == http.StatusNotFound {
+		_, err = s.databaseClient.CreateContainer(ctx, azcosmos.ContainerProperties{
+			ID:                     name,
+			PartitionKeyDefinition: azcosmos.PartitionKeyDefinition{Paths: []string{"/id"}},
+		}, nil)
+		if err != nil && cosmosStatus(err) != http.StatusConflict {
+			return nil, fmt.Errorf("failed to create migration lock container %s: %w", name, err)
+		}
+		_, err = container.CreateItem(ctx, pk, lockItem(), nil)
+	}
+	if cosmosStatus(err) == http.StatusConflict {
+		current, etag, err := read(ctx)
+		if err != nil || current == nil || current.ExpiresAt >= time.Now().UnixMilli() {
+			return nil, err
+		}
+		_, err = container.ReplaceItem(ctx, pk, migrationLockID, lockItem(), &azcosmos.ItemOptions{IfMatchEtag: etag})
+		if cosmosStatus(err) == http.StatusPreconditionFailed {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to take the migration lock: %w", err)
+	}
+
+	return funcMigrationLock{
+		refresh: func(ctx context.Context) error {
+			current, etag, err := read(ctx)
+			if err != nil {
+				return err
+			}
+			if current == nil || current.Owner != owner {
+				return fmt.Errorf("migration lock of %s was taken over", owner)
+			}
+			_, err = container.ReplaceItem(ctx, pk, migrationLockID, lockItem(), &azcosmos.ItemOptions{IfMatchEtag: etag})
+			return err
+		},
+		release: func(ctx context.Context) error {
+			current, etag, err := read(ctx)
+			if err != nil || current == nil || current.Owner != owner {
+				return err
+			}
+			_, err = container.DeleteItem(ctx, pk, migrationLockID, &azcosmos.ItemOptions{IfMatchEtag: etag})
+			return err
+		},
+	}, nil
+}
+
+// cosmosStatus returns the HTTP status of a Cosmos DB error, or 0.
+func cosmosStatus(err error) int {
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.StatusCode
+	}
+	return 0
 }
 
 func (s *CosmosDBAdapter) Create(item any, params ...map[string]any) error {
@@ -181,7 +610,7 @@ func (s *CosmosDBAdapter) CreateContext(ctx context.Context, item any, params ..
 	containerName := s.getContainerName(item)
 	containerClient, err := s.databaseClient.NewContainer(containerName)
 	if err != nil {
-		return fmt.Errorf("failed to create container client: %v", err)
+		return fmt.Errorf("failed to create container client: %w", err)
 	}
 
 	// Convert item to map to work with individual fields
@@ -194,7 +623,7 @@ func (s *CosmosDBAdapter) CreateContext(ctx context.Context, item any, params ..
 
 	// Build partition key from params if provided
 	if pk, err := s.buildPartitionKey(paramMap); err != nil {
-		return fmt.Errorf("failed to build partition key: %v", err)
+		return fmt.Errorf("failed to build partition key: %w", err)
 	} else if pk != "" {
 		// Set the partition key value in the item
 		pkFieldName := s.getPartitionKeyFieldName(paramMap)
@@ -209,7 +638,7 @@ func (s *CosmosDBAdapter) CreateContext(ctx context.Context, item any, params ..
 	// Marshal item to JSON
 	itemBytes, err := json.Marshal(itemMap)
 	if err != nil {
-		return fmt.Errorf("failed to marshal item: %v", err)
+		return fmt.Errorf("failed to marshal item: %w", err)
 	}
 
 	// Get the partition key value from the item
@@ -228,14 +657,26 @@ func (s *CosmosDBAdapter) CreateContext(ctx context.Context, item any, params ..
 	partitionKey := azcosmos.NewPartitionKeyString(pkValue)
 
 	// Create item
-	_, err = containerClient.CreateItem(ctx, partitionKey, itemBytes, nil)
+	response, err := containerClient.CreateItem(ctx, partitionKey, itemBytes, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create item: %v", err)
+		return fmt.Errorf("failed to create item: %w", err)
 	}
+	s.reportCharge(ctx, opCreate, containerName, response.RequestCharge)
 
 	return nil
 }
 
+// CreateIfAbsentContext is CreateContext, which never overwrites: Cosmos DB
+// rejects an item whose id is taken in its partition with 409 Conflict.
+func (s *CosmosDBAdapter) CreateIfAbsentContext(ctx context.Context, item any, params ...map[string]any) error {
+	err := s.CreateContext(ctx, item, params...)
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusConflict {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
 func (s *CosmosDBAdapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
 	return s.GetContext(context.Background(), dest, filter, params...)
 }
@@ -251,7 +692,7 @@ func (s *CosmosDBAdapter) GetContext(ctx context.Context, dest any, filter map[s
 	containerName := s.getContainerName(dest)
 	containerClient, err := s.databaseClient.NewContainer(containerName)
 	if err != nil {
-		return fmt.Errorf("failed to create container client: %v", err)
+		return fmt.Errorf("failed to create container client: %w", err)
 	}
 
 	// Build query
@@ -272,7 +713,7 @@ func (s *CosmosDBAdapter) GetContext(ctx context.Context, dest any, filter map[s
 
 	// Add partition key condition if provided in params
 	if pk, err := s.buildPartitionKey(paramMap); err != nil {
-		return fmt.Errorf("failed to build partition key: %v", err)
+		return fmt.Errorf("failed to build partition key: %w", err)
 	} else if pk != "" {
 		pkFieldName := s.getPartitionKeyFieldName(paramMap)
 		paramName := fmt.Sprintf("@param%d", paramIndex)
@@ -294,9 +735,9 @@ func (s *CosmosDBAdapter) GetContext(ctx context.Context, dest any, filter map[s
 	}
 
 	// Execute query
-	page, err := s.executeQuery(ctx, containerClient, query, paramMap, queryOptions)
+	page, err := s.executeQuery(ctx, opGet, containerClient, query, paramMap, queryOptions)
 	if err != nil {
-		return fmt.Errorf("failed to execute query: %v", err)
+		return fmt.Errorf("failed to execute query: %w", err)
 	}
 
 	if len(page.Items) == 0 {
@@ -306,7 +747,7 @@ func (s *CosmosDBAdapter) GetContext(ctx context.Context, dest any, filter map[s
 	// Unmarshal first result
 	err = json.Unmarshal(page.Items[0], dest)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal result: %v", err)
+		return fmt.Errorf("failed to unmarshal result: %w", err)
 	}
 
 	return nil
@@ -327,7 +768,7 @@ func (s *CosmosDBAdapter) UpdateContext(ctx context.Context, item any, filter ma
 	containerName := s.getContainerName(item)
 	containerClient, err := s.databaseClient.NewContainer(containerName)
 	if err != nil {
-		return fmt.Errorf("failed to create container client: %v", err)
+		return fmt.Errorf("failed to create container client: %w", err)
 	}
 
 	// First get the item to update
@@ -368,7 +809,7 @@ func (s *CosmosDBAdapter) UpdateContext(ctx context.Context, item any, filter ma
 	if !exists {
 		// Check if partition key is provided in params
 		if paramPk, err := s.buildPartitionKey(paramMap); err != nil {
-			return fmt.Errorf("failed to build partition key: %v", err)
+			return fmt.Errorf("failed to build partition key: %w", err)
 		} else if paramPk != "" {
 			pk = paramPk
 			existingItemMap[pkFieldName] = pk
@@ -387,17 +828,18 @@ func (s *CosmosDBAdapter) UpdateContext(ctx context.Context, item any, filter ma
 	// Marshal updated item
 	itemBytes, err := json.Marshal(existingItemMap)
 	if err != nil {
-		return fmt.Errorf("failed to marshal item: %v", err)
+		return fmt.Errorf("failed to marshal item: %w", err)
 	}
 
 	// Create partition key
 	partitionKey := azcosmos.NewPartitionKeyString(pk.(string))
 
 	// Update item
-	_, err = containerClient.ReplaceItem(ctx, partitionKey, id.(string), itemBytes, nil)
+	response, err := containerClient.ReplaceItem(ctx, partitionKey, id.(string), itemBytes, nil)
 	if err != nil {
-		return fmt.Errorf("failed to update item: %v", err)
+		return fmt.Errorf("failed to update item: %w", err)
 	}
+	s.reportCharge(ctx, opUpdate, containerName, response.RequestCharge)
 
 	return nil
 }
@@ -417,7 +859,7 @@ func (s *CosmosDBAdapter) DeleteContext(ctx context.Context, item any, filter ma
 	containerName := s.getContainerName(item)
 	containerClient, err := s.databaseClient.NewContainer(containerName)
 	if err != nil {
-		return fmt.Errorf("failed to create container client: %v", err)
+		return fmt.Errorf("failed to create container client: %w", err)
 	}
 
 	id := filter["id"]
@@ -425,7 +867,7 @@ func (s *CosmosDBAdapter) DeleteContext(ctx context.Context, item any, filter ma
 	// Try to get partition key from params first
 	pk, err := s.buildPartitionKey(paramMap)
 	if err != nil {
-		return fmt.Errorf("failed to build partition key: %v", err)
+		return fmt.Errorf("failed to build partition key: %w", err)
 	}
 
 	// If no partition key from params, try to get from filter
@@ -442,10 +884,11 @@ func (s *CosmosDBAdapter) DeleteContext(ctx context.Context, item any, filter ma
 	partitionKey := azcosmos.NewPartitionKeyString(pk)
 
 	// Delete item
-	_, err = containerClient.DeleteItem(ctx, partitionKey, id.(string), nil)
+	response, err := containerClient.DeleteItem(ctx, partitionKey, id.(string), nil)
 	if err != nil {
-		return fmt.Errorf("failed to delete item: %v", err)
+		return fmt.Errorf("failed to delete item: %w", err)
 	}
+	s.reportCharge(ctx, opDelete, containerName, response.RequestCharge)
 
 	return nil
 }
@@ -462,7 +905,7 @@ func (s *CosmosDBAdapter) ListContext(ctx context.Context, dest any, sortKey str
 		return "", fmt.Errorf("failed to list: %w", err)
 	}
 
-	return s.executePaginatedQuery(ctx, dest, sortKey, sortDirection, limit, cursor, filter, params...)
+	return s.executePaginatedQuery(ctx, opList, dest, sortKey, sortDirection, limit, cursor, filter, params...)
 }
 
 func (s *CosmosDBAdapter) Search(dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
@@ -483,7 +926,7 @@ func (s *CosmosDBAdapter) SearchContext(ctx context.Context, dest any, sortKey s
 	}
 
 	// Use executePaginatedQuery with empty filter (the query parameter is ignored for CosmosDB)
-	return s.executePaginatedQuery(ctx, dest, sortKey, sortDirection, limit, cursor, map[string]any{}, params...)
+	return s.executePaginatedQuery(ctx, opSearch, dest, sortKey, sortDirection, limit, cursor, map[string]any{}, params...)
 }
 
 func (s *CosmosDBAdapter) Count(dest any, filter map[string]any, params ...map[string]any) (int64, error) {
@@ -496,6 +939,154 @@ func (s *CosmosDBAdapter) CountContext(ctx context.Context, dest any, filter map
 	return total, nil
 }
 
+func (s *CosmosDBAdapter) Aggregate(model any, filter any, groupBy []string, metrics []AggSpec, params ...map[string]any) ([]AggregateRow, error) {
+	return s.AggregateContext(context.Background(), model, filter, groupBy, metrics, params...)
+}
+
+// AggregateContext translates the request into a Cosmos SQL GROUP BY query.
+// This adapter has no Lucene compiler, so a Lucene filter is evaluated in
+// process (see lucene.Matcher) over the items read, which are then folded as
+// they are without a partition key.
+func (s *CosmosDBAdapter) AggregateContext(ctx context.Context, model any, filter any, groupBy []string, metrics []AggSpec, params ...map[string]any) ([]AggregateRow, error) {
+	if err := validateAggregate(groupBy, metrics); err != nil {
+		return nil, &serviceErrors.BadRequest{Message: err.Error()}
+	}
+	filterMap, query, err := aggregateFilter(filter)
+	if err != nil {
+		return nil, &serviceErrors.BadRequest{Message: err.Error()}
+	}
+	matcher, err := aggregateMatcher(model, query)
+	if err != nil {
+		return nil, err
+	}
+
+	paramMap := extractParams(params...)
+
+	containerClient, err := s.databaseClient.NewContainer(s.getContainerName(model))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container client: %w", err)
+	}
+
+	pk, err := s.buildPartitionKey(paramMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build partition key: %w", err)
+	}
+	if pk == "" || matcher != nil {
+		// The gateway cannot serve cross-partition GROUP BY or aggregates,
+		// and Cosmos SQL cannot express a Lucene filter, so read the
+		// matching items and fold them as DynamoDB does.
+		statement, queryParams := s.buildAggregateScan(filterMap)
+		queryOptions := &azcosmos.QueryOptions{QueryParameters: queryParams}
+		if pk == "" {
+			enableCrossPartition := true
+			queryOptions.EnableCrossPartitionQuery = &enableCrossPartition
+		}
+		items, err := s.queryAll(ctx, containerClient, statement, azcosmos.NewPartitionKeyString(pk), queryOptions, aggregateScanLimit(paramMap))
+		if err != nil {
+			return nil, err
+		}
+		if matcher != nil {
+			items = slices.DeleteFunc(items, func(item map[string]any) bool { return !matcher.Match(item) })
+		}
+		return foldAggregate(items, groupBy, metrics), nil
+	}
+
+	statement, queryParams, err := s.buildAggregateQuery(filterMap, groupBy, metrics, paramMap)
+	if err != nil {
+		return nil, err
+	}
+	// GROUP BY results can span pages, so drain the pager.
+	rows, err := s.queryAll(ctx, containerClient, statement, azcosmos.NewPartitionKeyString(pk), &azcosmos.QueryOptions{QueryParameters: queryParams}, math.MaxInt)
+	if err != nil {
+		return nil, err
+	}
+	return splitAggregateRows(rows, groupBy), nil
+}
+
+// queryAll drains a query's pages for AggregateContext. It fails with
+// ErrAggregateScanLimit once more than limit items come back.
+func (s *CosmosDBAdapter) queryAll(ctx context.Context, containerClient *azcosmos.ContainerClient, statement string, pk azcosmos.PartitionKey, queryOptions *azcosmos.QueryOptions, limit int) ([]map[string]any, error) {
+	rows := []map[string]any{}
+	pager := containerClient.NewQueryItemsPager(statement, pk, queryOptions)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute query: %w", err)
+		}
+		s.reportCharge(ctx, opAggregate, containerClient.ID(), page.RequestCharge)
+		for _, item := range page.Items {
+			row := map[string]any{}
+			if err := json.Unmarshal(item, &row); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal result: %w", err)
+			}
+			rows = append(rows, row)
+		}
+		if len(rows) > limit {
+			return nil, ErrAggregateScanLimit
+		}
+	}
+	return rows, nil
+}
+
+// buildAggregateScan renders the cross-partition query that reads the items
+// an AggregateContext without a partition key folds.
+func (s *CosmosDBAdapter) buildAggregateScan(filter map[string]any) (string, []azcosmos.QueryParameter) {
+	query := "SELECT * FROM c"
+	paramIndex := 1
+	filterClause, queryParams := s.buildFilter(filter, &paramIndex)
+	if filterClause != "" {
+		query += " WHERE " + filterClause
+	}
+	return query, queryParams
+}
+
+// buildAggregateQuery renders the Cosmos SQL statement for AggregateContext.
+// Identifiers have already been validated by validateAggregate.
+func (s *CosmosDBAdapter) buildAggregateQuery(filter map[string]any, groupBy []string, metrics []AggSpec, paramMap map[string]any) (string, []azcosmos.QueryParameter, error) {
+	selects := make([]string, 0, len(groupBy)+len(metrics))
+	for _, g := range groupBy {
+		selects = append(selects, fmt.Sprintf("c.%s AS %s", g, g))
+	}
+	for _, m := range metrics {
+		arg := "1"
+		if m.Field != "" {
+			arg = "c." + m.Field
+		}
+		selects = append(selects, fmt.Sprintf("%s(%s) AS %s", strings.ToUpper(string(m.Func)), arg, m.alias()))
+	}
+	query := "SELECT " + strings.Join(selects, ", ") + " FROM c"
+
+	conditions := []string{}
+	queryParams := []azcosmos.QueryParameter{}
+	paramIndex := 1
+	if len(filter) > 0 {
+		filterClause, filterParams := s.buildFilter(filter, &paramIndex)
+		if filterClause != "" {
+			conditions = append(conditions, filterClause)
+			queryParams = append(queryParams, filterParams...)
+		}
+	}
+	if pk, err := s.buildPartitionKey(paramMap); err != nil {
+		return "", nil, fmt.Errorf("failed to build partition key: %w", err)
+	} else if pk != "" {
+		paramName := fmt.Sprintf("@param%d", paramIndex)
+		conditions = append(conditions, fmt.Sprintf("c.%s = %s", s.getPartitionKeyFieldName(paramMap), paramName))
+		queryParams = append(queryParams, azcosmos.QueryParameter{Name: paramName, Value: pk})
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	if len(groupBy) > 0 {
+		refs := make([]string, len(groupBy))
+		for i, g := range groupBy {
+			refs[i] = "c." + g
+		}
+		query += " GROUP BY " + strings.Join(refs, ", ")
+	}
+	return query, queryParams, nil
+}
+
 func (s *CosmosDBAdapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
 	return s.QueryContext(context.Background(), dest, statement, limit, cursor, params...)
 }
@@ -508,7 +1099,7 @@ func (s *CosmosDBAdapter) QueryContext(ctx context.Context, dest any, statement
 	containerName := s.getContainerName(dest)
 	containerClient, err := s.databaseClient.NewContainer(containerName)
 	if err != nil {
-		return "", fmt.Errorf("failed to create container client: %v", err)
+		return "", fmt.Errorf("failed to create container client: %w", err)
 	}
 
 	// Set up query options
@@ -530,8 +1121,9 @@ func (s *CosmosDBAdapter) QueryContext(ctx context.Context, dest any, statement
 	// Get first page
 	page, err := pager.NextPage(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to execute query: %v", err)
+		return "", fmt.Errorf("failed to execute query: %w", err)
 	}
+	s.reportCharge(ctx, opQuery, containerName, page.RequestCharge)
 
 	// Process results
 	var results []json.RawMessage
@@ -543,11 +1135,11 @@ func (s *CosmosDBAdapter) QueryContext(ctx context.Context, dest any, statement
 	if len(results) > 0 {
 		resultsJSON, err := json.Marshal(results)
 		if err != nil {
-			return "", fmt.Errorf("failed to marshal results: %v", err)
+			return "", fmt.Errorf("failed to marshal results: %w", err)
 		}
 		err = json.Unmarshal(resultsJSON, dest)
 		if err != nil {
-			return "", fmt.Errorf("failed to unmarshal results: %v", err)
+			return "", fmt.Errorf("failed to unmarshal results: %w", err)
 		}
 	}
 
@@ -565,6 +1157,7 @@ func (s *CosmosDBAdapter) QueryContext(ctx context.Context, dest any, statement
 // can cancel or deadline the operation and so tracing instrumentation has a parent span to use.
 func (s *CosmosDBAdapter) executePaginatedQuery(
 	ctx context.Context,
+	op string,
 	dest any,
 	sortKey string,
 	sortDirection SortingDirection,
@@ -583,7 +1176,7 @@ func (s *CosmosDBAdapter) executePaginatedQuery(
 	containerName := s.getContainerName(dest)
 	containerClient, err := s.databaseClient.NewContainer(containerName)
 	if err != nil {
-		return "", fmt.Errorf("failed to create container client: %v", err)
+		return "", fmt.Errorf("failed to create container client: %w", err)
 	}
 
 	// Build base query
@@ -605,7 +1198,7 @@ func (s *CosmosDBAdapter) executePaginatedQuery(
 
 	// Add partition key condition if provided in params
 	if pk, err := s.buildPartitionKey(paramMap); err != nil {
-		return "", fmt.Errorf("failed to build partition key: %v", err)
+		return "", fmt.Errorf("failed to build partition key: %w", err)
 	} else if pk != "" {
 		pkFieldName := s.getPartitionKeyFieldName(paramMap)
 		paramName := fmt.Sprintf("@param%d", paramIndex)
@@ -642,9 +1235,9 @@ func (s *CosmosDBAdapter) executePaginatedQuery(
 	}
 
 	// Execute query
-	page, err := s.executeQuery(ctx, containerClient, query, paramMap, queryOptions)
+	page, err := s.executeQuery(ctx, op, containerClient, query, paramMap, queryOptions)
 	if err != nil {
-		return "", fmt.Errorf("failed to execute query: %v", err)
+		return "", fmt.Errorf("failed to execute query: %w", err)
 	}
 
 	// Process results
@@ -657,11 +1250,11 @@ func (s *CosmosDBAdapter) executePaginatedQuery(
 	if len(results) > 0 {
 		resultsJSON, err := json.Marshal(results)
 		if err != nil {
-			return "", fmt.Errorf("failed to marshal results: %v", err)
+			return "", fmt.Errorf("failed to marshal results: %w", err)
 		}
 		err = json.Unmarshal(resultsJSON, dest)
 		if err != nil {
-			return "", fmt.Errorf("failed to unmarshal results: %v", err)
+			return "", fmt.Errorf("failed to unmarshal results: %w", err)
 		}
 	}
 
@@ -674,6 +1267,16 @@ func (s *CosmosDBAdapter) executePaginatedQuery(
 	return nextCursor, nil
 }
 
+// TableName implements CapacityReporter.
+func (s *CosmosDBAdapter) TableName(model any) string {
+	return s.getContainerName(model)
+}
+
+// reportCharge reports the request units Cosmos DB charged for a request.
+func (s *CosmosDBAdapter) reportCharge(ctx context.Context, op string, container string, charge float32) {
+	ReportCapacity(ctx, string(COSMOSDB_PROVIDER), op, container, float64(charge))
+}
+
 func (s *CosmosDBAdapter) getContainerName(obj any) string {
 	// Get the type of obj
 	tableName := ""
@@ -733,6 +1336,7 @@ func (s *CosmosDBAdapter) getPartitionKeyFieldName(paramMap map[string]any) stri
 // executeQuery executes a query and handles single-partition vs cross-partition logic
 func (s *CosmosDBAdapter) executeQuery(
 	ctx context.Context,
+	op string,
 	containerClient *azcosmos.ContainerClient,
 	query string,
 	paramMap map[string]any,
@@ -741,7 +1345,7 @@ func (s *CosmosDBAdapter) executeQuery(
 	// Determine if we need cross-partition query
 	pk, err := s.buildPartitionKey(paramMap)
 	if err != nil {
-		return azcosmos.QueryItemsResponse{}, fmt.Errorf("failed to build partition key: %v", err)
+		return azcosmos.QueryItemsResponse{}, fmt.Errorf("failed to build partition key: %w", err)
 	}
 
 	// Get first page
@@ -757,6 +1361,9 @@ func (s *CosmosDBAdapter) executeQuery(
 		pager := containerClient.NewQueryItemsPager(query, azcosmos.NewPartitionKeyString(""), queryOptions)
 		page, err = pager.NextPage(ctx)
 	}
+	if err == nil {
+		s.reportCharge(ctx, op, containerClient.ID(), page.RequestCharge)
+	}
 
 	return page, err
 }
@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"fmt"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -190,24 +192,15 @@ func TestCosmosDBTrivialGettersAndUnsupportedOps(t *testing.T) {
 		t.Fatalf("GetSchemaName = %q; want %q", got, "demo")
 	}
 
-	// CreateSchema is a compatibility no-op; the rest are
-	// documented as unsupported and must surface that as
-	// errors (and not a silent nil).
+	// CreateSchema is a compatibility no-op; Execute is
+	// documented as unsupported and must surface that as an
+	// error (and not a silent nil).
 	if err := s.CreateSchema(); err != nil {
 		t.Fatalf("CreateSchema must be a no-op, got %v", err)
 	}
 	if err := s.Execute("SELECT 1"); err == nil {
 		t.Fatalf("Execute must return an unsupported error")
 	}
-	if err := s.CreateMigrationTable(); err == nil {
-		t.Fatalf("CreateMigrationTable must return an error")
-	}
-	if err := s.UpdateMigrationTable(1, "x", "y"); err == nil {
-		t.Fatalf("UpdateMigrationTable must return an error")
-	}
-	if _, err := s.GetLatestMigration(); err == nil {
-		t.Fatalf("GetLatestMigration must return an error")
-	}
 }
 
 func TestCosmosDBBuildFilterMixedConditionsAreAndJoined(t *testing.T) {
@@ -222,3 +215,26 @@ func TestCosmosDBBuildFilterMixedConditionsAreAndJoined(t *testing.T) {
 		t.Fatalf("expected clauses joined by AND, got %q", clause)
 	}
 }
+
+func TestPartitionBatchesExecuteEachPatchOnce(t *testing.T) {
+	var executed []string
+	batches := newPartitionBatches(2, func(pk string, batch []int) error {
+		executed = append(executed, fmt.Sprint(pk, batch))
+		return nil
+	})
+	// a fills a batch, then starts another after b.
+	for i, pk := range []string{"a", "a", "b", "a"} {
+		if err := batches.add(pk, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batches.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a[0 1]", "a[3]", "b[2]"}; !slices.Equal(executed, want) {
+		t.Fatalf("executed = %v; want %v", executed, want)
+	}
+	if err := batches.flush(); err != nil || len(executed) != 3 {
+		t.Fatalf("a second flush executed %v, %v", executed, err)
+	}
+}
@@ -2,12 +2,17 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"reflect"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -16,6 +21,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 
+	serviceErrors "github.com/tink3rlabs/magic/errors"
 	"github.com/tink3rlabs/magic/logger"
 	"github.com/tink3rlabs/magic/storage/search/lucene"
 )
@@ -23,6 +29,8 @@ import (
 type DynamoDBAdapter struct {
 	DB     *dynamodb.Client
 	config map[string]string
+	// migrationNamespace names the migrations table, see MigrationNamespace.
+	migrationNamespace string
 }
 
 var dynamoDBAdapterLock = &sync.Mutex{}
@@ -75,10 +83,14 @@ func (s *DynamoDBAdapter) Execute(statement string) error {
 }
 
 func (s *DynamoDBAdapter) ExecuteContext(ctx context.Context, statement string) error {
-	_, err := s.DB.ExecuteStatement(ctx, &dynamodb.ExecuteStatementInput{Statement: &statement})
+	response, err := s.DB.ExecuteStatement(ctx, &dynamodb.ExecuteStatementInput{
+		Statement:              &statement,
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to execute statement %s: %v", statement, err)
+		return fmt.Errorf("failed to execute statement %s: %w", statement, err)
 	}
+	s.reportConsumed(ctx, opExecute, response.ConsumedCapacity)
 	return nil
 }
 
@@ -104,20 +116,331 @@ func (s *DynamoDBAdapter) GetSchemaName() string {
 	return ""
 }
 
+// CreateSchema is a no-op: DynamoDB tables belong to the account, not to a
+// schema.
 func (s *DynamoDBAdapter) CreateSchema() error {
-	return fmt.Errorf("DynamoDB CreateSchema is not supported")
+	return nil
+}
+
+// migrationTable is the table migrations are recorded in, migrations unless
+// the migration_table setting names another.
+func (s *DynamoDBAdapter) migrationTable() string {
+	table := s.config["migration_table"]
+	if table == "" {
+		table = "migrations"
+	}
+	return namespacedMigrationTable(table, s.migrationNamespace)
+}
+
+// MigrationNamespace returns a copy of the adapter, sharing its client, that
+// records migrations in the namespace's migrations table.
+func (s *DynamoDBAdapter) MigrationNamespace(namespace string) StorageAdapter {
+	return &DynamoDBAdapter{DB: s.DB, config: s.config, migrationNamespace: namespace}
 }
 
+// CreateMigrationTable creates the migrations table, keyed by the numeric
+// migration id, when it does not exist.
 func (s *DynamoDBAdapter) CreateMigrationTable() error {
-	return fmt.Errorf("DynamoDB CreateMigrationTable is not supported")
+	ctx := context.Background()
+	table := s.migrationTable()
+	_, err := s.DB.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	var missing *types.ResourceNotFoundException
+	if !errors.As(err, &missing) {
+		return err
+	}
+	return s.createTable(ctx, table, types.ScalarAttributeTypeN)
 }
 
 func (s *DynamoDBAdapter) UpdateMigrationTable(id int, name string, desc string) error {
-	return fmt.Errorf("DynamoDB UpgradeMigrationTable is not supported")
+	return s.RecordMigration(AppliedMigration{ID: id, Name: name, Description: desc})
+}
+
+// migrationKey is the key of a migration's item in the migrations table.
+func migrationKey(id int) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{"id": &types.AttributeValueMemberN{Value: strconv.Itoa(id)}}
+}
+
+// RecordMigration puts the migration's item, replacing the checkpoint item a
+// data migration saved while it ran.
+func (s *DynamoDBAdapter) RecordMigration(applied AppliedMigration) error {
+	if applied.AppliedAt.IsZero() {
+		applied.AppliedAt = time.Now()
+	}
+	item := migrationKey(applied.ID)
+	item["name"] = &types.AttributeValueMemberS{Value: applied.Name}
+	item["description"] = &types.AttributeValueMemberS{Value: applied.Description}
+	item["checksum"] = &types.AttributeValueMemberS{Value: applied.Checksum}
+	item["timestamp"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(applied.AppliedAt.UnixMilli(), 10)}
+	_, err := s.DB.PutItem(context.Background(), &dynamodb.PutItemInput{TableName: aws.String(s.migrationTable()), Item: item})
+	if err != nil {
+		return fmt.Errorf("failed to record migration %s: %w", applied.Name, err)
+	}
+	return nil
 }
 
+// AppliedMigrations scans the migrations table. Items of migrations that only
+// saved a checkpoint have no timestamp and are not applied. A missing table
+// has no applied migrations.
+func (s *DynamoDBAdapter) AppliedMigrations() ([]AppliedMigration, error) {
+	paginator := dynamodb.NewScanPaginator(s.DB, &dynamodb.ScanInput{
+		TableName:                aws.String(s.migrationTable()),
+		FilterExpression:         aws.String("attribute_exists(#ts)"),
+		ExpressionAttributeNames: map[string]string{"#ts": "timestamp"},
+		ConsistentRead:           aws.Bool(true),
+	})
+	applied := []AppliedMigration{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		var missing *types.ResourceNotFoundException
+		if errors.As(err, &missing) {
+			return applied, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan the migrations table: %w", err)
+		}
+		for _, item := range page.Items {
+			var row struct {
+				Id          int    `json:"id"`
+				Name        string `json:"name"`
+				Description string `json:"description"`
+				Checksum    string `json:"checksum"`
+				Timestamp   int64  `json:"timestamp"`
+			}
+			err := attributevalue.UnmarshalMapWithOptions(item, &row, func(eo *attributevalue.DecoderOptions) { eo.TagKey = "json" })
+			if err != nil {
+				return nil, fmt.Errorf("failed to unmarshal migration: %w", err)
+			}
+			applied = append(applied, AppliedMigration{
+				ID:          row.Id,
+				Name:        row.Name,
+				Description: row.Description,
+				Checksum:    row.Checksum,
+				AppliedAt:   time.UnixMilli(row.Timestamp),
+			})
+		}
+	}
+	slices.SortFunc(applied, func(a, b AppliedMigration) int { return a.ID - b.ID })
+	return applied, nil
+}
+
+// GetLatestMigration returns the highest applied migration id, or 0 when none
+// has been applied.
 func (s *DynamoDBAdapter) GetLatestMigration() (int, error) {
-	return -1, fmt.Errorf("DynamoDB GetLatestMigration is not supported")
+	applied, err := s.AppliedMigrations()
+	if err != nil || len(applied) == 0 {
+		return 0, err
+	}
+	return applied[len(applied)-1].ID, nil
+}
+
+func (s *DynamoDBAdapter) DeleteMigration(id int) error {
+	_, err := s.DB.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.migrationTable()),
+		Key:       migrationKey(id),
+	})
+	return err
+}
+
+// MigrationCheckpoint reads the checkpoint attribute of the migration's item.
+func (s *DynamoDBAdapter) MigrationCheckpoint(id int) (string, error) {
+	response, err := s.DB.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName:      aws.String(s.migrationTable()),
+		Key:            migrationKey(id),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	if checkpoint, ok := response.Item["checkpoint"].(*types.AttributeValueMemberS); ok {
+		return checkpoint.Value, nil
+	}
+	return "", nil
+}
+
+// SaveMigrationCheckpoint sets the checkpoint attribute of the migration's
+// item, creating the item when the migration is not applied yet.
+func (s *DynamoDBAdapter) SaveMigrationCheckpoint(id int, checkpoint string) error {
+	_, err := s.DB.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(s.migrationTable()),
+		Key:                       migrationKey(id),
+		UpdateExpression:          aws.String("SET #checkpoint = :checkpoint"),
+		ExpressionAttributeNames:  map[string]string{"#checkpoint": "checkpoint"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":checkpoint": &types.AttributeValueMemberS{Value: checkpoint}},
+	})
+	return err
+}
+
+// dynamoBatchSize is the most statements BatchExecuteStatement takes.
+const dynamoBatchSize = 25
+
+// MigrateItems backfills the items a PartiQL statement selects, for a Go
+// migration's Up or Down given ctx. It reads the statement page by page, with
+// params bound to its ? placeholders, passes each item to update and runs the
+// statements update returns with BatchExecuteStatement, 25 at a time. After
+// each page it saves the next page's token as the migration's checkpoint, so a
+// run that fails resumes from the page it failed on: update must be safe to
+// repeat for the items of that page. Call it once per migration, since the
+// next call would resume from where the last one finished.
+func (s *DynamoDBAdapter) MigrateItems(ctx context.Context, statement string, params []types.AttributeValue,
+	update func(item map[string]types.AttributeValue) ([]types.BatchStatementRequest, error)) error {
+	checkpoint := CheckpointFromContext(ctx)
+	token, err := checkpoint.Load()
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.ExecuteStatementInput{
+		Statement:              aws.String(statement),
+		Parameters:             params,
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+	}
+	if token != "" {
+		input.NextToken = aws.String(token)
+	}
+	for {
+		response, err := s.DB.ExecuteStatement(ctx, input)
+		if err != nil {
+			return fmt.Errorf("failed to execute statement %s: %w", statement, err)
+		}
+		s.reportConsumed(ctx, opQuery, response.ConsumedCapacity)
+		var requests []types.BatchStatementRequest
+		for _, item := range response.Items {
+			statements, err := update(item)
+			if err != nil {
+				return err
+			}
+			requests = append(requests, statements...)
+		}
+		for batch := range slices.Chunk(requests, dynamoBatchSize) {
+			if err := s.executeBatch(ctx, batch); err != nil {
+				return err
+			}
+		}
+		if err := checkpoint.Save(aws.ToString(response.NextToken)); err != nil {
+			return err
+		}
+		if response.NextToken == nil {
+			return nil
+		}
+		input.NextToken = response.NextToken
+	}
+}
+
+// executeBatch runs a batch of statements, failing on the first statement
+// DynamoDB rejected.
+func (s *DynamoDBAdapter) executeBatch(ctx context.Context, batch []types.BatchStatementRequest) error {
+	response, err := s.DB.BatchExecuteStatement(ctx, &dynamodb.BatchExecuteStatementInput{
+		Statements:             batch,
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to execute batch: %w", err)
+	}
+	for i := range response.ConsumedCapacity {
+		s.reportConsumed(ctx, opUpdate, &response.ConsumedCapacity[i])
+	}
+	for i, result := range response.Responses {
+		if result.Error != nil {
+			return fmt.Errorf("failed to execute statement %s: %s: %s", aws.ToString(batch[i].Statement), result.Error.Code, aws.ToString(result.Error.Message))
+		}
+	}
+	return nil
+}
+
+// migrationLockID is the key of the lock item on the stores that keep the
+// migration lock as an item.
+const migrationLockID = "migrations"
+
+// TryLockMigrations keeps the lock as an item of the migration_lock table, or
+// of the table named by the migration_lock_table setting, which it creates on
+// first use. The item is put only when it is missing or its lease expired, and
+// refreshed and deleted only by its owner.
+func (s *DynamoDBAdapter) TryLockMigrations(ctx context.Context, owner string, lease time.Duration) (MigrationLock, error) {
+	table := s.config["migration_lock_table"]
+	if table == "" {
+		table = "migration_lock"
+	}
+	millis := func(t time.Time) types.AttributeValue {
+		return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.UnixMilli(), 10)}
+	}
+	key := map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: migrationLockID}}
+	names := map[string]string{"#owner": "owner", "#expires": "expires_at"}
+	put := func() error {
+		now := time.Now()
+		_, err := s.DB.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(table),
+			Item: map[string]types.AttributeValue{
+				"id":         key["id"],
+				"owner":      &types.AttributeValueMemberS{Value: owner},
+				"expires_at": millis(now.Add(lease)),
+			},
+			ConditionExpression:       aws.String("attribute_not_exists(id) OR #expires < :now"),
+			ExpressionAttributeNames:  map[string]string{"#expires": "expires_at"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{":now": millis(now)},
+		})
+		return err
+	}
+
+	err := put()
+	var missing *types.ResourceNotFoundException
+	if errors.As(err, &missing) {
+		if err := s.createTable(ctx, table, types.ScalarAttributeTypeS); err != nil {
+			return nil, err
+		}
+		err = put()
+	}
+	var held *types.ConditionalCheckFailedException
+	if errors.As(err, &held) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to put the migration lock: %w", err)
+	}
+
+	ownedBy := map[string]types.AttributeValue{":owner": &types.AttributeValueMemberS{Value: owner}}
+	return funcMigrationLock{
+		refresh: func(ctx context.Context) error {
+			values := maps.Clone(ownedBy)
+			values[":expires"] = millis(time.Now().Add(lease))
+			_, err := s.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName:                 aws.String(table),
+				Key:                       key,
+				UpdateExpression:          aws.String("SET #expires = :expires"),
+				ConditionExpression:       aws.String("#owner = :owner"),
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			})
+			return err
+		},
+		release: func(ctx context.Context) error {
+			_, err := s.DB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+				TableName:                 aws.String(table),
+				Key:                       key,
+				ConditionExpression:       aws.String("#owner = :owner"),
+				ExpressionAttributeNames:  map[string]string{"#owner": "owner"},
+				ExpressionAttributeValues: ownedBy,
+			})
+			if errors.As(err, &held) {
+				return nil
+			}
+			return err
+		},
+	}, nil
+}
+
+// createTable creates an on-demand table keyed by an id of type key, and waits
+// for it to become active.
+func (s *DynamoDBAdapter) createTable(ctx context.Context, table string, key types.ScalarAttributeType) error {
+	_, err := s.DB.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:            aws.String(table),
+		AttributeDefinitions: []types.AttributeDefinition{{AttributeName: aws.String("id"), AttributeType: key}},
+		KeySchema:            []types.KeySchemaElement{{AttributeName: aws.String("id"), KeyType: types.KeyTypeHash}},
+		BillingMode:          types.BillingModePayPerRequest,
+	})
+	var exists *types.ResourceInUseException
+	if err != nil && !errors.As(err, &exists) {
+		return fmt.Errorf("failed to create table %s: %w", table, err)
+	}
+	return dynamodb.NewTableExistsWaiter(s.DB).Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)}, 2*time.Minute)
 }
 
 func (s *DynamoDBAdapter) Create(item any, params ...map[string]any) error {
@@ -125,23 +448,82 @@ func (s *DynamoDBAdapter) Create(item any, params ...map[string]any) error {
 }
 
 func (s *DynamoDBAdapter) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	return s.putItem(ctx, opCreate, item)
+}
+
+// putItem writes item whole, which is what both Create and Update do.
+func (s *DynamoDBAdapter) putItem(ctx context.Context, op string, item any) error {
 	i, err := attributevalue.MarshalMapWithOptions(item, func(eo *attributevalue.EncoderOptions) { eo.TagKey = "json" })
 	if err != nil {
-		return fmt.Errorf("failed to marshal input item into dynamodb item, %v", err)
+		return fmt.Errorf("failed to marshal input item into dynamodb item, %w", err)
 	}
 
-	_, err = s.DB.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(s.getTableName(item)),
-		Item:      i,
+	response, err := s.DB.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:              aws.String(s.getTableName(item)),
+		Item:                   i,
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to create or update item: %v", err)
+		return fmt.Errorf("failed to create or update item: %w", err)
 	}
+	s.reportConsumed(ctx, op, response.ConsumedCapacity)
+
+	return nil
+}
 
+// dynamoDBPartitionKeys caches the partition key attribute of each table
+// CreateIfAbsentContext writes to.
+var dynamoDBPartitionKeys sync.Map
+
+// CreateIfAbsentContext puts item on the condition that no item has its key.
+// The key attribute is read from the table's key schema once per table.
+func (s *DynamoDBAdapter) CreateIfAbsentContext(ctx context.Context, item any, params ...map[string]any) error {
+	table := s.getTableName(item)
+	key, err := s.partitionKey(ctx, table)
+	if err != nil {
+		return err
+	}
+	i, err := attributevalue.MarshalMapWithOptions(item, func(eo *attributevalue.EncoderOptions) { eo.TagKey = "json" })
+	if err != nil {
+		return fmt.Errorf("failed to marshal input item into dynamodb item, %w", err)
+	}
+	response, err := s.DB.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(table),
+		Item:                     i,
+		ConditionExpression:      aws.String("attribute_not_exists(#key)"),
+		ExpressionAttributeNames: map[string]string{"#key": key},
+		ReturnConsumedCapacity:   types.ReturnConsumedCapacityTotal,
+	})
+	var exists *types.ConditionalCheckFailedException
+	if errors.As(err, &exists) {
+		return ErrAlreadyExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create item: %w", err)
+	}
+	s.reportConsumed(ctx, opCreate, response.ConsumedCapacity)
 	return nil
 }
 
+// partitionKey returns the name of table's partition key attribute.
+func (s *DynamoDBAdapter) partitionKey(ctx context.Context, table string) (string, error) {
+	if key, ok := dynamoDBPartitionKeys.Load(table); ok {
+		return key.(string), nil
+	}
+	described, err := s.DB.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe table %s: %w", table, err)
+	}
+	for _, k := range described.Table.KeySchema {
+		if k.KeyType == types.KeyTypeHash {
+			dynamoDBPartitionKeys.Store(table, aws.ToString(k.AttributeName))
+			return aws.ToString(k.AttributeName), nil
+		}
+	}
+	return "", fmt.Errorf("table %s has no partition key", table)
+}
+
 func (s *DynamoDBAdapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
 	return s.GetContext(context.Background(), dest, filter, params...)
 }
@@ -149,24 +531,26 @@ func (s *DynamoDBAdapter) Get(dest any, filter map[string]any, params ...map[str
 func (s *DynamoDBAdapter) GetContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error {
 	key, err := attributevalue.MarshalMapWithOptions(filter, func(eo *attributevalue.EncoderOptions) { eo.TagKey = "json" })
 	if err != nil {
-		return fmt.Errorf("failed to marshal item id into dynamodb attribute, %v", err)
+		return fmt.Errorf("failed to marshal item id into dynamodb attribute, %w", err)
 	}
 
 	response, err := s.DB.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(s.getTableName(dest)),
-		Key:       key,
+		TableName:              aws.String(s.getTableName(dest)),
+		Key:                    key,
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to get item, %v", err)
+		return fmt.Errorf("failed to get item, %w", err)
 	}
+	s.reportConsumed(ctx, opGet, response.ConsumedCapacity)
 
 	if response.Item == nil {
 		return ErrNotFound
 	} else {
 		err = attributevalue.UnmarshalMapWithOptions(response.Item, &dest, func(eo *attributevalue.DecoderOptions) { eo.TagKey = "json" })
 		if err != nil {
-			return fmt.Errorf("failed to unmarshal dynamodb Get result into dest, %v", err)
+			return fmt.Errorf("failed to unmarshal dynamodb Get result into dest, %w", err)
 		}
 
 		return nil
@@ -177,8 +561,71 @@ func (s *DynamoDBAdapter) Update(item any, filter map[string]any, params ...map[
 	return s.UpdateContext(context.Background(), item, filter, params...)
 }
 
+// UpdateContext puts item on the condition that an item with its key exists
+// and matches filter. It fails with ErrNotFound, and writes nothing, otherwise.
 func (s *DynamoDBAdapter) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
-	return s.CreateContext(ctx, item)
+	if len(filter) == 0 {
+		return errors.New("filtering is required when updating a resource")
+	}
+	table := s.getTableName(item)
+	key, err := s.partitionKey(ctx, table)
+	if err != nil {
+		return err
+	}
+	i, err := attributevalue.MarshalMapWithOptions(item, func(eo *attributevalue.EncoderOptions) { eo.TagKey = "json" })
+	if err != nil {
+		return fmt.Errorf("failed to marshal input item into dynamodb item, %w", err)
+	}
+	condition, names, values, err := dynamoDBFilterCondition(filter)
+	if err != nil {
+		return err
+	}
+	names["#key"] = key
+	response, err := s.DB.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(table),
+		Item:                      i,
+		ConditionExpression:       aws.String("attribute_exists(#key) AND " + condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnConsumedCapacity:    types.ReturnConsumedCapacityTotal,
+	})
+	var unmatched *types.ConditionalCheckFailedException
+	if errors.As(err, &unmatched) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update item: %w", err)
+	}
+	s.reportConsumed(ctx, opUpdate, response.ConsumedCapacity)
+	return nil
+}
+
+// dynamoDBFilterCondition compiles an equality filter into a condition
+// expression. A slice value matches any of its elements, like IN.
+func dynamoDBFilterCondition(filter map[string]any) (string, map[string]string, map[string]types.AttributeValue, error) {
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{}
+	var clauses []string
+	for i, field := range slices.Sorted(maps.Keys(filter)) {
+		name := fmt.Sprintf("#f%d", i)
+		names[name] = field
+		var placeholders []string
+		for j, v := range filterValues(filter[field]) {
+			value, err := attributevalue.Marshal(v)
+			if err != nil {
+				return "", nil, nil, fmt.Errorf("failed to marshal filter value for %s, %w", field, err)
+			}
+			placeholder := fmt.Sprintf(":f%d_%d", i, j)
+			values[placeholder] = value
+			placeholders = append(placeholders, placeholder)
+		}
+		if len(placeholders) == 1 {
+			clauses = append(clauses, name+" = "+placeholders[0])
+		} else {
+			clauses = append(clauses, fmt.Sprintf("%s IN (%s)", name, strings.Join(placeholders, ", ")))
+		}
+	}
+	return strings.Join(clauses, " AND "), names, values, nil
 }
 
 func (s *DynamoDBAdapter) Delete(item any, filter map[string]any, params ...map[string]any) error {
@@ -188,30 +635,34 @@ func (s *DynamoDBAdapter) Delete(item any, filter map[string]any, params ...map[
 func (s *DynamoDBAdapter) DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
 	key, err := attributevalue.MarshalMapWithOptions(filter, func(eo *attributevalue.EncoderOptions) { eo.TagKey = "json" })
 	if err != nil {
-		return fmt.Errorf("failed to marshal item id into dynamodb attribute, %v", err)
+		return fmt.Errorf("failed to marshal item id into dynamodb attribute, %w", err)
 	}
 
-	_, err = s.DB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
-		TableName: aws.String(s.getTableName(item)),
-		Key:       key,
+	response, err := s.DB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:              aws.String(s.getTableName(item)),
+		Key:                    key,
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to delete item, %v", err)
+		return fmt.Errorf("failed to delete item, %w", err)
 	}
+	s.reportConsumed(ctx, opDelete, response.ConsumedCapacity)
 
 	return nil
 }
 
 func (s *DynamoDBAdapter) executePaginatedQuery(
 	ctx context.Context,
+	op string,
 	dest any,
 	limit int,
 	cursor string,
 	builder dynamoQueryBuilder,
 ) (string, error) {
 	input := &dynamodb.ExecuteStatementInput{
-		Limit: aws.Int32(int32(limit)),
+		Limit:                  aws.Int32(int32(limit)),
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
 	}
 
 	if cursor != "" {
@@ -225,6 +676,7 @@ func (s *DynamoDBAdapter) executePaginatedQuery(
 		slog.Error("Query execution failed", "error", err)
 		return "", err
 	}
+	s.reportConsumed(ctx, op, response.ConsumedCapacity)
 
 	nextToken := ""
 	if response.NextToken != nil {
@@ -251,7 +703,11 @@ func (s *DynamoDBAdapter) ListContext(ctx context.Context, dest any, sortKey str
 	if err := validateSortKey(sortKey); err != nil {
 		return "", err
 	}
-	return s.executePaginatedQuery(ctx, dest, limit, cursor, func(input *dynamodb.ExecuteStatementInput) *dynamodb.ExecuteStatementInput {
+	direction, err := extractSortDirection(extractParams(params...))
+	if err != nil {
+		return "", fmt.Errorf("failed to list: %w", err)
+	}
+	return s.executePaginatedQuery(ctx, opList, dest, limit, cursor, func(input *dynamodb.ExecuteStatementInput) *dynamodb.ExecuteStatementInput {
 		query := fmt.Sprintf(`SELECT * FROM "%s"`, s.getTableName(dest))
 
 		if len(filter) > 0 {
@@ -260,8 +716,9 @@ func (s *DynamoDBAdapter) ListContext(ctx context.Context, dest any, sortKey str
 			query += fmt.Sprintf(` WHERE %s`, s.buildFilter(filter))
 		}
 
+		// PartiQL only orders a query on one partition, by its sort key.
 		if sortKey != "" {
-			query += fmt.Sprintf(` ORDER BY %s`, sortKey)
+			query += fmt.Sprintf(` ORDER BY %s %s`, sortKey, direction)
 		}
 
 		input.Statement = aws.String(query)
@@ -289,7 +746,7 @@ func (s *DynamoDBAdapter) SearchContext(ctx context.Context, dest any, sortKey s
 		return "", err
 	}
 
-	return s.executePaginatedQuery(ctx, dest, limit, cursor, func(input *dynamodb.ExecuteStatementInput) *dynamodb.ExecuteStatementInput {
+	return s.executePaginatedQuery(ctx, opSearch, dest, limit, cursor, func(input *dynamodb.ExecuteStatementInput) *dynamodb.ExecuteStatementInput {
 		// Build query
 		query := fmt.Sprintf(`SELECT * FROM "%s"`, s.getTableName(dest))
 		if whereClause != "" {
@@ -315,17 +772,163 @@ func (s *DynamoDBAdapter) CountContext(ctx context.Context, dest any, filter map
 	return total, nil
 }
 
+func (s *DynamoDBAdapter) Aggregate(model any, filter any, groupBy []string, metrics []AggSpec, params ...map[string]any) ([]AggregateRow, error) {
+	return s.AggregateContext(context.Background(), model, filter, groupBy, metrics, params...)
+}
+
+// AggregateContext folds the aggregation client-side: PartiQL has no GROUP BY
+// or aggregate functions, so the matching items are read page by page and
+// reduced in process. The read is capped by AggregateScanLimitKey (default
+// DefaultAggregateScanLimit items); past the cap ErrAggregateScanLimit is
+// returned rather than a silently partial answer. Every page consumes read
+// capacity, so keep the filter selective or precompute aggregates for large
+// tables.
+func (s *DynamoDBAdapter) AggregateContext(ctx context.Context, model any, filter any, groupBy []string, metrics []AggSpec, params ...map[string]any) ([]AggregateRow, error) {
+	if err := validateAggregate(groupBy, metrics); err != nil {
+		return nil, &serviceErrors.BadRequest{Message: err.Error()}
+	}
+	filterMap, query, err := aggregateFilter(filter)
+	if err != nil {
+		return nil, &serviceErrors.BadRequest{Message: err.Error()}
+	}
+	limit := aggregateScanLimit(extractParams(params...))
+
+	input := &dynamodb.ExecuteStatementInput{}
+	statement := fmt.Sprintf(`SELECT * FROM "%s"`, s.getTableName(model))
+	switch {
+	case len(filterMap) > 0:
+		input.Parameters, err = s.buildParams(filterMap)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal filter values: %w", err)
+		}
+		statement += fmt.Sprintf(` WHERE %s`, s.buildFilter(filterMap))
+	case query != "":
+		parser, err := lucene.NewParser(reflect.New(modelType(model)).Elem().Interface())
+		if err != nil {
+			return nil, err
+		}
+		whereClause, dynamoParams, err := parser.ParseToDynamoDBPartiQL(query)
+		if err != nil {
+			if _, ok := err.(*lucene.InvalidFieldError); ok {
+				return nil, &serviceErrors.BadRequest{Message: err.Error()}
+			}
+			return nil, err
+		}
+		if whereClause != "" {
+			statement += fmt.Sprintf(` WHERE %s`, whereClause)
+			input.Parameters = dynamoParams
+		}
+	}
+	input.Statement = aws.String(statement)
+
+	items, err := s.scanAll(ctx, opAggregate, input, limit)
+	if err != nil {
+		return nil, err
+	}
+	return foldAggregate(items, groupBy, metrics), nil
+}
+
+func (s *DynamoDBAdapter) SearchWithFacets(dest any, sortKey string, query string, limit int, cursor string, facets []string, params ...map[string]any) (string, Facets, error) {
+	return s.SearchWithFacetsContext(context.Background(), dest, sortKey, query, limit, cursor, facets, params...)
+}
+
+// SearchWithFacetsContext returns the search page as Search does and counts
+// facets client-side over every matching item. Like AggregateContext, the
+// counting scan is capped by AggregateScanLimitKey and fails with
+// ErrAggregateScanLimit rather than returning partial counts.
+func (s *DynamoDBAdapter) SearchWithFacetsContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, facets []string, params ...map[string]any) (string, Facets, error) {
+	nextCursor, err := s.SearchContext(ctx, dest, sortKey, query, limit, cursor, params...)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(facets) == 0 {
+		return nextCursor, Facets{}, nil
+	}
+
+	parser, err := lucene.NewParser(reflect.New(modelType(dest)).Elem().Interface())
+	if err != nil {
+		return "", nil, err
+	}
+	for _, f := range facets {
+		if _, err := parser.FacetField(f); err != nil {
+			return "", nil, err
+		}
+	}
+
+	input := &dynamodb.ExecuteStatementInput{}
+	statement := fmt.Sprintf(`SELECT * FROM "%s"`, s.getTableName(dest))
+	if query != "" {
+		whereClause, dynamoParams, err := parser.ParseToDynamoDBPartiQL(query)
+		if err != nil {
+			return "", nil, err
+		}
+		if whereClause != "" {
+			statement += fmt.Sprintf(` WHERE %s`, whereClause)
+			input.Parameters = dynamoParams
+		}
+	}
+	input.Statement = aws.String(statement)
+
+	items, err := s.scanAll(ctx, opFacets, input, aggregateScanLimit(extractParams(params...)))
+	if err != nil {
+		return "", nil, err
+	}
+	return nextCursor, countFacets(items, facets), nil
+}
+
+// scanAll drains every page of input into generic documents, failing with
+// ErrAggregateScanLimit once more than limit items have been read.
+func (s *DynamoDBAdapter) scanAll(ctx context.Context, op string, input *dynamodb.ExecuteStatementInput, limit int) ([]map[string]any, error) {
+	input.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	items := []map[string]any{}
+	for {
+		response, err := s.DB.ExecuteStatement(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan items: %w", err)
+		}
+		s.reportConsumed(ctx, op, response.ConsumedCapacity)
+		var page []map[string]any
+		err = attributevalue.UnmarshalListOfMapsWithOptions(response.Items, &page, func(eo *attributevalue.DecoderOptions) { eo.TagKey = "json" })
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+		items = append(items, page...)
+		if len(items) > limit {
+			return nil, ErrAggregateScanLimit
+		}
+		if response.NextToken == nil {
+			break
+		}
+		input.NextToken = response.NextToken
+	}
+	return items, nil
+}
+
 func (s *DynamoDBAdapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
 	return s.QueryContext(context.Background(), dest, statement, limit, cursor, params...)
 }
 
 func (s *DynamoDBAdapter) QueryContext(ctx context.Context, dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
-	return s.executePaginatedQuery(ctx, dest, limit, cursor, func(input *dynamodb.ExecuteStatementInput) *dynamodb.ExecuteStatementInput {
+	return s.executePaginatedQuery(ctx, opQuery, dest, limit, cursor, func(input *dynamodb.ExecuteStatementInput) *dynamodb.ExecuteStatementInput {
 		input.Statement = aws.String(statement)
 		return input
 	})
 }
 
+// TableName implements CapacityReporter.
+func (s *DynamoDBAdapter) TableName(model any) string {
+	return s.getTableName(model)
+}
+
+// reportConsumed reports the capacity DynamoDB says a request consumed. The
+// response names the table, which for a Query statement is the only source.
+func (s *DynamoDBAdapter) reportConsumed(ctx context.Context, op string, consumed *types.ConsumedCapacity) {
+	if consumed == nil || consumed.CapacityUnits == nil {
+		return
+	}
+	ReportCapacity(ctx, string(DYNAMODB), op, aws.ToString(consumed.TableName), *consumed.CapacityUnits)
+}
+
 func (s *DynamoDBAdapter) getTableName(obj any) string {
 	// Get the type of obj
 	tableName := ""
@@ -343,9 +946,12 @@ func (s *DynamoDBAdapter) getTableName(obj any) string {
 	return tableName
 }
 
+// buildFilter and buildParams must agree on placeholder order, so both walk
+// the filter in sorted key order rather than Go's randomized map order.
 func (s *DynamoDBAdapter) buildFilter(filter map[string]any) string {
 	clauses := []string{}
-	for key, value := range filter {
+	for _, key := range slices.Sorted(maps.Keys(filter)) {
+		value := filter[key]
 		if reflect.ValueOf(value).Kind() == reflect.Slice {
 			c := "IN ("
 			len := reflect.ValueOf(value).Len()
@@ -367,7 +973,8 @@ func (s *DynamoDBAdapter) buildFilter(filter map[string]any) string {
 func (s *DynamoDBAdapter) buildParams(filter map[string]any) ([]types.AttributeValue, error) {
 	values := make([]types.AttributeValue, 0, len(filter))
 
-	for _, value := range filter {
+	for _, key := range slices.Sorted(maps.Keys(filter)) {
+		value := filter[key]
 		if reflect.ValueOf(value).Kind() == reflect.Slice {
 			len := reflect.ValueOf(value).Len()
 			for i := 0; i < len; i++ {
@@ -1,9 +1,18 @@
 package storage
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
@@ -117,7 +126,7 @@ func TestDynamoDBBuildParamsEmptyFilterReturnsEmpty(t *testing.T) {
 	}
 }
 
-func TestDynamoDBTrivialGettersAndUnsupportedOps(t *testing.T) {
+func TestDynamoDBTrivialGetters(t *testing.T) {
 	s := &DynamoDBAdapter{}
 
 	if got := s.GetType(); got != DYNAMODB {
@@ -130,22 +139,10 @@ func TestDynamoDBTrivialGettersAndUnsupportedOps(t *testing.T) {
 		t.Fatalf("GetSchemaName = %q; want empty", got)
 	}
 
-	// All of the "schema / migrations" entry points on the
-	// DynamoDB adapter are documented as unsupported. Pin
-	// that so silent regressions (e.g. someone changing a
-	// return to nil) fail a test rather than surprise a
-	// consumer at runtime.
-	if err := s.CreateSchema(); err == nil {
-		t.Fatalf("CreateSchema must return an error")
-	}
-	if err := s.CreateMigrationTable(); err == nil {
-		t.Fatalf("CreateMigrationTable must return an error")
-	}
-	if err := s.UpdateMigrationTable(1, "x", "y"); err == nil {
-		t.Fatalf("UpdateMigrationTable must return an error")
-	}
-	if _, err := s.GetLatestMigration(); err == nil {
-		t.Fatalf("GetLatestMigration must return an error")
+	// Tables belong to the account, so there is no schema to
+	// create; migrations still run against the migrations table.
+	if err := s.CreateSchema(); err != nil {
+		t.Fatalf("CreateSchema must be a no-op, got %v", err)
 	}
 }
 
@@ -163,3 +160,191 @@ func TestDynamoDBBuildParamsMarshalsIntoMemberAttributeValue(t *testing.T) {
 		t.Fatalf("params[0] = %T; want *AttributeValueMemberN", params[0])
 	}
 }
+
+// fakeDynamoDB serves ExecuteStatement from pages keyed by NextToken, and
+// records the statements of BatchExecuteStatement, rejecting every statement
+// while fail is set.
+type fakeDynamoDB struct {
+	pages   map[string][]string
+	read    []string
+	updated []string
+	fail    bool
+}
+
+func (f *fakeDynamoDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		NextToken  string
+		Statements []struct{ Statement string }
+	}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	switch r.Header.Get("X-Amz-Target") {
+	case "DynamoDB_20120810.ExecuteStatement":
+		f.read = append(f.read, body.NextToken)
+		ids := f.pages[body.NextToken]
+		items := []map[string]any{}
+		for _, id := range ids[1:] {
+			items = append(items, map[string]any{"id": map[string]string{"S": id}})
+		}
+		response := map[string]any{"Items": items}
+		if ids[0] != "" {
+			response["NextToken"] = ids[0]
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	case "DynamoDB_20120810.BatchExecuteStatement":
+		responses := []map[string]any{}
+		for _, s := range body.Statements {
+			if f.fail {
+				responses = append(responses, map[string]any{"Error": map[string]string{"Code": "ValidationError", "Message": "rejected"}})
+				continue
+			}
+			f.updated = append(f.updated, s.Statement)
+			responses = append(responses, map[string]any{})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"Responses": responses})
+	default:
+		http.Error(w, "unexpected operation", http.StatusBadRequest)
+	}
+}
+
+func TestDynamoDBMigrateItemsResumesFromItsCheckpoint(t *testing.T) {
+	// Each page lists the next page's token, then its item ids.
+	fake := &fakeDynamoDB{pages: map[string][]string{
+		"":   {"p2", "a", "b"},
+		"p2": {"", "c"},
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	s := &DynamoDBAdapter{DB: dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	})}
+
+	checkpoints := memoryCheckpoints{}
+	ctx := context.WithValue(context.Background(), checkpointKey{}, &Checkpoint{id: 7, name: "7__go", store: checkpoints})
+	update := func(item map[string]types.AttributeValue) ([]types.BatchStatementRequest, error) {
+		id := item["id"].(*types.AttributeValueMemberS).Value
+		return []types.BatchStatementRequest{{Statement: aws.String("UPDATE widgets SET v = 2 WHERE id = '" + id + "'")}}, nil
+	}
+
+	// The first page is migrated before the second fails, so the run
+	// leaves the second page's token behind.
+	calls := 0
+	failSecond := func(item map[string]types.AttributeValue) ([]types.BatchStatementRequest, error) {
+		if calls++; calls == 3 {
+			fake.fail = true
+		}
+		return update(item)
+	}
+	if err := s.MigrateItems(ctx, "SELECT * FROM widgets", nil, failSecond); err == nil {
+		t.Fatal("MigrateItems succeeded though the second page's batch failed")
+	}
+	if checkpoints[7] != "p2" || !CheckpointFromContext(ctx).saved {
+		t.Fatalf("checkpoint = %q; want the second page's token", checkpoints[7])
+	}
+
+	fake.fail = false
+	fake.read = nil
+	if err := s.MigrateItems(ctx, "SELECT * FROM widgets", nil, update); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(fake.read, []string{"p2"}) {
+		t.Fatalf("pages read on resume = %q; want only the second", fake.read)
+	}
+	if len(fake.updated) != 3 {
+		t.Fatalf("updated = %q; want every item once", fake.updated)
+	}
+	if checkpoints[7] != "" || CheckpointFromContext(ctx).saved {
+		t.Fatalf("checkpoint = %q after the last page; want it cleared", checkpoints[7])
+	}
+}
+
+// fakeConditionalTable serves DescribeTable and PutItem for a table keyed by
+// entity_key, honouring attribute_exists and attribute_not_exists conditions
+// on the key, and records the statements of ExecuteStatement.
+type fakeConditionalTable struct {
+	items      map[string]bool
+	conditions []string
+	statements []string
+}
+
+func (f *fakeConditionalTable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Statement           string
+		ConditionExpression string
+		Item                map[string]map[string]string
+	}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	switch r.Header.Get("X-Amz-Target") {
+	case "DynamoDB_20120810.DescribeTable":
+		_ = json.NewEncoder(w).Encode(map[string]any{"Table": map[string]any{"KeySchema": []map[string]string{
+			{"AttributeName": "entity_key", "KeyType": "HASH"},
+			{"AttributeName": "id", "KeyType": "RANGE"},
+		}}})
+	case "DynamoDB_20120810.PutItem":
+		f.conditions = append(f.conditions, body.ConditionExpression)
+		key := body.Item["entity_key"]["S"] + "/" + body.Item["id"]["S"]
+		exists := strings.HasPrefix(body.ConditionExpression, "attribute_exists")
+		if body.ConditionExpression != "" && f.items[key] != exists {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"__type": "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException", "message": "The conditional request failed"})
+			return
+		}
+		f.items[key] = true
+		_ = json.NewEncoder(w).Encode(map[string]any{})
+	case "DynamoDB_20120810.ExecuteStatement":
+		f.statements = append(f.statements, body.Statement)
+		_ = json.NewEncoder(w).Encode(map[string]any{"Items": []any{}})
+	default:
+		http.Error(w, "unexpected operation", http.StatusBadRequest)
+	}
+}
+
+type conditionalRow struct {
+	EntityKey string `json:"entity_key"`
+	Id        string `json:"id"`
+}
+
+func TestDynamoDBConditionalWrites(t *testing.T) {
+	fake := &fakeConditionalTable{items: map[string]bool{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	s := &DynamoDBAdapter{DB: dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	})}
+	ctx := context.Background()
+	row := &conditionalRow{EntityKey: "doc#1", Id: "doc#1#0000000001"}
+
+	if err := s.CreateIfAbsentContext(ctx, row); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateIfAbsentContext(ctx, row); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("second create = %v; want ErrAlreadyExists", err)
+	}
+	if fake.conditions[0] != "attribute_not_exists(#key)" {
+		t.Fatalf("condition = %q", fake.conditions[0])
+	}
+
+	filter := map[string]any{"entity_key": "doc#1", "id": []string{"doc#1#0000000001", "doc#1#0000000002"}}
+	if err := s.UpdateContext(ctx, row, filter); err != nil {
+		t.Fatal(err)
+	}
+	if want := "attribute_exists(#key) AND #f0 = :f0_0 AND #f1 IN (:f1_0, :f1_1)"; fake.conditions[2] != want {
+		t.Fatalf("condition = %q; want %q", fake.conditions[2], want)
+	}
+	if err := s.UpdateContext(ctx, &conditionalRow{EntityKey: "doc#2", Id: "doc#2#1"}, map[string]any{"entity_key": "doc#2"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("update of a missing item = %v; want ErrNotFound", err)
+	}
+
+	var rows []conditionalRow
+	if _, err := s.ListContext(ctx, &rows, "id", map[string]any{"entity_key": "doc#1"}, 1, "", map[string]any{SortDirectionKey: "desc"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(fake.statements[0], "ORDER BY id DESC") {
+		t.Fatalf("statement = %q; want it ordered newest first", fake.statements[0])
+	}
+}
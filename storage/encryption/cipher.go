@@ -0,0 +1,256 @@
+package encryption
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Ciphertexts are stored as text so they fit the column, attribute or JSON
+// property the plaintext used to occupy:
+//
+//	enc:v1:r:<key id>:<wrapped data key>:<nonce||ciphertext>   randomized
+//	enc:v1:d:<key id>:<nonce||ciphertext>                      deterministic
+//
+// Binary parts are unpadded URL-safe base64. A key id that would break the
+// format, such as an AWS KMS ARN, is stored as ~ followed by its base64. The
+// field's JSON name is bound in
+// as additional authenticated data, so a ciphertext copied into another field
+// fails to decrypt instead of reading back as that field's value.
+const (
+	prefix            = "enc:v1:"
+	encodedKeyID      = "~"
+	modeRandom        = "r"
+	modeDeterministic = "d"
+
+	deterministicLabel = "magic/deterministic/v1/"
+	dekCacheSize       = 1024
+)
+
+var b64 = base64.RawURLEncoding
+
+// IsCiphertext reports whether s is a value produced by this package.
+// Values without the prefix are treated as plaintext written before the field
+// was encrypted, and read back unchanged.
+func IsCiphertext(s string) bool {
+	return strings.HasPrefix(s, prefix)
+}
+
+// KeyID returns the master key ID a ciphertext was produced under, which is
+// how rows still on a rotated-out key can be found.
+func KeyID(s string) (string, bool) {
+	if !IsCiphertext(s) {
+		return "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(s, prefix), ":")
+	if len(parts) < 3 {
+		return "", false
+	}
+	keyID, err := decodeKeyID(parts[1])
+	if err != nil {
+		return "", false
+	}
+	return keyID, true
+}
+
+// encodeKeyID returns keyID as it is written in a ciphertext.
+func encodeKeyID(keyID string) string {
+	if strings.Contains(keyID, ":") || strings.HasPrefix(keyID, encodedKeyID) {
+		return encodedKeyID + b64.EncodeToString([]byte(keyID))
+	}
+	return keyID
+}
+
+// decodeKeyID reverses encodeKeyID.
+func decodeKeyID(s string) (string, error) {
+	if !strings.HasPrefix(s, encodedKeyID) {
+		return s, nil
+	}
+	keyID, err := b64.DecodeString(strings.TrimPrefix(s, encodedKeyID))
+	if err != nil {
+		return "", fmt.Errorf("malformed key id: %w", err)
+	}
+	return string(keyID), nil
+}
+
+// cryptor performs the field-level operations for an Adapter and caches the
+// key material that would otherwise cost a provider call per value.
+type cryptor struct {
+	keys KeyProvider
+
+	mu      sync.Mutex
+	dekByID map[string][]byte // unwrapped data keys by keyID:wrapped
+	derived map[string][2][]byte
+}
+
+func newCryptor(keys KeyProvider) *cryptor {
+	return &cryptor{
+		keys:    keys,
+		dekByID: map[string][]byte{},
+		derived: map[string][2][]byte{},
+	}
+}
+
+// dataKey is the envelope for one write: a fresh data key used for every
+// randomized field of the item, stored wrapped next to each ciphertext.
+type dataKey struct {
+	keyID   string
+	plain   []byte
+	wrapped string
+}
+
+func (c *cryptor) newDataKey(ctx context.Context) (*dataKey, error) {
+	keyID := c.keys.PrimaryKeyID()
+	plain, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := c.keys.WrapKey(ctx, keyID, plain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	return &dataKey{keyID: keyID, plain: plain, wrapped: b64.EncodeToString(wrapped)}, nil
+}
+
+// encrypt produces the stored form of plaintext for field. dek may be nil
+// when the field is deterministic.
+func (c *cryptor) encrypt(ctx context.Context, f encryptedField, plaintext string, dek *dataKey) (string, error) {
+	if f.deterministic {
+		return c.encryptDeterministic(ctx, c.keys.PrimaryKeyID(), f.name, plaintext)
+	}
+	sealed, err := sealRandom(dek.plain, []byte(plaintext), []byte(f.name))
+	if err != nil {
+		return "", err
+	}
+	return prefix + modeRandom + ":" + encodeKeyID(dek.keyID) + ":" + dek.wrapped + ":" + b64.EncodeToString(sealed), nil
+}
+
+// encryptDeterministic is AES-GCM with a synthetic nonce: the nonce is a MAC
+// of the plaintext, so equal inputs give equal outputs (which is what makes
+// equality filters work) while distinct inputs never share a nonce. It leaks
+// equality, and nothing else, to anyone reading the column.
+func (c *cryptor) encryptDeterministic(ctx context.Context, keyID, field, plaintext string) (string, error) {
+	keys, err := c.deterministicKeys(ctx, keyID, field)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newGCM(keys[0])
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, keys[1])
+	mac.Write([]byte(plaintext))
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+	sealed := aead.Seal(append([]byte(nil), nonce...), nonce, []byte(plaintext), []byte(field))
+	return prefix + modeDeterministic + ":" + encodeKeyID(keyID) + ":" + b64.EncodeToString(sealed), nil
+}
+
+// decrypt reverses encrypt. Values that are not ciphertexts pass through.
+func (c *cryptor) decrypt(ctx context.Context, f encryptedField, stored string) (string, error) {
+	if !IsCiphertext(stored) {
+		return stored, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(stored, prefix), ":")
+	if len(parts) < 3 {
+		return "", fmt.Errorf("malformed ciphertext in field %s", f.name)
+	}
+	keyID, err := decodeKeyID(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed ciphertext in field %s: %w", f.name, err)
+	}
+	switch {
+	case len(parts) == 4 && parts[0] == modeRandom:
+		dek, err := c.unwrap(ctx, keyID, parts[2])
+		if err != nil {
+			return "", err
+		}
+		sealed, err := b64.DecodeString(parts[3])
+		if err != nil {
+			return "", fmt.Errorf("malformed ciphertext in field %s: %w", f.name, err)
+		}
+		plain, err := open(dek, sealed, []byte(f.name))
+		if err != nil {
+			return "", fmt.Errorf("field %s: %w", f.name, err)
+		}
+		return string(plain), nil
+	case len(parts) == 3 && parts[0] == modeDeterministic:
+		keys, err := c.deterministicKeys(ctx, keyID, f.name)
+		if err != nil {
+			return "", err
+		}
+		sealed, err := b64.DecodeString(parts[2])
+		if err != nil {
+			return "", fmt.Errorf("malformed ciphertext in field %s: %w", f.name, err)
+		}
+		plain, err := open(keys[0], sealed, []byte(f.name))
+		if err != nil {
+			return "", fmt.Errorf("field %s: %w", f.name, err)
+		}
+		return string(plain), nil
+	}
+	return "", fmt.Errorf("malformed ciphertext in field %s", f.name)
+}
+
+func (c *cryptor) unwrap(ctx context.Context, keyID, wrapped string) ([]byte, error) {
+	cacheKey := keyID + ":" + wrapped
+	c.mu.Lock()
+	dek, ok := c.dekByID[cacheKey]
+	c.mu.Unlock()
+	if ok {
+		return dek, nil
+	}
+
+	raw, err := b64.DecodeString(wrapped)
+	if err != nil {
+		return nil, errors.New("malformed wrapped data key")
+	}
+	dek, err = c.keys.UnwrapKey(ctx, keyID, raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	c.mu.Lock()
+	// A crude bound: dropping the whole cache is cheap and avoids LRU
+	// bookkeeping for what is only a saving on provider calls.
+	if len(c.dekByID) >= dekCacheSize {
+		c.dekByID = map[string][]byte{}
+	}
+	c.dekByID[cacheKey] = dek
+	c.mu.Unlock()
+	return dek, nil
+}
+
+// deterministicKeys returns the encryption and MAC keys for one field under
+// one master key. Keys are per field so equal values in different fields do
+// not produce equal ciphertexts.
+func (c *cryptor) deterministicKeys(ctx context.Context, keyID, field string) ([2][]byte, error) {
+	cacheKey := keyID + ":" + field
+	c.mu.Lock()
+	keys, ok := c.derived[cacheKey]
+	c.mu.Unlock()
+	if ok {
+		return keys, nil
+	}
+
+	base, err := c.keys.DeriveKey(ctx, keyID, []byte(deterministicLabel+field))
+	if err != nil {
+		return keys, fmt.Errorf("failed to derive deterministic key: %w", err)
+	}
+	if keys[0], err = hkdf.Key(sha256.New, base, nil, "enc", KeySize); err != nil {
+		return keys, err
+	}
+	if keys[1], err = hkdf.Key(sha256.New, base, nil, "mac", KeySize); err != nil {
+		return keys, err
+	}
+
+	c.mu.Lock()
+	c.derived[cacheKey] = keys
+	c.mu.Unlock()
+	return keys, nil
+}
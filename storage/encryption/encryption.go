@@ -0,0 +1,413 @@
+// Package encryption adds field-level encryption at rest to any
+// storage.StorageAdapter. Fields tagged `magic:"encrypted"` are encrypted
+// before Create and Update reach the database and decrypted in place after
+// Get, List, Search and Query, so SQL, DynamoDB and CosmosDB only ever store
+// ciphertext for them.
+//
+//	type Customer struct {
+//		Id    string `json:"id"`
+//		SSN   string `json:"ssn" magic:"encrypted"`
+//		Email string `json:"email" magic:"encrypted,deterministic"`
+//	}
+//
+//	keys, err := encryption.LoadKeyringFile("/etc/app/keyring.json")
+//	if err != nil { ... }
+//	adapter := encryption.New(storage.StorageAdapterFactory{}.GetInstance(storage.SQL, cfg), keys)
+//
+// Randomized fields (the default) use envelope encryption with a fresh data
+// key per write and cannot be filtered on. Deterministic fields always
+// encrypt a value to the same ciphertext, so they work in the equality
+// filters of Get, Update, Delete, List and Count; the price is that anyone
+// reading the table can tell which rows share a value.
+//
+// Encrypted values are opaque to the database: Lucene queries, sorting and
+// aggregation over encrypted fields do not see plaintext. Empty strings and
+// nil pointers are stored as is.
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/tink3rlabs/magic/storage"
+)
+
+// Adapter is a storage.ContextualStorageAdapter decorator that encrypts and
+// decrypts tagged fields around the wrapped adapter.
+type Adapter struct {
+	inner    storage.StorageAdapter
+	ctxInner storage.ContextualStorageAdapter
+	crypt    *cryptor
+}
+
+var _ storage.ContextualStorageAdapter = (*Adapter)(nil)
+
+// New wraps inner. keys supplies the master keys; see Keyring and
+// KMSProvider.
+func New(inner storage.StorageAdapter, keys KeyProvider) *Adapter {
+	a := &Adapter{inner: inner, crypt: newCryptor(keys)}
+	if c, ok := inner.(storage.ContextualStorageAdapter); ok {
+		a.ctxInner = c
+	}
+	return a
+}
+
+// UnwrapStorageAdapter returns the wrapped adapter, so storage.UnwrapAdapter
+// can reach the concrete adapter underneath.
+func (a *Adapter) UnwrapStorageAdapter() storage.StorageAdapter {
+	return a.inner
+}
+
+// ReEncrypt reads the item matching filter and writes it back, moving every
+// encrypted field onto the current primary key. Run it over existing rows
+// after a rotation, then retire the old key once KeyID reports it unused.
+// Deterministic filters only match rows encrypted under the primary key, so
+// re-encrypt before relying on them after a rotation.
+func (a *Adapter) ReEncrypt(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error {
+	if err := a.GetContext(ctx, dest, filter, params...); err != nil {
+		return err
+	}
+	return a.UpdateContext(ctx, dest, filter, params...)
+}
+
+func (a *Adapter) Execute(statement string) error {
+	return a.ExecuteContext(context.Background(), statement)
+}
+
+func (a *Adapter) ExecuteContext(ctx context.Context, statement string) error {
+	if a.ctxInner != nil {
+		return a.ctxInner.ExecuteContext(ctx, statement)
+	}
+	return a.inner.Execute(statement)
+}
+
+func (a *Adapter) Ping() error {
+	return a.PingContext(context.Background())
+}
+
+func (a *Adapter) PingContext(ctx context.Context) error {
+	if a.ctxInner != nil {
+		return a.ctxInner.PingContext(ctx)
+	}
+	return a.inner.Ping()
+}
+
+func (a *Adapter) GetType() storage.StorageAdapterType   { return a.inner.GetType() }
+func (a *Adapter) GetProvider() storage.StorageProviders { return a.inner.GetProvider() }
+func (a *Adapter) GetSchemaName() string                 { return a.inner.GetSchemaName() }
+func (a *Adapter) CreateSchema() error                   { return a.inner.CreateSchema() }
+func (a *Adapter) CreateMigrationTable() error           { return a.inner.CreateMigrationTable() }
+func (a *Adapter) GetLatestMigration() (int, error)      { return a.inner.GetLatestMigration() }
+
+func (a *Adapter) UpdateMigrationTable(id int, name string, desc string) error {
+	return a.inner.UpdateMigrationTable(id, name, desc)
+}
+
+func (a *Adapter) Create(item any, params ...map[string]any) error {
+	return a.CreateContext(context.Background(), item, params...)
+}
+
+func (a *Adapter) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	return a.withEncrypted(ctx, item, func() error {
+		if a.ctxInner != nil {
+			return a.ctxInner.CreateContext(ctx, item, params...)
+		}
+		return a.inner.Create(item, params...)
+	})
+}
+
+func (a *Adapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
+	return a.GetContext(context.Background(), dest, filter, params...)
+}
+
+func (a *Adapter) GetContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error {
+	filter, err := a.encryptFilter(ctx, dest, filter)
+	if err != nil {
+		return err
+	}
+	if a.ctxInner != nil {
+		err = a.ctxInner.GetContext(ctx, dest, filter, params...)
+	} else {
+		err = a.inner.Get(dest, filter, params...)
+	}
+	if err != nil {
+		return err
+	}
+	return a.decrypt(ctx, dest)
+}
+
+func (a *Adapter) Update(item any, filter map[string]any, params ...map[string]any) error {
+	return a.UpdateContext(context.Background(), item, filter, params...)
+}
+
+func (a *Adapter) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	filter, err := a.encryptFilter(ctx, item, filter)
+	if err != nil {
+		return err
+	}
+	return a.withEncrypted(ctx, item, func() error {
+		if a.ctxInner != nil {
+			return a.ctxInner.UpdateContext(ctx, item, filter, params...)
+		}
+		return a.inner.Update(item, filter, params...)
+	})
+}
+
+func (a *Adapter) Delete(item any, filter map[string]any, params ...map[string]any) error {
+	return a.DeleteContext(context.Background(), item, filter, params...)
+}
+
+func (a *Adapter) DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	filter, err := a.encryptFilter(ctx, item, filter)
+	if err != nil {
+		return err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.DeleteContext(ctx, item, filter, params...)
+	}
+	return a.inner.Delete(item, filter, params...)
+}
+
+func (a *Adapter) List(dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.ListContext(context.Background(), dest, sortKey, filter, limit, cursor, params...)
+}
+
+func (a *Adapter) ListContext(ctx context.Context, dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	filter, err := a.encryptFilter(ctx, dest, filter)
+	if err != nil {
+		return "", err
+	}
+	var next string
+	if a.ctxInner != nil {
+		next, err = a.ctxInner.ListContext(ctx, dest, sortKey, filter, limit, cursor, params...)
+	} else {
+		next, err = a.inner.List(dest, sortKey, filter, limit, cursor, params...)
+	}
+	if err != nil {
+		return "", err
+	}
+	return next, a.decrypt(ctx, dest)
+}
+
+func (a *Adapter) Search(dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.SearchContext(context.Background(), dest, sortKey, query, limit, cursor, params...)
+}
+
+// SearchContext decrypts the returned page. The query itself runs against
+// stored values, so terms on encrypted fields never match.
+func (a *Adapter) SearchContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	var next string
+	var err error
+	if a.ctxInner != nil {
+		next, err = a.ctxInner.SearchContext(ctx, dest, sortKey, query, limit, cursor, params...)
+	} else {
+		next, err = a.inner.Search(dest, sortKey, query, limit, cursor, params...)
+	}
+	if err != nil {
+		return "", err
+	}
+	return next, a.decrypt(ctx, dest)
+}
+
+func (a *Adapter) Count(dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	return a.CountContext(context.Background(), dest, filter, params...)
+}
+
+func (a *Adapter) CountContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	filter, err := a.encryptFilter(ctx, dest, filter)
+	if err != nil {
+		return 0, err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.CountContext(ctx, dest, filter, params...)
+	}
+	return a.inner.Count(dest, filter, params...)
+}
+
+func (a *Adapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.QueryContext(context.Background(), dest, statement, limit, cursor, params...)
+}
+
+func (a *Adapter) QueryContext(ctx context.Context, dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	var next string
+	var err error
+	if a.ctxInner != nil {
+		next, err = a.ctxInner.QueryContext(ctx, dest, statement, limit, cursor, params...)
+	} else {
+		next, err = a.inner.Query(dest, statement, limit, cursor, params...)
+	}
+	if err != nil {
+		return "", err
+	}
+	return next, a.decrypt(ctx, dest)
+}
+
+type ciphertextKey struct{}
+
+// AcceptCiphertext marks the writes made with ctx as carrying tagged values
+// that are already encrypted, such as rows copied from another store, so
+// values that decrypt are stored as they are instead of encrypted again.
+// Without it, every tagged value is input and is encrypted, so ciphertext
+// copied from another row is stored as an opaque string rather than becoming
+// that row's plaintext.
+func AcceptCiphertext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ciphertextKey{}, true)
+}
+
+func acceptsCiphertext(ctx context.Context) bool {
+	accepted, _ := ctx.Value(ciphertextKey{}).(bool)
+	return accepted
+}
+
+// withEncrypted encrypts item's tagged fields in place, runs write, and then
+// restores the plaintext. Encrypting in place rather than on a copy keeps
+// whatever the adapter writes back into item (generated IDs, timestamps)
+// visible to the caller.
+func (a *Adapter) withEncrypted(ctx context.Context, item any, write func() error) error {
+	t := structType(item)
+	if t == nil {
+		return write()
+	}
+	fields, err := planFor(t)
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return write()
+	}
+
+	var dek *dataKey
+	var restore []func()
+	defer func() {
+		for _, r := range restore {
+			r()
+		}
+	}()
+	err = eachStruct(item, func(sv reflect.Value) error {
+		for _, f := range fields {
+			plain, ok := f.getString(sv)
+			if !ok || plain == "" {
+				continue
+			}
+			// Ciphertext is stored as is only when the caller asked for
+			// it: the AAD binds a value to its field, not its row, so
+			// any other ciphertext of the field would decrypt there.
+			if IsCiphertext(plain) && acceptsCiphertext(ctx) {
+				if _, err := a.crypt.decrypt(ctx, f, plain); err == nil {
+					continue
+				}
+			}
+			if !f.deterministic && dek == nil {
+				if dek, err = a.crypt.newDataKey(ctx); err != nil {
+					return err
+				}
+			}
+			stored, err := a.crypt.encrypt(ctx, f, plain, dek)
+			if err != nil {
+				return err
+			}
+			fv := sv.FieldByIndex(f.index)
+			orig := reflect.ValueOf(fv.Interface())
+			restore = append(restore, func() { fv.Set(orig) })
+			f.setString(sv, stored)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return write()
+}
+
+// decrypt replaces the tagged fields of dest with their plaintext.
+func (a *Adapter) decrypt(ctx context.Context, dest any) error {
+	t := structType(dest)
+	if t == nil {
+		return nil
+	}
+	fields, err := planFor(t)
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+	return eachStruct(dest, func(sv reflect.Value) error {
+		for _, f := range fields {
+			stored, ok := f.getString(sv)
+			if !ok || !IsCiphertext(stored) {
+				continue
+			}
+			plain, err := a.crypt.decrypt(ctx, f, stored)
+			if err != nil {
+				return err
+			}
+			f.setString(sv, plain)
+		}
+		return nil
+	})
+}
+
+// encryptFilter rewrites equality filters on deterministic fields to their
+// ciphertext and rejects filters on randomized fields, which could never
+// match. The caller's map is not modified.
+func (a *Adapter) encryptFilter(ctx context.Context, model any, filter map[string]any) (map[string]any, error) {
+	t := structType(model)
+	if t == nil || len(filter) == 0 {
+		return filter, nil
+	}
+	fields, err := planFor(t)
+	if err != nil || len(fields) == 0 {
+		return filter, err
+	}
+
+	var out map[string]any
+	for _, f := range fields {
+		v, ok := filter[f.name]
+		if !ok || v == nil {
+			continue
+		}
+		if !f.deterministic {
+			return nil, fmt.Errorf("cannot filter on %s: it is encrypted with a random nonce; tag it magic:\"encrypted,deterministic\" to allow equality filters", f.name)
+		}
+		if out == nil {
+			out = make(map[string]any, len(filter))
+			for k, v := range filter {
+				out[k] = v
+			}
+		}
+		if out[f.name], err = a.encryptFilterValue(ctx, f, v); err != nil {
+			return nil, err
+		}
+	}
+	if out == nil {
+		return filter, nil
+	}
+	return out, nil
+}
+
+// encryptFilterValue handles the scalar and slice (IN) shapes filters take.
+func (a *Adapter) encryptFilterValue(ctx context.Context, f encryptedField, v any) (any, error) {
+	keyID := a.crypt.keys.PrimaryKeyID()
+	switch val := v.(type) {
+	case string:
+		if val == "" {
+			return val, nil
+		}
+		return a.crypt.encryptDeterministic(ctx, keyID, f.name, val)
+	case *string:
+		if val == nil || *val == "" {
+			return val, nil
+		}
+		return a.crypt.encryptDeterministic(ctx, keyID, f.name, *val)
+	case []string:
+		out := make([]string, len(val))
+		for i, s := range val {
+			enc, err := a.crypt.encryptDeterministic(ctx, keyID, f.name, s)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = enc
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("cannot filter on encrypted field %s with a %T value", f.name, v)
+}
@@ -0,0 +1,338 @@
+package encryption_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/tink3rlabs/magic/storage"
+	"github.com/tink3rlabs/magic/storage/encryption"
+)
+
+type customer struct {
+	Id    string  `json:"id" gorm:"primaryKey;column:id"`
+	Name  string  `json:"name" gorm:"column:name"`
+	SSN   string  `json:"ssn" gorm:"column:ssn" magic:"encrypted"`
+	Email string  `json:"email" gorm:"column:email" magic:"encrypted,deterministic"`
+	Token *string `json:"token" gorm:"column:token" magic:"encrypted"`
+}
+
+func (customer) TableName() string { return "encrypted_customers" }
+
+// storedRow reads a row straight from the memory adapter, bypassing
+// decryption, so tests can assert what actually reached the database.
+type storedRow struct {
+	Id    string  `gorm:"column:id"`
+	SSN   string  `gorm:"column:ssn"`
+	Email string  `gorm:"column:email"`
+	Token *string `gorm:"column:token"`
+}
+
+func setup(t *testing.T) (*storage.MemoryAdapter, *encryption.Keyring, *encryption.Adapter) {
+	t.Helper()
+	m := storage.GetMemoryAdapterInstance()
+	if err := m.Execute(`CREATE TABLE IF NOT EXISTS encrypted_customers (id TEXT PRIMARY KEY, name TEXT, ssn TEXT, email TEXT, token TEXT)`); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	if err := m.Execute(`DELETE FROM encrypted_customers`); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	key, err := encryption.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := encryption.NewKeyring("k1", map[string][]byte{"k1": key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, keys, encryption.New(m, keys)
+}
+
+func stored(t *testing.T, m *storage.MemoryAdapter, id string) storedRow {
+	t.Helper()
+	var row storedRow
+	if err := m.DB.DB.Table("encrypted_customers").Where("id = ?", id).Take(&row).Error; err != nil {
+		t.Fatalf("raw read: %v", err)
+	}
+	return row
+}
+
+func TestCreateStoresCiphertextAndGetDecrypts(t *testing.T) {
+	m, _, a := setup(t)
+	token := "tok-123"
+	c := &customer{Id: "1", Name: "Ada", SSN: "123-45-6789", Email: "ada@example.com", Token: &token}
+	if err := a.Create(c); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if c.SSN != "123-45-6789" || c.Token != &token {
+		t.Fatalf("caller's item was left encrypted: %+v", c)
+	}
+
+	row := stored(t, m, "1")
+	for name, v := range map[string]string{"ssn": row.SSN, "email": row.Email, "token": *row.Token} {
+		if !encryption.IsCiphertext(v) {
+			t.Errorf("%s stored as %q; want ciphertext", name, v)
+		}
+	}
+	if strings.Contains(row.SSN, "6789") {
+		t.Errorf("ciphertext leaks plaintext: %q", row.SSN)
+	}
+
+	var got customer
+	if err := a.Get(&got, map[string]any{"id": "1"}); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.SSN != "123-45-6789" || got.Email != "ada@example.com" || got.Token == nil || *got.Token != "tok-123" {
+		t.Fatalf("Get = %+v; want decrypted fields", got)
+	}
+}
+
+func TestInputShapedLikeCiphertextIsEncrypted(t *testing.T) {
+	m, _, a := setup(t)
+	forged := "enc:v1:r:x:y:z"
+	if err := a.Create(&customer{Id: "1", SSN: forged, Email: "enc:v1:d:x:y"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if row := stored(t, m, "1"); row.SSN == forged {
+		t.Fatalf("ssn stored in clear: %q", row.SSN)
+	}
+	var got customer
+	if err := a.Get(&got, map[string]any{"id": "1"}); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.SSN != forged || got.Email != "enc:v1:d:x:y" {
+		t.Fatalf("Get = %+v; want the values as written", got)
+	}
+}
+
+func TestCiphertextCopiedFromAnotherRowIsEncryptedAsInput(t *testing.T) {
+	m, _, a := setup(t)
+	if err := a.Create(&customer{Id: "1", SSN: "123-45-6789"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	copied := stored(t, m, "1").SSN
+	if err := a.Create(&customer{Id: "2", SSN: copied}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	var got customer
+	if err := a.Get(&got, map[string]any{"id": "2"}); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.SSN != copied {
+		t.Fatalf("ssn = %q; want the copied ciphertext, not the other row's plaintext", got.SSN)
+	}
+
+	// Rows moved between stores keep their ciphertext when asked to.
+	ctx := encryption.AcceptCiphertext(context.Background())
+	if err := a.CreateContext(ctx, &customer{Id: "3", SSN: copied}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if row := stored(t, m, "3"); row.SSN != copied {
+		t.Fatalf("ssn stored as %q; want the accepted ciphertext", row.SSN)
+	}
+}
+
+func TestRandomizedFieldsDifferPerWriteDeterministicDoNot(t *testing.T) {
+	m, _, a := setup(t)
+	for _, id := range []string{"1", "2"} {
+		if err := a.Create(&customer{Id: id, SSN: "same", Email: "same@example.com"}); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+	r1, r2 := stored(t, m, "1"), stored(t, m, "2")
+	if r1.SSN == r2.SSN {
+		t.Errorf("randomized ciphertexts are equal: %q", r1.SSN)
+	}
+	if r1.Email != r2.Email {
+		t.Errorf("deterministic ciphertexts differ: %q vs %q", r1.Email, r2.Email)
+	}
+}
+
+func TestDeterministicFieldSupportsEqualityFilters(t *testing.T) {
+	_, _, a := setup(t)
+	if err := a.Create(&customer{Id: "1", Email: "ada@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Create(&customer{Id: "2", Email: "bob@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got customer
+	if err := a.Get(&got, map[string]any{"email": "bob@example.com"}); err != nil {
+		t.Fatalf("Get by email: %v", err)
+	}
+	if got.Id != "2" || got.Email != "bob@example.com" {
+		t.Fatalf("Get by email = %+v", got)
+	}
+
+	var page []customer
+	if _, err := a.List(&page, "id", map[string]any{"email": "ada@example.com"}, 10, ""); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(page) != 1 || page[0].Email != "ada@example.com" {
+		t.Fatalf("List = %+v", page)
+	}
+
+	n, err := a.Count(&[]customer{}, map[string]any{"email": "ada@example.com"})
+	if err != nil || n != 1 {
+		t.Fatalf("Count = %d, %v; want 1", n, err)
+	}
+}
+
+func TestFilteringOnRandomizedFieldIsRejected(t *testing.T) {
+	_, _, a := setup(t)
+	var got customer
+	err := a.Get(&got, map[string]any{"ssn": "123"})
+	if err == nil || !strings.Contains(err.Error(), "deterministic") {
+		t.Fatalf("err = %v; want a hint to use deterministic", err)
+	}
+}
+
+func TestUpdateReEncryptsAndListDecryptsEveryItem(t *testing.T) {
+	m, _, a := setup(t)
+	if err := a.Create(&customer{Id: "1", SSN: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	before := stored(t, m, "1").SSN
+	if err := a.Update(&customer{Id: "1", SSN: "new"}, map[string]any{"id": "1"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if after := stored(t, m, "1").SSN; after == before || !encryption.IsCiphertext(after) {
+		t.Fatalf("stored SSN after update = %q", after)
+	}
+	if err := a.Create(&customer{Id: "2", SSN: "second"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var page []*customer
+	if _, err := a.List(&page, "id", nil, 10, ""); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(page) != 2 || page[0].SSN != "new" || page[1].SSN != "second" {
+		t.Fatalf("List = %+v, %+v", page[0], page[1])
+	}
+}
+
+func TestPlaintextRowsReadBackUnchanged(t *testing.T) {
+	m, _, a := setup(t)
+	if err := m.Execute(`INSERT INTO encrypted_customers (id, ssn) VALUES ('legacy', 'plain')`); err != nil {
+		t.Fatal(err)
+	}
+	var got customer
+	if err := a.Get(&got, map[string]any{"id": "legacy"}); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.SSN != "plain" {
+		t.Fatalf("SSN = %q; want plain", got.SSN)
+	}
+}
+
+func TestRotationKeepsOldRowsReadableAndReEncryptMovesThem(t *testing.T) {
+	m, keys, a := setup(t)
+	if err := a.Create(&customer{Id: "1", SSN: "123", Email: "ada@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	newKey, _ := encryption.GenerateKey()
+	if err := keys.Rotate("k2", newKey); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+
+	var got customer
+	if err := a.Get(&got, map[string]any{"id": "1"}); err != nil || got.SSN != "123" {
+		t.Fatalf("Get after rotation = %+v, %v", got, err)
+	}
+
+	if err := a.ReEncrypt(context.Background(), &customer{}, map[string]any{"id": "1"}); err != nil {
+		t.Fatalf("ReEncrypt: %v", err)
+	}
+	row := stored(t, m, "1")
+	for name, v := range map[string]string{"ssn": row.SSN, "email": row.Email} {
+		if id, _ := encryption.KeyID(v); id != "k2" {
+			t.Errorf("%s key id = %q after ReEncrypt; want k2", name, id)
+		}
+	}
+
+	if err := keys.Remove("k1"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	got = customer{}
+	if err := a.Get(&got, map[string]any{"email": "ada@example.com"}); err != nil || got.SSN != "123" {
+		t.Fatalf("Get after retiring k1 = %+v, %v", got, err)
+	}
+}
+
+func TestKMSProviderRoundTrip(t *testing.T) {
+	m, _, _ := setup(t)
+
+	kms := encryption.NewInMemoryKMS()
+	if err := kms.CreateKey("kms-1"); err != nil {
+		t.Fatal(err)
+	}
+	a := encryption.New(m, encryption.NewKMSProvider(kms, "kms-1"))
+	if err := a.Create(&customer{Id: "1", SSN: "123", Email: "ada@example.com"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	var got customer
+	if err := a.Get(&got, map[string]any{"email": "ada@example.com"}); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.SSN != "123" {
+		t.Fatalf("SSN = %q", got.SSN)
+	}
+	if storage.UnwrapAdapter(a) != storage.StorageAdapter(m) {
+		t.Fatalf("UnwrapAdapter should reach the memory adapter")
+	}
+}
+
+func TestKMSProviderAcceptsARNKeyIDs(t *testing.T) {
+	m, _, _ := setup(t)
+
+	arn := "arn:aws:kms:eu-west-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	kms := encryption.NewInMemoryKMS()
+	if err := kms.CreateKey(arn); err != nil {
+		t.Fatal(err)
+	}
+	a := encryption.New(m, encryption.NewKMSProvider(kms, arn))
+	if err := a.Create(&customer{Id: "1", SSN: "123", Email: "ada@example.com"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	row := stored(t, m, "1")
+	for name, v := range map[string]string{"ssn": row.SSN, "email": row.Email} {
+		if id, ok := encryption.KeyID(v); !ok || id != arn {
+			t.Errorf("%s key id = %q, %v; want the ARN", name, id, ok)
+		}
+	}
+	var got customer
+	if err := a.Get(&got, map[string]any{"email": "ada@example.com"}); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.SSN != "123" {
+		t.Fatalf("SSN = %q", got.SSN)
+	}
+}
+
+func TestCiphertextIsBoundToItsField(t *testing.T) {
+	m, _, a := setup(t)
+	if err := a.Create(&customer{Id: "1", SSN: "123", Email: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	// Copy the ssn ciphertext into the token column: it must not decrypt there.
+	if err := m.Execute(`UPDATE encrypted_customers SET token = ssn WHERE id = '1'`); err != nil {
+		t.Fatal(err)
+	}
+	var got customer
+	if err := a.Get(&got, map[string]any{"id": "1"}); err == nil {
+		t.Fatalf("Get succeeded with a swapped ciphertext: %+v", got)
+	}
+}
+
+func TestNonStringEncryptedFieldIsAnError(t *testing.T) {
+	type bad struct {
+		Id  string `json:"id"`
+		Age int    `json:"age" magic:"encrypted"`
+	}
+	_, _, a := setup(t)
+	if err := a.Create(&bad{Id: "1", Age: 3}); err == nil {
+		t.Fatalf("expected an error for an encrypted int field")
+	}
+}
@@ -0,0 +1,159 @@
+package encryption
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// TagName is the struct tag that marks encrypted fields:
+//
+//	SSN    string `json:"ssn" magic:"encrypted"`
+//	Email  string `json:"email" magic:"encrypted,deterministic"`
+const TagName = "magic"
+
+// encryptedField is one tagged field of a model type.
+type encryptedField struct {
+	index         []int
+	name          string // JSON name: the key used in filters, and the AAD
+	deterministic bool
+	pointer       bool // *string rather than string
+}
+
+// fieldPlans caches the tagged fields per struct type.
+var fieldPlans sync.Map // reflect.Type -> planResult
+
+type planResult struct {
+	fields []encryptedField
+	err    error
+}
+
+// planFor returns the encrypted fields of t, which must be a struct type.
+// Only top-level string and *string fields can be encrypted; anything else
+// tagged is reported as an error on first use rather than stored in clear.
+func planFor(t reflect.Type) ([]encryptedField, error) {
+	if cached, ok := fieldPlans.Load(t); ok {
+		r := cached.(planResult)
+		return r.fields, r.err
+	}
+
+	var fields []encryptedField
+	var err error
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		encrypted, deterministic := parseTag(f.Tag.Get(TagName))
+		if !encrypted {
+			continue
+		}
+		pointer := f.Type.Kind() == reflect.Pointer
+		base := f.Type
+		if pointer {
+			base = base.Elem()
+		}
+		if base.Kind() != reflect.String {
+			err = fmt.Errorf("encrypted field %s.%s must be a string or *string, got %s", t.Name(), f.Name, f.Type)
+			break
+		}
+		fields = append(fields, encryptedField{
+			index:         f.Index,
+			name:          jsonName(f),
+			deterministic: deterministic,
+			pointer:       pointer,
+		})
+	}
+
+	fieldPlans.Store(t, planResult{fields: fields, err: err})
+	return fields, err
+}
+
+func parseTag(tag string) (encrypted, deterministic bool) {
+	for _, opt := range strings.Split(tag, ",") {
+		switch strings.TrimSpace(opt) {
+		case "encrypted":
+			encrypted = true
+		case "deterministic":
+			deterministic = true
+		}
+	}
+	return encrypted, encrypted && deterministic
+}
+
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return f.Name
+	}
+	return name
+}
+
+// structType strips pointer and slice layers so &T, &[]T and []*T all
+// resolve to T. It returns nil when there is no struct underneath.
+func structType(v any) reflect.Type {
+	t := reflect.TypeOf(v)
+	for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	return t
+}
+
+// eachStruct calls fn for every addressable struct value reachable from v: a
+// pointer to a struct, or a pointer to a slice of structs or struct pointers.
+func eachStruct(v any, fn func(reflect.Value) error) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Struct:
+		if !rv.CanAddr() {
+			return nil
+		}
+		return fn(rv)
+	case reflect.Slice:
+		for i := 0; i < rv.Len(); i++ {
+			elem := rv.Index(i)
+			for elem.Kind() == reflect.Pointer {
+				if elem.IsNil() {
+					break
+				}
+				elem = elem.Elem()
+			}
+			if elem.Kind() == reflect.Struct {
+				if err := fn(elem); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// getString reads a tagged field; ok is false for a nil *string.
+func (f encryptedField) getString(sv reflect.Value) (string, bool) {
+	fv := sv.FieldByIndex(f.index)
+	if f.pointer {
+		if fv.IsNil() {
+			return "", false
+		}
+		return fv.Elem().String(), true
+	}
+	return fv.String(), true
+}
+
+// setString writes a tagged field. A *string field gets a fresh pointer so the
+// caller's original string is never aliased by the ciphertext.
+func (f encryptedField) setString(sv reflect.Value, s string) {
+	fv := sv.FieldByIndex(f.index)
+	if f.pointer {
+		fv.Set(reflect.ValueOf(&s))
+		return
+	}
+	fv.SetString(s)
+}
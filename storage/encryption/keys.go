@@ -0,0 +1,251 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// KeySize is the length in bytes of master keys, data keys and derived keys
+// (AES-256).
+const KeySize = 32
+
+// ErrKeyNotFound is returned when a ciphertext names a key the provider does
+// not hold, typically because a retired key was removed before every row was
+// re-encrypted.
+var ErrKeyNotFound = errors.New("encryption key not found")
+
+// KeyProvider holds the master keys that protect data keys.
+//
+// Randomized fields use envelope encryption: every write generates a fresh
+// data key, which is wrapped under the primary master key and stored next to
+// the ciphertext. Deterministic fields instead use a key derived from the
+// master key, so equal plaintexts produce equal ciphertexts.
+//
+// Ciphertexts record the ID of the master key that produced them, so rotating
+// the primary key never breaks reads of older rows as long as the old key is
+// still held by the provider.
+type KeyProvider interface {
+	// PrimaryKeyID names the key new writes are encrypted under.
+	PrimaryKeyID() string
+	// WrapKey encrypts a data key under the named master key.
+	WrapKey(ctx context.Context, keyID string, dek []byte) ([]byte, error)
+	// UnwrapKey reverses WrapKey.
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+	// DeriveKey returns a KeySize-byte key that is a deterministic function of
+	// the named master key and label.
+	DeriveKey(ctx context.Context, keyID string, label []byte) ([]byte, error)
+}
+
+// GenerateKey returns a new random master key.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+// Keyring is a KeyProvider backed by master keys held in process, usually
+// loaded from a local file with LoadKeyringFile. It suits development and
+// deployments that inject the file from a secrets manager; use KMSProvider
+// when master keys must never leave a KMS.
+type Keyring struct {
+	mu      sync.RWMutex
+	primary string
+	keys    map[string][]byte
+}
+
+// keyringFile is the on-disk format read by LoadKeyringFile:
+//
+//	{"primary": "2024-06", "keys": {"2024-01": "<base64>", "2024-06": "<base64>"}}
+type keyringFile struct {
+	Primary string            `json:"primary"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// NewKeyring creates a keyring from raw master keys. primary must be one of
+// the keys.
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string][]byte, len(keys))}
+	for id, key := range keys {
+		if err := validateKey(id, key); err != nil {
+			return nil, err
+		}
+		k.keys[id] = append([]byte(nil), key...)
+	}
+	if _, ok := k.keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not in the keyring", primary)
+	}
+	k.primary = primary
+	return k, nil
+}
+
+// LoadKeyringFile reads a keyring from a JSON file whose keys are standard
+// base64 encoded.
+func LoadKeyringFile(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring file: %w", err)
+	}
+	var f keyringFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring file: %w", err)
+	}
+	keys := make(map[string][]byte, len(f.Keys))
+	for id, encoded := range f.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q is not valid base64: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return NewKeyring(f.Primary, keys)
+}
+
+// SaveKeyringFile writes the keyring in the format LoadKeyringFile reads,
+// readable only by the owner.
+func (k *Keyring) SaveKeyringFile(path string) error {
+	k.mu.RLock()
+	f := keyringFile{Primary: k.primary, Keys: make(map[string]string, len(k.keys))}
+	for id, key := range k.keys {
+		f.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	k.mu.RUnlock()
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// Rotate adds key under id and makes it the primary. Previous keys stay in
+// the keyring so existing ciphertexts remain readable; see Adapter.ReEncrypt
+// for moving rows onto the new key.
+func (k *Keyring) Rotate(id string, key []byte) error {
+	if err := validateKey(id, key); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, exists := k.keys[id]; exists {
+		return fmt.Errorf("key %q already exists", id)
+	}
+	k.keys[id] = append([]byte(nil), key...)
+	k.primary = id
+	return nil
+}
+
+// Remove drops a retired key. Rows still encrypted under it become
+// unreadable, so only remove a key once every row has been re-encrypted.
+func (k *Keyring) Remove(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id == k.primary {
+		return fmt.Errorf("cannot remove the primary key %q", id)
+	}
+	delete(k.keys, id)
+	return nil
+}
+
+func (k *Keyring) PrimaryKeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.primary
+}
+
+func (k *Keyring) WrapKey(ctx context.Context, keyID string, dek []byte) ([]byte, error) {
+	master, err := k.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return sealRandom(master, dek, []byte(keyID))
+}
+
+func (k *Keyring) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	master, err := k.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return open(master, wrapped, []byte(keyID))
+}
+
+func (k *Keyring) DeriveKey(ctx context.Context, keyID string, label []byte) ([]byte, error) {
+	master, err := k.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return hkdf.Key(sha256.New, master, nil, string(label), KeySize)
+}
+
+func (k *Keyring) key(id string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, id)
+	}
+	return key, nil
+}
+
+// validateKey rejects IDs that would break the ciphertext format and keys of
+// the wrong size.
+func validateKey(id string, key []byte) error {
+	if id == "" || strings.Contains(id, ":") {
+		return fmt.Errorf("invalid key id %q: must be non-empty and must not contain ':'", id)
+	}
+	if len(key) != KeySize {
+		return fmt.Errorf("key %q is %d bytes; want %d", id, len(key), KeySize)
+	}
+	return nil
+}
+
+// sealRandom encrypts plaintext with AES-GCM under a random nonce, returning
+// nonce||ciphertext.
+func sealRandom(key, plaintext, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// open reverses sealRandom and sealDeterministic.
+func open(key, sealed, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ct := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ct, aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
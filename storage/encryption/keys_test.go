@@ -0,0 +1,86 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyringFileRoundTrip(t *testing.T) {
+	k1, _ := GenerateKey()
+	k2, _ := GenerateKey()
+	ring, err := NewKeyring("k2", map[string][]byte{"k1": k1, "k2": k2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	if err := ring.SaveKeyringFile(path); err != nil {
+		t.Fatalf("SaveKeyringFile: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("keyring file mode = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+
+	loaded, err := LoadKeyringFile(path)
+	if err != nil {
+		t.Fatalf("LoadKeyringFile: %v", err)
+	}
+	if loaded.PrimaryKeyID() != "k2" {
+		t.Fatalf("primary = %q; want k2", loaded.PrimaryKeyID())
+	}
+
+	ctx := context.Background()
+	wrapped, err := ring.WrapKey(ctx, "k1", []byte("data key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := loaded.UnwrapKey(ctx, "k1", wrapped)
+	if err != nil || string(plain) != "data key" {
+		t.Fatalf("UnwrapKey = %q, %v", plain, err)
+	}
+}
+
+func TestKeyringValidation(t *testing.T) {
+	key, _ := GenerateKey()
+	if _, err := NewKeyring("missing", map[string][]byte{"k1": key}); err == nil {
+		t.Errorf("expected an error for a primary that is not in the keyring")
+	}
+	if _, err := NewKeyring("a:b", map[string][]byte{"a:b": key}); err == nil {
+		t.Errorf("expected an error for a key id containing ':'")
+	}
+	if _, err := NewKeyring("k1", map[string][]byte{"k1": key[:16]}); err == nil {
+		t.Errorf("expected an error for a short key")
+	}
+
+	ring, _ := NewKeyring("k1", map[string][]byte{"k1": key})
+	if err := ring.Remove("k1"); err == nil {
+		t.Errorf("expected an error removing the primary key")
+	}
+	if _, err := ring.UnwrapKey(context.Background(), "gone", nil); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("UnwrapKey(unknown) = %v; want ErrKeyNotFound", err)
+	}
+}
+
+func TestDeriveKeyIsDeterministicPerLabel(t *testing.T) {
+	ctx := context.Background()
+	kms := NewInMemoryKMS()
+	if err := kms.CreateKey("k"); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := GenerateKey()
+	ring, _ := NewKeyring("k", map[string][]byte{"k": key})
+
+	for name, p := range map[string]KeyProvider{"keyring": ring, "kms": NewKMSProvider(kms, "k")} {
+		a, err := p.DeriveKey(ctx, "k", []byte("email"))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		b, _ := p.DeriveKey(ctx, "k", []byte("email"))
+		c, _ := p.DeriveKey(ctx, "k", []byte("phone"))
+		if string(a) != string(b) || string(a) == string(c) || len(a) != KeySize {
+			t.Errorf("%s: DeriveKey is not a deterministic per-label %d-byte key", name, KeySize)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// FacetCount is the number of matching items holding one value of a faceted
+// field. Items without a value are reported under a nil Value.
+type FacetCount struct {
+	Value any   `json:"value"`
+	Count int64 `json:"count"`
+}
+
+// Facets maps each requested facet field to its counts, ordered by Count
+// (highest first) and then by Value.
+type Facets map[string][]FacetCount
+
+// Faceter is an extension interface for adapters that can return facet counts
+// alongside a Lucene search, e.g. how many matching tasks exist per status. It
+// is kept separate from StorageAdapter for the same reason as Aggregator;
+// callers type-assert for it.
+//
+// The hits and cursor behave exactly like Search. Facet counts are computed
+// over every item the query matches, not just the returned page. A facet on an
+// array field counts each element, so an item tagged ["go","db"] adds one to
+// both "go" and "db".
+//
+// The wrapper returned by StorageAdapterFactory always implements Faceter; it
+// returns ErrNotSupported when the underlying adapter does not.
+type Faceter interface {
+	SearchWithFacets(dest any, sortKey string, query string, limit int, cursor string, facets []string, params ...map[string]any) (string, Facets, error)
+	SearchWithFacetsContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, facets []string, params ...map[string]any) (string, Facets, error)
+}
+
+// countFacets counts facet values client-side for adapters without GROUP BY.
+// Slice values (other than []byte) are counted per element, mirroring the SQL
+// unnesting of array fields.
+func countFacets(items []map[string]any, fields []string) Facets {
+	out := make(Facets, len(fields))
+	for _, field := range fields {
+		counts := map[string]*FacetCount{}
+		add := func(v any) {
+			k := fmt.Sprintf("%T:%v", v, v)
+			if c, ok := counts[k]; ok {
+				c.Count++
+				return
+			}
+			counts[k] = &FacetCount{Value: v, Count: 1}
+		}
+		for _, item := range items {
+			v := item[field]
+			rv := reflect.ValueOf(v)
+			if v != nil && (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() != reflect.Uint8 {
+				for i := 0; i < rv.Len(); i++ {
+					add(rv.Index(i).Interface())
+				}
+				continue
+			}
+			add(v)
+		}
+
+		list := make([]FacetCount, 0, len(counts))
+		for _, c := range counts {
+			list = append(list, *c)
+		}
+		sortFacetCounts(list)
+		out[field] = list
+	}
+	return out
+}
+
+// sortFacetCounts applies the documented Facets order.
+func sortFacetCounts(list []FacetCount) {
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return compareValues(list[i].Value, list[j].Value) < 0
+	})
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCountFacetsCountsArrayElementsAndOrders(t *testing.T) {
+	items := []map[string]any{
+		{"status": "open", "tags": []any{"go", "db"}},
+		{"status": "open", "tags": []any{"go"}},
+		{"status": "closed"},
+	}
+	got := countFacets(items, []string{"status", "tags"})
+
+	status := got["status"]
+	if len(status) != 2 || status[0] != (FacetCount{Value: "open", Count: 2}) || status[1] != (FacetCount{Value: "closed", Count: 1}) {
+		t.Fatalf("status = %+v", status)
+	}
+	// The item without tags is reported under a nil value; ties sort by value.
+	tags := got["tags"]
+	want := []FacetCount{{Value: "go", Count: 2}, {Value: nil, Count: 1}, {Value: "db", Count: 1}}
+	if len(tags) != len(want) {
+		t.Fatalf("tags = %+v; want %+v", tags, want)
+	}
+	for i := range want {
+		if tags[i] != want[i] {
+			t.Fatalf("tags = %+v; want %+v", tags, want)
+		}
+	}
+}
+
+func TestInstrumentedSearchWithFacetsOnLegacyAdapterIsNotSupported(t *testing.T) {
+	wrapped := wrapForTelemetry(&legacyAdapter{provider: "legacy-db"})
+	f, ok := wrapped.(Faceter)
+	if !ok {
+		t.Fatalf("wrapper should always implement Faceter")
+	}
+	_, _, err := f.SearchWithFacetsContext(context.Background(), &[]struct{}{}, "", "", 10, "", []string{"status"})
+	if !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("err = %v; want ErrNotSupported", err)
+	}
+}
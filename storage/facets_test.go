@@ -0,0 +1,90 @@
+package storage_test
+
+import (
+	"errors"
+	"testing"
+
+	magicerrors "github.com/tink3rlabs/magic/errors"
+	"github.com/tink3rlabs/magic/storage"
+)
+
+type facetItem struct {
+	Id     string   `json:"id" gorm:"primaryKey;column:id"`
+	Status string   `json:"status" gorm:"column:status"`
+	Tags   []string `json:"tags" gorm:"column:tags;serializer:json"`
+}
+
+func (facetItem) TableName() string { return "facet_items" }
+
+// setupFacets seeds the sqlite memory adapter with three items, two of them
+// "open", and tags stored as JSON arrays.
+func setupFacets(t *testing.T) *storage.MemoryAdapter {
+	t.Helper()
+	m := storage.GetMemoryAdapterInstance()
+	if err := m.Execute(`CREATE TABLE IF NOT EXISTS facet_items (id TEXT PRIMARY KEY, status TEXT, tags TEXT)`); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	if err := m.Execute(`DELETE FROM facet_items`); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	for _, item := range []facetItem{
+		{Id: "1", Status: "open", Tags: []string{"go", "db"}},
+		{Id: "2", Status: "open", Tags: []string{"go"}},
+		{Id: "3", Status: "closed", Tags: []string{"db"}},
+	} {
+		if err := m.Create(&item); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+	return m
+}
+
+func TestMemoryAdapterSearchWithFacetsCountsScalarAndArrayFields(t *testing.T) {
+	m := setupFacets(t)
+
+	var hits []facetItem
+	_, facets, err := m.SearchWithFacets(&hits, "id", "", 1, "", []string{"status", "tags"})
+	if err != nil {
+		t.Fatalf("SearchWithFacets: %v", err)
+	}
+	if len(hits) != 1 {
+		t.Fatalf("len(hits) = %d; want the page size of 1", len(hits))
+	}
+
+	status := facets["status"]
+	if len(status) != 2 || status[0].Value != "open" || status[0].Count != 2 || status[1].Count != 1 {
+		t.Fatalf("status facet = %+v; want open:2 then closed:1", status)
+	}
+	tags := facets["tags"]
+	if len(tags) != 2 || tags[0].Value != "db" || tags[0].Count != 2 || tags[1].Value != "go" || tags[1].Count != 2 {
+		t.Fatalf("tags facet = %+v; want db:2 and go:2 in value order", tags)
+	}
+}
+
+func TestMemoryAdapterSearchWithFacetsHonoursQuery(t *testing.T) {
+	m := setupFacets(t)
+
+	var hits []facetItem
+	_, facets, err := m.SearchWithFacets(&hits, "id", "status:open", 10, "", []string{"tags"})
+	if err != nil {
+		t.Fatalf("SearchWithFacets: %v", err)
+	}
+	if len(hits) != 2 {
+		t.Fatalf("len(hits) = %d; want 2", len(hits))
+	}
+	tags := facets["tags"]
+	if len(tags) != 2 || tags[0].Value != "go" || tags[0].Count != 2 || tags[1].Value != "db" || tags[1].Count != 1 {
+		t.Fatalf("tags facet = %+v; want go:2 then db:1", tags)
+	}
+}
+
+func TestMemoryAdapterSearchWithFacetsUnknownFieldIsBadRequest(t *testing.T) {
+	m := setupFacets(t)
+
+	var hits []facetItem
+	_, _, err := m.SearchWithFacets(&hits, "id", "", 10, "", []string{"bogus"})
+	var bad *magicerrors.BadRequest
+	if !errors.As(err, &bad) {
+		t.Fatalf("err = %v; want *errors.BadRequest", err)
+	}
+}
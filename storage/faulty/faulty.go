@@ -0,0 +1,462 @@
+// Package faulty injects failures and latency into a storage adapter, for
+// testing retry logic and error handling. The Adapter decorator matches each
+// call against a list of Rules and, when one fires, delays the call, fails it
+// with an error, or holds it until it times out.
+//
+//	adapter := storage.Instrument(faulty.New(storage.UnwrapAdapter(base), faulty.Options{
+//		Seed: 42,
+//		Rules: []faulty.Rule{
+//			{Ops: []faulty.Op{faulty.OpUpdate}, Calls: []int{3}, Err: faulty.DynamoDBThrottle()},
+//			{Models: []string{"Order"}, Probability: 0.1, Latency: 200 * time.Millisecond},
+//		},
+//	}))
+//
+// Rules are evaluated in order on every call, and the random draws are taken
+// from a source seeded with Options.Seed, so a test that makes the same calls
+// sees the same faults on every run. Each injected fault is added as an event
+// to the span in the call's context; wrapping the decorator with
+// storage.Instrument, as above, puts that span around it.
+package faulty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tink3rlabs/magic/storage"
+)
+
+// Op names a storage operation. The values match the operation label of the
+// storage metrics.
+type Op string
+
+const (
+	OpCreate  Op = "create"
+	OpGet     Op = "get"
+	OpUpdate  Op = "update"
+	OpDelete  Op = "delete"
+	OpList    Op = "list"
+	OpSearch  Op = "search"
+	OpCount   Op = "count"
+	OpQuery   Op = "query"
+	OpExecute Op = "execute"
+	OpPing    Op = "ping"
+)
+
+// ErrInjected matches, with errors.Is, every error the Adapter injects.
+var ErrInjected = errors.New("faulty: injected fault")
+
+// Rule selects calls and describes the fault to inject into them. A call must
+// match Ops and Models to be counted by the rule; Calls, Times and
+// Probability then decide whether the fault fires.
+type Rule struct {
+	// Name identifies the rule in injected errors, span events and
+	// Injections. Defaults to "rule-<index>".
+	Name string
+	// Ops restricts the rule to these operations. Empty matches every
+	// operation.
+	Ops []Op
+	// Models restricts the rule to these model type names, such as "Order"
+	// for an *Order or *[]Order. Empty matches every call; a non-empty list
+	// never matches Execute or Ping, which have no model.
+	Models []string
+	// Calls lists the matching calls, counted from 1, the fault fires on:
+	// []int{3} with Ops set to OpUpdate fails the third Update. Empty fires
+	// on every matching call.
+	Calls []int
+	// Times caps how often the fault fires. Zero means no cap.
+	Times int
+	// Probability is the chance a matching call is hit, between 0 and 1.
+	// Zero means always.
+	Probability float64
+
+	// Latency delays the call. It is combined with Err or Timeout, or on
+	// its own slows a call that then proceeds.
+	Latency time.Duration
+	// Timeout holds the call for this long, or until its context is done,
+	// and then fails it with an error that matches context.DeadlineExceeded.
+	// The wrapped adapter is not called.
+	Timeout time.Duration
+	// Err fails the call without calling the wrapped adapter. The returned
+	// error wraps Err, so errors.Is and errors.As see through it.
+	Err error
+}
+
+// Options configures an Adapter.
+type Options struct {
+	// Rules are evaluated in order on every call. Latency from every rule
+	// that fires is added up; the first one that fires with a Timeout or Err
+	// ends the call.
+	Rules []Rule
+	// Seed seeds the source of Probability draws.
+	Seed uint64
+}
+
+// Injection describes a fault the Adapter injected.
+type Injection struct {
+	Rule  string
+	Op    Op
+	Model string
+	// Call is the number of the call among those the rule matched.
+	Call int
+}
+
+// InjectedError is the error returned for a fault. It matches ErrInjected
+// and unwraps to the rule's error.
+type InjectedError struct {
+	Rule string
+	Op   Op
+	Err  error
+}
+
+func (e *InjectedError) Error() string {
+	return fmt.Sprintf("faulty: rule %s failed %s: %v", e.Rule, e.Op, e.Err)
+}
+
+func (e *InjectedError) Unwrap() error { return e.Err }
+
+func (e *InjectedError) Is(target error) bool { return target == ErrInjected }
+
+// DynamoDBThrottle returns the error DynamoDB reports when a table's
+// provisioned throughput is exhausted.
+func DynamoDBThrottle() error {
+	return &types.ProvisionedThroughputExceededException{
+		Message: aws.String("The level of configured provisioned throughput for the table was exceeded. Consider increasing your provisioning level with the UpdateTable API."),
+	}
+}
+
+// CosmosThrottle returns the error the Cosmos DB SDK reports for a request
+// rate too large: status 429 with the delay the service asks for.
+func CosmosThrottle(retryAfter time.Duration) error {
+	header := http.Header{}
+	header.Set("x-ms-retry-after-ms", strconv.FormatInt(retryAfter.Milliseconds(), 10))
+	return &azcore.ResponseError{
+		ErrorCode:  "TooManyRequests",
+		StatusCode: http.StatusTooManyRequests,
+		RawResponse: &http.Response{
+			Status:     "429 Too Many Requests",
+			StatusCode: http.StatusTooManyRequests,
+			Header:     header,
+			Body:       http.NoBody,
+		},
+	}
+}
+
+// Adapter is a storage.ContextualStorageAdapter decorator that injects the
+// faults its rules describe. Schema and migration methods pass through
+// untouched.
+type Adapter struct {
+	inner    storage.StorageAdapter
+	ctxInner storage.ContextualStorageAdapter
+	rules    []Rule
+
+	mu         sync.Mutex
+	seed       uint64
+	rng        *rand.Rand
+	matched    []int
+	fired      []int
+	injections []Injection
+}
+
+var _ storage.ContextualStorageAdapter = (*Adapter)(nil)
+
+// New wraps inner.
+func New(inner storage.StorageAdapter, opts Options) *Adapter {
+	rules := slices.Clone(opts.Rules)
+	for i := range rules {
+		if rules[i].Name == "" {
+			rules[i].Name = "rule-" + strconv.Itoa(i)
+		}
+	}
+	a := &Adapter{inner: inner, rules: rules, seed: opts.Seed}
+	if c, ok := inner.(storage.ContextualStorageAdapter); ok {
+		a.ctxInner = c
+	}
+	a.Reset()
+	return a
+}
+
+// UnwrapStorageAdapter returns the wrapped adapter.
+func (a *Adapter) UnwrapStorageAdapter() storage.StorageAdapter {
+	return a.inner
+}
+
+// Reset clears the call counts and injections and reseeds the random source,
+// so the rules replay from the start.
+func (a *Adapter) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rng = rand.New(rand.NewPCG(a.seed, a.seed))
+	a.matched = make([]int, len(a.rules))
+	a.fired = make([]int, len(a.rules))
+	a.injections = nil
+}
+
+// Injections returns the faults injected so far, oldest first.
+func (a *Adapter) Injections() []Injection {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.injections)
+}
+
+// firing returns the rules that fire for a call, recording them.
+func (a *Adapter) firing(op Op, model string) []Rule {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var fire []Rule
+	for i, r := range a.rules {
+		if len(r.Ops) > 0 && !slices.Contains(r.Ops, op) {
+			continue
+		}
+		if len(r.Models) > 0 && (model == "" || !slices.Contains(r.Models, model)) {
+			continue
+		}
+		a.matched[i]++
+		if len(r.Calls) > 0 && !slices.Contains(r.Calls, a.matched[i]) {
+			continue
+		}
+		if r.Times > 0 && a.fired[i] >= r.Times {
+			continue
+		}
+		// Draw only for rules that need it, so adding a rule without a
+		// Probability does not shift the draws of the others.
+		if r.Probability > 0 && a.rng.Float64() >= r.Probability {
+			continue
+		}
+		a.fired[i]++
+		a.injections = append(a.injections, Injection{Rule: r.Name, Op: op, Model: model, Call: a.matched[i]})
+		fire = append(fire, r)
+	}
+	return fire
+}
+
+// inject applies the faults for a call. A nil result means the call goes on
+// to the wrapped adapter.
+func (a *Adapter) inject(ctx context.Context, op Op, model any) error {
+	span := trace.SpanFromContext(ctx)
+	for _, r := range a.firing(op, modelName(model)) {
+		span.AddEvent("magic.storage.fault", trace.WithAttributes(
+			attribute.String("magic.fault.rule", r.Name),
+			attribute.String("magic.fault.kind", kind(r)),
+			attribute.String("magic.storage.operation", string(op)),
+			attribute.Int64("magic.fault.latency_ms", r.Latency.Milliseconds()),
+		))
+		if err := wait(ctx, r.Latency); err != nil {
+			return err
+		}
+		switch {
+		case r.Timeout > 0:
+			if err := wait(ctx, r.Timeout); err != nil {
+				return &InjectedError{Rule: r.Name, Op: op, Err: err}
+			}
+			return &InjectedError{Rule: r.Name, Op: op, Err: context.DeadlineExceeded}
+		case r.Err != nil:
+			return &InjectedError{Rule: r.Name, Op: op, Err: r.Err}
+		}
+	}
+	return nil
+}
+
+func kind(r Rule) string {
+	switch {
+	case r.Timeout > 0:
+		return "timeout"
+	case r.Err != nil:
+		return "error"
+	default:
+		return "latency"
+	}
+}
+
+// wait sleeps for d, returning early with the context's error when it is
+// done first.
+func wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// modelName reports the element type name of a model, as the storage
+// telemetry does.
+func modelName(v any) string {
+	if v == nil {
+		return ""
+	}
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+func (a *Adapter) GetType() storage.StorageAdapterType   { return a.inner.GetType() }
+func (a *Adapter) GetProvider() storage.StorageProviders { return a.inner.GetProvider() }
+func (a *Adapter) GetSchemaName() string                 { return a.inner.GetSchemaName() }
+func (a *Adapter) CreateSchema() error                   { return a.inner.CreateSchema() }
+func (a *Adapter) CreateMigrationTable() error           { return a.inner.CreateMigrationTable() }
+func (a *Adapter) GetLatestMigration() (int, error)      { return a.inner.GetLatestMigration() }
+
+func (a *Adapter) UpdateMigrationTable(id int, name string, desc string) error {
+	return a.inner.UpdateMigrationTable(id, name, desc)
+}
+
+func (a *Adapter) Execute(statement string) error {
+	return a.ExecuteContext(context.Background(), statement)
+}
+
+func (a *Adapter) ExecuteContext(ctx context.Context, statement string) error {
+	if err := a.inject(ctx, OpExecute, nil); err != nil {
+		return err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.ExecuteContext(ctx, statement)
+	}
+	return a.inner.Execute(statement)
+}
+
+func (a *Adapter) Ping() error {
+	return a.PingContext(context.Background())
+}
+
+func (a *Adapter) PingContext(ctx context.Context) error {
+	if err := a.inject(ctx, OpPing, nil); err != nil {
+		return err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.PingContext(ctx)
+	}
+	return a.inner.Ping()
+}
+
+func (a *Adapter) Create(item any, params ...map[string]any) error {
+	return a.CreateContext(context.Background(), item, params...)
+}
+
+func (a *Adapter) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	if err := a.inject(ctx, OpCreate, item); err != nil {
+		return err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.CreateContext(ctx, item, params...)
+	}
+	return a.inner.Create(item, params...)
+}
+
+func (a *Adapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
+	return a.GetContext(context.Background(), dest, filter, params...)
+}
+
+func (a *Adapter) GetContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error {
+	if err := a.inject(ctx, OpGet, dest); err != nil {
+		return err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.GetContext(ctx, dest, filter, params...)
+	}
+	return a.inner.Get(dest, filter, params...)
+}
+
+func (a *Adapter) Update(item any, filter map[string]any, params ...map[string]any) error {
+	return a.UpdateContext(context.Background(), item, filter, params...)
+}
+
+func (a *Adapter) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	if err := a.inject(ctx, OpUpdate, item); err != nil {
+		return err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.UpdateContext(ctx, item, filter, params...)
+	}
+	return a.inner.Update(item, filter, params...)
+}
+
+func (a *Adapter) Delete(item any, filter map[string]any, params ...map[string]any) error {
+	return a.DeleteContext(context.Background(), item, filter, params...)
+}
+
+func (a *Adapter) DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	if err := a.inject(ctx, OpDelete, item); err != nil {
+		return err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.DeleteContext(ctx, item, filter, params...)
+	}
+	return a.inner.Delete(item, filter, params...)
+}
+
+func (a *Adapter) List(dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.ListContext(context.Background(), dest, sortKey, filter, limit, cursor, params...)
+}
+
+func (a *Adapter) ListContext(ctx context.Context, dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	if err := a.inject(ctx, OpList, dest); err != nil {
+		return "", err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.ListContext(ctx, dest, sortKey, filter, limit, cursor, params...)
+	}
+	return a.inner.List(dest, sortKey, filter, limit, cursor, params...)
+}
+
+func (a *Adapter) Search(dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.SearchContext(context.Background(), dest, sortKey, query, limit, cursor, params...)
+}
+
+func (a *Adapter) SearchContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	if err := a.inject(ctx, OpSearch, dest); err != nil {
+		return "", err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.SearchContext(ctx, dest, sortKey, query, limit, cursor, params...)
+	}
+	return a.inner.Search(dest, sortKey, query, limit, cursor, params...)
+}
+
+func (a *Adapter) Count(dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	return a.CountContext(context.Background(), dest, filter, params...)
+}
+
+func (a *Adapter) CountContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	if err := a.inject(ctx, OpCount, dest); err != nil {
+		return 0, err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.CountContext(ctx, dest, filter, params...)
+	}
+	return a.inner.Count(dest, filter, params...)
+}
+
+func (a *Adapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.QueryContext(context.Background(), dest, statement, limit, cursor, params...)
+}
+
+func (a *Adapter) QueryContext(ctx context.Context, dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	if err := a.inject(ctx, OpQuery, dest); err != nil {
+		return "", err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.QueryContext(ctx, dest, statement, limit, cursor, params...)
+	}
+	return a.inner.Query(dest, statement, limit, cursor, params...)
+}
@@ -0,0 +1,218 @@
+package faulty_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/codes"
+
+	"github.com/tink3rlabs/magic/observability/obstest"
+	"github.com/tink3rlabs/magic/storage"
+	"github.com/tink3rlabs/magic/storage/faulty"
+)
+
+type widget struct {
+	Id   string `json:"id" gorm:"primaryKey;column:id"`
+	Name string `json:"name" gorm:"column:name"`
+}
+
+func (widget) TableName() string { return "faulty_widgets" }
+
+type gadget struct {
+	Id string `json:"id" gorm:"primaryKey;column:id"`
+}
+
+func (gadget) TableName() string { return "faulty_gadgets" }
+
+func memory(t *testing.T) *storage.MemoryAdapter {
+	t.Helper()
+	m := storage.GetMemoryAdapterInstance()
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS faulty_widgets (id TEXT PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE IF NOT EXISTS faulty_gadgets (id TEXT PRIMARY KEY)`,
+		`DELETE FROM faulty_widgets`,
+		`DELETE FROM faulty_gadgets`,
+	} {
+		if err := m.Execute(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	return m
+}
+
+func TestScriptedCallFailsOnlyTheThirdUpdate(t *testing.T) {
+	a := faulty.New(memory(t), faulty.Options{Rules: []faulty.Rule{
+		{Name: "third-update", Ops: []faulty.Op{faulty.OpUpdate}, Calls: []int{3}, Err: faulty.DynamoDBThrottle()},
+	}})
+	w := &widget{Id: "1", Name: "v0"}
+	if err := a.Create(w); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		w.Name = "v" + string(rune('0'+i))
+		err := a.Update(w, map[string]any{"id": "1"})
+		if i != 3 {
+			if err != nil {
+				t.Fatalf("update %d: %v", i, err)
+			}
+			continue
+		}
+		var throttled *types.ProvisionedThroughputExceededException
+		if !errors.Is(err, faulty.ErrInjected) || !errors.As(err, &throttled) {
+			t.Fatalf("update 3 error = %v, want an injected DynamoDB throttle", err)
+		}
+	}
+
+	var got widget
+	if err := a.Get(&got, map[string]any{"id": "1"}); err != nil || got.Name != "v4" {
+		t.Fatalf("Get = %+v, %v; want the fourth update applied", got, err)
+	}
+	want := []faulty.Injection{{Rule: "third-update", Op: faulty.OpUpdate, Model: "widget", Call: 3}}
+	if got := a.Injections(); !slices.Equal(got, want) {
+		t.Fatalf("Injections = %+v, want %+v", got, want)
+	}
+}
+
+func TestProbabilityIsDeterministicForASeed(t *testing.T) {
+	m := memory(t)
+	run := func(seed uint64) []int {
+		a := faulty.New(m, faulty.Options{Seed: seed, Rules: []faulty.Rule{
+			{Ops: []faulty.Op{faulty.OpCount}, Probability: 0.3, Err: errors.New("boom")},
+		}})
+		var failed []int
+		for i := range 100 {
+			if _, err := a.Count(&[]widget{}, nil); err != nil {
+				failed = append(failed, i)
+			}
+		}
+		return failed
+	}
+
+	first := run(7)
+	if len(first) < 15 || len(first) > 45 {
+		t.Fatalf("%d of 100 calls failed at probability 0.3", len(first))
+	}
+	if again := run(7); !slices.Equal(first, again) {
+		t.Fatalf("seed 7 failed calls %v, then %v", first, again)
+	}
+	if other := run(8); slices.Equal(first, other) {
+		t.Fatalf("seeds 7 and 8 failed the same calls %v", first)
+	}
+}
+
+func TestRulesSelectByModelAndCapWithTimes(t *testing.T) {
+	a := faulty.New(memory(t), faulty.Options{Rules: []faulty.Rule{
+		{Models: []string{"widget"}, Ops: []faulty.Op{faulty.OpGet}, Times: 2, Err: storage.ErrNotFound},
+	}})
+	if err := a.Create(&widget{Id: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Create(&gadget{Id: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Get(&gadget{}, map[string]any{"id": "1"}); err != nil {
+		t.Fatalf("Get(gadget) = %v; the rule is for widgets", err)
+	}
+	for i := 1; i <= 3; i++ {
+		err := a.Get(&widget{}, map[string]any{"id": "1"})
+		if wantFault := i <= 2; errors.Is(err, storage.ErrNotFound) != wantFault {
+			t.Fatalf("Get(widget) %d = %v, want fault %v", i, err, wantFault)
+		}
+	}
+	if err := a.Ping(); err != nil {
+		t.Fatalf("Ping = %v; a model rule must not match calls without a model", err)
+	}
+}
+
+func TestTimeoutEndsWithTheContext(t *testing.T) {
+	a := faulty.New(memory(t), faulty.Options{Rules: []faulty.Rule{
+		{Ops: []faulty.Op{faulty.OpList}, Timeout: time.Hour},
+		{Ops: []faulty.Op{faulty.OpCount}, Timeout: 5 * time.Millisecond},
+	}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := a.ListContext(ctx, &[]widget{}, "id", nil, 10, "")
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, faulty.ErrInjected) {
+		t.Fatalf("List error = %v, want an injected deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("List held for %v after its context ended", elapsed)
+	}
+
+	if _, err := a.Count(&[]widget{}, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Count error = %v, want deadline exceeded after the rule's timeout", err)
+	}
+}
+
+func TestLatencyDelaysACallThatSucceeds(t *testing.T) {
+	a := faulty.New(memory(t), faulty.Options{Rules: []faulty.Rule{
+		{Ops: []faulty.Op{faulty.OpCreate}, Latency: 20 * time.Millisecond},
+	}})
+	start := time.Now()
+	if err := a.Create(&widget{Id: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("Create took %v, want at least the injected 20ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := a.CreateContext(ctx, &widget{Id: "2"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Create with a cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestCosmosThrottleIsA429ResponseError(t *testing.T) {
+	err := faulty.CosmosThrottle(150 * time.Millisecond)
+	var resp *azcore.ResponseError
+	if !errors.As(err, &resp) || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("CosmosThrottle() = %v, want a 429 azcore.ResponseError", err)
+	}
+	if got := resp.RawResponse.Header.Get("x-ms-retry-after-ms"); got != "150" {
+		t.Fatalf("x-ms-retry-after-ms = %q, want 150", got)
+	}
+	if err.Error() == "" {
+		t.Fatal("empty error message")
+	}
+}
+
+func TestInjectedFaultsAreRecordedOnTheStorageSpan(t *testing.T) {
+	obs := obstest.NewTestObserver(t)
+	defer obs.Close()
+
+	f := faulty.New(memory(t), faulty.Options{Rules: []faulty.Rule{
+		{Name: "flaky-get", Ops: []faulty.Op{faulty.OpGet}, Err: errors.New("connection reset")},
+	}})
+	adapter := storage.Instrument(f).(storage.ContextualStorageAdapter)
+	if storage.UnwrapAdapter(adapter) != storage.GetMemoryAdapterInstance() {
+		t.Fatal("UnwrapAdapter does not reach the memory adapter through the decorator")
+	}
+
+	if err := adapter.GetContext(context.Background(), &widget{}, map[string]any{"id": "1"}); !errors.Is(err, faulty.ErrInjected) {
+		t.Fatalf("Get error = %v, want the injected fault", err)
+	}
+	span := obs.AssertSpan(t, "storage.get")
+	if span.Status().Code != codes.Error {
+		t.Fatalf("span status = %v, want error", span.Status())
+	}
+	for _, e := range span.Events() {
+		if e.Name != "magic.storage.fault" {
+			continue
+		}
+		for _, kv := range e.Attributes {
+			if kv.Key == "magic.fault.rule" && kv.Value.AsString() == "flaky-get" {
+				return
+			}
+		}
+	}
+	t.Fatalf("span events %+v do not record rule flaky-get", span.Events())
+}
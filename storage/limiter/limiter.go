@@ -0,0 +1,437 @@
+// Package limiter keeps a process within a share of a table's provisioned
+// capacity. The Adapter decorator holds a token bucket per table or
+// container, refilled at the configured rate; each call is charged what the
+// store reports it consumed, and calls wait while the bucket is in debt.
+//
+//	adapter := storage.Instrument(limiter.New(storage.UnwrapAdapter(base), limiter.Options{
+//		Limits: map[string]limiter.Limit{
+//			"orders": {Rate: 400, Burst: 800}, // capacity units per second
+//		},
+//	}))
+//
+//	// In a batch job:
+//	ctx = limiter.WithPriority(ctx, limiter.Background)
+//
+// Background calls wait until the bucket holds a reserve, Options.Reserve of
+// its burst, while interactive calls only wait out debt, so interactive
+// requests get through first whenever the two compete.
+//
+// Charges are known only once the store has answered, so a call is let
+// through on the bucket's balance and charged afterwards; a large scan can
+// overdraw the bucket, and the calls after it wait until the debt is repaid.
+// Only adapters that implement storage.CapacityReporter (DynamoDB and Cosmos
+// DB) report charges, whether wrapped directly or behind other decorators;
+// others are passed through unlimited.
+package limiter
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tink3rlabs/magic/storage"
+)
+
+// Priority orders calls competing for a table's capacity.
+type Priority int
+
+const (
+	// Interactive calls, the default, wait only while the bucket is in debt.
+	Interactive Priority = iota
+	// Background calls also leave the reserve to interactive ones.
+	Background
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose calls are made at priority p.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityOf(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// Limit is the capacity a process may consume on one table.
+type Limit struct {
+	// Rate is the refill rate in capacity units per second: DynamoDB read
+	// and write capacity units, or Cosmos DB request units.
+	Rate float64
+	// Burst is the bucket size. Defaults to Rate, one second's worth.
+	Burst float64
+}
+
+// Options configures an Adapter.
+type Options struct {
+	// Limits maps table and container names to their limits.
+	Limits map[string]Limit
+	// Default applies to tables missing from Limits. A zero Rate leaves
+	// them unlimited.
+	Default Limit
+	// Reserve is the share of a bucket's burst kept for interactive calls,
+	// between 0 and 1. Defaults to 0.5.
+	Reserve float64
+}
+
+// Adapter is a storage.ContextualStorageAdapter decorator that limits the
+// capacity its calls consume. Schema and migration methods pass through
+// untouched.
+type Adapter struct {
+	inner    storage.StorageAdapter
+	ctxInner storage.ContextualStorageAdapter
+	reporter storage.CapacityReporter
+	opts     Options
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+var (
+	_ storage.ContextualStorageAdapter = (*Adapter)(nil)
+	_ storage.Aggregator               = (*Adapter)(nil)
+	_ storage.Faceter                  = (*Adapter)(nil)
+)
+
+// New wraps inner. Calls are limited only when inner, or an adapter it
+// wraps, implements storage.CapacityReporter.
+func New(inner storage.StorageAdapter, opts Options) *Adapter {
+	if opts.Reserve <= 0 || opts.Reserve > 1 {
+		opts.Reserve = 0.5
+	}
+	a := &Adapter{inner: inner, opts: opts, buckets: map[string]*bucket{}}
+	if c, ok := inner.(storage.ContextualStorageAdapter); ok {
+		a.ctxInner = c
+	}
+	// The reporter may sit behind other decorators: charges reach the
+	// limiter through the context, which decorators pass along.
+	a.reporter, _ = storage.AdapterAs[storage.CapacityReporter](inner)
+	if a.reporter == nil && (len(opts.Limits) > 0 || opts.Default.Rate > 0) {
+		slog.Warn("storage: limiter wraps an adapter that reports no capacity; calls are not limited")
+	}
+	return a
+}
+
+// UnwrapStorageAdapter returns the wrapped adapter.
+func (a *Adapter) UnwrapStorageAdapter() storage.StorageAdapter {
+	return a.inner
+}
+
+// Balance returns the capacity units left in a table's bucket, negative when
+// it is in debt, and whether the table is limited.
+func (a *Adapter) Balance(table string) (float64, bool) {
+	b := a.bucket(table)
+	if b == nil {
+		return 0, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	return b.tokens, true
+}
+
+// bucket returns the bucket of table, creating it full, or nil when the
+// table is not limited.
+func (a *Adapter) bucket(table string) *bucket {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if b, ok := a.buckets[table]; ok {
+		return b
+	}
+	limit, ok := a.opts.Limits[table]
+	if !ok {
+		limit = a.opts.Default
+	}
+	if limit.Rate <= 0 {
+		a.buckets[table] = nil
+		return nil
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = limit.Rate
+	}
+	b := &bucket{rate: limit.Rate, burst: limit.Burst, tokens: limit.Burst, last: time.Now()}
+	a.buckets[table] = b
+	return b
+}
+
+// admit waits until model's table has capacity for a call at ctx's priority,
+// and returns the context to make the call with, which charges the buckets of
+// every table the call reports consuming.
+func (a *Adapter) admit(ctx context.Context, model any) (context.Context, error) {
+	if a.reporter == nil {
+		return ctx, nil
+	}
+	if model != nil {
+		if b := a.bucket(a.reporter.TableName(model)); b != nil {
+			threshold := 0.0
+			if priorityOf(ctx) == Background {
+				threshold = a.opts.Reserve * b.burst
+			}
+			waited, err := b.wait(ctx, threshold)
+			if waited > 0 {
+				trace.SpanFromContext(ctx).AddEvent("magic.storage.capacity_wait", trace.WithAttributes(
+					attribute.String("magic.storage.table", a.reporter.TableName(model)),
+					attribute.Int64("magic.storage.capacity_wait_ms", waited.Milliseconds()),
+				))
+			}
+			if err != nil {
+				return ctx, err
+			}
+		}
+	}
+	return storage.WithCapacityObserver(ctx, func(table string, units float64) {
+		if b := a.bucket(table); b != nil {
+			b.charge(units)
+		}
+	}), nil
+}
+
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+func (b *bucket) charge(units float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens -= units
+}
+
+// wait blocks until the bucket holds at least threshold tokens and returns
+// how long it waited.
+func (b *bucket) wait(ctx context.Context, threshold float64) (time.Duration, error) {
+	var waited time.Duration
+	for {
+		b.mu.Lock()
+		b.refill(time.Now())
+		short := threshold - b.tokens
+		b.mu.Unlock()
+		if short <= 0 {
+			return waited, nil
+		}
+		// Sleep at least a millisecond so a sliver of debt does not spin.
+		d := max(time.Duration(short/b.rate*float64(time.Second)), time.Millisecond)
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+			waited += d
+		case <-ctx.Done():
+			timer.Stop()
+			return waited, ctx.Err()
+		}
+	}
+}
+
+func (a *Adapter) GetType() storage.StorageAdapterType   { return a.inner.GetType() }
+func (a *Adapter) GetProvider() storage.StorageProviders { return a.inner.GetProvider() }
+func (a *Adapter) GetSchemaName() string                 { return a.inner.GetSchemaName() }
+func (a *Adapter) CreateSchema() error                   { return a.inner.CreateSchema() }
+func (a *Adapter) CreateMigrationTable() error           { return a.inner.CreateMigrationTable() }
+func (a *Adapter) GetLatestMigration() (int, error)      { return a.inner.GetLatestMigration() }
+
+func (a *Adapter) UpdateMigrationTable(id int, name string, desc string) error {
+	return a.inner.UpdateMigrationTable(id, name, desc)
+}
+
+func (a *Adapter) Execute(statement string) error {
+	return a.ExecuteContext(context.Background(), statement)
+}
+
+// ExecuteContext is not held back, since the statement names no model, but
+// what it consumes is charged to the tables it touched.
+func (a *Adapter) ExecuteContext(ctx context.Context, statement string) error {
+	ctx, err := a.admit(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.ExecuteContext(ctx, statement)
+	}
+	return a.inner.Execute(statement)
+}
+
+func (a *Adapter) Ping() error {
+	return a.PingContext(context.Background())
+}
+
+func (a *Adapter) PingContext(ctx context.Context) error {
+	if a.ctxInner != nil {
+		return a.ctxInner.PingContext(ctx)
+	}
+	return a.inner.Ping()
+}
+
+func (a *Adapter) Create(item any, params ...map[string]any) error {
+	return a.CreateContext(context.Background(), item, params...)
+}
+
+func (a *Adapter) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	ctx, err := a.admit(ctx, item)
+	if err != nil {
+		return err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.CreateContext(ctx, item, params...)
+	}
+	return a.inner.Create(item, params...)
+}
+
+func (a *Adapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
+	return a.GetContext(context.Background(), dest, filter, params...)
+}
+
+func (a *Adapter) GetContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error {
+	ctx, err := a.admit(ctx, dest)
+	if err != nil {
+		return err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.GetContext(ctx, dest, filter, params...)
+	}
+	return a.inner.Get(dest, filter, params...)
+}
+
+func (a *Adapter) Update(item any, filter map[string]any, params ...map[string]any) error {
+	return a.UpdateContext(context.Background(), item, filter, params...)
+}
+
+func (a *Adapter) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	ctx, err := a.admit(ctx, item)
+	if err != nil {
+		return err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.UpdateContext(ctx, item, filter, params...)
+	}
+	return a.inner.Update(item, filter, params...)
+}
+
+func (a *Adapter) Delete(item any, filter map[string]any, params ...map[string]any) error {
+	return a.DeleteContext(context.Background(), item, filter, params...)
+}
+
+func (a *Adapter) DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	ctx, err := a.admit(ctx, item)
+	if err != nil {
+		return err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.DeleteContext(ctx, item, filter, params...)
+	}
+	return a.inner.Delete(item, filter, params...)
+}
+
+func (a *Adapter) List(dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.ListContext(context.Background(), dest, sortKey, filter, limit, cursor, params...)
+}
+
+func (a *Adapter) ListContext(ctx context.Context, dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	ctx, err := a.admit(ctx, dest)
+	if err != nil {
+		return "", err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.ListContext(ctx, dest, sortKey, filter, limit, cursor, params...)
+	}
+	return a.inner.List(dest, sortKey, filter, limit, cursor, params...)
+}
+
+func (a *Adapter) Search(dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.SearchContext(context.Background(), dest, sortKey, query, limit, cursor, params...)
+}
+
+func (a *Adapter) SearchContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	ctx, err := a.admit(ctx, dest)
+	if err != nil {
+		return "", err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.SearchContext(ctx, dest, sortKey, query, limit, cursor, params...)
+	}
+	return a.inner.Search(dest, sortKey, query, limit, cursor, params...)
+}
+
+func (a *Adapter) Count(dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	return a.CountContext(context.Background(), dest, filter, params...)
+}
+
+func (a *Adapter) CountContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	ctx, err := a.admit(ctx, dest)
+	if err != nil {
+		return 0, err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.CountContext(ctx, dest, filter, params...)
+	}
+	return a.inner.Count(dest, filter, params...)
+}
+
+func (a *Adapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.QueryContext(context.Background(), dest, statement, limit, cursor, params...)
+}
+
+// QueryContext is held back on the table of dest, which the statement is
+// expected to read.
+func (a *Adapter) QueryContext(ctx context.Context, dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	ctx, err := a.admit(ctx, dest)
+	if err != nil {
+		return "", err
+	}
+	if a.ctxInner != nil {
+		return a.ctxInner.QueryContext(ctx, dest, statement, limit, cursor, params...)
+	}
+	return a.inner.Query(dest, statement, limit, cursor, params...)
+}
+
+func (a *Adapter) Aggregate(model any, filter any, groupBy []string, metrics []storage.AggSpec, params ...map[string]any) ([]storage.AggregateRow, error) {
+	return a.AggregateContext(context.Background(), model, filter, groupBy, metrics, params...)
+}
+
+// AggregateContext reports storage.ErrNotSupported when the wrapped adapter
+// is not a storage.Aggregator. Aggregations scan, so run them at Background
+// priority unless a user is waiting on the result.
+func (a *Adapter) AggregateContext(ctx context.Context, model any, filter any, groupBy []string, metrics []storage.AggSpec, params ...map[string]any) ([]storage.AggregateRow, error) {
+	agg, ok := a.inner.(storage.Aggregator)
+	if !ok {
+		return nil, storage.ErrNotSupported
+	}
+	ctx, err := a.admit(ctx, model)
+	if err != nil {
+		return nil, err
+	}
+	return agg.AggregateContext(ctx, model, filter, groupBy, metrics, params...)
+}
+
+func (a *Adapter) SearchWithFacets(dest any, sortKey string, query string, limit int, cursor string, facets []string, params ...map[string]any) (string, storage.Facets, error) {
+	return a.SearchWithFacetsContext(context.Background(), dest, sortKey, query, limit, cursor, facets, params...)
+}
+
+// SearchWithFacetsContext reports storage.ErrNotSupported when the wrapped
+// adapter is not a storage.Faceter.
+func (a *Adapter) SearchWithFacetsContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, facets []string, params ...map[string]any) (string, storage.Facets, error) {
+	f, ok := a.inner.(storage.Faceter)
+	if !ok {
+		return "", nil, storage.ErrNotSupported
+	}
+	ctx, err := a.admit(ctx, dest)
+	if err != nil {
+		return "", nil, err
+	}
+	return f.SearchWithFacetsContext(ctx, dest, sortKey, query, limit, cursor, facets, params...)
+}
@@ -0,0 +1,170 @@
+package limiter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tink3rlabs/magic/observability/obstest"
+	"github.com/tink3rlabs/magic/storage"
+	"github.com/tink3rlabs/magic/storage/limiter"
+	"github.com/tink3rlabs/magic/telemetry"
+)
+
+type note struct {
+	Id   string `json:"id" gorm:"primaryKey;column:id"`
+	Text string `json:"text" gorm:"column:text"`
+}
+
+func (note) TableName() string { return "limiter_notes" }
+
+// metered is the memory adapter dressed up as a store that charges a fixed
+// number of capacity units per write and per read.
+type metered struct {
+	*storage.MemoryAdapter
+	write, read float64
+}
+
+func (m *metered) TableName(model any) string { return "limiter_notes" }
+
+func (m *metered) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	if err := m.MemoryAdapter.CreateContext(ctx, item, params...); err != nil {
+		return err
+	}
+	storage.ReportCapacity(ctx, "metered", "create", "limiter_notes", m.write)
+	return nil
+}
+
+func (m *metered) GetContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error {
+	if err := m.MemoryAdapter.GetContext(ctx, dest, filter, params...); err != nil {
+		return err
+	}
+	storage.ReportCapacity(ctx, "metered", "get", "limiter_notes", m.read)
+	return nil
+}
+
+func memory(t *testing.T) *storage.MemoryAdapter {
+	t.Helper()
+	m := storage.GetMemoryAdapterInstance()
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS limiter_notes (id TEXT PRIMARY KEY, text TEXT)`,
+		`DELETE FROM limiter_notes`,
+	} {
+		if err := m.Execute(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	return m
+}
+
+func TestDebtHoldsBackTheNextCall(t *testing.T) {
+	a := limiter.New(&metered{MemoryAdapter: memory(t), write: 30}, limiter.Options{
+		Limits: map[string]limiter.Limit{"limiter_notes": {Rate: 100, Burst: 10}},
+	})
+
+	// The first write is let through on a full bucket and overdraws it by 20
+	// units, which take 200ms to repay.
+	if err := a.Create(&note{Id: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if balance, limited := a.Balance("limiter_notes"); !limited || balance > -15 {
+		t.Fatalf("Balance = %v, %v; want about -20 after the first write", balance, limited)
+	}
+	start := time.Now()
+	if err := a.Create(&note{Id: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("second write went through after %v, before the debt was repaid", elapsed)
+	}
+}
+
+func TestStoresBehindOtherDecoratorsAreLimited(t *testing.T) {
+	a := limiter.New(storage.Instrument(&metered{MemoryAdapter: memory(t), write: 30}), limiter.Options{
+		Limits: map[string]limiter.Limit{"limiter_notes": {Rate: 100, Burst: 10}},
+	})
+	if err := a.Create(&note{Id: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if balance, limited := a.Balance("limiter_notes"); !limited || balance > -15 {
+		t.Fatalf("Balance = %v, %v; want the write charged through the instrumented adapter", balance, limited)
+	}
+}
+
+func TestBackgroundCallsLeaveTheReserveToInteractiveOnes(t *testing.T) {
+	a := limiter.New(&metered{MemoryAdapter: memory(t), write: 8, read: 1}, limiter.Options{
+		Limits:  map[string]limiter.Limit{"limiter_notes": {Rate: 10, Burst: 10}},
+		Reserve: 0.5,
+	})
+	if err := a.Create(&note{Id: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two units are left: short of the five-unit reserve, which takes 300ms
+	// to refill, but not in debt.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	err := a.GetContext(limiter.WithPriority(ctx, limiter.Background), &note{}, map[string]any{"id": "1"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("background Get = %v, want it held back until its deadline", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if err := a.GetContext(ctx, &note{}, map[string]any{"id": "1"}); err != nil {
+		t.Fatalf("interactive Get = %v, want it let through", err)
+	}
+}
+
+func TestCallsPassThroughWithoutALimitOrCharges(t *testing.T) {
+	m := memory(t)
+	unlimited := limiter.New(&metered{MemoryAdapter: m, write: 1000}, limiter.Options{
+		Limits: map[string]limiter.Limit{"other": {Rate: 1}},
+	})
+	unmetered := limiter.New(m, limiter.Options{Default: limiter.Limit{Rate: 1}})
+
+	start := time.Now()
+	for i, a := range []*limiter.Adapter{unlimited, unlimited, unmetered, unmetered} {
+		if err := a.Create(&note{Id: string(rune('a' + i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("writes took %v; nothing should have been held back", elapsed)
+	}
+	if _, limited := unlimited.Balance("limiter_notes"); limited {
+		t.Fatal("limiter_notes is limited without a limit or a default")
+	}
+}
+
+func TestWaitsAreRecordedOnTheSpanAndChargesCounted(t *testing.T) {
+	obs := obstest.NewTestObserver(t)
+	defer obs.Close()
+
+	l := limiter.New(&metered{MemoryAdapter: memory(t), write: 3}, limiter.Options{
+		Default: limiter.Limit{Rate: 100, Burst: 1},
+	})
+	adapter := storage.Instrument(l).(storage.ContextualStorageAdapter)
+	for _, id := range []string{"1", "2"} {
+		if err := adapter.CreateContext(context.Background(), &note{Id: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waits := 0
+	for _, span := range obs.Spans.Ended() {
+		for _, e := range span.Events() {
+			if e.Name == "magic.storage.capacity_wait" {
+				waits++
+			}
+		}
+	}
+	if waits != 1 {
+		t.Fatalf("%d capacity waits recorded, want 1 for the second write", waits)
+	}
+	obs.AssertCounter(t, "magic_storage_consumed_capacity_total", 6,
+		telemetry.Label{Key: "provider", Value: "metered"},
+		telemetry.Label{Key: "operation", Value: "create"},
+		telemetry.Label{Key: "table", Value: "limiter_notes"},
+	)
+}
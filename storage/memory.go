@@ -2,7 +2,9 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"sync"
+	"time"
 )
 
 var memoryAdapterLock = &sync.Mutex{}
@@ -15,6 +17,8 @@ type MemoryAdapter struct {
 }
 
 var _ ContextualStorageAdapter = (*MemoryAdapter)(nil)
+var _ Aggregator = (*MemoryAdapter)(nil)
+var _ Faceter = (*MemoryAdapter)(nil)
 
 var memoryAdapterInstance *MemoryAdapter
 
@@ -75,15 +79,53 @@ func (m *MemoryAdapter) UpdateMigrationTable(id int, name string, desc string) e
 	return m.DB.UpdateMigrationTable(id, name, desc)
 }
 
+func (m *MemoryAdapter) RecordMigration(applied AppliedMigration) error {
+	return m.DB.RecordMigration(applied)
+}
+
+func (m *MemoryAdapter) AppliedMigrations() ([]AppliedMigration, error) {
+	return m.DB.AppliedMigrations()
+}
+
+func (m *MemoryAdapter) DeleteMigration(id int) error {
+	return m.DB.DeleteMigration(id)
+}
+
+func (m *MemoryAdapter) InMigrationTransaction(ctx context.Context, fn func(tx StorageAdapter) error) error {
+	return m.DB.InMigrationTransaction(ctx, func(tx StorageAdapter) error {
+		return fn(&MemoryAdapter{DB: tx.(*SQLAdapter)})
+	})
+}
+
+func (m *MemoryAdapter) TransactionalDDL() bool {
+	return true
+}
+
+func (m *MemoryAdapter) DryRunMigrations(ctx context.Context, plan MigrationPlan) error {
+	return m.DB.DryRunMigrations(ctx, plan)
+}
+
+func (m *MemoryAdapter) SnapshotSchema(ctx context.Context) ([]Migration, error) {
+	return m.DB.SnapshotSchema(ctx)
+}
+
+func (m *MemoryAdapter) TryLockMigrations(ctx context.Context, owner string, lease time.Duration) (MigrationLock, error) {
+	return m.DB.TryLockMigrations(ctx, owner, lease)
+}
+
+func (m *MemoryAdapter) MigrationNamespace(namespace string) StorageAdapter {
+	return &MemoryAdapter{DB: m.DB.MigrationNamespace(namespace).(*SQLAdapter)}
+}
+
 func (m *MemoryAdapter) GetLatestMigration() (int, error) {
-	statement := "SELECT max(id) from migrations"
+	statement := fmt.Sprintf("SELECT max(id) from %s", m.DB.migrationsTable())
 	var latestMigration int
 
 	result := m.DB.DB.Raw(statement).Scan(&latestMigration)
 	if result.Error != nil {
 		//either a real issue or there are no migrations yet check if we can query the migration table
 		var count int
-		statement = "SELECT count(*) from migrations"
+		statement = fmt.Sprintf("SELECT count(*) from %s", m.DB.migrationsTable())
 		countResult := m.DB.DB.Raw(statement).Scan(&count)
 		if countResult.Error != nil {
 			return latestMigration, result.Error
@@ -100,6 +142,10 @@ func (m *MemoryAdapter) CreateContext(ctx context.Context, item any, params ...m
 	return m.DB.CreateContext(ctx, item, params...)
 }
 
+func (m *MemoryAdapter) CreateIfAbsentContext(ctx context.Context, item any, params ...map[string]any) error {
+	return m.DB.CreateIfAbsentContext(ctx, item, params...)
+}
+
 func (m *MemoryAdapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
 	return m.GetContext(context.Background(), dest, filter, params...)
 }
@@ -148,6 +194,22 @@ func (m *MemoryAdapter) CountContext(ctx context.Context, dest any, filter map[s
 	return m.DB.CountContext(ctx, dest, filter, params...)
 }
 
+func (m *MemoryAdapter) Aggregate(model any, filter any, groupBy []string, metrics []AggSpec, params ...map[string]any) ([]AggregateRow, error) {
+	return m.AggregateContext(context.Background(), model, filter, groupBy, metrics, params...)
+}
+
+func (m *MemoryAdapter) AggregateContext(ctx context.Context, model any, filter any, groupBy []string, metrics []AggSpec, params ...map[string]any) ([]AggregateRow, error) {
+	return m.DB.AggregateContext(ctx, model, filter, groupBy, metrics, params...)
+}
+
+func (m *MemoryAdapter) SearchWithFacets(dest any, sortKey string, query string, limit int, cursor string, facets []string, params ...map[string]any) (string, Facets, error) {
+	return m.SearchWithFacetsContext(context.Background(), dest, sortKey, query, limit, cursor, facets, params...)
+}
+
+func (m *MemoryAdapter) SearchWithFacetsContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, facets []string, params ...map[string]any) (string, Facets, error) {
+	return m.DB.SearchWithFacetsContext(ctx, dest, sortKey, query, limit, cursor, facets, params...)
+}
+
 func (m *MemoryAdapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
 	return m.QueryContext(context.Background(), dest, statement, limit, cursor, params...)
 }
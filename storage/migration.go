@@ -1,146 +1,816 @@
 package storage
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
-	"path/filepath"
+	"maps"
+	"math"
 	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tink3rlabs/magic/logger"
-
-	"gopkg.in/yaml.v3"
 )
 
 type MigrationFile struct {
-	Description string
-	Migrations  []Migration
+	Description string      `yaml:",omitempty"`
+	Migrations  []Migration `yaml:",omitempty"`
+	// NoTransaction runs the file outside a transaction, for statements
+	// that cannot run in one, such as PostgreSQL's CREATE INDEX CONCURRENTLY.
+	NoTransaction bool `yaml:"no_transaction,omitempty"`
+	// Template renders the statements as Go templates of
+	// MigrationTemplateData before they run.
+	Template bool `yaml:",omitempty"`
+	// Providers replaces Migrations on the providers it names, so that a
+	// file shared by every provider can differ where their dialects do.
+	Providers map[string][]Migration `yaml:",omitempty"`
+	// Squash makes the file a consolidated schema that fresh databases run
+	// instead of every migration up to its id. See DatabaseMigration.Squash.
+	Squash bool `yaml:",omitempty"`
 }
 
 type Migration struct {
 	Migrate  string
-	Rollback string
+	Rollback string `yaml:",omitempty"`
+}
+
+// MigrationDeleter is implemented by adapters that can remove a migration's
+// row from the migrations table, which MigrateTo and Rollback need in order to
+// undo applied migrations. The SQL, memory, Bolt, Redis, Cassandra, DynamoDB
+// and Cosmos DB adapters implement it.
+type MigrationDeleter interface {
+	DeleteMigration(id int) error
+}
+
+// Checksum returns the hex SHA-256 of the file's statements. The description
+// and the YAML layout do not count, so only an edit that changes what runs
+// changes the checksum.
+func (f MigrationFile) Checksum() string {
+	h := sha256.New()
+	for _, stmt := range f.Migrations {
+		fmt.Fprintf(h, "%d:%s%d:%s", len(stmt.Migrate), stmt.Migrate, len(stmt.Rollback), stmt.Rollback)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// MigrationHistory is implemented by adapters that can list the rows of their
+// migrations table and record a migration with the checksum of its file, which
+// DatabaseMigration needs to notice migrations edited after they were applied,
+// applied migrations whose file is gone and pending migrations older than the
+// latest applied. The SQL, memory, Bolt, Redis, Cassandra, DynamoDB and
+// Cosmos DB adapters implement it.
+type MigrationHistory interface {
+	// AppliedMigrations returns the rows of the migrations table by id.
+	AppliedMigrations() ([]AppliedMigration, error)
+	// RecordMigration adds a row to the migrations table, applied now when
+	// AppliedAt is zero.
+	RecordMigration(applied AppliedMigration) error
+}
+
+// AppliedMigration is a row of the migrations table. Rows recorded before
+// checksums were have an empty Checksum, and are never reported as modified.
+type AppliedMigration struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Checksum    string    `json:"checksum,omitempty"`
+	AppliedAt   time.Time `json:"applied_at"`
+}
+
+// PendingMigration is a migration file that has not been applied.
+type PendingMigration struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Checksum    string `json:"checksum"`
+	// OutOfOrder reports that a later migration is already applied, as
+	// happens when branches that each add a migration are merged.
+	OutOfOrder bool `json:"out_of_order"`
+}
+
+// MigrationStatus compares the migration files with the migrations table.
+type MigrationStatus struct {
+	// Applied lists every row of the migrations table.
+	Applied []AppliedMigration `json:"applied"`
+	// Pending lists the migration files not applied yet.
+	Pending []PendingMigration `json:"pending"`
+	// Modified lists the applied migrations whose file no longer matches
+	// the checksum recorded when they were applied.
+	Modified []AppliedMigration `json:"modified"`
+	// Missing lists the applied migrations that have no file.
+	Missing []AppliedMigration `json:"missing"`
 }
 
+// ErrMigrationModified is returned when an applied migration's file was edited
+// and MigrationOptions.OnChecksumMismatch is ChecksumFail.
+var ErrMigrationModified = errors.New("applied migration was modified")
+
+// ErrMigrationOutOfOrder is returned when a pending migration is older than the
+// latest applied one and MigrationOptions.AllowOutOfOrder is not set.
+var ErrMigrationOutOfOrder = errors.New("pending migration is older than the latest applied")
+
+// ErrIrreversibleMigration is returned by MigrateTo and Rollback, before
+// anything is rolled back, when a migration to undo has a statement without a
+// Rollback.
+var ErrIrreversibleMigration = errors.New("migration has a statement without a rollback")
+
+// MigrationError reports a migration whose statements failed to apply.
+type MigrationError struct {
+	ID   int
+	Name string
+	Err  error
+	// RolledBack reports whether the migration's Rollback statements undid
+	// the statements that had been applied; RollbackErr is why not.
+	RolledBack  bool
+	RollbackErr error
+}
+
+func (e *MigrationError) Error() string {
+	if e.RolledBack {
+		return fmt.Sprintf("migration %s failed and was rolled back: %v", e.Name, e.Err)
+	}
+	return fmt.Sprintf("migration %s failed and could not be rolled back: %v (rollback: %v)", e.Name, e.Err, e.RollbackErr)
+}
+
+func (e *MigrationError) Unwrap() error { return e.Err }
+
 type DatabaseMigration struct {
 	storageType     StorageAdapterType
 	storageProvider StorageProviders
 	storage         StorageAdapter
+	opts            MigrationOptions
+	// namespace is the namespace whose migrations MigrateTo, Rollback,
+	// Status and Plan act on, "" for the application's.
+	namespace string
+	// held is set while withLock holds the migration lock, and is cancelled
+	// when the lock is lost.
+	held context.Context
 }
 
-func NewDatabaseMigration(storageAdapter StorageAdapter) *DatabaseMigration {
+// NewDatabaseMigration returns a DatabaseMigration for storageAdapter,
+// configured by the first of opts when given.
+func NewDatabaseMigration(storageAdapter StorageAdapter, opts ...MigrationOptions) *DatabaseMigration {
 	m := DatabaseMigration{
 		storage:         storageAdapter,
 		storageType:     storageAdapter.GetType(),
 		storageProvider: storageAdapter.GetProvider(),
 	}
+	if len(opts) > 0 {
+		m.opts = opts[0]
+	}
+	if m.opts.LockTimeout <= 0 {
+		m.opts.LockTimeout = 5 * time.Minute
+	}
+	if m.opts.LockLease <= 0 {
+		m.opts.LockLease = 30 * time.Second
+	}
+	if m.opts.LockOwner == "" {
+		m.opts.LockOwner = defaultLockOwner()
+	}
+	if m.opts.OnChecksumMismatch == "" {
+		m.opts.OnChecksumMismatch = ChecksumFail
+	}
+	if m.opts.Transactions == "" {
+		m.opts.Transactions = TransactionPerFile
+	}
 	return &m
 }
 
+// migrationEntry is a migration file with the id parsed from its name, or a
+// registered Go migration.
+type migrationEntry struct {
+	id   int
+	name string
+	file MigrationFile
+	code *GoMigration
+	// replaces lists, for a squash file run on a fresh database, the
+	// migrations it is recorded as.
+	replaces []migrationEntry
+}
+
+// checksum is the file's checksum. Go migrations have none.
+func (e migrationEntry) checksum() string {
+	if e.code != nil {
+		return ""
+	}
+	return e.file.Checksum()
+}
+
+// squash reports whether e is a squash file.
+func (e migrationEntry) squash() bool {
+	return e.code == nil && e.file.Squash
+}
+
+// migrationState is what a run knows before it starts: the migration files in
+// order, the squash files, the rows of the migrations table by id, and the
+// highest applied id.
+type migrationState struct {
+	entries  []migrationEntry
+	squashes []migrationEntry
+	applied  map[int]AppliedMigration
+	latest   int
+}
+
+// source is where the migration files are read from.
+func (m *DatabaseMigration) source() MigrationSource {
+	if m.opts.Source != nil {
+		return m.opts.Source
+	}
+	return FSMigrationSource{FS: ConfigFs, Dir: "config/migrations"}
+}
+
+// getMigrationFiles returns the migration files of the namespace m runs.
 func (m *DatabaseMigration) getMigrationFiles() (map[string]MigrationFile, error) {
-	var err error
-	migrations := map[string]MigrationFile{}
-	path := fmt.Sprintf("config/migrations/%s", m.storageProvider)
-	files, _ := ConfigFs.ReadDir(path)
-
-	for _, f := range files {
-		var contents []byte
-		contents, err = ConfigFs.ReadFile(filepath.Join(path, f.Name()))
+	_, sources := splitSources(m.source())
+	return MultiMigrationSource(sources[m.namespace]).MigrationFiles(m.provider())
+}
+
+// provider names the provider to sources and templates. Adapters without
+// providers, such as DynamoDB, go by the adapter's name.
+func (m *DatabaseMigration) provider() string {
+	if m.storageProvider == "" {
+		return string(m.storageType)
+	}
+	return string(m.storageProvider)
+}
+
+// sortMigrations parses the migration id each file name starts with and
+// orders the migrations by it.
+func sortMigrations(migrations map[string]MigrationFile) ([]migrationEntry, error) {
+	//iterating over a map is randomized so we need to make sure we use the correct order of migrations
+	keys := make([]string, 0, len(migrations))
+	for k := range migrations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	entries := make([]migrationEntry, 0, len(keys))
+	for _, k := range keys {
+		id, err := strconv.Atoi(strings.Split(k, "__")[0])
 		if err != nil {
-			return nil, fmt.Errorf("failed to read migration file %s: %v", f.Name(), err)
+			return nil, fmt.Errorf("failed to determine migration id of %s: %w", k, err)
 		}
-		mf := MigrationFile{}
-		err = yaml.Unmarshal([]byte(contents), &mf)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse migration file %s: %v", f.Name(), err)
+		entries = append(entries, migrationEntry{id: id, name: k, file: migrations[k]})
+	}
+	return orderMigrations(entries)
+}
+
+// orderMigrations sorts migrations by id and fails when two share one. A
+// squash file may share the id of the last migration it replaces, and sorts
+// after it.
+func orderMigrations(entries []migrationEntry) ([]migrationEntry, error) {
+	// Names sort as strings, which puts 10__ before 9__.
+	slices.SortStableFunc(entries, func(a, b migrationEntry) int {
+		if a.id != b.id {
+			return a.id - b.id
+		}
+		if a.squash() == b.squash() {
+			return 0
+		}
+		if a.squash() {
+			return 1
+		}
+		return -1
+	})
+	for i := 1; i < len(entries); i++ {
+		if entries[i].id == entries[i-1].id && entries[i].squash() == entries[i-1].squash() {
+			return nil, fmt.Errorf("migrations %s and %s have the same id", entries[i-1].name, entries[i].name)
 		}
-		migrations[f.Name()] = mf
 	}
-	return migrations, nil
+	return entries, nil
 }
 
 func (m *DatabaseMigration) rollbackMigration(migration MigrationFile) error {
-	var err error
-	slices.Reverse(migration.Migrations)
-	for _, s := range migration.Migrations {
-		err = m.storage.Execute(s.Rollback)
-		if err != nil {
-			break
+	return rollbackOn(m.storage, migration)
+}
+
+// rollbackOn executes a migration file's Rollback statements on adapter, last
+// statement first.
+func rollbackOn(adapter StorageAdapter, migration MigrationFile) error {
+	for i := len(migration.Migrations) - 1; i >= 0; i-- {
+		if err := adapter.Execute(migration.Migrations[i].Rollback); err != nil {
+			return err
 		}
 	}
-	return err
+	return nil
 }
 
-func (m *DatabaseMigration) runMigrations(migrations map[string]MigrationFile) {
-	slog.Info("Getting last migration applied")
-	rollback := false
-	latestMigrationId, err := m.storage.GetLatestMigration()
+// prepare loads the migration files, creates the schema and migrations table
+// and reads which migrations are applied. It fails when an applied migration's
+// file was edited and OnChecksumMismatch is ChecksumFail, and warns about
+// applied migrations whose file is gone. Runs call it holding the migration
+// lock, so that the applied migrations cannot change under them.
+func (m *DatabaseMigration) prepare() (*migrationState, error) {
+	entries, err := m.loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("creating schema")
+	if err := m.storage.CreateSchema(); err != nil {
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+	slog.Info("creating migration table")
+	if err := m.storage.CreateMigrationTable(); err != nil {
+		return nil, fmt.Errorf("failed to create migration table: %w", err)
+	}
+	slog.Info("Getting applied migrations")
+	state, err := m.load(entries)
 	if err != nil {
-		logger.Fatal("failed to get latest migration", slog.Any("error", err))
+		return nil, err
+	}
+	if err := m.verify(state); err != nil {
+		return nil, err
 	}
+	return state, nil
+}
 
-	//iterating over a map is randomized so we need to make sure we use the correct order of migrations
-	keys := make([]string, 0, len(migrations))
-	for k := range migrations {
-		keys = append(keys, k)
+// verify checks the applied migrations against their files.
+func (m *DatabaseMigration) verify(state *migrationState) error {
+	status := state.status()
+	for _, missing := range status.Missing {
+		slog.Warn("applied migration has no migration file", slog.String("key", missing.Name))
 	}
-	sort.Strings(keys)
+	if len(status.Modified) > 0 {
+		names := make([]string, len(status.Modified))
+		for i, modified := range status.Modified {
+			names[i] = modified.Name
+		}
+		if m.opts.OnChecksumMismatch != ChecksumWarn {
+			return fmt.Errorf("%w: %s", ErrMigrationModified, strings.Join(names, ", "))
+		}
+		slog.Warn("applied migrations were modified", slog.Any("keys", names))
+	}
+	return nil
+}
 
-	for _, k := range keys {
-		migrationId, err := strconv.Atoi(strings.Split(k, "__")[0])
+// loadMigrations returns the migration files and, for the application's
+// namespace, the registered Go migrations, in order.
+func (m *DatabaseMigration) loadMigrations() ([]migrationEntry, error) {
+	migrations, err := m.getMigrationFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get migration files: %w", err)
+	}
+	entries, err := sortMigrations(migrations)
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range entries {
+		if entries[i].file, err = m.resolve(e.name, e.file); err != nil {
+			return nil, err
+		}
+	}
+	if m.namespace != "" {
+		return entries, nil
+	}
+	return orderMigrations(append(entries, registeredMigrations()...))
+}
+
+// load reads the migrations table. Adapters that are not MigrationHistory
+// only report the latest id, so every migration up to it counts as applied.
+func (m *DatabaseMigration) load(entries []migrationEntry) (*migrationState, error) {
+	state := &migrationState{applied: map[int]AppliedMigration{}}
+	for _, e := range entries {
+		if e.squash() {
+			state.squashes = append(state.squashes, e)
+		} else {
+			state.entries = append(state.entries, e)
+		}
+	}
+	if history, ok := unwrapAs[MigrationHistory](m.storage); ok {
+		rows, err := history.AppliedMigrations()
 		if err != nil {
-			logger.Fatal("failed to determine migration id", slog.Any("error", err))
-		}
-		if migrationId > latestMigrationId {
-			mf := migrations[k]
-			for _, stmt := range mf.Migrations {
-				err := m.storage.Execute(stmt.Migrate)
-				if err != nil {
-					slog.Error("failed to execute migration statement", slog.Any("error", err))
-					slog.Info("failed to execute migration statement", slog.String("key", k))
-					rollback = true
-					err = m.rollbackMigration(mf)
-					if err != nil {
-						logger.Fatal("failed to rollback migration", slog.Any("error", err))
-					}
-					slog.Info("rollback successful")
-					break
-				}
+			return nil, fmt.Errorf("failed to get applied migrations: %w", err)
+		}
+		for _, row := range rows {
+			state.applied[row.ID] = row
+			state.latest = max(state.latest, row.ID)
+		}
+		return state, nil
+	}
+	latest, err := m.storage.GetLatestMigration()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest migration: %w", err)
+	}
+	for _, e := range state.entries {
+		if e.id <= latest {
+			state.applied[e.id] = AppliedMigration{ID: e.id, Name: e.name, Description: e.file.Description}
+		}
+	}
+	state.latest = latest
+	return state, nil
+}
+
+// status compares the migration files with the migrations table. On a fresh
+// database with a squash file, the squash file is pending in place of the
+// migrations it replaces.
+func (s *migrationState) status() MigrationStatus {
+	status := MigrationStatus{Applied: []AppliedMigration{}, Pending: []PendingMigration{}, Modified: []AppliedMigration{}, Missing: []AppliedMigration{}}
+	files := map[int]migrationEntry{}
+	squash := s.squash(math.MaxInt)
+	if squash != nil {
+		status.Pending = append(status.Pending, PendingMigration{
+			ID:          squash.id,
+			Name:        squash.name,
+			Description: squash.file.Description,
+			Checksum:    squash.checksum(),
+		})
+	}
+	for _, e := range s.entries {
+		files[e.id] = e
+		if squash != nil && e.id <= squash.id {
+			continue
+		}
+		applied, ok := s.applied[e.id]
+		if !ok {
+			status.Pending = append(status.Pending, PendingMigration{
+				ID:          e.id,
+				Name:        e.name,
+				Description: e.file.Description,
+				Checksum:    e.checksum(),
+				OutOfOrder:  e.id < s.latest,
+			})
+			continue
+		}
+		if applied.Checksum != "" && applied.Checksum != e.checksum() {
+			status.Modified = append(status.Modified, applied)
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(s.applied)) {
+		applied := s.applied[id]
+		status.Applied = append(status.Applied, applied)
+		if _, ok := files[id]; !ok && !s.bookkeeping(applied) {
+			status.Missing = append(status.Missing, applied)
+		}
+	}
+	return status
+}
+
+// record adds a migration that was just applied to the migrations table of
+// adapter. A squash file is recorded as the migrations it replaces.
+func (m *DatabaseMigration) record(adapter StorageAdapter, e migrationEntry) error {
+	if e.replaces != nil {
+		for _, replaced := range e.replaces {
+			if err := m.record(adapter, replaced); err != nil {
+				return err
 			}
-			if rollback {
-				break
+		}
+		return nil
+	}
+	history, ok := unwrapAs[MigrationHistory](adapter)
+	if !ok {
+		return adapter.UpdateMigrationTable(e.id, e.name, e.file.Description)
+	}
+	return history.RecordMigration(AppliedMigration{
+		ID:          e.id,
+		Name:        e.name,
+		Description: e.file.Description,
+		Checksum:    e.checksum(),
+	})
+}
+
+// pending returns, in order, the migrations that are not applied up to and
+// including target. It fails when some are older than the latest applied one,
+// unless AllowOutOfOrder is set.
+func (m *DatabaseMigration) pending(s *migrationState, target int) ([]migrationEntry, error) {
+	var apply []migrationEntry
+	var outOfOrder []string
+	squash := s.squash(target)
+	if squash != nil {
+		apply = append(apply, *squash)
+	}
+	for _, e := range s.entries {
+		if _, ok := s.applied[e.id]; ok || e.id > target || (squash != nil && e.id <= squash.id) {
+			continue
+		}
+		apply = append(apply, e)
+		if e.id < s.latest {
+			outOfOrder = append(outOfOrder, e.name)
+		}
+	}
+	if len(outOfOrder) > 0 {
+		if !m.opts.AllowOutOfOrder {
+			return nil, fmt.Errorf("%w: %s not applied before migration %d", ErrMigrationOutOfOrder, strings.Join(outOfOrder, ", "), s.latest)
+		}
+		slog.Warn("pending migrations are out of order", slog.Any("keys", outOfOrder))
+	}
+	return apply, nil
+}
+
+// migrateUp applies, in order, the pending migrations up to and including
+// target. Pending migrations older than the latest applied one fail the run
+// before anything is applied, unless AllowOutOfOrder is set. A migration
+// that fails is rolled back and reported as a *MigrationError; the migrations
+// after it are not applied.
+func (m *DatabaseMigration) migrateUp(s *migrationState, target int) error {
+	apply, err := m.pending(s, target)
+	if err != nil {
+		return err
+	}
+	for _, group := range m.group(apply) {
+		if err := m.lockLost(); err != nil {
+			return err
+		}
+		if group.transaction != nil {
+			err = m.applyInTransaction(group.transaction, group.entries)
+		} else {
+			err = m.applyWithoutTransaction(group.entries[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyWithoutTransaction applies a migration statement by statement. When a
+// statement fails, the file's Rollback statements undo the ones before it.
+func (m *DatabaseMigration) applyWithoutTransaction(e migrationEntry) error {
+	if e.code != nil {
+		return m.applyCode(e)
+	}
+	for _, stmt := range e.file.Migrations {
+		if err := m.storage.Execute(stmt.Migrate); err != nil {
+			slog.Error("failed to execute migration statement", slog.String("key", e.name), slog.Any("error", err))
+			failure := &MigrationError{ID: e.id, Name: e.name, Err: err}
+			if failure.RollbackErr = m.rollbackMigration(e.file); failure.RollbackErr == nil {
+				failure.RolledBack = true
+				slog.Info("rollback successful")
 			}
-			slog.Info("updating migration table for", slog.String("key", k))
-			err = m.storage.UpdateMigrationTable(migrationId, k, mf.Description)
-			if err != nil {
-				logger.Fatal("failed to update migration table", slog.Any("error", err))
+			return failure
+		}
+	}
+	slog.Info("updating migration table for", slog.String("key", e.name))
+	if err := m.record(m.storage, e); err != nil {
+		return fmt.Errorf("failed to update migration table for %s: %w", e.name, err)
+	}
+	return nil
+}
+
+// migrateDown rolls back, newest first, the applied migrations after target
+// and deletes their rows from the migrations table.
+func (m *DatabaseMigration) migrateDown(s *migrationState, target int) error {
+	if _, ok := unwrapAs[MigrationDeleter](m.storage); !ok {
+		return fmt.Errorf("%s storage adapter cannot delete migrations: %w", m.storageType, ErrNotSupported)
+	}
+	files := map[int]migrationEntry{}
+	for _, e := range s.entries {
+		files[e.id] = e
+	}
+	for _, e := range s.squashes {
+		if _, ok := files[e.id]; !ok && s.applied[e.id].Name == e.name {
+			files[e.id] = e
+		}
+	}
+	var undo []migrationEntry
+	for _, id := range slices.Backward(slices.Sorted(maps.Keys(s.applied))) {
+		if id <= target {
+			break
+		}
+		e, ok := files[id]
+		if !ok && s.applied[id].Name == baselineName(id) {
+			return fmt.Errorf("cannot roll back past the baseline at %d", id)
+		}
+		if !ok {
+			return fmt.Errorf("failed to roll back %s: the migration file is missing", s.applied[id].Name)
+		}
+		if e.code != nil && e.code.Down == nil {
+			return fmt.Errorf("failed to roll back %s: %w", e.name, ErrIrreversibleMigration)
+		}
+		for _, stmt := range e.file.Migrations {
+			if strings.TrimSpace(stmt.Rollback) == "" {
+				return fmt.Errorf("failed to roll back %s: %w", e.name, ErrIrreversibleMigration)
 			}
 		}
+		undo = append(undo, e)
 	}
+	for _, group := range m.group(undo) {
+		if err := m.lockLost(); err != nil {
+			return err
+		}
+		var err error
+		if group.transaction != nil {
+			err = m.undoInTransaction(group.transaction, group.entries)
+		} else {
+			err = m.undoWithoutTransaction(group.entries[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (m *DatabaseMigration) Migrate() {
-	if m.storageType == DYNAMODB {
-		slog.Info(fmt.Sprintf(`using %s storage adapter, migrations are not supported`, m.storageType))
-	} else {
-		slog.Info(fmt.Sprintf(`using %s storage adapter, executing migrations`, m.storageType))
-		migrations, err := m.getMigrationFiles()
+// undoWithoutTransaction rolls a migration back and then deletes its row.
+func (m *DatabaseMigration) undoWithoutTransaction(e migrationEntry) error {
+	slog.Info("rolling back migration", slog.String("key", e.name))
+	if e.code != nil {
+		ctx := withCheckpoint(m.runContext(), m.storage, e)
+		if err := m.runCode(ctx, e, e.code.Down, m.deleteRow(e)); err != nil {
+			return fmt.Errorf("failed to roll back %s: %w", e.name, err)
+		}
+		return nil
+	}
+	if err := m.rollbackMigration(e.file); err != nil {
+		return fmt.Errorf("failed to roll back %s: %w", e.name, err)
+	}
+	if err := m.deleteRow(e)(m.storage); err != nil {
+		return fmt.Errorf("failed to delete %s from the migration table: %w", e.name, err)
+	}
+	return nil
+}
+
+// deleteRow returns a function that deletes e's row from the migrations table
+// of the adapter it is given.
+func (m *DatabaseMigration) deleteRow(e migrationEntry) func(s StorageAdapter) error {
+	return func(s StorageAdapter) error {
+		deleter, ok := unwrapAs[MigrationDeleter](s)
+		if !ok {
+			return fmt.Errorf("%s storage adapter cannot delete migrations: %w", m.storageType, ErrNotSupported)
+		}
+		return deleter.DeleteMigration(e.id)
+	}
+}
+
+// migrateTo moves from the latest applied migration to version, up or down.
+func (m *DatabaseMigration) migrateTo(s *migrationState, version int) error {
+	known := func(e migrationEntry) bool { return e.id == version }
+	if version != 0 && !slices.ContainsFunc(s.entries, known) && !slices.ContainsFunc(s.squashes, known) {
+		return fmt.Errorf("no migration with id %d", version)
+	}
+	if version >= s.latest {
+		return m.migrateUp(s, version)
+	}
+	return m.migrateDown(s, version)
+}
+
+// rollback rolls back the last steps applied migrations.
+func (m *DatabaseMigration) rollback(s *migrationState, steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("rollback steps must be positive, got %d", steps)
+	}
+	applied := slices.Sorted(maps.Keys(s.applied))
+	if steps > len(applied) {
+		return fmt.Errorf("cannot roll back %d migrations, only %d are applied", steps, len(applied))
+	}
+	target := 0
+	if steps < len(applied) {
+		target = applied[len(applied)-steps-1]
+	}
+	return m.migrateDown(s, target)
+}
+
+// Namespace returns a DatabaseMigration whose MigrateTo, Rollback, Status and
+// Plan act on the migrations of a namespace of the source rather than on the
+// application's.
+func (m *DatabaseMigration) Namespace(namespace string) *DatabaseMigration {
+	n := *m
+	n.namespace = namespace
+	return &n
+}
+
+// namespaced returns m with its storage bound to the namespace's migrations
+// table. Namespaced migrations run on the adapter itself rather than on the
+// decorators around it.
+func (m *DatabaseMigration) namespaced() (*DatabaseMigration, error) {
+	if m.namespace == "" {
+		return m, nil
+	}
+	if !migrationNamespacePattern.MatchString(m.namespace) {
+		return nil, fmt.Errorf("invalid migration namespace %q: use lowercase letters, digits and underscores", m.namespace)
+	}
+	namespacer, ok := unwrapAs[MigrationNamespacer](m.storage)
+	if !ok {
+		return nil, fmt.Errorf("%s storage adapter cannot namespace migrations: %w", m.storageType, ErrNotSupported)
+	}
+	n := *m
+	n.storage = namespacer.MigrationNamespace(m.namespace)
+	return &n, nil
+}
+
+// MigrateTo applies or rolls back migrations until version is the latest
+// applied. Rolling back runs each migration's Rollback statements in reverse
+// order, newest migration first, and deletes its row from the migrations
+// table. Version 0 rolls back every migration.
+//
+// Like Migrate and Rollback, it holds the adapter's migration lock while it
+// runs, and waits for it when another process holds it.
+func (m *DatabaseMigration) MigrateTo(version int) error {
+	n, err := m.namespaced()
+	if err != nil {
+		return err
+	}
+	return n.withLock(func(n *DatabaseMigration) error {
+		state, err := n.prepare()
+		if err != nil {
+			return err
+		}
+		return n.migrateTo(state, version)
+	})
+}
+
+// Rollback rolls back the last steps applied migrations, as MigrateTo does.
+func (m *DatabaseMigration) Rollback(steps int) error {
+	n, err := m.namespaced()
+	if err != nil {
+		return err
+	}
+	return n.withLock(func(n *DatabaseMigration) error {
+		state, err := n.prepare()
 		if err != nil {
-			logger.Fatal("failed to get migration files", slog.Any("error", err))
+			return err
 		}
-		slog.Info("creating schema")
-		err = m.storage.CreateSchema()
+		return n.rollback(state, steps)
+	})
+}
+
+// Status compares the migration files with the migrations table, for display
+// in an admin endpoint or a deploy check. It neither creates the migrations
+// table nor takes the migration lock; before the first run, every migration
+// is pending. Adapters that are not MigrationHistory
+// keep only the latest id, so their status has no checksums and cannot report
+// modified, missing or out-of-order migrations.
+func (m *DatabaseMigration) Status() (MigrationStatus, error) {
+	n, err := m.namespaced()
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+	entries, err := n.loadMigrations()
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+	state, err := n.load(entries)
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+	return state.status(), nil
+}
+
+// Up applies every pending migration of every namespace of the source, and
+// returns the error that stopped it. Namespaces are migrated in the order
+// their sources appear in, the application's last unless it has a source
+// before. A migration that fails is rolled back and returned as a
+// *MigrationError; the migrations after it, in every namespace, are skipped.
+// Like MigrateTo, it holds the migration lock while it runs. Namespaces
+// without migrations are skipped, and when none has any, Up neither takes the
+// lock nor creates the migrations table.
+func (m *DatabaseMigration) Up() error {
+	namespaces, _ := splitSources(m.source())
+	// Namespaces without migrations are left alone: taking the lock and
+	// creating the migrations table would need permissions, and claim
+	// table names, that an application without migrations never asked for.
+	var migrated []string
+	for _, namespace := range namespaces {
+		n, err := m.Namespace(namespace).namespaced()
 		if err != nil {
-			logger.Fatal("failed to create schema", slog.Any("error", err))
+			return err
 		}
-		slog.Info("creating migration table")
-		err = m.storage.CreateMigrationTable()
+		entries, err := n.loadMigrations()
 		if err != nil {
-			logger.Fatal("failed to create migration table", slog.Any("error", err))
+			return err
+		}
+		if len(entries) > 0 {
+			migrated = append(migrated, namespace)
 		}
-		m.runMigrations(migrations)
-		slog.Info("finished running migrations")
 	}
+	if len(migrated) == 0 {
+		slog.Info("no migrations to run")
+		return nil
+	}
+	return m.withLock(func(m *DatabaseMigration) error {
+		for _, namespace := range migrated {
+			n, err := m.Namespace(namespace).namespaced()
+			if err != nil {
+				return err
+			}
+			state, err := n.prepare()
+			if err != nil {
+				return err
+			}
+			if err := n.migrateUp(state, math.MaxInt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Migrate runs Up at startup, and exits the process when it fails. A
+// migration that fails and is rolled back is only logged, since it left the
+// schema as it was. Use Up to handle failures instead.
+func (m *DatabaseMigration) Migrate() {
+	slog.Info(fmt.Sprintf(`using %s storage adapter, executing migrations`, m.storageType))
+	err := m.Up()
+	var failure *MigrationError
+	if errors.As(err, &failure) && failure.RolledBack {
+		slog.Error("migration failed and was rolled back", slog.String("key", failure.Name), slog.Any("error", failure.Err))
+	} else if err != nil {
+		logger.Fatal("failed to run migrations", slog.Any("error", err))
+	}
+	slog.Info("finished running migrations")
 }
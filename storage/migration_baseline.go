@@ -0,0 +1,325 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// ErrMigrationsApplied is returned by Baseline when the migrations table
+// already has rows.
+var ErrMigrationsApplied = errors.New("migrations are already applied")
+
+// MigrationSnapshotter is implemented by adapters that can describe their
+// schema as migration statements, which Squash needs. The SQL adapter on
+// SQLite and the memory adapter implement it.
+type MigrationSnapshotter interface {
+	// SnapshotSchema returns statements that create the schema, in an
+	// order they can run in, each with a Rollback that drops what it
+	// creates. The migrations tables and the migration lock are left out.
+	SnapshotSchema(ctx context.Context) ([]Migration, error)
+}
+
+// baselineName names the row Baseline records for a version that has no
+// migration file.
+func baselineName(version int) string {
+	return fmt.Sprintf("%d__baseline", version)
+}
+
+// squash returns the latest squash file when the database is fresh and
+// target is at or after it, or nil. The squash file is recorded as the
+// migrations it replaces, or as itself when their files are gone.
+func (s *migrationState) squash(target int) *migrationEntry {
+	if s.latest != 0 || len(s.squashes) == 0 {
+		return nil
+	}
+	squash := s.squashes[len(s.squashes)-1]
+	if squash.id > target {
+		return nil
+	}
+	squash.replaces = []migrationEntry{}
+	for _, e := range s.entries {
+		if e.id <= squash.id {
+			squash.replaces = append(squash.replaces, e)
+		}
+	}
+	if n := len(squash.replaces); n == 0 || squash.replaces[n-1].id != squash.id {
+		squash.replaces = append(squash.replaces, migrationEntry{id: squash.id, name: squash.name, file: squash.file})
+	}
+	return &squash
+}
+
+// bookkeeping reports whether a row without a migration file was recorded by
+// Baseline or by a squash file, rather than by a migration whose file is gone.
+func (s *migrationState) bookkeeping(applied AppliedMigration) bool {
+	if applied.Name == baselineName(applied.ID) {
+		return true
+	}
+	for _, e := range s.squashes {
+		if e.id == applied.ID && e.name == applied.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// Baseline marks a database whose schema is already at version as migrated to
+// it, without executing anything, so that a database created before the
+// migrations were, or restored from elsewhere, does not replay them. Every
+// migration up to version is recorded as applied, with its checksum, and when
+// no migration has the id version a row named <version>__baseline is recorded
+// for it. The migrations after version run as usual. Baseline fails with
+// ErrMigrationsApplied unless the migrations table is empty, and holds the
+// migration lock like Migrate does.
+func (m *DatabaseMigration) Baseline(version int) error {
+	if version <= 0 {
+		return fmt.Errorf("baseline version must be positive, got %d", version)
+	}
+	n, err := m.namespaced()
+	if err != nil {
+		return err
+	}
+	return n.withLock(func(n *DatabaseMigration) error {
+		state, err := n.prepare()
+		if err != nil {
+			return err
+		}
+		return n.baseline(state, version)
+	})
+}
+
+// baseline records the migrations up to version without applying them.
+func (m *DatabaseMigration) baseline(s *migrationState, version int) error {
+	if s.latest != 0 {
+		return fmt.Errorf("cannot baseline at %d: %w up to %d", version, ErrMigrationsApplied, s.latest)
+	}
+	var mark []migrationEntry
+	for _, e := range s.entries {
+		if e.id <= version {
+			mark = append(mark, e)
+		}
+	}
+	if len(mark) == 0 || mark[len(mark)-1].id != version {
+		mark = append(mark, migrationEntry{id: version, name: baselineName(version), file: MigrationFile{Description: "baseline"}})
+	}
+	for _, e := range mark {
+		if err := m.lockLost(); err != nil {
+			return err
+		}
+		slog.Info("marking migration as applied", slog.String("key", e.name))
+		if err := m.record(m.storage, e); err != nil {
+			return fmt.Errorf("failed to update migration table for %s: %w", e.name, err)
+		}
+	}
+	return nil
+}
+
+// Squash returns a squash file for the migrations up to version, built from a
+// snapshot of the schema of a database that is at exactly version, such as a
+// scratch database migrated with MigrateTo. Saved as <version>__squash.yaml
+// alongside the migrations, with yaml.Marshal, it is what fresh databases run
+// instead of every migration up to version: they record those migrations as
+// applied, so their history matches that of existing databases, which never
+// run the squash file. The migrations it replaces can then be deleted once
+// every existing database is past version.
+//
+// The snapshot holds the schema only, not rows that migrations inserted, and
+// every table of the database, including those of other namespaces. Adapters
+// that are not MigrationSnapshotter return ErrNotSupported; on them, write the
+// squash file with SquashDump instead.
+func (m *DatabaseMigration) Squash(ctx context.Context, version int) (MigrationFile, error) {
+	n, err := m.namespaced()
+	if err != nil {
+		return MigrationFile{}, err
+	}
+	snapshotter, ok := unwrapAs[MigrationSnapshotter](n.storage)
+	if !ok {
+		return MigrationFile{}, fmt.Errorf("%s storage adapter cannot snapshot its schema: %w", n.storageType, ErrNotSupported)
+	}
+	if err := n.squashable(version); err != nil {
+		return MigrationFile{}, err
+	}
+	statements, err := snapshotter.SnapshotSchema(ctx)
+	if err != nil {
+		return MigrationFile{}, fmt.Errorf("failed to snapshot the schema: %w", err)
+	}
+	return squashFile(version, statements), nil
+}
+
+// SquashDump is Squash for stores that cannot snapshot their own schema, such
+// as PostgreSQL and MySQL: it builds the squash file from dump, the schema of
+// a database at exactly version as written by pg_dump --schema-only, mysqldump
+// --no-data or sqlite3 .schema. Exclude the migrations and migration_lock
+// tables from the dump. Each statement of the dump becomes one migration;
+// psql meta-commands and SET statements, which only configure the session
+// that restores the dump, are dropped. The statements have no rollbacks, so
+// the squash file cannot be rolled back unless they are written by hand.
+func (m *DatabaseMigration) SquashDump(version int, dump string) (MigrationFile, error) {
+	n, err := m.namespaced()
+	if err != nil {
+		return MigrationFile{}, err
+	}
+	if err := n.squashable(version); err != nil {
+		return MigrationFile{}, err
+	}
+	var statements []Migration
+	for _, statement := range splitSQLStatements(dump) {
+		if !sessionStatement(statement) {
+			statements = append(statements, Migration{Migrate: statement})
+		}
+	}
+	if len(statements) == 0 {
+		return MigrationFile{}, fmt.Errorf("cannot squash migrations up to %d: the dump holds no statements", version)
+	}
+	return squashFile(version, statements), nil
+}
+
+// squashable fails unless the database is at exactly version, with every
+// migration up to it applied, so a snapshot of it matches version.
+func (m *DatabaseMigration) squashable(version int) error {
+	entries, err := m.loadMigrations()
+	if err != nil {
+		return err
+	}
+	state, err := m.load(entries)
+	if err != nil {
+		return err
+	}
+	if version <= 0 || state.latest != version {
+		return fmt.Errorf("cannot squash migrations up to %d: the database is at %d", version, state.latest)
+	}
+	for _, e := range state.entries {
+		if _, ok := state.applied[e.id]; !ok && e.id <= version {
+			return fmt.Errorf("cannot squash migrations up to %d: %s is not applied", version, e.name)
+		}
+	}
+	return nil
+}
+
+func squashFile(version int, statements []Migration) MigrationFile {
+	return MigrationFile{
+		Description: fmt.Sprintf("Squash of the migrations up to %d", version),
+		Migrations:  statements,
+		Squash:      true,
+	}
+}
+
+// splitSQLStatements splits a schema dump into its statements, without their
+// terminating semicolons or the comments between them. Semicolons inside
+// quotes, comments and PostgreSQL dollar-quoted bodies do not end a
+// statement, and a line starting with a backslash is a psql meta-command.
+func splitSQLStatements(dump string) []string {
+	var statements []string
+	var current strings.Builder
+	end := func() {
+		if statement := strings.TrimSpace(current.String()); statement != "" {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+	}
+	lineStart := true
+	for i := 0; i < len(dump); {
+		c := dump[i]
+		switch {
+		case lineStart && c == '\\':
+			next := strings.IndexByte(dump[i:], '\n')
+			if next < 0 {
+				next = len(dump) - i
+			}
+			i += next
+			continue
+		case c == '-' && strings.HasPrefix(dump[i:], "--"):
+			next := strings.IndexByte(dump[i:], '\n')
+			if next < 0 {
+				next = len(dump) - i
+			}
+			i += next
+			continue
+		case c == '/' && strings.HasPrefix(dump[i:], "/*"):
+			next := strings.Index(dump[i+2:], "*/")
+			if next < 0 {
+				next = len(dump) - i - 4
+			}
+			// MySQL runs the body of /*!NNNNN ... */ comments.
+			if strings.HasPrefix(dump[i:], "/*!") {
+				body := strings.TrimLeft(dump[i+3:i+2+next], "0123456789")
+				current.WriteString(body)
+			}
+			i += next + 4
+			lineStart = false
+			continue
+		case c == ';':
+			end()
+			i++
+			continue
+		case c == '\'' || c == '"' || c == '`':
+			n := quotedLen(dump[i:], c)
+			current.WriteString(dump[i : i+n])
+			i += n
+			lineStart = false
+			continue
+		case c == '$':
+			if tag := dollarTag(dump[i:]); tag != "" {
+				n := len(dump) - i
+				if close := strings.Index(dump[i+len(tag):], tag); close >= 0 {
+					n = len(tag) + close + len(tag)
+				}
+				current.WriteString(dump[i : i+n])
+				i += n
+				lineStart = false
+				continue
+			}
+		}
+		current.WriteByte(c)
+		lineStart = c == '\n' || (lineStart && (c == ' ' || c == '\t' || c == '\r'))
+		i++
+	}
+	end()
+	return statements
+}
+
+// quotedLen is the length of the quoted string or identifier at the start of
+// s, up to its closing quote; a doubled quote or a backslash escapes one.
+func quotedLen(s string, quote byte) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quote == '\'' {
+				i++
+			}
+		case quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// dollarTag is the $tag$ opening a PostgreSQL dollar-quoted string at the
+// start of s, or "" if s does not start one, as in a $1 parameter.
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			return s[:i+1]
+		case c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || (i > 1 && '0' <= c && c <= '9'):
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+// sessionStatement reports whether statement only configures the session that
+// restores a dump, rather than the schema.
+func sessionStatement(statement string) bool {
+	upper := strings.ToUpper(statement)
+	return strings.HasPrefix(upper, "SET ") ||
+		strings.HasPrefix(upper, "SELECT PG_CATALOG.SET_CONFIG(")
+}
@@ -0,0 +1,354 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"gopkg.in/yaml.v3"
+)
+
+// squashedWidgets consolidates widgetMigrations, and adds a migration after.
+var squashedWidgets = map[string]MigrationFile{
+	"3__squash.yaml": {Description: "squash", Squash: true, Migrations: []Migration{
+		{Migrate: "CREATE TABLE mig_widgets (id TEXT PRIMARY KEY, name TEXT, size INTEGER)", Rollback: "DROP TABLE mig_widgets"},
+	}},
+	"4__add_color.yaml": {Description: "add color", Migrations: []Migration{
+		{Migrate: "ALTER TABLE mig_widgets ADD COLUMN color TEXT", Rollback: "ALTER TABLE mig_widgets DROP COLUMN color"},
+	}},
+}
+
+func withFiles(sets ...map[string]MigrationFile) map[string]MigrationFile {
+	files := map[string]MigrationFile{}
+	for _, set := range sets {
+		for name, file := range set {
+			files[name] = file
+		}
+	}
+	return files
+}
+
+func appliedNames(t *testing.T, m *DatabaseMigration) []string {
+	t.Helper()
+	status, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Missing) != 0 || len(status.Modified) != 0 {
+		t.Fatalf("status = %+v; want nothing missing or modified", status)
+	}
+	var names []string
+	for _, applied := range status.Applied {
+		names = append(names, applied.Name)
+	}
+	return names
+}
+
+func TestBaselineRecordsWithoutExecuting(t *testing.T) {
+	m, _ := migrationOnMemory(t, nil)
+	m.opts.Source = MapMigrationSource(widgetMigrations)
+
+	if err := m.Baseline(2); err != nil {
+		t.Fatal(err)
+	}
+	if columns := widgetColumns(t); len(columns) != 0 {
+		t.Fatalf("columns = %v; want no migration executed", columns)
+	}
+	if names := appliedNames(t, m); !slices.Equal(names, []string{"1__create_widgets.yaml", "2__add_name.yaml"}) {
+		t.Fatalf("applied = %v", names)
+	}
+	status, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Applied[0].Checksum != widgetMigrations["1__create_widgets.yaml"].Checksum() {
+		t.Fatal("baseline did not record the checksum")
+	}
+	if len(status.Pending) != 1 || status.Pending[0].ID != 3 {
+		t.Fatalf("pending = %+v; want 3", status.Pending)
+	}
+	if err := m.Baseline(3); !errors.Is(err, ErrMigrationsApplied) {
+		t.Fatalf("Baseline = %v; want ErrMigrationsApplied", err)
+	}
+}
+
+func TestBaselineWithoutFilesRecordsAMarker(t *testing.T) {
+	m, _ := migrationOnMemory(t, nil)
+	m.opts.Source = MapMigrationSource{"3__add_size.yaml": widgetMigrations["3__add_size.yaml"]}
+
+	if err := m.Baseline(2); err != nil {
+		t.Fatal(err)
+	}
+	if latest := latestMigration(t, m); latest != 2 {
+		t.Fatalf("latest = %d; want 2", latest)
+	}
+	if names := appliedNames(t, m); !slices.Equal(names, []string{"2__baseline"}) {
+		t.Fatalf("applied = %v", names)
+	}
+	if err := m.Rollback(1); err == nil || !strings.Contains(err.Error(), "baseline") {
+		t.Fatalf("Rollback = %v; want the baseline to stop it", err)
+	}
+}
+
+func TestSquashRunsOnFreshDatabases(t *testing.T) {
+	files := withFiles(widgetMigrations, squashedWidgets)
+	m, entries := migrationOnMemory(t, files)
+	m.opts.Source = MapMigrationSource(files)
+
+	status, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Pending) != 2 || status.Pending[0].Name != "3__squash.yaml" || status.Pending[1].ID != 4 {
+		t.Fatalf("pending = %+v; want the squash file and 4", status.Pending)
+	}
+	if err := m.migrateUp(loaded(t, m, entries), math.MaxInt); err != nil {
+		t.Fatal(err)
+	}
+	if columns := widgetColumns(t); !slices.Equal(columns, []string{"id", "name", "size", "color"}) {
+		t.Fatalf("columns = %v", columns)
+	}
+	// The history is the one migrating file by file leaves.
+	want := []string{"1__create_widgets.yaml", "2__add_name.yaml", "3__add_size.yaml", "4__add_color.yaml"}
+	if names := appliedNames(t, m); !slices.Equal(names, want) {
+		t.Fatalf("applied = %v; want %v", names, want)
+	}
+}
+
+func TestSquashIsSkippedOnExistingDatabases(t *testing.T) {
+	files := withFiles(widgetMigrations, squashedWidgets)
+	m, entries := migrationOnMemory(t, files)
+	if err := m.migrateTo(loaded(t, m, entries), 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.migrateUp(loaded(t, m, entries), math.MaxInt); err != nil {
+		t.Fatal(err)
+	}
+	if columns := widgetColumns(t); !slices.Equal(columns, []string{"id", "name", "size", "color"}) {
+		t.Fatalf("columns = %v", columns)
+	}
+	if latest := latestMigration(t, m); latest != 4 {
+		t.Fatalf("latest = %d; want 4", latest)
+	}
+}
+
+func TestSquashWithoutReplacedFilesRecordsItself(t *testing.T) {
+	m, entries := migrationOnMemory(t, squashedWidgets)
+	m.opts.Source = MapMigrationSource(squashedWidgets)
+
+	if err := m.migrateUp(loaded(t, m, entries), math.MaxInt); err != nil {
+		t.Fatal(err)
+	}
+	if names := appliedNames(t, m); !slices.Equal(names, []string{"3__squash.yaml", "4__add_color.yaml"}) {
+		t.Fatalf("applied = %v", names)
+	}
+	if err := m.migrateDown(loaded(t, m, entries), 0); err != nil {
+		t.Fatal(err)
+	}
+	if columns := widgetColumns(t); len(columns) != 0 {
+		t.Fatalf("columns = %v; want the squash file rolled back", columns)
+	}
+}
+
+func TestSquashSnapshotsTheSchema(t *testing.T) {
+	m, entries := migrationOnMemory(t, widgetMigrations)
+	m.opts.Source = MapMigrationSource(widgetMigrations)
+	if err := m.migrateTo(loaded(t, m, entries), 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Squash(context.Background(), 2); err == nil {
+		t.Fatal("Squash(2) succeeded on a database at 3")
+	}
+	file, err := m.Squash(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !file.Squash {
+		t.Fatal("Squash returned a regular migration file")
+	}
+	var widgets *Migration
+	for i, stmt := range file.Migrations {
+		if strings.Contains(stmt.Migrate, "migrations") || strings.Contains(stmt.Migrate, "migration_lock") {
+			t.Fatalf("snapshot has bookkeeping table: %s", stmt.Migrate)
+		}
+		if strings.Contains(stmt.Migrate, "mig_widgets") {
+			widgets = &file.Migrations[i]
+		}
+	}
+	if widgets == nil || widgets.Rollback != `DROP TABLE "mig_widgets"` {
+		t.Fatalf("snapshot = %+v; want mig_widgets with a rollback", file.Migrations)
+	}
+	out, err := yaml.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "squash: true") || strings.Contains(string(out), "providers") {
+		t.Fatalf("yaml = %s", out)
+	}
+
+	// The snapshot rebuilds the schema the migrations did.
+	if err := m.migrateDown(loaded(t, m, entries), 0); err != nil {
+		t.Fatal(err)
+	}
+	squashed := map[string]MigrationFile{"3__squash.yaml": {Squash: true, Migrations: []Migration{*widgets}}}
+	fresh, freshEntries := migrationOnMemory(t, squashed)
+	if err := fresh.migrateUp(loaded(t, fresh, freshEntries), math.MaxInt); err != nil {
+		t.Fatal(err)
+	}
+	if columns := widgetColumns(t); !slices.Equal(columns, []string{"id", "name", "size"}) {
+		t.Fatalf("columns = %v", columns)
+	}
+}
+
+func TestHandWrittenSquashFilesRunOnFreshDatabases(t *testing.T) {
+	// A squash file written from a schema dump rather than Squash.
+	source := FSMigrationSource{Dir: "migrations", FS: fstest.MapFS{
+		"migrations/sqlite/3__squash.yaml": {Data: []byte(`description: squash of 1 to 3, from sqlite3 .schema
+squash: true
+migrations:
+  - migrate: CREATE TABLE mig_widgets (id TEXT PRIMARY KEY, name TEXT, size INTEGER)
+    rollback: DROP TABLE mig_widgets
+`)},
+	}}
+	squash, err := source.MigrationFiles(string(SQLITE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !squash["3__squash.yaml"].Squash {
+		t.Fatalf("files = %+v; want a squash file", squash)
+	}
+	files := withFiles(widgetMigrations, squash)
+
+	fresh, entries := migrationOnMemory(t, files)
+	if err := fresh.migrateUp(loaded(t, fresh, entries), math.MaxInt); err != nil {
+		t.Fatal(err)
+	}
+	if columns := widgetColumns(t); !slices.Equal(columns, []string{"id", "name", "size"}) {
+		t.Fatalf("columns = %v", columns)
+	}
+	fresh.opts.Source = MapMigrationSource(files)
+	want := []string{"1__create_widgets.yaml", "2__add_name.yaml", "3__add_size.yaml"}
+	if names := appliedNames(t, fresh); !slices.Equal(names, want) {
+		t.Fatalf("applied = %v; want %v", names, want)
+	}
+
+	// It rolls back like any other file.
+	if err := fresh.migrateDown(loaded(t, fresh, entries), 0); err != nil {
+		t.Fatal(err)
+	}
+	if columns := widgetColumns(t); len(columns) != 0 {
+		t.Fatalf("columns = %v; want mig_widgets dropped", columns)
+	}
+}
+
+func TestSquashDumpBuildsTheSquashFileFromADump(t *testing.T) {
+	m, entries := migrationOnMemory(t, widgetMigrations)
+	m.opts.Source = MapMigrationSource(widgetMigrations)
+	if err := m.migrateTo(loaded(t, m, entries), 2); err != nil {
+		t.Fatal(err)
+	}
+	dump := "CREATE TABLE mig_widgets (id TEXT PRIMARY KEY, name TEXT, size INTEGER);\n"
+	if _, err := m.SquashDump(3, dump); err == nil {
+		t.Fatal("SquashDump(3) succeeded on a database at 2")
+	}
+	if err := m.migrateUp(loaded(t, m, entries), math.MaxInt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.SquashDump(3, "-- nothing but comments\nSET search_path = public;\n"); err == nil {
+		t.Fatal("SquashDump succeeded on a dump without statements")
+	}
+	file, err := m.SquashDump(3, dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !file.Squash || len(file.Migrations) != 1 || file.Migrations[0].Migrate != strings.TrimSuffix(dump, ";\n") {
+		t.Fatalf("file = %+v; want the dump's statement in a squash file", file)
+	}
+}
+
+func TestSplitSQLStatementsReadsPgDump(t *testing.T) {
+	dump := `--
+-- PostgreSQL database dump
+--
+\restrict abc123
+
+SET statement_timeout = 0;
+SELECT pg_catalog.set_config('search_path', '', false);
+
+/* widgets; the main table */
+CREATE TABLE public.widgets (
+    id text NOT NULL,
+    name text DEFAULT 'a;b'::text,
+    "odd;name" integer
+);
+
+CREATE FUNCTION public.touch() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $_$
+BEGIN
+  NEW.name := $1 || ';';
+  RETURN NEW;
+END;
+$_$;
+
+ALTER TABLE ONLY public.widgets
+    ADD CONSTRAINT widgets_pkey PRIMARY KEY (id);
+
+\unrestrict abc123
+`
+	var statements []string
+	for _, statement := range splitSQLStatements(dump) {
+		if !sessionStatement(statement) {
+			statements = append(statements, statement)
+		}
+	}
+	want := []string{
+		"CREATE TABLE public.widgets (\n    id text NOT NULL,\n    name text DEFAULT 'a;b'::text,\n    \"odd;name\" integer\n)",
+		"CREATE FUNCTION public.touch() RETURNS trigger\n    LANGUAGE plpgsql\n    AS $_$\nBEGIN\n  NEW.name := $1 || ';';\n  RETURN NEW;\nEND;\n$_$",
+		"ALTER TABLE ONLY public.widgets\n    ADD CONSTRAINT widgets_pkey PRIMARY KEY (id)",
+	}
+	if !slices.Equal(statements, want) {
+		t.Fatalf("statements = %q; want %q", statements, want)
+	}
+}
+
+func TestSplitSQLStatementsReadsMysqldump(t *testing.T) {
+	dump := "/*!40101 SET NAMES utf8mb4 */;\n" +
+		"DROP TABLE IF EXISTS `widgets`;\n" +
+		"CREATE TABLE `widgets` (\n  `id` varchar(64) NOT NULL COMMENT 'it''s; the key',\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB;\n" +
+		"/*!50001 CREATE VIEW `big` AS select 1 AS `x` */;\n"
+	var statements []string
+	for _, statement := range splitSQLStatements(dump) {
+		if !sessionStatement(statement) {
+			statements = append(statements, statement)
+		}
+	}
+	want := []string{
+		"DROP TABLE IF EXISTS `widgets`",
+		"CREATE TABLE `widgets` (\n  `id` varchar(64) NOT NULL COMMENT 'it''s; the key',\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB",
+		"CREATE VIEW `big` AS select 1 AS `x`",
+	}
+	if !slices.Equal(statements, want) {
+		t.Fatalf("statements = %q; want %q", statements, want)
+	}
+}
+
+func TestSquashFilesMayShareTheirId(t *testing.T) {
+	entries, err := sortMigrations(withFiles(widgetMigrations, squashedWidgets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[2].name != "3__add_size.yaml" || entries[3].name != "3__squash.yaml" {
+		t.Fatalf("order = %v, %v; want the squash file after the migration", entries[2].name, entries[3].name)
+	}
+	_, err = sortMigrations(map[string]MigrationFile{"3__a.yaml": {Squash: true}, "3__b.yaml": {Squash: true}})
+	if err == nil {
+		t.Fatal("two squash files with one id were accepted")
+	}
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// MigrationCheckpointer is implemented by adapters that can keep a running
+// migration's progress in its migrations-table record, so that a long
+// backfill interrupted by a crash or a deploy resumes where it stopped rather
+// than from the start. The DynamoDB and Cosmos DB adapters implement it.
+type MigrationCheckpointer interface {
+	// MigrationCheckpoint returns the checkpoint saved for a migration, or
+	// "" when there is none.
+	MigrationCheckpoint(id int) (string, error)
+	// SaveMigrationCheckpoint saves a migration's checkpoint. Recording the
+	// migration as applied, or deleting its row, clears it.
+	SaveMigrationCheckpoint(id int, checkpoint string) error
+}
+
+// Checkpoint is the saved progress of the Go migration that is running.
+type Checkpoint struct {
+	id    int
+	name  string
+	store MigrationCheckpointer
+	// saved reports whether the migration left a checkpoint to resume from.
+	saved bool
+}
+
+type checkpointKey struct{}
+
+// withCheckpoint returns ctx carrying the checkpoint of migration e on
+// adapter.
+func withCheckpoint(ctx context.Context, adapter StorageAdapter, e migrationEntry) context.Context {
+	store, _ := unwrapAs[MigrationCheckpointer](adapter)
+	return context.WithValue(ctx, checkpointKey{}, &Checkpoint{id: e.id, name: e.name, store: store})
+}
+
+// CheckpointFromContext returns the checkpoint of the Go migration whose Up or
+// Down was given ctx. Outside a migration, or on an adapter that is not a
+// MigrationCheckpointer, the checkpoint keeps nothing: Load returns "" and
+// Save does nothing.
+func CheckpointFromContext(ctx context.Context) *Checkpoint {
+	if c, ok := ctx.Value(checkpointKey{}).(*Checkpoint); ok {
+		return c
+	}
+	return &Checkpoint{}
+}
+
+// Load returns the saved checkpoint, or "" when the migration has not saved
+// one.
+func (c *Checkpoint) Load() (string, error) {
+	if c.store == nil {
+		return "", nil
+	}
+	checkpoint, err := c.store.MigrationCheckpoint(c.id)
+	if err != nil {
+		return "", fmt.Errorf("failed to load the checkpoint of %s: %w", c.name, err)
+	}
+	if checkpoint != "" {
+		slog.Info("resuming migration from its checkpoint", slog.String("key", c.name))
+	}
+	return checkpoint, nil
+}
+
+// Save saves checkpoint, typically the cursor of the next page to migrate.
+// Saving "" clears it.
+func (c *Checkpoint) Save(checkpoint string) error {
+	if c.store == nil {
+		return nil
+	}
+	if err := c.store.SaveMigrationCheckpoint(c.id, checkpoint); err != nil {
+		return fmt.Errorf("failed to save the checkpoint of %s: %w", c.name, err)
+	}
+	c.saved = checkpoint != ""
+	return nil
+}
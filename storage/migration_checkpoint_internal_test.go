@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+// memoryCheckpoints is a MigrationCheckpointer keeping checkpoints in a map.
+type memoryCheckpoints map[int]string
+
+func (c memoryCheckpoints) MigrationCheckpoint(id int) (string, error) { return c[id], nil }
+func (c memoryCheckpoints) SaveMigrationCheckpoint(id int, checkpoint string) error {
+	c[id] = checkpoint
+	return nil
+} // checkpointingMemory is the memory adapter with checkpoints, as the DynamoDB
+// and Cosmos DB adapters keep them.
+type checkpointingMemory struct {
+	*MemoryAdapter
+	memoryCheckpoints
+}
+
+func TestGoMigrationResumesFromItsCheckpointInsteadOfRollingBack(t *testing.T) {
+	files := map[string]MigrationFile{"1__create_widgets.yaml": widgetMigrations["1__create_widgets.yaml"]}
+	m, entries := migrationOnMemory(t, files)
+	checkpoints := memoryCheckpoints{}
+	m.storage = checkpointingMemory{GetMemoryAdapterInstance(), checkpoints}
+
+	// The backfill inserts one widget per page, checkpointing after each,
+	// and fails after the first page on its first run.
+	failed, undone := false, false
+	registerForTest(t, GoMigration{
+		ID: 2,
+		Up: func(ctx context.Context, s StorageAdapter) error {
+			checkpoint := CheckpointFromContext(ctx)
+			from, err := checkpoint.Load()
+			if err != nil {
+				return err
+			}
+			for _, id := range []string{"w1", "w2"} {
+				if id <= from {
+					continue
+				}
+				if err := s.Execute("INSERT INTO mig_widgets (id) VALUES ('" + id + "')"); err != nil {
+					return err
+				}
+				if err := checkpoint.Save(id); err != nil {
+					return err
+				}
+				if !failed {
+					failed = true
+					return errors.New("interrupted")
+				}
+			}
+			return nil
+		},
+		Down: func(ctx context.Context, s StorageAdapter) error {
+			undone = true
+			return s.Execute("DELETE FROM mig_widgets")
+		},
+	})
+	entries = withRegistered(t, entries)
+
+	err := m.migrateUp(loaded(t, m, entries), math.MaxInt)
+	var failure *MigrationError
+	if !errors.As(err, &failure) || failure.RolledBack || failure.RollbackErr == nil || undone {
+		t.Fatalf("migrateUp = %v, Down ran = %v; want the progress kept", err, undone)
+	}
+	if checkpoints[2] != "w1" || widgetCount(t) != 1 {
+		t.Fatalf("checkpoint = %q with %d widgets; want w1 and its widget", checkpoints[2], widgetCount(t))
+	}
+
+	if err := m.migrateUp(loaded(t, m, entries), math.MaxInt); err != nil {
+		t.Fatal(err)
+	}
+	if n := widgetCount(t); n != 2 {
+		t.Fatalf("mig_widgets has %d rows; want each widget inserted once", n)
+	}
+	if latest := latestMigration(t, m); latest != 2 {
+		t.Fatalf("latest migration = %d; want the resumed migration recorded", latest)
+	}
+}
+
+func TestCheckpointOutsideAMigrationKeepsNothing(t *testing.T) {
+	checkpoint := CheckpointFromContext(context.Background())
+	if err := checkpoint.Save("page"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := checkpoint.Load(); got != "" || err != nil {
+		t.Fatalf("Load = %q, %v; want nothing", got, err)
+	}
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// GoMigration is a migration written in Go, for changes statements cannot
+// express, such as backfills that need application logic. Registered
+// migrations run in id order with the YAML files and are recorded in the same
+// migrations table.
+type GoMigration struct {
+	ID int
+	// Name is recorded in the migrations table. Defaults to "<id>__go".
+	Name        string
+	Description string
+	// Up applies the migration to the adapter it is given.
+	Up func(ctx context.Context, s StorageAdapter) error
+	// Down undoes Up. Without it the migration cannot be rolled back.
+	Down func(ctx context.Context, s StorageAdapter) error
+	// Transactional runs Up and Down in a transaction, with the adapter
+	// they are given bound to it, on adapters that are MigrationTransactors.
+	// Elsewhere they run without one.
+	Transactional bool
+}
+
+// MigrationTransactor is implemented by adapters that can run a migration in
+// a transaction, handing it an adapter bound to the transaction. The
+// transaction commits when fn returns nil and rolls back otherwise. The SQL
+// and memory adapters implement it.
+type MigrationTransactor interface {
+	InMigrationTransaction(ctx context.Context, fn func(tx StorageAdapter) error) error
+	// TransactionalDDL reports whether rolling a transaction back undoes
+	// schema changes too, so that migration files can run in one.
+	TransactionalDDL() bool
+}
+
+var goMigrationsLock sync.Mutex
+var goMigrations = map[int]GoMigration{}
+
+// RegisterMigration registers a Go migration for every DatabaseMigration,
+// typically from an init function. Like sql.Register, it panics when Up is nil
+// or the id is already registered.
+func RegisterMigration(migration GoMigration) {
+	goMigrationsLock.Lock()
+	defer goMigrationsLock.Unlock()
+	if migration.Up == nil {
+		panic(fmt.Sprintf("storage: Go migration %d has no Up", migration.ID))
+	}
+	if _, dup := goMigrations[migration.ID]; dup {
+		panic(fmt.Sprintf("storage: Go migration %d registered twice", migration.ID))
+	}
+	if migration.Name == "" {
+		migration.Name = fmt.Sprintf("%d__go", migration.ID)
+	}
+	goMigrations[migration.ID] = migration
+}
+
+// registeredMigrations returns the registered Go migrations as entries.
+func registeredMigrations() []migrationEntry {
+	goMigrationsLock.Lock()
+	defer goMigrationsLock.Unlock()
+	entries := make([]migrationEntry, 0, len(goMigrations))
+	for _, id := range slices.Sorted(maps.Keys(goMigrations)) {
+		migration := goMigrations[id]
+		entries = append(entries, migrationEntry{
+			id:   id,
+			name: migration.Name,
+			file: MigrationFile{Description: migration.Description},
+			code: &migration,
+		})
+	}
+	return entries
+}
+
+// runCode runs a Go migration's Up or Down, in a transaction when it asks for
+// one and the adapter can, and then calls after with the adapter it ran on,
+// inside the same transaction.
+func (m *DatabaseMigration) runCode(ctx context.Context, e migrationEntry, fn func(ctx context.Context, s StorageAdapter) error, after func(s StorageAdapter) error) error {
+	run := func(s StorageAdapter) error {
+		if err := fn(ctx, s); err != nil {
+			return err
+		}
+		return after(s)
+	}
+	if !e.code.Transactional {
+		return run(m.storage)
+	}
+	transactor, ok := unwrapAs[MigrationTransactor](m.storage)
+	if !ok {
+		slog.Warn(fmt.Sprintf("%s storage adapter cannot run migrations in a transaction, running without one", m.storageType),
+			slog.String("key", e.name))
+		return run(m.storage)
+	}
+	return transactor.InMigrationTransaction(ctx, run)
+}
+
+// applyCode runs a Go migration's Up and records it. When Up or the record
+// fails, a transaction is rolled back; without one, Down undoes Up, unless Up
+// saved a checkpoint to resume from.
+func (m *DatabaseMigration) applyCode(e migrationEntry) error {
+	slog.Info("running Go migration", slog.String("key", e.name))
+	ctx := withCheckpoint(m.runContext(), m.storage, e)
+	err := m.runCode(ctx, e, e.code.Up, func(s StorageAdapter) error { return m.record(s, e) })
+	if err == nil {
+		return nil
+	}
+	slog.Error("failed to run Go migration", slog.String("key", e.name), slog.Any("error", err))
+	failure := &MigrationError{ID: e.id, Name: e.name, Err: err}
+	if _, ok := unwrapAs[MigrationTransactor](m.storage); ok && e.code.Transactional {
+		failure.RolledBack = true
+	} else if CheckpointFromContext(ctx).saved {
+		failure.RollbackErr = fmt.Errorf("%s saved a checkpoint, the next run resumes it", e.name)
+	} else if e.code.Down == nil {
+		failure.RollbackErr = ErrIrreversibleMigration
+	} else if failure.RollbackErr = e.code.Down(context.Background(), m.storage); failure.RollbackErr == nil {
+		failure.RolledBack = true
+	}
+	return failure
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"math"
+	"testing"
+)
+
+// registerForTest registers Go migrations until the test ends.
+func registerForTest(t *testing.T, migrations ...GoMigration) {
+	t.Helper()
+	for _, migration := range migrations {
+		RegisterMigration(migration)
+	}
+	t.Cleanup(func() {
+		goMigrationsLock.Lock()
+		defer goMigrationsLock.Unlock()
+		for _, migration := range migrations {
+			delete(goMigrations, migration.ID)
+		}
+	})
+}
+
+// withRegistered adds the registered Go migrations to entries, as
+// loadMigrations does.
+func withRegistered(t *testing.T, entries []migrationEntry) []migrationEntry {
+	t.Helper()
+	entries, err := orderMigrations(append(entries, registeredMigrations()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func widgetCount(t *testing.T) int {
+	t.Helper()
+	var count int
+	if err := GetMemoryAdapterInstance().DB.DB.Raw("SELECT count(*) FROM mig_widgets").Scan(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+var seedWidgets = GoMigration{
+	ID:          2,
+	Name:        "2__seed_widgets",
+	Description: "seed widgets",
+	Up: func(ctx context.Context, s StorageAdapter) error {
+		return s.Execute("INSERT INTO mig_widgets (id) VALUES ('w1'), ('w2')")
+	},
+	Down: func(ctx context.Context, s StorageAdapter) error {
+		return s.Execute("DELETE FROM mig_widgets")
+	},
+	Transactional: true,
+}
+
+func TestGoMigrationsRunBetweenFilesAndAreRecorded(t *testing.T) {
+	files := maps.Clone(widgetMigrations)
+	delete(files, "2__add_name.yaml")
+	m, entries := migrationOnMemory(t, files)
+	registerForTest(t, seedWidgets)
+	entries = withRegistered(t, entries)
+
+	plan, err := m.plan(loaded(t, m, entries))
+	if err != nil || len(plan.Migrations) != 3 || !plan.Migrations[1].Go || len(plan.Migrations[1].Statements) != 0 {
+		t.Fatalf("plan = %+v, %v; want the Go migration second", plan, err)
+	}
+	if err := m.migrateUp(loaded(t, m, entries), math.MaxInt); err != nil {
+		t.Fatal(err)
+	}
+	if n := widgetCount(t); n != 2 {
+		t.Fatalf("mig_widgets has %d rows; want the seeded 2", n)
+	}
+	status := loaded(t, m, entries).status()
+	if len(status.Applied) != 3 || status.Applied[1].Name != "2__seed_widgets" || status.Applied[1].Description != "seed widgets" {
+		t.Fatalf("applied = %+v", status.Applied)
+	}
+
+	if err := m.rollback(loaded(t, m, entries), 2); err != nil {
+		t.Fatal(err)
+	}
+	if n := widgetCount(t); n != 0 {
+		t.Fatalf("mig_widgets has %d rows after rolling the seed back", n)
+	}
+	if latest := latestMigration(t, m); latest != 1 {
+		t.Fatalf("latest migration = %d; want 1", latest)
+	}
+}
+
+func TestFailedTransactionalGoMigrationIsRolledBack(t *testing.T) {
+	files := map[string]MigrationFile{"1__create_widgets.yaml": widgetMigrations["1__create_widgets.yaml"]}
+	m, entries := migrationOnMemory(t, files)
+	boom := errors.New("backfill failed")
+	failing := seedWidgets
+	failing.Up = func(ctx context.Context, s StorageAdapter) error {
+		if err := seedWidgets.Up(ctx, s); err != nil {
+			return err
+		}
+		return boom
+	}
+	registerForTest(t, failing)
+
+	err := m.migrateUp(loaded(t, m, withRegistered(t, entries)), math.MaxInt)
+	var failure *MigrationError
+	if !errors.As(err, &failure) || !errors.Is(err, boom) || !failure.RolledBack || failure.ID != 2 {
+		t.Fatalf("migrateUp = %v; want migration 2 reported as rolled back", err)
+	}
+	if n := widgetCount(t); n != 0 {
+		t.Fatalf("mig_widgets has %d rows; the transaction should have rolled back", n)
+	}
+	if latest := latestMigration(t, m); latest != 1 {
+		t.Fatalf("latest migration = %d; the failed migration was recorded", latest)
+	}
+}
+
+func TestFailedGoMigrationWithoutDownIsNotRolledBack(t *testing.T) {
+	files := map[string]MigrationFile{"1__create_widgets.yaml": widgetMigrations["1__create_widgets.yaml"]}
+	m, entries := migrationOnMemory(t, files)
+	registerForTest(t, GoMigration{ID: 2, Up: func(ctx context.Context, s StorageAdapter) error {
+		return errors.New("backfill failed")
+	}})
+	entries = withRegistered(t, entries)
+	if entries[1].name != "2__go" {
+		t.Fatalf("name = %q; want the default", entries[1].name)
+	}
+
+	err := m.migrateUp(loaded(t, m, entries), math.MaxInt)
+	var failure *MigrationError
+	if !errors.As(err, &failure) || failure.RolledBack || !errors.Is(failure.RollbackErr, ErrIrreversibleMigration) {
+		t.Fatalf("migrateUp = %v; want an irreversible failure", err)
+	}
+}
+
+func TestRegisterMigrationRejectsDuplicates(t *testing.T) {
+	registerForTest(t, seedWidgets)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering id 2 twice did not panic")
+		}
+	}()
+	RegisterMigration(seedWidgets)
+}
@@ -2,6 +2,9 @@ package storage
 
 import (
 	"errors"
+	"maps"
+	"math"
+	"slices"
 	"testing"
 )
 
@@ -91,3 +94,290 @@ func TestGetMigrationFilesReturnsEmptyForUninitializedConfigFs(t *testing.T) {
 		t.Fatalf("expected empty migration map, got %v", got)
 	}
 }
+
+// widgetMigrations creates mig_widgets and adds two columns to it, one
+// migration each.
+var widgetMigrations = map[string]MigrationFile{
+	"1__create_widgets.yaml": {Description: "create widgets", Migrations: []Migration{
+		{Migrate: "CREATE TABLE mig_widgets (id TEXT PRIMARY KEY)", Rollback: "DROP TABLE mig_widgets"},
+	}},
+	"2__add_name.yaml": {Description: "add name", Migrations: []Migration{
+		{Migrate: "ALTER TABLE mig_widgets ADD COLUMN name TEXT", Rollback: "ALTER TABLE mig_widgets DROP COLUMN name"},
+	}},
+	"3__add_size.yaml": {Description: "add size", Migrations: []Migration{
+		{Migrate: "ALTER TABLE mig_widgets ADD COLUMN size INTEGER", Rollback: "ALTER TABLE mig_widgets DROP COLUMN size"},
+	}},
+}
+
+// migrationOnMemory returns a DatabaseMigration over the memory adapter, with
+// an empty migrations table and no mig_widgets table, and the migrations
+// sorted.
+func migrationOnMemory(t *testing.T, files map[string]MigrationFile) (*DatabaseMigration, []migrationEntry) {
+	t.Helper()
+	adapter := GetMemoryAdapterInstance()
+	reset := func() {
+		for _, stmt := range []string{"DROP TABLE IF EXISTS mig_widgets", "DELETE FROM migrations"} {
+			if err := adapter.Execute(stmt); err != nil {
+				t.Fatalf("%s: %v", stmt, err)
+			}
+		}
+	}
+	if err := adapter.CreateMigrationTable(); err != nil {
+		t.Fatal(err)
+	}
+	reset()
+	t.Cleanup(reset)
+	entries, err := sortMigrations(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewDatabaseMigration(adapter), entries
+}
+
+// loaded reads the migrations table as a run does after prepare.
+func loaded(t *testing.T, m *DatabaseMigration, entries []migrationEntry) *migrationState {
+	t.Helper()
+	state, err := m.load(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func latestMigration(t *testing.T, m *DatabaseMigration) int {
+	t.Helper()
+	latest, err := m.storage.GetLatestMigration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return latest
+}
+
+func widgetColumns(t *testing.T) []string {
+	t.Helper()
+	var columns []string
+	err := GetMemoryAdapterInstance().DB.DB.Raw("SELECT name FROM pragma_table_info('mig_widgets') ORDER BY cid").Scan(&columns).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return columns
+}
+
+func TestMigrateToMovesUpAndDown(t *testing.T) {
+	m, entries := migrationOnMemory(t, widgetMigrations)
+
+	if err := m.migrateTo(loaded(t, m, entries), 3); err != nil {
+		t.Fatalf("migrateTo(3): %v", err)
+	}
+	if got := widgetColumns(t); !slices.Equal(got, []string{"id", "name", "size"}) {
+		t.Fatalf("columns after migrating to 3 = %v", got)
+	}
+
+	if err := m.migrateTo(loaded(t, m, entries), 1); err != nil {
+		t.Fatalf("migrateTo(1): %v", err)
+	}
+	if got := widgetColumns(t); !slices.Equal(got, []string{"id"}) {
+		t.Fatalf("columns after migrating down to 1 = %v", got)
+	}
+	if latest := latestMigration(t, m); latest != 1 {
+		t.Fatalf("latest migration = %d; want 1", latest)
+	}
+
+	if err := m.migrateTo(loaded(t, m, entries), 4); err == nil {
+		t.Fatal("migrateTo(4) succeeded; there is no migration 4")
+	}
+	if err := m.migrateTo(loaded(t, m, entries), 0); err != nil {
+		t.Fatalf("migrateTo(0): %v", err)
+	}
+	if got := widgetColumns(t); len(got) != 0 {
+		t.Fatalf("mig_widgets still has columns %v after migrating to 0", got)
+	}
+}
+
+func TestRollbackUndoesTheLastSteps(t *testing.T) {
+	m, entries := migrationOnMemory(t, widgetMigrations)
+	// Migrations are bookkept through wrappers that only pass the
+	// StorageAdapter methods on.
+	m.storage = &recordingWrapper{StorageAdapter: m.storage}
+	if err := m.migrateUp(loaded(t, m, entries), math.MaxInt); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.rollback(loaded(t, m, entries), 4); err == nil {
+		t.Fatal("rolling back 4 of 3 applied migrations succeeded")
+	}
+	if err := m.rollback(loaded(t, m, entries), 2); err != nil {
+		t.Fatalf("rollback(2): %v", err)
+	}
+	if latest := latestMigration(t, m); latest != 1 {
+		t.Fatalf("latest migration = %d; want 1", latest)
+	}
+	if got := widgetColumns(t); !slices.Equal(got, []string{"id"}) {
+		t.Fatalf("columns = %v", got)
+	}
+}
+
+type recordingWrapper struct{ StorageAdapter }
+
+func (w *recordingWrapper) UnwrapStorageAdapter() StorageAdapter { return w.StorageAdapter }
+
+func TestRollbackRefusesIrreversibleMigrations(t *testing.T) {
+	files := map[string]MigrationFile{
+		"1__create_widgets.yaml": widgetMigrations["1__create_widgets.yaml"],
+		"2__seed.yaml": {Migrations: []Migration{
+			{Migrate: "INSERT INTO mig_widgets (id) VALUES ('w1')"},
+		}},
+	}
+	m, entries := migrationOnMemory(t, files)
+	if err := m.migrateUp(loaded(t, m, entries), math.MaxInt); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.rollback(loaded(t, m, entries), 2); !errors.Is(err, ErrIrreversibleMigration) {
+		t.Fatalf("rollback = %v; want ErrIrreversibleMigration", err)
+	}
+	if latest := latestMigration(t, m); latest != 2 {
+		t.Fatalf("latest migration = %d; nothing should have been rolled back", latest)
+	}
+}
+
+func TestFailedMigrationIsRolledBackAndReported(t *testing.T) {
+	files := map[string]MigrationFile{
+		"1__create_widgets.yaml": widgetMigrations["1__create_widgets.yaml"],
+		"2__broken.yaml": {Migrations: []Migration{
+			{Migrate: "ALTER TABLE mig_widgets ADD COLUMN name TEXT", Rollback: "ALTER TABLE mig_widgets DROP COLUMN name"},
+			{Migrate: "ALTER TABLE no_such_table ADD COLUMN size INTEGER", Rollback: "SELECT 1"},
+		}},
+	}
+	m, entries := migrationOnMemory(t, files)
+	err := m.migrateTo(loaded(t, m, entries), 2)
+	var failure *MigrationError
+	if !errors.As(err, &failure) || failure.ID != 2 || !failure.RolledBack {
+		t.Fatalf("migrateTo = %v; want migration 2 reported as rolled back", err)
+	}
+	if latest := latestMigration(t, m); latest != 1 {
+		t.Fatalf("latest migration = %d; want 1", latest)
+	}
+	if got := widgetColumns(t); !slices.Equal(got, []string{"id"}) {
+		t.Fatalf("columns = %v; the failed migration's first statement should be undone", got)
+	}
+}
+
+func TestSortMigrationsOrdersByIdAndRejectsDuplicates(t *testing.T) {
+	entries, err := sortMigrations(map[string]MigrationFile{"10__b.yaml": {}, "9__a.yaml": {}, "100__c.yaml": {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, e := range entries {
+		ids = append(ids, e.id)
+	}
+	if !slices.Equal(ids, []int{9, 10, 100}) {
+		t.Fatalf("ids = %v", ids)
+	}
+	if _, err := sortMigrations(map[string]MigrationFile{"3__a.yaml": {}, "03__b.yaml": {}}); err == nil {
+		t.Fatal("two migrations with id 3 were accepted")
+	}
+}
+
+func TestMigrationChecksumIgnoresTheDescription(t *testing.T) {
+	file := widgetMigrations["2__add_name.yaml"]
+	described := file
+	described.Description = "something else"
+	if file.Checksum() != described.Checksum() {
+		t.Fatal("changing the description changed the checksum")
+	}
+	edited := MigrationFile{Migrations: []Migration{{Migrate: file.Migrations[0].Migrate + " NOT NULL", Rollback: file.Migrations[0].Rollback}}}
+	if file.Checksum() == edited.Checksum() {
+		t.Fatal("changing a statement kept the checksum")
+	}
+}
+
+func TestEditedMigrationsFailOrWarnByPolicy(t *testing.T) {
+	m, entries := migrationOnMemory(t, widgetMigrations)
+	if err := m.migrateUp(loaded(t, m, entries), math.MaxInt); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.verify(loaded(t, m, entries)); err != nil {
+		t.Fatalf("verify = %v before anything was edited", err)
+	}
+
+	edited := maps.Clone(widgetMigrations)
+	edited["2__add_name.yaml"] = MigrationFile{Migrations: []Migration{
+		{Migrate: "ALTER TABLE mig_widgets ADD COLUMN title TEXT", Rollback: "ALTER TABLE mig_widgets DROP COLUMN title"},
+	}}
+	entries, err := sortMigrations(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := loaded(t, m, entries)
+	if err := m.verify(state); !errors.Is(err, ErrMigrationModified) {
+		t.Fatalf("verify = %v; want ErrMigrationModified", err)
+	}
+	status := state.status()
+	if len(status.Modified) != 1 || status.Modified[0].ID != 2 {
+		t.Fatalf("modified = %+v; want migration 2", status.Modified)
+	}
+
+	m.opts.OnChecksumMismatch = ChecksumWarn
+	if err := m.verify(state); err != nil {
+		t.Fatalf("verify = %v; the policy only warns", err)
+	}
+}
+
+func TestOutOfOrderMigrationsFailUnlessAllowed(t *testing.T) {
+	files := maps.Clone(widgetMigrations)
+	delete(files, "2__add_name.yaml")
+	m, entries := migrationOnMemory(t, files)
+	if err := m.migrateUp(loaded(t, m, entries), math.MaxInt); err != nil {
+		t.Fatal(err)
+	}
+
+	// Migration 2 arrives from a branch merged after 3 was applied.
+	entries, err := sortMigrations(widgetMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := loaded(t, m, entries).status().Pending; len(pending) != 1 || !pending[0].OutOfOrder {
+		t.Fatalf("pending = %+v; want migration 2 out of order", pending)
+	}
+	if err := m.migrateUp(loaded(t, m, entries), math.MaxInt); !errors.Is(err, ErrMigrationOutOfOrder) {
+		t.Fatalf("migrateUp = %v; want ErrMigrationOutOfOrder", err)
+	}
+	if got := widgetColumns(t); !slices.Equal(got, []string{"id", "size"}) {
+		t.Fatalf("columns = %v; nothing should have been applied", got)
+	}
+
+	m.opts.AllowOutOfOrder = true
+	if err := m.migrateUp(loaded(t, m, entries), math.MaxInt); err != nil {
+		t.Fatal(err)
+	}
+	if got := widgetColumns(t); !slices.Equal(got, []string{"id", "size", "name"}) {
+		t.Fatalf("columns = %v; want migration 2 applied", got)
+	}
+}
+
+func TestStatusReportsAppliedPendingAndMissing(t *testing.T) {
+	m, entries := migrationOnMemory(t, widgetMigrations)
+	if err := m.migrateTo(loaded(t, m, entries), 2); err != nil {
+		t.Fatal(err)
+	}
+	status := loaded(t, m, entries[1:]).status()
+	if len(status.Applied) != 2 || status.Applied[0].Checksum != entries[0].file.Checksum() || status.Applied[0].AppliedAt.IsZero() {
+		t.Fatalf("applied = %+v", status.Applied)
+	}
+	if len(status.Pending) != 1 || status.Pending[0].ID != 3 || status.Pending[0].OutOfOrder {
+		t.Fatalf("pending = %+v; want migration 3", status.Pending)
+	}
+	if len(status.Missing) != 1 || status.Missing[0].Name != "1__create_widgets.yaml" {
+		t.Fatalf("missing = %+v; want migration 1, whose file was dropped", status.Missing)
+	}
+	if err := m.migrateDown(loaded(t, m, entries[1:]), 0); err == nil {
+		t.Fatal("rolled back a migration whose file is missing")
+	}
+
+	// Status reads the files the application embeds, which tests have none of.
+	public, err := m.Status()
+	if err != nil || len(public.Missing) != 2 || len(public.Pending) != 0 {
+		t.Fatalf("Status = %+v, %v", public, err)
+	}
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// MigrationLocker is implemented by adapters that can keep two processes from
+// migrating the same store at once. DatabaseMigration takes the lock before it
+// reads the latest migration and holds it until the run ends, so when many
+// instances start together one migrates and the others wait for it.
+//
+// PostgreSQL uses an advisory lock, MySQL GET_LOCK and SQL Server an
+// application lock, each held by a dedicated connection. SQLite keeps a lock
+// row in a migration_lock table. DynamoDB and Cosmos DB keep a lock item,
+// taken and renewed with conditional writes.
+type MigrationLocker interface {
+	// TryLockMigrations takes the lock for owner unless another owner holds
+	// it, in which case it returns a nil MigrationLock and no error. A lock
+	// kept as a row or item expires lease after it was last refreshed, so a
+	// crashed holder does not keep it forever.
+	TryLockMigrations(ctx context.Context, owner string, lease time.Duration) (MigrationLock, error)
+}
+
+// MigrationLock is a held migration lock.
+type MigrationLock interface {
+	// Refresh renews the lease, and fails when the lock has been lost.
+	Refresh(ctx context.Context) error
+	// Release gives the lock up.
+	Release(ctx context.Context) error
+}
+
+// ErrMigrationLockTimeout is returned when another process held the migration
+// lock for longer than MigrationOptions.LockTimeout.
+var ErrMigrationLockTimeout = errors.New("timed out waiting for the migration lock")
+
+// ErrMigrationLockLost fails a run whose migration lock could not be
+// refreshed, since another process may have taken it over. The migration
+// running at the time finishes, or has its context cancelled when written in
+// Go, and no other migration is started.
+var ErrMigrationLockLost = errors.New("lost the migration lock")
+
+// MigrationOptions configures a DatabaseMigration.
+type MigrationOptions struct {
+	// LockTimeout bounds how long a run waits for another process to finish
+	// migrating. Defaults to 5 minutes.
+	LockTimeout time.Duration
+	// LockLease is how long a lock row or item stays held without being
+	// refreshed; the holder refreshes it every third of the lease. Keep it
+	// well above the clock skew between instances. Defaults to 30 seconds.
+	LockLease time.Duration
+	// LockOwner identifies this process in lock rows and items. Defaults to
+	// the host name, process id and a random suffix.
+	LockOwner string
+	// OnChecksumMismatch is what a run does when an applied migration's file
+	// no longer matches the checksum recorded when it was applied. Defaults
+	// to ChecksumFail.
+	OnChecksumMismatch ChecksumPolicy
+	// AllowOutOfOrder applies pending migrations older than the latest
+	// applied one instead of failing the run.
+	AllowOutOfOrder bool
+	// Transactions is how migrations are grouped into transactions on
+	// providers whose DDL is transactional. Defaults to TransactionPerFile.
+	Transactions TransactionMode
+	// Source is where migration files are read from. Defaults to the
+	// config/migrations directory of storage.ConfigFs.
+	Source MigrationSource
+	// TemplateVars are the Vars of templated migration files.
+	TemplateVars map[string]any
+}
+
+// TransactionMode is how a migration run uses transactions.
+type TransactionMode string
+
+const (
+	// TransactionPerFile runs each migration, with its migrations row, in a
+	// transaction of its own.
+	TransactionPerFile TransactionMode = "file"
+	// TransactionBatch runs every migration of the run in one transaction,
+	// so that a failure leaves none of them applied.
+	TransactionBatch TransactionMode = "batch"
+	// TransactionNone runs statements one at a time and relies on their
+	// Rollback statements when one fails.
+	TransactionNone TransactionMode = "none"
+)
+
+// ChecksumPolicy is what a migration run does about edited migrations.
+type ChecksumPolicy string
+
+const (
+	// ChecksumFail fails the run before anything is applied.
+	ChecksumFail ChecksumPolicy = "fail"
+	// ChecksumWarn logs the edited migrations and carries on.
+	ChecksumWarn ChecksumPolicy = "warn"
+)
+
+// migrationLockPoll is how often a waiting run retries the lock.
+var migrationLockPoll = time.Second
+
+// funcMigrationLock is a MigrationLock made of two functions.
+type funcMigrationLock struct {
+	refresh func(ctx context.Context) error
+	release func(ctx context.Context) error
+}
+
+func (l funcMigrationLock) Refresh(ctx context.Context) error { return l.refresh(ctx) }
+func (l funcMigrationLock) Release(ctx context.Context) error { return l.release(ctx) }
+
+func defaultLockOwner() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// withLock runs run holding the adapter's migration lock, waiting up to
+// LockTimeout for it. Adapters that are not MigrationLockers run unlocked.
+// run is given a copy of m that knows the lock is held, so that it stops
+// between migrations once a refresh fails; see lockLost.
+func (m *DatabaseMigration) withLock(run func(m *DatabaseMigration) error) error {
+	locker, ok := unwrapAs[MigrationLocker](m.storage)
+	if !ok {
+		slog.Warn(fmt.Sprintf("%s storage adapter cannot lock migrations, running without a lock", m.storageType))
+		return run(m)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.opts.LockTimeout)
+	defer cancel()
+	var lock MigrationLock
+	for {
+		var err error
+		if lock, err = locker.TryLockMigrations(ctx, m.opts.LockOwner, m.opts.LockLease); err != nil {
+			if ctx.Err() != nil {
+				return ErrMigrationLockTimeout
+			}
+			return fmt.Errorf("failed to take the migration lock: %w", err)
+		}
+		if lock != nil {
+			break
+		}
+		slog.Info("waiting for another instance to finish migrating")
+		select {
+		case <-time.After(migrationLockPoll):
+		case <-ctx.Done():
+			return ErrMigrationLockTimeout
+		}
+	}
+	slog.Info("took the migration lock", slog.String("owner", m.opts.LockOwner))
+
+	held, lost := context.WithCancelCause(context.Background())
+	defer lost(nil)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(m.opts.LockLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// A refresh that outlasts the interval is treated as failed:
+				// the lease may run out before it returns.
+				refreshCtx, cancel := context.WithTimeout(context.Background(), m.opts.LockLease/3)
+				err := lock.Refresh(refreshCtx)
+				cancel()
+				if err != nil {
+					slog.Error("failed to refresh the migration lock, stopping the run", slog.Any("error", err))
+					lost(fmt.Errorf("%w: %w", ErrMigrationLockLost, err))
+					return
+				}
+			}
+		}
+	}()
+	defer func() {
+		close(done)
+		<-stopped
+		if err := lock.Release(context.Background()); err != nil {
+			slog.Error("failed to release the migration lock", slog.Any("error", err))
+		}
+	}()
+	locked := *m
+	locked.held = held
+	return run(&locked)
+}
+
+// lockLost returns an error wrapping ErrMigrationLockLost once the migration
+// lock of the run has failed to refresh, and nil otherwise.
+func (m *DatabaseMigration) lockLost() error {
+	if m.held == nil || m.held.Err() == nil {
+		return nil
+	}
+	return context.Cause(m.held)
+}
+
+// runContext is the context Go migrations run with, cancelled when the
+// migration lock of the run is lost.
+func (m *DatabaseMigration) runContext() context.Context {
+	if m.held == nil {
+		return context.Background()
+	}
+	return m.held
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryMigrationLock returns the memory adapter with no migration lock row
+// and an empty migrations table, and polls for the lock every millisecond.
+func memoryMigrationLock(t *testing.T) *MemoryAdapter {
+	t.Helper()
+	adapter := GetMemoryAdapterInstance()
+	if err := adapter.CreateMigrationTable(); err != nil {
+		t.Fatal(err)
+	}
+	reset := func() {
+		for _, stmt := range []string{"DROP TABLE IF EXISTS migration_lock", "DELETE FROM migrations"} {
+			if err := adapter.Execute(stmt); err != nil {
+				t.Fatalf("%s: %v", stmt, err)
+			}
+		}
+	}
+	reset()
+	t.Cleanup(reset)
+	poll := migrationLockPoll
+	migrationLockPoll = time.Millisecond
+	t.Cleanup(func() { migrationLockPoll = poll })
+	return adapter
+}
+
+func TestSQLiteMigrationLockRowIsHeldUntilItsLeaseExpires(t *testing.T) {
+	adapter := memoryMigrationLock(t)
+	ctx := context.Background()
+
+	first, err := adapter.TryLockMigrations(ctx, "first", 20*time.Millisecond)
+	if err != nil || first == nil {
+		t.Fatalf("TryLockMigrations(first) = %v, %v", first, err)
+	}
+	if second, err := adapter.TryLockMigrations(ctx, "second", time.Minute); err != nil || second != nil {
+		t.Fatalf("TryLockMigrations(second) = %v, %v; first holds the lock", second, err)
+	}
+	if err := first.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	second, err := adapter.TryLockMigrations(ctx, "second", time.Minute)
+	if err != nil || second == nil {
+		t.Fatalf("TryLockMigrations(second) = %v, %v; first's lease has expired", second, err)
+	}
+	if err := first.Refresh(ctx); err == nil {
+		t.Fatal("first refreshed a lock second took over")
+	}
+	if err := first.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if third, _ := adapter.TryLockMigrations(ctx, "third", time.Minute); third != nil {
+		t.Fatal("first's Release freed second's lock")
+	}
+	if err := second.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if third, err := adapter.TryLockMigrations(ctx, "third", time.Minute); err != nil || third == nil {
+		t.Fatalf("TryLockMigrations(third) = %v, %v after second released", third, err)
+	}
+}
+
+func TestMigrationRunsWaitForTheLockAndTimeOut(t *testing.T) {
+	adapter := memoryMigrationLock(t)
+	holder, err := adapter.TryLockMigrations(context.Background(), "holder", time.Minute)
+	if err != nil || holder == nil {
+		t.Fatal("could not take the lock", err)
+	}
+
+	m := NewDatabaseMigration(adapter, MigrationOptions{LockTimeout: 30 * time.Millisecond})
+	ran := false
+	if err := m.withLock(func(*DatabaseMigration) error { ran = true; return nil }); !errors.Is(err, ErrMigrationLockTimeout) || ran {
+		t.Fatalf("withLock = %v, ran = %v; want a timeout without running", err, ran)
+	}
+
+	time.AfterFunc(20*time.Millisecond, func() { _ = holder.Release(context.Background()) })
+	m = NewDatabaseMigration(adapter, MigrationOptions{LockTimeout: time.Second})
+	if err := m.withLock(func(*DatabaseMigration) error { ran = true; return nil }); err != nil || !ran {
+		t.Fatalf("withLock = %v, ran = %v; want it run once the holder released", err, ran)
+	}
+}
+
+func TestConcurrentMigrationRunsAreSerialized(t *testing.T) {
+	adapter := memoryMigrationLock(t)
+
+	// Each run reads the latest migration and records the next one, as
+	// migrateUp does; unserialized, the runs would record the same id.
+	const runs = 5
+	var wg sync.WaitGroup
+	errs := make(chan error, runs)
+	for i := range runs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := NewDatabaseMigration(adapter, MigrationOptions{LockOwner: fmt.Sprintf("run-%d", i), LockLease: 30 * time.Millisecond})
+			errs <- m.withLock(func(*DatabaseMigration) error {
+				latest, err := adapter.GetLatestMigration()
+				if err != nil {
+					return err
+				}
+				// Outlast the lease, so the heartbeat must keep the lock.
+				time.Sleep(40 * time.Millisecond)
+				return adapter.UpdateMigrationTable(latest+1, fmt.Sprintf("%d__m.yaml", latest+1), "m")
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if latest, err := adapter.GetLatestMigration(); err != nil || latest != runs {
+		t.Fatalf("latest migration = %d, %v; want %d", latest, err, runs)
+	}
+}
+
+func TestALostMigrationLockStopsTheRun(t *testing.T) {
+	m, entries := migrationOnMemory(t, widgetMigrations)
+	adapter := memoryMigrationLock(t)
+	m.opts.LockLease = 15 * time.Millisecond
+
+	err := m.withLock(func(m *DatabaseMigration) error {
+		// Another process took the lock over: the next refresh fails.
+		if err := adapter.Execute("DELETE FROM migration_lock"); err != nil {
+			return err
+		}
+		time.Sleep(30 * time.Millisecond)
+		return m.migrateUp(loaded(t, m, entries), 3)
+	})
+	if !errors.Is(err, ErrMigrationLockLost) {
+		t.Fatalf("withLock = %v; want ErrMigrationLockLost", err)
+	}
+	if columns := widgetColumns(t); len(columns) != 0 {
+		t.Fatalf("columns = %v; want no migration started once the lock was lost", columns)
+	}
+}
+
+// hangingLocker grants the migration lock, then never answers a refresh
+// until its context is done.
+type hangingLocker struct {
+	*MemoryAdapter
+}
+
+func (hangingLocker) TryLockMigrations(ctx context.Context, owner string, lease time.Duration) (MigrationLock, error) {
+	return funcMigrationLock{
+		refresh: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+		release: func(ctx context.Context) error { return nil },
+	}, nil
+}
+
+func TestAHungLockRefreshStopsTheRun(t *testing.T) {
+	m, entries := migrationOnMemory(t, widgetMigrations)
+	m.storage = hangingLocker{m.storage.(*MemoryAdapter)}
+	m.opts.LockLease = 15 * time.Millisecond
+
+	finished := make(chan error, 1)
+	go func() {
+		finished <- m.withLock(func(m *DatabaseMigration) error {
+			time.Sleep(50 * time.Millisecond)
+			return m.migrateUp(loaded(t, m, entries), 3)
+		})
+	}()
+	select {
+	case err := <-finished:
+		if !errors.Is(err, ErrMigrationLockLost) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("withLock = %v; want ErrMigrationLockLost after the refresh timed out", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("withLock did not return while a lock refresh hung")
+	}
+	if columns := widgetColumns(t); len(columns) != 0 {
+		t.Fatalf("columns = %v; want no migration started once the lock was lost", columns)
+	}
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// MigrationPlan is what a run would apply: the pending migrations in order,
+// each with the statements it executes.
+type MigrationPlan struct {
+	Provider   StorageProviders   `json:"provider"`
+	Migrations []PlannedMigration `json:"migrations"`
+}
+
+// PlannedMigration is a pending migration in a MigrationPlan.
+type PlannedMigration struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Checksum    string `json:"checksum"`
+	OutOfOrder  bool   `json:"out_of_order"`
+	// Go reports a registered Go migration, which has no statements to show
+	// and is skipped by Validate.
+	Go         bool     `json:"go"`
+	Statements []string `json:"statements"`
+}
+
+// PlanFormat is how MigrationPlan.Render writes a plan.
+type PlanFormat string
+
+const (
+	// PlanSQL writes the statements as a SQL script, with a comment before
+	// each migration.
+	PlanSQL PlanFormat = "sql"
+	// PlanJSON writes the plan as indented JSON.
+	PlanJSON PlanFormat = "json"
+)
+
+// MigrationDryRunner is implemented by adapters that can execute a plan's
+// statements in a transaction and roll it back, which checks them against
+// the live schema without changing it. Only providers with transactional DDL
+// can: the SQL adapter on PostgreSQL and SQLite, and the memory adapter.
+type MigrationDryRunner interface {
+	DryRunMigrations(ctx context.Context, plan MigrationPlan) error
+}
+
+// errDryRunRollback makes a dry run's transaction roll back.
+var errDryRunRollback = errors.New("dry run")
+
+// Plan returns the migrations Migrate would apply, without executing anything
+// or taking the migration lock. It fails as Migrate would on edited or
+// out-of-order migrations. Like Status, it works before the first run.
+func (m *DatabaseMigration) Plan() (MigrationPlan, error) {
+	n, err := m.namespaced()
+	if err != nil {
+		return MigrationPlan{}, err
+	}
+	entries, err := n.loadMigrations()
+	if err != nil {
+		return MigrationPlan{}, err
+	}
+	state, err := n.load(entries)
+	if err != nil {
+		return MigrationPlan{}, err
+	}
+	if err := n.verify(state); err != nil {
+		return MigrationPlan{}, err
+	}
+	return n.plan(state)
+}
+
+func (m *DatabaseMigration) plan(s *migrationState) (MigrationPlan, error) {
+	apply, err := m.pending(s, math.MaxInt)
+	if err != nil {
+		return MigrationPlan{}, err
+	}
+	plan := MigrationPlan{Provider: m.storageProvider, Migrations: []PlannedMigration{}}
+	for _, e := range apply {
+		planned := PlannedMigration{
+			ID:          e.id,
+			Name:        e.name,
+			Description: e.file.Description,
+			Checksum:    e.checksum(),
+			OutOfOrder:  e.id < s.latest,
+			Go:          e.code != nil,
+			Statements:  []string{},
+		}
+		for _, stmt := range e.file.Migrations {
+			planned.Statements = append(planned.Statements, stmt.Migrate)
+		}
+		plan.Migrations = append(plan.Migrations, planned)
+	}
+	return plan, nil
+}
+
+// Validate executes the plan's statements against the store in a transaction
+// and rolls it back. It returns ErrNotSupported for adapters that are not
+// MigrationDryRunners and for providers whose DDL is not transactional.
+func (m *DatabaseMigration) Validate(ctx context.Context, plan MigrationPlan) error {
+	runner, ok := unwrapAs[MigrationDryRunner](m.storage)
+	if !ok {
+		return fmt.Errorf("%s storage adapter cannot dry-run migrations: %w", m.storageType, ErrNotSupported)
+	}
+	return runner.DryRunMigrations(ctx, plan)
+}
+
+// Render writes the plan in format.
+func (p MigrationPlan) Render(w io.Writer, format PlanFormat) error {
+	switch format {
+	case PlanJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(p)
+	case PlanSQL:
+		var b strings.Builder
+		fmt.Fprintf(&b, "-- %d pending migrations for %s\n", len(p.Migrations), p.Provider)
+		for _, planned := range p.Migrations {
+			fmt.Fprintf(&b, "\n-- %s", planned.Name)
+			if planned.OutOfOrder {
+				b.WriteString(" (out of order)")
+			}
+			if planned.Description != "" {
+				fmt.Fprintf(&b, ": %s", planned.Description)
+			}
+			b.WriteString("\n")
+			if planned.Go {
+				b.WriteString("-- runs Go code\n")
+			}
+			for _, stmt := range planned.Statements {
+				stmt = strings.TrimSpace(stmt)
+				b.WriteString(stmt)
+				if !strings.HasSuffix(stmt, ";") {
+					b.WriteString(";")
+				}
+				b.WriteString("\n")
+			}
+		}
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	return fmt.Errorf("unknown migration plan format %q", format)
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPlanListsThePendingStatementsInOrder(t *testing.T) {
+	m, entries := migrationOnMemory(t, widgetMigrations)
+	if err := m.migrateTo(loaded(t, m, entries), 1); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := m.plan(loaded(t, m, entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Migrations) != 2 || plan.Migrations[0].Name != "2__add_name.yaml" || plan.Provider != SQLITE {
+		t.Fatalf("plan = %+v; want migrations 2 and 3", plan)
+	}
+
+	var script bytes.Buffer
+	if err := plan.Render(&script, PlanSQL); err != nil {
+		t.Fatal(err)
+	}
+	want := `-- 2 pending migrations for sqlite
+
+-- 2__add_name.yaml: add name
+ALTER TABLE mig_widgets ADD COLUMN name TEXT;
+
+-- 3__add_size.yaml: add size
+ALTER TABLE mig_widgets ADD COLUMN size INTEGER;
+`
+	if script.String() != want {
+		t.Fatalf("SQL plan:\n%s\nwant:\n%s", script.String(), want)
+	}
+
+	var doc bytes.Buffer
+	if err := plan.Render(&doc, PlanJSON); err != nil {
+		t.Fatal(err)
+	}
+	var decoded MigrationPlan
+	if err := json.Unmarshal(doc.Bytes(), &decoded); err != nil || !reflect.DeepEqual(decoded, plan) {
+		t.Fatalf("JSON plan decoded to %+v, %v", decoded, err)
+	}
+	if err := plan.Render(&doc, "yaml"); err == nil {
+		t.Fatal("rendered an unknown format")
+	}
+	if got := widgetColumns(t); !slices.Equal(got, []string{"id"}) {
+		t.Fatalf("columns = %v; planning must not execute anything", got)
+	}
+}
+
+func TestStatusAndPlanBeforeTheMigrationsTableExists(t *testing.T) {
+	adapter := GetMemoryAdapterInstance()
+	m := NewDatabaseMigration(adapter, MigrationOptions{Source: MultiMigrationSource{
+		NamespacedMigrationSource("unmigrated", MapMigrationSource(widgetMigrations)),
+		NamespacedMigrationSource("legacy", MapMigrationSource(widgetMigrations)),
+	}})
+
+	// No run has created the table of this namespace.
+	fresh := m.Namespace("unmigrated")
+	status, err := fresh.Status()
+	if err != nil || len(status.Applied) != 0 || len(status.Pending) != 3 {
+		t.Fatalf("Status = %+v, %v; want every migration pending", status, err)
+	}
+	plan, err := fresh.Plan()
+	if err != nil || len(plan.Migrations) != 3 {
+		t.Fatalf("Plan = %+v, %v; want every migration", plan, err)
+	}
+	var tables int64
+	if err := adapter.DB.DB.Raw("SELECT COUNT(*) FROM sqlite_master WHERE name = 'migrations_unmigrated'").Scan(&tables).Error; err != nil || tables != 0 {
+		t.Fatalf("tables = %d, %v; Status and Plan must not create the migrations table", tables, err)
+	}
+
+	// A table from before migrations were checksummed.
+	t.Cleanup(func() {
+		if err := adapter.Execute("DROP TABLE IF EXISTS migrations_legacy"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for _, stmt := range []string{
+		"CREATE TABLE migrations_legacy (id INTEGER PRIMARY KEY, name TEXT, description TEXT, timestamp INTEGER)",
+		"INSERT INTO migrations_legacy VALUES (1, '1__create_widgets.yaml', 'create widgets', 0)",
+	} {
+		if err := adapter.Execute(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	legacy := m.Namespace("legacy")
+	status, err = legacy.Status()
+	if err != nil || len(status.Applied) != 1 || status.Applied[0].Checksum != "" || len(status.Modified) != 0 || len(status.Pending) != 2 {
+		t.Fatalf("Status = %+v, %v; want 1 applied without a checksum", status, err)
+	}
+	if plan, err := legacy.Plan(); err != nil || len(plan.Migrations) != 2 {
+		t.Fatalf("Plan = %+v, %v; want migrations 2 and 3", plan, err)
+	}
+}
+
+func TestValidateRollsTheDryRunBack(t *testing.T) {
+	m, entries := migrationOnMemory(t, widgetMigrations)
+	plan, err := m.plan(loaded(t, m, entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(context.Background(), plan); err != nil {
+		t.Fatalf("Validate = %v", err)
+	}
+	if got := widgetColumns(t); len(got) != 0 {
+		t.Fatalf("mig_widgets has columns %v after a dry run", got)
+	}
+
+	plan.Migrations[2].Statements = []string{"ALTER TABLE mig_widgets ADD COLUMN name TEXT"}
+	if err := m.Validate(context.Background(), plan); err == nil || !strings.Contains(err.Error(), "3__add_size.yaml statement 1") {
+		t.Fatalf("Validate = %v; want the duplicate column reported", err)
+	}
+	if latest := latestMigration(t, m); latest != 0 {
+		t.Fatalf("latest migration = %d after a dry run", latest)
+	}
+}
+
+func TestDryRunNeedsTransactionalDDL(t *testing.T) {
+	adapter, _ := newDryRunSQLServerAdapter(t)
+	if err := adapter.DryRunMigrations(context.Background(), MigrationPlan{}); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("DryRunMigrations on SQL Server = %v; want ErrNotSupported", err)
+	}
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MigrationSource supplies migration files. A DatabaseMigration reads them
+// from MigrationOptions.Source, or from storage.ConfigFs when it is unset.
+type MigrationSource interface {
+	// MigrationFiles returns the migration files for a provider by file
+	// name, or none when the source has no migrations for it.
+	MigrationFiles(provider string) (map[string]MigrationFile, error)
+}
+
+// FSMigrationSource reads the YAML files of the common directory under Dir,
+// which every provider shares, and of the directory named after the provider,
+// such as config/migrations/postgresql, from an embed.FS, an os.DirFS or any
+// other fs.FS. A provider's file replaces the common file of the same name.
+type FSMigrationSource struct {
+	FS  fs.FS
+	Dir string
+}
+
+// commonMigrationsDir is the directory of the migrations every provider runs.
+const commonMigrationsDir = "common"
+
+func (s FSMigrationSource) MigrationFiles(provider string) (map[string]MigrationFile, error) {
+	migrations := map[string]MigrationFile{}
+	for _, dir := range []string{commonMigrationsDir, provider} {
+		if err := s.readDir(path.Join(s.Dir, dir), migrations); err != nil {
+			return nil, err
+		}
+	}
+	return migrations, nil
+}
+
+// readDir adds the files of dir to migrations.
+func (s FSMigrationSource) readDir(dir string, migrations map[string]MigrationFile) error {
+	files, err := fs.ReadDir(s.FS, dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read migrations directory %s: %w", dir, err)
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		contents, err := fs.ReadFile(s.FS, path.Join(dir, f.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read migration file %s: %v", f.Name(), err)
+		}
+		mf := MigrationFile{}
+		if err := yaml.Unmarshal(contents, &mf); err != nil {
+			return fmt.Errorf("failed to parse migration file %s: %v", f.Name(), err)
+		}
+		migrations[f.Name()] = mf
+	}
+	return nil
+}
+
+// MapMigrationSource is an in-memory source, typically for tests. It returns
+// the same files whatever the provider.
+type MapMigrationSource map[string]MigrationFile
+
+func (s MapMigrationSource) MigrationFiles(provider string) (map[string]MigrationFile, error) {
+	migrations := make(map[string]MigrationFile, len(s))
+	for name, file := range s {
+		migrations[name] = file
+	}
+	return migrations, nil
+}
+
+// MultiMigrationSource combines sources, so that an application can compose
+// its migrations with those its modules ship. The files of sources in the same
+// namespace must have different names and ids.
+type MultiMigrationSource []MigrationSource
+
+func (s MultiMigrationSource) MigrationFiles(provider string) (map[string]MigrationFile, error) {
+	migrations := map[string]MigrationFile{}
+	for _, source := range s {
+		files, err := source.MigrationFiles(provider)
+		if err != nil {
+			return nil, err
+		}
+		for name, file := range files {
+			if _, dup := migrations[name]; dup {
+				return nil, fmt.Errorf("migration file %s is in more than one source", name)
+			}
+			migrations[name] = file
+		}
+	}
+	return migrations, nil
+}
+
+// namespacedSource is a source whose migrations are recorded apart from the
+// application's.
+type namespacedSource struct {
+	namespace string
+	MigrationSource
+}
+
+// NamespacedMigrationSource returns a source whose migrations are recorded in a
+// migrations table of their own, migrations_<namespace>, so that a library can
+// number its migrations without regard to the application's. Namespaces are
+// lowercase letters, digits and underscores. Adapters record namespaced
+// migrations when they implement MigrationNamespacer.
+func NamespacedMigrationSource(namespace string, source MigrationSource) MigrationSource {
+	return namespacedSource{namespace: namespace, MigrationSource: source}
+}
+
+// MigrationNamespacer is implemented by adapters that can record a namespace's
+// migrations in a migrations table of its own. The SQL, memory, Bolt, Redis,
+// Cassandra, DynamoDB and Cosmos DB adapters implement it.
+type MigrationNamespacer interface {
+	// MigrationNamespace returns a copy of the adapter whose migration
+	// bookkeeping uses the namespace's migrations table.
+	MigrationNamespace(namespace string) StorageAdapter
+}
+
+var migrationNamespacePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// namespacedMigrationTable is the name of a namespace's migrations table:
+// table itself for the application's migrations, table_<namespace> otherwise.
+func namespacedMigrationTable(table string, namespace string) string {
+	if namespace == "" {
+		return table
+	}
+	return table + "_" + namespace
+}
+
+// splitSources returns the namespaces of source, in the order they first
+// appear, and the sources of each. The application's namespace, "", is always
+// included.
+func splitSources(source MigrationSource) ([]string, map[string][]MigrationSource) {
+	var order []string
+	sources := map[string][]MigrationSource{}
+	var walk func(source MigrationSource, namespace string)
+	walk = func(source MigrationSource, namespace string) {
+		switch s := source.(type) {
+		case MultiMigrationSource:
+			for _, inner := range s {
+				walk(inner, namespace)
+			}
+			return
+		case namespacedSource:
+			walk(s.MigrationSource, s.namespace)
+			return
+		}
+		if !slices.Contains(order, namespace) {
+			order = append(order, namespace)
+		}
+		sources[namespace] = append(sources[namespace], source)
+	}
+	walk(source, "")
+	if !slices.Contains(order, "") {
+		order = append(order, "")
+	}
+	return order, sources
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFSMigrationSourceReadsTheProvidersDirectory(t *testing.T) {
+	source := FSMigrationSource{Dir: "migrations", FS: fstest.MapFS{
+		"migrations/sqlite/1__create.yaml": {Data: []byte("description: create\nmigrations:\n  - migrate: CREATE TABLE t (id TEXT)\n    rollback: DROP TABLE t\n")},
+		"migrations/sqlite/nested/x.yaml":  {Data: []byte("description: ignored")},
+		"migrations/postgresql/1__a.yaml":  {Data: []byte("description: other provider")},
+	}}
+
+	files, err := source.MigrationFiles(string(SQLITE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files["1__create.yaml"].Migrations[0].Migrate != "CREATE TABLE t (id TEXT)" {
+		t.Fatalf("files = %+v; want the sqlite file only", files)
+	}
+	if files, err := source.MigrationFiles(string(MYSQL)); err != nil || len(files) != 0 {
+		t.Fatalf("MigrationFiles(mysql) = %v, %v; want none", files, err)
+	}
+}
+
+func TestMultiMigrationSourceRejectsTheSameFileTwice(t *testing.T) {
+	file := widgetMigrations["1__create_widgets.yaml"]
+	source := MultiMigrationSource{
+		MapMigrationSource{"1__create_widgets.yaml": file},
+		MapMigrationSource{"1__create_widgets.yaml": file},
+	}
+	if _, err := source.MigrationFiles(string(SQLITE)); err == nil {
+		t.Fatal("MigrationFiles merged two files with the same name")
+	}
+}
+
+func TestNamespacedMigrationsAreRecordedApart(t *testing.T) {
+	m, _ := migrationOnMemory(t, nil)
+	adapter := GetMemoryAdapterInstance()
+	t.Cleanup(func() {
+		for _, stmt := range []string{"DROP TABLE IF EXISTS mig_gadgets", "DROP TABLE IF EXISTS migrations_gadgets"} {
+			if err := adapter.Execute(stmt); err != nil {
+				t.Fatalf("%s: %v", stmt, err)
+			}
+		}
+	})
+	// Both sets number their first migration 1.
+	library := MapMigrationSource{"1__create_gadgets.yaml": {Description: "create gadgets", Migrations: []Migration{
+		{Migrate: "CREATE TABLE mig_gadgets (id TEXT PRIMARY KEY)", Rollback: "DROP TABLE mig_gadgets"},
+	}}}
+	m.opts.Source = MultiMigrationSource{
+		NamespacedMigrationSource("gadgets", library),
+		MapMigrationSource{"1__create_widgets.yaml": widgetMigrations["1__create_widgets.yaml"]},
+	}
+
+	m.Migrate()
+
+	app, err := m.Status()
+	if err != nil || len(app.Applied) != 1 || app.Applied[0].Name != "1__create_widgets.yaml" {
+		t.Fatalf("Status = %+v, %v; want the application's migration", app.Applied, err)
+	}
+	lib, err := m.Namespace("gadgets").Status()
+	if err != nil || len(lib.Applied) != 1 || lib.Applied[0].Name != "1__create_gadgets.yaml" || len(lib.Pending) != 0 {
+		t.Fatalf("Status(gadgets) = %+v, %v; want the library's migration", lib, err)
+	}
+
+	if err := m.Namespace("gadgets").Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.Execute("SELECT 1 FROM mig_gadgets"); err == nil {
+		t.Fatal("mig_gadgets survived rolling the library back")
+	}
+	if n := widgetCount(t); n != 0 {
+		t.Fatalf("mig_widgets has %d rows", n)
+	}
+	if latest := latestMigration(t, m); latest != 1 {
+		t.Fatalf("latest application migration = %d; want it untouched", latest)
+	}
+}
+
+func TestUpReturnsTheFailureAndSkipsLaterNamespaces(t *testing.T) {
+	m, _ := migrationOnMemory(t, nil)
+	t.Cleanup(func() {
+		if err := GetMemoryAdapterInstance().Execute("DROP TABLE IF EXISTS migrations_gadgets"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	broken := MapMigrationSource{"1__broken.yaml": {Description: "broken", Migrations: []Migration{
+		{Migrate: "CREATE TABLE mig_gadgets (id TEXT PRIMARY KEY)", Rollback: "DROP TABLE mig_gadgets"},
+		{Migrate: "NOT SQL"},
+	}}}
+	m.opts.Source = MultiMigrationSource{
+		NamespacedMigrationSource("gadgets", broken),
+		MapMigrationSource{"1__create_widgets.yaml": widgetMigrations["1__create_widgets.yaml"]},
+	}
+
+	var failure *MigrationError
+	if err := m.Up(); !errors.As(err, &failure) || failure.Name != "1__broken.yaml" || !failure.RolledBack {
+		t.Fatalf("Up = %v; want the rolled back migration", err)
+	}
+	if columns := widgetColumns(t); len(columns) != 0 {
+		t.Fatalf("columns = %v; want the application's migrations skipped", columns)
+	}
+
+	m.opts.Source = NamespacedMigrationSource("drop table;", broken)
+	if err := m.Up(); err == nil {
+		t.Fatal("Up accepted a namespace that is not an identifier")
+	}
+}
+
+func TestMigrationNamespacesMustBeIdentifiers(t *testing.T) {
+	m := NewDatabaseMigration(GetMemoryAdapterInstance())
+	if _, err := m.Namespace("drop table;").Status(); err == nil {
+		t.Fatal("Status accepted a namespace that is not an identifier")
+	}
+	legacy := NewDatabaseMigration(&legacyAdapter{}).Namespace("gadgets")
+	if _, err := legacy.Status(); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("Status = %v; want ErrNotSupported on an adapter that cannot namespace", err)
+	}
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// MigrationTemplateData is what the statements of a migration file with
+// template: true are rendered with, as in
+//
+//	CREATE TABLE {{.Schema}}.widgets (id TEXT)
+type MigrationTemplateData struct {
+	// Schema is the adapter's schema name, "" on providers without schemas.
+	Schema string
+	// Provider is the provider the migration runs on, such as postgresql,
+	// or the adapter's name when it has no providers.
+	Provider string
+	// Vars are MigrationOptions.TemplateVars.
+	Vars map[string]any
+}
+
+// resolve returns a migration file as it runs on m's provider: with the
+// statements of its providers block for the provider, when it has one, and
+// rendered when it is a template.
+func (m *DatabaseMigration) resolve(name string, file MigrationFile) (MigrationFile, error) {
+	if statements, ok := file.Providers[m.provider()]; ok {
+		file.Migrations = statements
+	}
+	file.Providers = nil
+	if !file.Template {
+		return file, nil
+	}
+	data := MigrationTemplateData{Schema: m.storage.GetSchemaName(), Provider: m.provider(), Vars: m.opts.TemplateVars}
+	rendered := make([]Migration, len(file.Migrations))
+	for i, migration := range file.Migrations {
+		var err error
+		if rendered[i].Migrate, err = renderStatement(name, migration.Migrate, data); err != nil {
+			return MigrationFile{}, err
+		}
+		if rendered[i].Rollback, err = renderStatement(name, migration.Rollback, data); err != nil {
+			return MigrationFile{}, err
+		}
+	}
+	file.Migrations = rendered
+	return file, nil
+}
+
+// renderStatement renders a statement of migration file name, failing on
+// variables that data lacks.
+func renderStatement(name string, statement string, data MigrationTemplateData) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(statement)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template of migration %s: %w", name, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render migration %s: %w", name, err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestTemplatedMigrationsRenderSchemaProviderAndVars(t *testing.T) {
+	m := NewDatabaseMigration(GetMemoryAdapterInstance(), MigrationOptions{
+		TemplateVars: map[string]any{"Table": "widgets"},
+		Source: MapMigrationSource{"1__create.yaml": {Template: true, Migrations: []Migration{{
+			Migrate:  "CREATE TABLE {{if .Schema}}{{.Schema}}.{{end}}{{.Vars.Table}} (id TEXT) -- {{.Provider}}",
+			Rollback: "DROP TABLE {{.Vars.Table}}",
+		}}}},
+	})
+
+	entries, err := m.loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := entries[0].file.Migrations[0]
+	if got.Migrate != "CREATE TABLE widgets (id TEXT) -- sqlite" || got.Rollback != "DROP TABLE widgets" {
+		t.Fatalf("rendered = %+v", got)
+	}
+	// The checksum is of what runs, so changing a variable is drift.
+	plain := MigrationFile{Migrations: []Migration{got}}
+	if entries[0].checksum() != plain.Checksum() {
+		t.Fatal("checksum is not of the rendered statements")
+	}
+
+	m.opts.TemplateVars = nil
+	if _, err := m.loadMigrations(); err == nil || !strings.Contains(err.Error(), "1__create.yaml") {
+		t.Fatalf("loadMigrations = %v; want the missing variable reported", err)
+	}
+}
+
+func TestUntemplatedMigrationsKeepBraces(t *testing.T) {
+	m := NewDatabaseMigration(GetMemoryAdapterInstance(), MigrationOptions{
+		Source: MapMigrationSource{"1__seed.yaml": {Migrations: []Migration{{Migrate: "SELECT '{{1,2}}'"}}}},
+	})
+	entries, err := m.loadMigrations()
+	if err != nil || entries[0].file.Migrations[0].Migrate != "SELECT '{{1,2}}'" {
+		t.Fatalf("loadMigrations = %+v, %v; want the statement as written", entries, err)
+	}
+}
+
+func TestCommonMigrationsUseTheProvidersBlockAndFiles(t *testing.T) {
+	source := FSMigrationSource{Dir: "migrations", FS: fstest.MapFS{
+		"migrations/common/1__create.yaml": {Data: []byte(`
+description: create
+migrations:
+  - migrate: CREATE TABLE things (id TEXT)
+providers:
+  sqlite:
+    - migrate: CREATE TABLE things (id TEXT) STRICT
+`)},
+		"migrations/common/2__index.yaml": {Data: []byte("migrations:\n  - migrate: CREATE INDEX common_index ON things (id)\n")},
+		"migrations/sqlite/2__index.yaml": {Data: []byte("migrations:\n  - migrate: CREATE INDEX sqlite_index ON things (id)\n")},
+	}}
+
+	for provider, want := range map[StorageProviders][]string{
+		SQLITE:     {"CREATE TABLE things (id TEXT) STRICT", "CREATE INDEX sqlite_index ON things (id)"},
+		POSTGRESQL: {"CREATE TABLE things (id TEXT)", "CREATE INDEX common_index ON things (id)"},
+	} {
+		m := NewDatabaseMigration(GetMemoryAdapterInstance(), MigrationOptions{Source: source})
+		m.storageProvider = provider
+		entries, err := m.loadMigrations()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 || entries[0].file.Migrations[0].Migrate != want[0] || entries[1].file.Migrations[0].Migrate != want[1] {
+			t.Fatalf("%s: entries = %+v; want %q", provider, entries, want)
+		}
+	}
+}
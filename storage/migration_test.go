@@ -1,8 +1,15 @@
 package storage_test
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
 	"github.com/tink3rlabs/magic/storage"
 )
 
@@ -32,32 +39,92 @@ func TestNewDatabaseMigrationCapturesStorageMetadata(t *testing.T) {
 	}
 }
 
-func TestMigrateShortCircuitsForDynamoDB(t *testing.T) {
-	// Any Execute/CreateSchema/... call would panic because the
-	// embedded StorageAdapter is nil. Reaching the end of this
-	// test without panic proves Migrate took the DYNAMODB
-	// short-circuit branch and only logged.
-	adapter := &stubAdapter{typ: storage.DYNAMODB}
-	m := storage.NewDatabaseMigration(adapter)
+// trackingAdapter is a stubAdapter that records the migration
+// bookkeeping calls a run makes.
+type trackingAdapter struct {
+	stubAdapter
+	calls []string
+}
+
+func (s *trackingAdapter) CreateSchema() error {
+	s.calls = append(s.calls, "CreateSchema")
+	return nil
+}
+
+func (s *trackingAdapter) CreateMigrationTable() error {
+	s.calls = append(s.calls, "CreateMigrationTable")
+	return nil
+}
+
+func (s *trackingAdapter) GetLatestMigration() (int, error) {
+	s.calls = append(s.calls, "GetLatestMigration")
+	return 0, nil
+}
+
+func (s *trackingAdapter) Execute(statement string) error {
+	s.calls = append(s.calls, "Execute")
+	return nil
+}
+
+func (s *trackingAdapter) UpdateMigrationTable(id int, name string, desc string) error {
+	s.calls = append(s.calls, "UpdateMigrationTable")
+	return nil
+}
+
+func TestMigrateRunsForDynamoDB(t *testing.T) {
+	// DynamoDB used to skip migrations altogether; it now
+	// creates and reads its migrations table like any other
+	// adapter, once it has migrations to run.
+	adapter := &trackingAdapter{stubAdapter: stubAdapter{typ: storage.DYNAMODB}}
+	m := storage.NewDatabaseMigration(adapter, storage.MigrationOptions{Source: storage.MapMigrationSource{
+		"1__create.yaml": {Description: "create", Migrations: []storage.Migration{{Migrate: "CREATE", Rollback: "DROP"}}},
+	}})
 
 	m.Migrate()
+
+	want := []string{"CreateSchema", "CreateMigrationTable", "GetLatestMigration", "Execute", "UpdateMigrationTable"}
+	if !slices.Equal(adapter.calls, want) {
+		t.Fatalf("calls = %v; want %v", adapter.calls, want)
+	}
+}
+
+func TestMigrateWithoutMigrationsLeavesDynamoDBAlone(t *testing.T) {
+	// An application without migrations must not need the
+	// permission to create tables, nor have a table of its own
+	// named migrations read as history.
+	var operations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operations = append(operations, r.Header.Get("X-Amz-Target"))
+		http.Error(w, "unexpected operation", http.StatusBadRequest)
+	}))
+	defer server.Close()
+	adapter := &storage.DynamoDBAdapter{DB: dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	})}
+	m := storage.NewDatabaseMigration(adapter, storage.MigrationOptions{Source: storage.MapMigrationSource{}})
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if len(operations) != 0 {
+		t.Fatalf("operations = %v; want no CreateTable, PutItem or any other call", operations)
+	}
 }
 
 func TestMigrateHappyPathOnSQLiteWithNoConfiguredMigrations(t *testing.T) {
 	// The memory adapter's underlying sqlite is always
 	// available in this test process. ConfigFs is the
-	// package-level zero-value embed.FS, so getMigrationFiles
-	// returns an empty map, which drives runMigrations through
-	// its "no work to do" branch without any logger.Fatal.
+	// package-level zero-value embed.FS, so there are no
+	// migration files, which drives Up through its "no work
+	// to do" branch without any logger.Fatal.
 	adapter := storage.GetMemoryAdapterInstance()
 	m := storage.NewDatabaseMigration(adapter)
 
 	m.Migrate()
 
-	// Side-effect: the migrations table must now exist.
-	// Querying it confirms that CreateSchema and
-	// CreateMigrationTable both ran cleanly.
-	if _, err := adapter.GetLatestMigration(); err != nil {
-		t.Fatalf("GetLatestMigration after Migrate: %v", err)
+	if err := m.Up(); err != nil {
+		t.Fatalf("Up without migrations: %v", err)
 	}
 }
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// migrationGroup is a run of migrations executed together: in one
+// transaction, or alone without one when transaction is nil.
+type migrationGroup struct {
+	entries     []migrationEntry
+	transaction MigrationTransactor
+}
+
+// transactional reports whether a migration may run in a transaction with
+// others. Files can opt out; Go migrations opt in.
+func (e migrationEntry) transactional() bool {
+	if e.code != nil {
+		return e.code.Transactional
+	}
+	return !e.file.NoTransaction
+}
+
+// group splits migrations into the transactions they run in. Without
+// transactional DDL, or with TransactionNone, every migration runs alone
+// without a transaction.
+func (m *DatabaseMigration) group(entries []migrationEntry) []migrationGroup {
+	transactor, ok := unwrapAs[MigrationTransactor](m.storage)
+	if !ok || !transactor.TransactionalDDL() || m.opts.Transactions == TransactionNone {
+		transactor = nil
+	}
+	var groups []migrationGroup
+	for _, e := range entries {
+		switch {
+		case transactor == nil || !e.transactional():
+			groups = append(groups, migrationGroup{entries: []migrationEntry{e}})
+		case m.opts.Transactions == TransactionBatch && len(groups) > 0 && groups[len(groups)-1].transaction != nil:
+			groups[len(groups)-1].entries = append(groups[len(groups)-1].entries, e)
+		default:
+			groups = append(groups, migrationGroup{entries: []migrationEntry{e}, transaction: transactor})
+		}
+	}
+	return groups
+}
+
+// applyInTransaction applies migrations and records them in one transaction.
+// When one fails the transaction rolls back, undoing the migrations before it
+// in the group as well.
+func (m *DatabaseMigration) applyInTransaction(transactor MigrationTransactor, entries []migrationEntry) error {
+	ctx := m.runContext()
+	var current migrationEntry
+	err := transactor.InMigrationTransaction(ctx, func(tx StorageAdapter) error {
+		for _, e := range entries {
+			current = e
+			slog.Info("applying migration in a transaction", slog.String("key", e.name))
+			if e.code != nil {
+				if err := e.code.Up(withCheckpoint(ctx, tx, e), tx); err != nil {
+					return err
+				}
+			} else {
+				for _, stmt := range e.file.Migrations {
+					if err := tx.Execute(stmt.Migrate); err != nil {
+						return err
+					}
+				}
+			}
+			if err := m.record(tx, e); err != nil {
+				return fmt.Errorf("failed to update migration table: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("migration failed, transaction rolled back", slog.String("key", current.name), slog.Any("error", err))
+		return &MigrationError{ID: current.id, Name: current.name, Err: err, RolledBack: true}
+	}
+	return nil
+}
+
+// undoInTransaction rolls migrations back, in the order given, and deletes
+// their rows in one transaction.
+func (m *DatabaseMigration) undoInTransaction(transactor MigrationTransactor, entries []migrationEntry) error {
+	ctx := m.runContext()
+	return transactor.InMigrationTransaction(ctx, func(tx StorageAdapter) error {
+		for _, e := range entries {
+			slog.Info("rolling back migration in a transaction", slog.String("key", e.name))
+			var err error
+			if e.code != nil {
+				err = e.code.Down(withCheckpoint(ctx, tx, e), tx)
+			} else {
+				err = rollbackOn(tx, e.file)
+			}
+			if err == nil {
+				err = m.deleteRow(e)(tx)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to roll back %s: %w", e.name, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"slices"
+	"testing"
+)
+
+// halfBroken adds a column and then fails, with a Rollback that leaves the
+// column in place, so only a transaction can undo it.
+var halfBroken = MigrationFile{Migrations: []Migration{
+	{Migrate: "ALTER TABLE mig_widgets ADD COLUMN name TEXT", Rollback: "SELECT 1"},
+	{Migrate: "ALTER TABLE no_such_table ADD COLUMN size INTEGER", Rollback: "SELECT 1"},
+}}
+
+func TestFailedFileIsUndoneByItsTransaction(t *testing.T) {
+	files := map[string]MigrationFile{
+		"1__create_widgets.yaml": widgetMigrations["1__create_widgets.yaml"],
+		"2__broken.yaml":         halfBroken,
+	}
+	m, entries := migrationOnMemory(t, files)
+	err := m.migrateUp(loaded(t, m, entries), math.MaxInt)
+	var failure *MigrationError
+	if !errors.As(err, &failure) || failure.ID != 2 || !failure.RolledBack {
+		t.Fatalf("migrateUp = %v; want migration 2 rolled back", err)
+	}
+	if got := widgetColumns(t); !slices.Equal(got, []string{"id"}) {
+		t.Fatalf("columns = %v; the transaction should have undone the first statement", got)
+	}
+	if latest := latestMigration(t, m); latest != 1 {
+		t.Fatalf("latest migration = %d; want 1", latest)
+	}
+
+	// Without transactions only the Rollback statements run.
+	m.opts.Transactions = TransactionNone
+	if err := m.migrateUp(loaded(t, m, entries), math.MaxInt); !errors.As(err, &failure) {
+		t.Fatalf("migrateUp = %v", err)
+	}
+	if got := widgetColumns(t); !slices.Equal(got, []string{"id", "name"}) {
+		t.Fatalf("columns = %v; want the column the Rollback statements left", got)
+	}
+}
+
+func TestBatchRollsBackTheWholeRun(t *testing.T) {
+	files := maps.Clone(widgetMigrations)
+	files["4__broken.yaml"] = MigrationFile{Migrations: []Migration{
+		{Migrate: "ALTER TABLE no_such_table ADD COLUMN size INTEGER", Rollback: "SELECT 1"},
+	}}
+	m, entries := migrationOnMemory(t, files)
+	m.opts.Transactions = TransactionBatch
+
+	err := m.migrateUp(loaded(t, m, entries), math.MaxInt)
+	var failure *MigrationError
+	if !errors.As(err, &failure) || failure.ID != 4 || !failure.RolledBack {
+		t.Fatalf("migrateUp = %v; want migration 4 reported", err)
+	}
+	if latest := latestMigration(t, m); latest != 0 {
+		t.Fatalf("latest migration = %d; the batch should have rolled back", latest)
+	}
+	if got := widgetColumns(t); len(got) != 0 {
+		t.Fatalf("mig_widgets exists with columns %v", got)
+	}
+
+	m.opts.Transactions = TransactionPerFile
+	if err := m.migrateUp(loaded(t, m, entries), math.MaxInt); !errors.As(err, &failure) || failure.ID != 4 {
+		t.Fatalf("migrateUp = %v", err)
+	}
+	if latest := latestMigration(t, m); latest != 3 {
+		t.Fatalf("latest migration = %d; the files before the failure are kept", latest)
+	}
+}
+
+func TestFilesOutsideTransactionsSplitTheBatch(t *testing.T) {
+	files := maps.Clone(widgetMigrations)
+	concurrently := files["2__add_name.yaml"]
+	concurrently.NoTransaction = true
+	files["2__add_name.yaml"] = concurrently
+	files["4__add_colour.yaml"] = MigrationFile{}
+	m, entries := migrationOnMemory(t, files)
+	m.opts.Transactions = TransactionBatch
+
+	var shape [][]int
+	for _, group := range m.group(entries) {
+		var ids []int
+		for _, e := range group.entries {
+			ids = append(ids, e.id)
+		}
+		if group.transaction == nil {
+			ids = append(ids, -1)
+		}
+		shape = append(shape, ids)
+	}
+	want := [][]int{{1}, {2, -1}, {3, 4}}
+	if !slices.EqualFunc(shape, want, slices.Equal) {
+		t.Fatalf("groups = %v; want %v, with -1 marking no transaction", shape, want)
+	}
+}
+
+func TestRollingBackInATransactionIsAllOrNothing(t *testing.T) {
+	files := map[string]MigrationFile{
+		"1__create_widgets.yaml": widgetMigrations["1__create_widgets.yaml"],
+		"2__index_name.yaml": {Migrations: []Migration{
+			{Migrate: "ALTER TABLE mig_widgets ADD COLUMN name TEXT", Rollback: "ALTER TABLE no_such_table DROP COLUMN name"},
+			{Migrate: "CREATE INDEX mig_widgets_name ON mig_widgets (name)", Rollback: "DROP INDEX mig_widgets_name"},
+		}},
+	}
+	m, entries := migrationOnMemory(t, files)
+	if err := m.migrateUp(loaded(t, m, entries), math.MaxInt); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.rollback(loaded(t, m, entries), 1); err == nil {
+		t.Fatal("rollback succeeded with a failing Rollback statement")
+	}
+	var indexes int
+	if err := GetMemoryAdapterInstance().DB.DB.Raw("SELECT count(*) FROM sqlite_master WHERE name = 'mig_widgets_name'").Scan(&indexes).Error; err != nil {
+		t.Fatal(err)
+	}
+	if indexes != 1 || latestMigration(t, m) != 2 {
+		t.Fatalf("index count = %d, latest = %d; the failed rollback should have changed nothing", indexes, latestMigration(t, m))
+	}
+}
@@ -0,0 +1,277 @@
+package storage_test
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/tink3rlabs/magic/storage"
+)
+
+type RedisSession struct {
+	Id        string    `json:"id"`
+	User      string    `json:"user"`
+	Plan      string    `json:"plan"`
+	Hits      int       `json:"hits"`
+	Active    bool      `json:"active"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Scopes    []string  `json:"scopes"`
+}
+
+func newRedisAdapter(t *testing.T, config map[string]string) (*storage.RedisAdapter, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	if config == nil {
+		config = map[string]string{}
+	}
+	return storage.NewRedisAdapter(client, config), server
+}
+
+// seedRedisSessions creates n sessions. Hits cycle through 0-2, so several
+// sessions share a score; plans alternate between free and pro; expiry dates
+// run backwards from the ids.
+func seedRedisSessions(t *testing.T, adapter *storage.RedisAdapter, n int) {
+	t.Helper()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= n; i++ {
+		plan := "free"
+		if i%2 == 0 {
+			plan = "pro"
+		}
+		session := RedisSession{
+			Id:        fmt.Sprintf("s%02d", i),
+			User:      fmt.Sprintf("user-%d", i),
+			Plan:      plan,
+			Hits:      i % 3,
+			Active:    i != 3,
+			ExpiresAt: start.Add(time.Duration(n-i) * time.Hour),
+			Scopes:    []string{"read"},
+		}
+		if err := adapter.Create(&session); err != nil {
+			t.Fatalf("Create(%s): %v", session.Id, err)
+		}
+	}
+}
+
+func redisIDs(sessions []RedisSession) []string {
+	var ids []string
+	for _, s := range sessions {
+		ids = append(ids, s.Id)
+	}
+	return ids
+}
+
+func TestRedisAdapterCRUD(t *testing.T) {
+	adapter, _ := newRedisAdapter(t, nil)
+	if err := adapter.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	session := RedisSession{Id: "a", User: "ada", Plan: "free", Hits: 1}
+	if err := adapter.Create(&session); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.Create(&session); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("duplicate Create error = %v, want already exists", err)
+	}
+
+	session.Plan = "pro"
+	if err := adapter.Update(&session, map[string]any{"id": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	var got RedisSession
+	if err := adapter.Get(&got, map[string]any{"plan": "pro"}); err != nil || got.Id != "a" {
+		t.Fatalf("Get by plan after Update = %+v, %v", got, err)
+	}
+	if err := adapter.Get(&got, map[string]any{"plan": "free"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Get by old plan error = %v, want ErrNotFound", err)
+	}
+
+	if err := adapter.Delete(&RedisSession{}, map[string]any{"id": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.Get(&got, map[string]any{"id": "a"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Get after Delete error = %v, want ErrNotFound", err)
+	}
+	if n, err := adapter.Count(&RedisSession{}, nil); err != nil || n != 0 {
+		t.Fatalf("Count after Delete = %d, %v", n, err)
+	}
+}
+
+func TestRedisAdapterListPaginates(t *testing.T) {
+	adapter, _ := newRedisAdapter(t, nil)
+	seedRedisSessions(t, adapter, 7)
+
+	desc := map[string]any{storage.SortDirectionKey: "DESC"}
+	tests := []struct {
+		name    string
+		sortKey string
+		filter  map[string]any
+		params  map[string]any
+		want    []string
+	}{
+		{"text key", "id", nil, nil, []string{"s01", "s02", "s03", "s04", "s05", "s06", "s07"}},
+		{"text key descending", "id", nil, desc, []string{"s07", "s06", "s05", "s04", "s03", "s02", "s01"}},
+		// Ties on the score are ordered by key, in both directions.
+		{"score with ties", "hits", nil, nil, []string{"s03", "s06", "s01", "s04", "s07", "s02", "s05"}},
+		{"score with ties descending", "hits", nil, desc, []string{"s05", "s02", "s07", "s04", "s01", "s06", "s03"}},
+		{"time", "expires_at", nil, nil, []string{"s07", "s06", "s05", "s04", "s03", "s02", "s01"}},
+		{"boolean", "active", nil, nil, []string{"s03", "s01", "s02", "s04", "s05", "s06", "s07"}},
+		{"filtered", "hits", map[string]any{"plan": "free"}, nil, []string{"s03", "s01", "s07", "s05"}},
+		{"filtered on two fields", "id", map[string]any{"plan": "free", "hits": 1}, nil, []string{"s01", "s07"}},
+		{"filter on a list of values", "id", map[string]any{"user": []string{"user-2", "user-6"}}, desc, []string{"s06", "s02"}},
+		{"filter on a time", "id", map[string]any{"expires_at": time.Date(2026, 1, 1, 5, 0, 0, 0, time.UTC)}, nil, []string{"s02"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var all []string
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > len(tt.want) {
+					t.Fatal("pagination did not terminate")
+				}
+				var page []RedisSession
+				next, err := adapter.List(&page, tt.sortKey, tt.filter, 2, cursor, tt.params)
+				if err != nil {
+					t.Fatal(err)
+				}
+				all = append(all, redisIDs(page)...)
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+			if !slices.Equal(all, tt.want) {
+				t.Errorf("listed %v, want %v", all, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedisAdapterTTL(t *testing.T) {
+	adapter, server := newRedisAdapter(t, map[string]string{"prefix": "app"})
+	seedRedisSessions(t, adapter, 3)
+
+	short := RedisSession{Id: "short", User: "tmp", Plan: "free"}
+	if err := adapter.Create(&short, map[string]any{storage.RedisTTLKey: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := server.TTL("app:redis_sessions:item:short"); ttl != time.Minute {
+		t.Fatalf("TTL after Create = %v, want 1m", ttl)
+	}
+	// Update without a TTL keeps the one the item has.
+	short.Hits = 5
+	if err := adapter.Update(&short, map[string]any{"id": "short"}); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := server.TTL("app:redis_sessions:item:short"); ttl != time.Minute {
+		t.Fatalf("TTL after Update = %v, want 1m", ttl)
+	}
+	if err := adapter.Create(&RedisSession{Id: "bad"}, map[string]any{storage.RedisTTLKey: "soon"}); err == nil {
+		t.Fatal("expected an invalid ttl to be rejected")
+	}
+
+	server.FastForward(2 * time.Minute)
+
+	var got RedisSession
+	if err := adapter.Get(&got, map[string]any{"id": "short"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Get after expiry error = %v, want ErrNotFound", err)
+	}
+	var page []RedisSession
+	if _, err := adapter.List(&page, "id", nil, 10, ""); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"s01", "s02", "s03"}; !slices.Equal(redisIDs(page), want) {
+		t.Fatalf("List after expiry = %v, want %v", redisIDs(page), want)
+	}
+	if n, err := adapter.Count(&RedisSession{}, map[string]any{"plan": "free"}); err != nil || n != 2 {
+		t.Fatalf("Count after expiry = %d, %v, want 2", n, err)
+	}
+	// The reads above removed the expired item from the indexes they used.
+	if members, _ := server.ZMembers("app:redis_sessions:lex:id"); len(members) != 3 {
+		t.Fatalf("key index still holds %d members", len(members))
+	}
+	if ok, _ := server.SIsMember("app:redis_sessions:eq:plan:free", "short"); ok {
+		t.Fatal("equality set still holds the expired item")
+	}
+}
+
+func TestRedisAdapterSearch(t *testing.T) {
+	adapter, _ := newRedisAdapter(t, map[string]string{"search_scan_limit": "5"})
+	seedRedisSessions(t, adapter, 6)
+
+	var page []RedisSession
+	_, err := adapter.Search(&page, "id", `plan:pro`, 10, "")
+	if err == nil || !strings.Contains(err.Error(), "more than the limit of 5") {
+		t.Fatalf("Search over the scan limit error = %v", err)
+	}
+
+	override := map[string]any{storage.RedisScanLimitKey: 100}
+	cursor, err := adapter.Search(&page, "expires_at", `plan:pro AND hits:[0 TO 1]`, 1, "", override)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(redisIDs(page), []string{"s06"}) || cursor == "" {
+		t.Fatalf("first page = %v, cursor %q", redisIDs(page), cursor)
+	}
+	page = nil
+	if cursor, err = adapter.Search(&page, "expires_at", `plan:pro AND hits:[0 TO 1]`, 1, cursor, override); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(redisIDs(page), []string{"s04"}) || cursor != "" {
+		t.Fatalf("second page = %v, cursor %q", redisIDs(page), cursor)
+	}
+}
+
+func TestRedisAdapterExecuteAndMigrations(t *testing.T) {
+	adapter, server := newRedisAdapter(t, nil)
+	if err := adapter.Execute(`SET "feature:dark mode" "on \"beta\""`); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := server.Get("feature:dark mode"); v != `on "beta"` {
+		t.Fatalf("Execute stored %q", v)
+	}
+	if err := adapter.Execute(`NOSUCHCOMMAND x`); err == nil {
+		t.Fatal("expected an unknown command to fail")
+	}
+
+	if err := adapter.CreateMigrationTable(); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err := adapter.GetLatestMigration(); err != nil || latest != 0 {
+		t.Fatalf("GetLatestMigration with none applied = %d, %v", latest, err)
+	}
+	for _, id := range []int{1, 12, 3} {
+		if err := adapter.UpdateMigrationTable(id, fmt.Sprintf("%d__m.yaml", id), "m"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if latest, err := adapter.GetLatestMigration(); err != nil || latest != 12 {
+		t.Fatalf("GetLatestMigration = %d, %v, want 12", latest, err)
+	}
+	if err := adapter.DeleteMigration(12); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err := adapter.GetLatestMigration(); err != nil || latest != 3 {
+		t.Fatalf("GetLatestMigration after deleting 12 = %d, %v, want 3", latest, err)
+	}
+	if server.Exists("magic:migrations:12") {
+		t.Fatal("the deleted migration's hash is still stored")
+	}
+	if err := adapter.RecordMigration(storage.AppliedMigration{ID: 20, Name: "20__m.yaml", Checksum: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	applied, err := adapter.AppliedMigrations()
+	if err != nil || len(applied) != 3 || applied[1].ID != 3 || applied[2].Checksum != "abc" || applied[2].AppliedAt.IsZero() {
+		t.Fatalf("AppliedMigrations = %+v, %v", applied, err)
+	}
+}
@@ -0,0 +1,38 @@
+package retry
+
+import "sync"
+
+// Budget caps retries across every call that shares it, so that when a store
+// is failing outright the retries do not multiply the load on it. It follows
+// the token scheme of gRPC's retry throttling: each failed attempt costs a
+// token, each success earns back Ratio of one, and retries are allowed only
+// while more than half of the tokens are left.
+//
+// Share one Budget between the adapters that reach the same store.
+type Budget struct {
+	mu     sync.Mutex
+	tokens float64
+	max    float64
+	ratio  float64
+}
+
+// NewBudget returns a full budget of maxTokens. With NewBudget(10, 0.1),
+// about five failures in a row stop retries, and ten successes per failure
+// keep them going.
+func NewBudget(maxTokens int, ratio float64) *Budget {
+	return &Budget{tokens: float64(maxTokens), max: float64(maxTokens), ratio: ratio}
+}
+
+func (b *Budget) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.max, b.tokens+b.ratio)
+}
+
+// failure spends a token and reports whether a retry is still allowed.
+func (b *Budget) failure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = max(0, b.tokens-1)
+	return b.tokens > b.max/2
+}
@@ -0,0 +1,163 @@
+package retry
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/aws/smithy-go"
+	"github.com/go-sql-driver/mysql"
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/tink3rlabs/magic/storage"
+)
+
+// Class is what a Classifier makes of an error: whether it is worth retrying,
+// and whether the failed attempt can have taken effect.
+type Class string
+
+const (
+	// Permanent errors are returned without a retry.
+	Permanent Class = ""
+	// Throttled errors mean the store refused the request for its rate, as
+	// DynamoDB's ProvisionedThroughputExceededException and Cosmos DB's 429
+	// do. The request was not carried out, so any operation is retried.
+	Throttled Class = "throttled"
+	// Conflict errors mean the store rolled the statement back to resolve
+	// contention: a deadlock, a serialization failure, a busy SQLite file.
+	// Like Throttled, any operation is retried.
+	Conflict Class = "conflict"
+	// Transient errors are failures of the connection or the server, such as
+	// a reset connection or an internal server error. The request may or may
+	// not have been carried out, so only idempotent operations are retried.
+	Transient Class = "transient"
+)
+
+// safeForAnyOp reports whether the failed attempt cannot have taken effect.
+func (c Class) safeForAnyOp() bool {
+	return c == Throttled || c == Conflict
+}
+
+// Classifier sorts an error into a Class.
+type Classifier func(err error) Class
+
+// ClassifierFor returns the classifier for the errors adapter returns. Every
+// classifier also recognises the connection failures common to all adapters.
+func ClassifierFor(adapter storage.StorageAdapter) Classifier {
+	switch adapter.GetType() {
+	case storage.DYNAMODB:
+		return DynamoDB
+	case storage.COSMOSDB:
+		return CosmosDB
+	case storage.SQL, storage.MEMORY:
+		return SQL
+	default:
+		return Common
+	}
+}
+
+// Common classifies the failures any adapter can see: a broken connection, a
+// network timeout and a per-attempt deadline. The caller's own deadline is not
+// among them; the retry loop stops when the caller's context is done.
+func Common(err error) Class {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return Transient
+	}
+	return Permanent
+}
+
+// DynamoDB classifies DynamoDB service errors by their error code.
+func DynamoDB(err error) Class {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ProvisionedThroughputExceededException", "RequestLimitExceeded", "ThrottlingException":
+			return Throttled
+		case "TransactionConflictException":
+			return Conflict
+		case "InternalServerError", "ServiceUnavailable":
+			return Transient
+		}
+	}
+	return Common(err)
+}
+
+// CosmosDB classifies Cosmos DB responses by their status code. 449 is Cosmos
+// DB's "retry with", returned when a write raced another on the same item.
+func CosmosDB(err error) Class {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.StatusCode {
+		case http.StatusTooManyRequests:
+			return Throttled
+		case 449:
+			return Conflict
+		case http.StatusRequestTimeout, http.StatusGone, http.StatusServiceUnavailable:
+			return Transient
+		}
+	}
+	return Common(err)
+}
+
+// SQL classifies the errors of the PostgreSQL, MySQL, SQL Server and SQLite
+// drivers. Deadlocks and serialization failures are Conflicts: the database
+// rolled the statement back, so it is safe to run again.
+func SQL(err error) Class {
+	// pgx reports SQLSTATE through a method, so the driver need not be
+	// imported to read it.
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		switch state := pgErr.SQLState(); {
+		case state == "40001", state == "40P01":
+			return Conflict
+		case state == "53300", state == "57P01", strings.HasPrefix(state, "08"):
+			return Transient
+		}
+	}
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		switch myErr.Number {
+		case 1205, 1213: // lock wait timeout, deadlock
+			return Conflict
+		case 1040: // too many connections
+			return Transient
+		}
+	}
+	var msErr interface{ SQLErrorNumber() int32 }
+	if errors.As(err, &msErr) && msErr.SQLErrorNumber() == 1205 {
+		return Conflict
+	}
+	var liteErr sqlite3.Error
+	if errors.As(err, &liteErr) && (liteErr.Code == sqlite3.ErrBusy || liteErr.Code == sqlite3.ErrLocked) {
+		return Conflict
+	}
+	return Common(err)
+}
+
+// retryAfter returns the delay the store asked for, if any. Only Cosmos DB
+// sends one with its throttling responses.
+func retryAfter(err error) time.Duration {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) || respErr.RawResponse == nil {
+		return 0
+	}
+	ms, convErr := strconv.ParseFloat(respErr.RawResponse.Header.Get("x-ms-retry-after-ms"), 64)
+	if convErr != nil {
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
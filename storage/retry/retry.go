@@ -0,0 +1,423 @@
+// Package retry retries storage operations that fail for reasons that pass:
+// throttling, contention and connection trouble. The Adapter decorator
+// classifies each error with a Classifier for the wrapped adapter, waits with
+// exponential backoff and full jitter, and tries again.
+//
+//	adapter := storage.Instrument(retry.New(storage.UnwrapAdapter(base), retry.Options{
+//		MaxAttempts: 4,
+//		Budget:      retry.NewBudget(10, 0.1),
+//	}))
+//
+// Not every error can be retried on every operation. A throttled request or a
+// deadlock victim was not carried out, so any operation is retried. After a
+// dropped connection the write may have happened, so only idempotent
+// operations are: reads and Delete. Create, Update, Execute and Query are
+// retried then only when the context is marked with MarkIdempotent.
+//
+// Wrapped with storage.Instrument as above, every attempt falls within one
+// storage span. Each retry is added to it as an event and counted in
+// magic_storage_operation_retries_total.
+package retry
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tink3rlabs/magic/storage"
+	"github.com/tink3rlabs/magic/telemetry"
+)
+
+// Metric and label names, kept in sync with observability/builtins.go, which
+// registers the metric at Init.
+const (
+	metricRetriesTotal = "magic_storage_operation_retries_total"
+
+	labelProvider  = "provider"
+	labelOperation = "operation"
+	labelClass     = "class"
+)
+
+const (
+	opCreate    = "create"
+	opGet       = "get"
+	opUpdate    = "update"
+	opDelete    = "delete"
+	opList      = "list"
+	opSearch    = "search"
+	opCount     = "count"
+	opQuery     = "query"
+	opExecute   = "execute"
+	opPing      = "ping"
+	opAggregate = "aggregate"
+	opFacets    = "search_facets"
+)
+
+// Options configures an Adapter.
+type Options struct {
+	// MaxAttempts is the number of attempts, the first included. Defaults
+	// to 3.
+	MaxAttempts int
+	// BaseDelay is the backoff cap of the first retry; it doubles with each
+	// retry up to MaxDelay. The wait is drawn uniformly below the cap.
+	// Defaults to 50ms.
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff. A longer delay asked for by the store, as
+	// Cosmos DB does with x-ms-retry-after-ms, is honoured anyway. Defaults
+	// to 2s.
+	MaxDelay time.Duration
+	// Classifier sorts errors into classes. Defaults to ClassifierFor the
+	// wrapped adapter.
+	Classifier Classifier
+	// Budget, when set, stops retries while the store keeps failing.
+	Budget *Budget
+	// Rand draws the jitter, for tests. Defaults to the math/rand/v2
+	// top-level source.
+	Rand *rand.Rand
+}
+
+type idempotentKey struct{}
+
+// MarkIdempotent marks the operations made with ctx as safe to repeat, so that
+// a Create, Update, Execute or Query is retried after a Transient error too.
+// Use it for a Create with a caller-chosen key whose duplicate the caller
+// tolerates, an Update whose filter the item it writes still matches, or a
+// read-only Query.
+func MarkIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func markedIdempotent(ctx context.Context) bool {
+	marked, _ := ctx.Value(idempotentKey{}).(bool)
+	return marked
+}
+
+// Adapter is a storage.ContextualStorageAdapter decorator that retries failed
+// operations. Schema and migration methods pass through untouched.
+type Adapter struct {
+	inner    storage.StorageAdapter
+	ctxInner storage.ContextualStorageAdapter
+	opts     Options
+	provider string
+	retries  telemetry.Counter
+}
+
+var (
+	_ storage.ContextualStorageAdapter = (*Adapter)(nil)
+	_ storage.Aggregator               = (*Adapter)(nil)
+	_ storage.Faceter                  = (*Adapter)(nil)
+)
+
+// New wraps inner.
+func New(inner storage.StorageAdapter, opts Options) *Adapter {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = 50 * time.Millisecond
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = 2 * time.Second
+	}
+	if opts.Classifier == nil {
+		opts.Classifier = ClassifierFor(inner)
+	}
+	a := &Adapter{inner: inner, opts: opts, provider: string(inner.GetProvider())}
+	if a.provider == "" {
+		a.provider = string(inner.GetType())
+	}
+	if c, ok := inner.(storage.ContextualStorageAdapter); ok {
+		a.ctxInner = c
+	}
+	counter, err := telemetry.Global().Metrics.Counter(telemetry.MetricDefinition{
+		Name:   metricRetriesTotal,
+		Help:   "Total storage operation retries, labeled by provider, operation, and the class of the error retried.",
+		Kind:   telemetry.KindCounter,
+		Labels: []string{labelProvider, labelOperation, labelClass},
+	})
+	if err != nil {
+		slog.Warn("storage: failed to register retries counter", "error", err)
+	} else {
+		a.retries = counter
+	}
+	return a
+}
+
+// UnwrapStorageAdapter returns the wrapped adapter.
+func (a *Adapter) UnwrapStorageAdapter() storage.StorageAdapter {
+	return a.inner
+}
+
+// do runs attempt until it succeeds, fails in a way that is not retried, or
+// runs out of attempts, and returns its last error.
+func (a *Adapter) do(ctx context.Context, op string, idempotent bool, attempt func() error) error {
+	idempotent = idempotent || markedIdempotent(ctx)
+	span := trace.SpanFromContext(ctx)
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil {
+			if a.opts.Budget != nil {
+				a.opts.Budget.success()
+			}
+			if n > 1 {
+				span.SetAttributes(attribute.Int("magic.storage.attempts", n))
+			}
+			return nil
+		}
+		class := a.opts.Classifier(err)
+		if class == Permanent || (!idempotent && !class.safeForAnyOp()) {
+			return err
+		}
+		allowed := a.opts.Budget == nil || a.opts.Budget.failure()
+		if n >= a.opts.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		if !allowed {
+			span.AddEvent("magic.storage.retry_budget_exhausted", trace.WithAttributes(
+				attribute.String("magic.storage.retry.class", string(class)),
+			))
+			return err
+		}
+		delay := a.backoff(n, err)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+		span.AddEvent("magic.storage.retry", trace.WithAttributes(
+			attribute.Int("magic.storage.retry.attempt", n),
+			attribute.String("magic.storage.retry.class", string(class)),
+			attribute.Int64("magic.storage.retry.delay_ms", delay.Milliseconds()),
+			attribute.String("error", err.Error()),
+		))
+		if a.retries != nil {
+			a.retries.Add(1,
+				telemetry.Label{Key: labelProvider, Value: a.provider},
+				telemetry.Label{Key: labelOperation, Value: op},
+				telemetry.Label{Key: labelClass, Value: string(class)},
+			)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// backoff returns the wait before retry n: full jitter below an exponential
+// cap, or the delay the store asked for when that is longer.
+func (a *Adapter) backoff(n int, err error) time.Duration {
+	ceiling := a.opts.BaseDelay
+	for i := 1; i < n && ceiling < a.opts.MaxDelay; i++ {
+		ceiling *= 2
+	}
+	ceiling = min(ceiling, a.opts.MaxDelay)
+	draw := rand.Float64
+	if a.opts.Rand != nil {
+		draw = a.opts.Rand.Float64
+	}
+	return max(time.Duration(draw()*float64(ceiling)), retryAfter(err))
+}
+
+func (a *Adapter) GetType() storage.StorageAdapterType   { return a.inner.GetType() }
+func (a *Adapter) GetProvider() storage.StorageProviders { return a.inner.GetProvider() }
+func (a *Adapter) GetSchemaName() string                 { return a.inner.GetSchemaName() }
+func (a *Adapter) CreateSchema() error                   { return a.inner.CreateSchema() }
+func (a *Adapter) CreateMigrationTable() error           { return a.inner.CreateMigrationTable() }
+func (a *Adapter) GetLatestMigration() (int, error)      { return a.inner.GetLatestMigration() }
+
+func (a *Adapter) UpdateMigrationTable(id int, name string, desc string) error {
+	return a.inner.UpdateMigrationTable(id, name, desc)
+}
+
+func (a *Adapter) Execute(statement string) error {
+	return a.ExecuteContext(context.Background(), statement)
+}
+
+func (a *Adapter) ExecuteContext(ctx context.Context, statement string) error {
+	return a.do(ctx, opExecute, false, func() error {
+		if a.ctxInner != nil {
+			return a.ctxInner.ExecuteContext(ctx, statement)
+		}
+		return a.inner.Execute(statement)
+	})
+}
+
+func (a *Adapter) Ping() error {
+	return a.PingContext(context.Background())
+}
+
+func (a *Adapter) PingContext(ctx context.Context) error {
+	return a.do(ctx, opPing, true, func() error {
+		if a.ctxInner != nil {
+			return a.ctxInner.PingContext(ctx)
+		}
+		return a.inner.Ping()
+	})
+}
+
+func (a *Adapter) Create(item any, params ...map[string]any) error {
+	return a.CreateContext(context.Background(), item, params...)
+}
+
+func (a *Adapter) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	return a.do(ctx, opCreate, false, func() error {
+		if a.ctxInner != nil {
+			return a.ctxInner.CreateContext(ctx, item, params...)
+		}
+		return a.inner.Create(item, params...)
+	})
+}
+
+func (a *Adapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
+	return a.GetContext(context.Background(), dest, filter, params...)
+}
+
+func (a *Adapter) GetContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error {
+	return a.do(ctx, opGet, true, func() error {
+		if a.ctxInner != nil {
+			return a.ctxInner.GetContext(ctx, dest, filter, params...)
+		}
+		return a.inner.Get(dest, filter, params...)
+	})
+}
+
+func (a *Adapter) Update(item any, filter map[string]any, params ...map[string]any) error {
+	return a.UpdateContext(context.Background(), item, filter, params...)
+}
+
+// UpdateContext is not retried after a Transient error unless the context is
+// marked with MarkIdempotent. Update only writes when the stored item matches
+// its filter, and a first attempt that committed can change that match: with
+// an optimistic-concurrency filter such as {"version": 3}, the retry would
+// fail with ErrNotFound although the write happened.
+func (a *Adapter) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return a.do(ctx, opUpdate, false, func() error {
+		if a.ctxInner != nil {
+			return a.ctxInner.UpdateContext(ctx, item, filter, params...)
+		}
+		return a.inner.Update(item, filter, params...)
+	})
+}
+
+func (a *Adapter) Delete(item any, filter map[string]any, params ...map[string]any) error {
+	return a.DeleteContext(context.Background(), item, filter, params...)
+}
+
+// DeleteContext is retried as idempotent: deleting an item that is already
+// gone succeeds.
+func (a *Adapter) DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return a.do(ctx, opDelete, true, func() error {
+		if a.ctxInner != nil {
+			return a.ctxInner.DeleteContext(ctx, item, filter, params...)
+		}
+		return a.inner.Delete(item, filter, params...)
+	})
+}
+
+func (a *Adapter) List(dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.ListContext(context.Background(), dest, sortKey, filter, limit, cursor, params...)
+}
+
+func (a *Adapter) ListContext(ctx context.Context, dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (next string, err error) {
+	err = a.do(ctx, opList, true, func() (err error) {
+		if a.ctxInner != nil {
+			next, err = a.ctxInner.ListContext(ctx, dest, sortKey, filter, limit, cursor, params...)
+		} else {
+			next, err = a.inner.List(dest, sortKey, filter, limit, cursor, params...)
+		}
+		return err
+	})
+	return next, err
+}
+
+func (a *Adapter) Search(dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.SearchContext(context.Background(), dest, sortKey, query, limit, cursor, params...)
+}
+
+func (a *Adapter) SearchContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (next string, err error) {
+	err = a.do(ctx, opSearch, true, func() (err error) {
+		if a.ctxInner != nil {
+			next, err = a.ctxInner.SearchContext(ctx, dest, sortKey, query, limit, cursor, params...)
+		} else {
+			next, err = a.inner.Search(dest, sortKey, query, limit, cursor, params...)
+		}
+		return err
+	})
+	return next, err
+}
+
+func (a *Adapter) Count(dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	return a.CountContext(context.Background(), dest, filter, params...)
+}
+
+func (a *Adapter) CountContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) (total int64, err error) {
+	err = a.do(ctx, opCount, true, func() (err error) {
+		if a.ctxInner != nil {
+			total, err = a.ctxInner.CountContext(ctx, dest, filter, params...)
+		} else {
+			total, err = a.inner.Count(dest, filter, params...)
+		}
+		return err
+	})
+	return total, err
+}
+
+func (a *Adapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.QueryContext(context.Background(), dest, statement, limit, cursor, params...)
+}
+
+// QueryContext is not retried after a Transient error unless the context is
+// marked with MarkIdempotent: the statement may write.
+func (a *Adapter) QueryContext(ctx context.Context, dest any, statement string, limit int, cursor string, params ...map[string]any) (next string, err error) {
+	err = a.do(ctx, opQuery, false, func() (err error) {
+		if a.ctxInner != nil {
+			next, err = a.ctxInner.QueryContext(ctx, dest, statement, limit, cursor, params...)
+		} else {
+			next, err = a.inner.Query(dest, statement, limit, cursor, params...)
+		}
+		return err
+	})
+	return next, err
+}
+
+func (a *Adapter) Aggregate(model any, filter any, groupBy []string, metrics []storage.AggSpec, params ...map[string]any) ([]storage.AggregateRow, error) {
+	return a.AggregateContext(context.Background(), model, filter, groupBy, metrics, params...)
+}
+
+// AggregateContext reports storage.ErrNotSupported when the wrapped adapter
+// is not a storage.Aggregator.
+func (a *Adapter) AggregateContext(ctx context.Context, model any, filter any, groupBy []string, metrics []storage.AggSpec, params ...map[string]any) (rows []storage.AggregateRow, err error) {
+	agg, ok := a.inner.(storage.Aggregator)
+	if !ok {
+		return nil, storage.ErrNotSupported
+	}
+	err = a.do(ctx, opAggregate, true, func() (err error) {
+		rows, err = agg.AggregateContext(ctx, model, filter, groupBy, metrics, params...)
+		return err
+	})
+	return rows, err
+}
+
+func (a *Adapter) SearchWithFacets(dest any, sortKey string, query string, limit int, cursor string, facets []string, params ...map[string]any) (string, storage.Facets, error) {
+	return a.SearchWithFacetsContext(context.Background(), dest, sortKey, query, limit, cursor, facets, params...)
+}
+
+// SearchWithFacetsContext reports storage.ErrNotSupported when the wrapped
+// adapter is not a storage.Faceter.
+func (a *Adapter) SearchWithFacetsContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, facets []string, params ...map[string]any) (next string, counts storage.Facets, err error) {
+	f, ok := a.inner.(storage.Faceter)
+	if !ok {
+		return "", nil, storage.ErrNotSupported
+	}
+	err = a.do(ctx, opFacets, true, func() (err error) {
+		next, counts, err = f.SearchWithFacetsContext(ctx, dest, sortKey, query, limit, cursor, facets, params...)
+		return err
+	})
+	return next, counts, err
+}
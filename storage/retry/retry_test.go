@@ -0,0 +1,281 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/go-sql-driver/mysql"
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/tink3rlabs/magic/observability/obstest"
+	"github.com/tink3rlabs/magic/storage"
+	"github.com/tink3rlabs/magic/storage/faulty"
+	"github.com/tink3rlabs/magic/storage/retry"
+	"github.com/tink3rlabs/magic/telemetry"
+)
+
+type note struct {
+	Id   string `json:"id" gorm:"primaryKey;column:id"`
+	Text string `json:"text" gorm:"column:text"`
+}
+
+func (note) TableName() string { return "retry_notes" }
+
+func memory(t *testing.T) *storage.MemoryAdapter {
+	t.Helper()
+	m := storage.GetMemoryAdapterInstance()
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS retry_notes (id TEXT PRIMARY KEY, text TEXT)`,
+		`DELETE FROM retry_notes`,
+	} {
+		if err := m.Execute(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	return m
+}
+
+// setup wraps the memory adapter in faults and then in retries, with delays
+// short enough for tests.
+func setup(t *testing.T, opts retry.Options, rules ...faulty.Rule) (*faulty.Adapter, *retry.Adapter) {
+	t.Helper()
+	f := faulty.New(memory(t), faulty.Options{Rules: rules})
+	opts.BaseDelay = time.Millisecond
+	opts.Rand = rand.New(rand.NewPCG(1, 1))
+	return f, retry.New(f, opts)
+}
+
+var (
+	throttled = faulty.DynamoDBThrottle()
+	reset     = &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+)
+
+type timeoutError struct{ error }
+
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestThrottledWriteIsRetriedUntilItSucceeds(t *testing.T) {
+	f, a := setup(t, retry.Options{Classifier: retry.DynamoDB},
+		faulty.Rule{Ops: []faulty.Op{faulty.OpCreate}, Calls: []int{1, 2}, Err: throttled})
+
+	if err := a.Create(&note{Id: "1", Text: "hello"}); err != nil {
+		t.Fatalf("Create = %v, want success on the third attempt", err)
+	}
+	if n := len(f.Injections()); n != 2 {
+		t.Fatalf("%d faults injected, want 2", n)
+	}
+	var got note
+	if err := a.Get(&got, map[string]any{"id": "1"}); err != nil || got.Text != "hello" {
+		t.Fatalf("Get = %+v, %v", got, err)
+	}
+}
+
+func TestTransientErrorsAreRetriedOnlyForIdempotentOperations(t *testing.T) {
+	timeout := timeoutError{reset}
+	rules := []faulty.Rule{{Ops: []faulty.Op{faulty.OpCreate, faulty.OpGet}, Calls: []int{1}, Err: timeout}}
+
+	_, a := setup(t, retry.Options{}, rules...)
+	if err := a.Create(&note{Id: "1"}); !errors.Is(err, faulty.ErrInjected) {
+		t.Fatalf("Create = %v; a Create that may have happened must not be repeated", err)
+	}
+
+	_, a = setup(t, retry.Options{}, rules...)
+	if err := a.CreateContext(retry.MarkIdempotent(context.Background()), &note{Id: "1"}); err != nil {
+		t.Fatalf("Create marked idempotent = %v, want it retried", err)
+	}
+	if err := a.Get(&note{}, map[string]any{"id": "1"}); err != nil {
+		t.Fatalf("Get = %v, want it retried", err)
+	}
+}
+
+func TestUpdateIsRetriedAfterATransientErrorOnlyWhenMarked(t *testing.T) {
+	rules := []faulty.Rule{{Ops: []faulty.Op{faulty.OpUpdate}, Calls: []int{1}, Err: timeoutError{reset}}}
+
+	// The first attempt may have committed version 4, which a retry
+	// filtering on version 3 would then report as not found.
+	_, a := setup(t, retry.Options{}, rules...)
+	if err := a.Create(&note{Id: "1", Text: "v3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Update(&note{Id: "1", Text: "v4"}, map[string]any{"text": "v3"}); !errors.Is(err, faulty.ErrInjected) {
+		t.Fatalf("Update = %v; an Update that may have happened must not be repeated", err)
+	}
+
+	_, a = setup(t, retry.Options{}, rules...)
+	if err := a.Create(&note{Id: "1", Text: "v3"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := retry.MarkIdempotent(context.Background())
+	if err := a.UpdateContext(ctx, &note{Id: "1", Text: "v4"}, map[string]any{"id": "1"}); err != nil {
+		t.Fatalf("Update marked idempotent = %v, want it retried", err)
+	}
+}
+
+func TestPermanentErrorsAreNotRetried(t *testing.T) {
+	f, a := setup(t, retry.Options{},
+		faulty.Rule{Ops: []faulty.Op{faulty.OpGet}, Err: storage.ErrNotFound})
+	if err := a.Get(&note{}, map[string]any{"id": "1"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Get = %v, want ErrNotFound", err)
+	}
+	if n := len(f.Injections()); n != 1 {
+		t.Fatalf("Get attempted %d times, want 1", n)
+	}
+}
+
+func TestAttemptsAreCapped(t *testing.T) {
+	f, a := setup(t, retry.Options{MaxAttempts: 4, Classifier: retry.DynamoDB},
+		faulty.Rule{Ops: []faulty.Op{faulty.OpCount}, Err: throttled})
+	_, err := a.Count(&[]note{}, nil)
+	var exceeded *types.ProvisionedThroughputExceededException
+	if !errors.As(err, &exceeded) {
+		t.Fatalf("Count = %v, want the last throttling error", err)
+	}
+	if n := len(f.Injections()); n != 4 {
+		t.Fatalf("Count attempted %d times, want 4", n)
+	}
+}
+
+func TestBudgetStopsRetriesWhileTheStoreKeepsFailing(t *testing.T) {
+	budget := retry.NewBudget(4, 0.5)
+	f, a := setup(t, retry.Options{MaxAttempts: 10, Classifier: retry.DynamoDB, Budget: budget},
+		faulty.Rule{Ops: []faulty.Op{faulty.OpList}, Times: 3, Err: throttled})
+
+	// Four tokens allow a retry after the first failure only: the second
+	// leaves two, which is not more than half.
+	if _, err := a.List(&[]note{}, "id", nil, 10, ""); err == nil {
+		t.Fatal("List succeeded; the budget should have stopped it after two attempts")
+	}
+	if n := len(f.Injections()); n != 2 {
+		t.Fatalf("List attempted %d times, want 2", n)
+	}
+
+	// Each success earns back half a token; after three, the next failure
+	// leaves more than half and is retried.
+	for range 3 {
+		if err := a.Ping(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := a.List(&[]note{}, "id", nil, 10, ""); err != nil {
+		t.Fatalf("List = %v, want the refilled budget to allow a retry", err)
+	}
+}
+
+func TestCallerDeadlineEndsRetries(t *testing.T) {
+	_, a := setup(t, retry.Options{MaxAttempts: 100, MaxDelay: time.Hour, Classifier: retry.CosmosDB},
+		faulty.Rule{Ops: []faulty.Op{faulty.OpGet}, Err: faulty.CosmosThrottle(time.Hour)})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := a.GetContext(ctx, &note{}, map[string]any{"id": "1"}); err == nil {
+		t.Fatal("Get succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Get waited %v for a retry past the caller's deadline", elapsed)
+	}
+}
+
+func TestCosmosRetryAfterIsHonoured(t *testing.T) {
+	_, a := setup(t, retry.Options{Classifier: retry.CosmosDB},
+		faulty.Rule{Ops: []faulty.Op{faulty.OpGet}, Calls: []int{1}, Err: faulty.CosmosThrottle(30 * time.Millisecond)})
+	if err := a.Create(&note{Id: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := a.Get(&note{}, map[string]any{"id": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("retried after %v, before the 30ms Cosmos DB asked for", elapsed)
+	}
+}
+
+func TestRetriesAreRecordedOnTheSpanAndCounted(t *testing.T) {
+	obs := obstest.NewTestObserver(t)
+	defer obs.Close()
+
+	_, r := setup(t, retry.Options{Classifier: retry.DynamoDB},
+		faulty.Rule{Ops: []faulty.Op{faulty.OpCreate}, Calls: []int{1, 2}, Err: throttled})
+	adapter := storage.Instrument(r).(storage.ContextualStorageAdapter)
+	if err := adapter.CreateContext(context.Background(), &note{Id: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	span := obs.AssertSpan(t, "storage.create")
+	retries := 0
+	for _, e := range span.Events() {
+		if e.Name == "magic.storage.retry" {
+			retries++
+		}
+	}
+	if retries != 2 {
+		t.Fatalf("span has %d retry events, want 2: %+v", retries, span.Events())
+	}
+	obs.AssertCounter(t, "magic_storage_operation_retries_total", 2,
+		telemetry.Label{Key: "provider", Value: "sqlite"},
+		telemetry.Label{Key: "operation", Value: "create"},
+		telemetry.Label{Key: "class", Value: "throttled"},
+	)
+	obs.AssertCounter(t, "magic_storage_operations_total", 1,
+		telemetry.Label{Key: "provider", Value: "sqlite"},
+		telemetry.Label{Key: "operation", Value: "create"},
+		telemetry.Label{Key: "status", Value: "ok"},
+	)
+}
+
+type pgError struct{ state string }
+
+func (e pgError) Error() string    { return "pg: " + e.state }
+func (e pgError) SQLState() string { return e.state }
+
+type mssqlError struct{ number int32 }
+
+func (e mssqlError) Error() string         { return fmt.Sprintf("mssql: %d", e.number) }
+func (e mssqlError) SQLErrorNumber() int32 { return e.number }
+
+func TestClassifiers(t *testing.T) {
+	cosmos := func(status int) error { return &azcore.ResponseError{StatusCode: status} }
+	wrap := func(err error) error { return fmt.Errorf("failed to update item: %w", err) }
+	cases := []struct {
+		name       string
+		classifier retry.Classifier
+		err        error
+		want       retry.Class
+	}{
+		{"dynamodb throughput", retry.DynamoDB, wrap(throttled), retry.Throttled},
+		{"dynamodb request limit", retry.DynamoDB, &types.RequestLimitExceeded{}, retry.Throttled},
+		{"dynamodb transaction conflict", retry.DynamoDB, &types.TransactionConflictException{}, retry.Conflict},
+		{"dynamodb internal error", retry.DynamoDB, &types.InternalServerError{}, retry.Transient},
+		{"dynamodb condition failed", retry.DynamoDB, &types.ConditionalCheckFailedException{}, retry.Permanent},
+		{"cosmos 429", retry.CosmosDB, wrap(cosmos(429)), retry.Throttled},
+		{"cosmos 449", retry.CosmosDB, cosmos(449), retry.Conflict},
+		{"cosmos 503", retry.CosmosDB, cosmos(503), retry.Transient},
+		{"cosmos 409", retry.CosmosDB, cosmos(409), retry.Permanent},
+		{"postgres serialization failure", retry.SQL, pgError{"40001"}, retry.Conflict},
+		{"postgres deadlock", retry.SQL, wrap(pgError{"40P01"}), retry.Conflict},
+		{"postgres connection failure", retry.SQL, pgError{"08006"}, retry.Transient},
+		{"postgres unique violation", retry.SQL, pgError{"23505"}, retry.Permanent},
+		{"mysql deadlock", retry.SQL, &mysql.MySQLError{Number: 1213}, retry.Conflict},
+		{"mysql duplicate key", retry.SQL, &mysql.MySQLError{Number: 1062}, retry.Permanent},
+		{"sql server deadlock", retry.SQL, mssqlError{1205}, retry.Conflict},
+		{"sqlite busy", retry.SQL, sqlite3.Error{Code: sqlite3.ErrBusy}, retry.Conflict},
+		{"network timeout", retry.Common, wrap(timeoutError{reset}), retry.Transient},
+		{"deadline exceeded", retry.SQL, context.DeadlineExceeded, retry.Transient},
+		{"not found", retry.Common, storage.ErrNotFound, retry.Permanent},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.classifier(tc.err); got != tc.want {
+				t.Errorf("class = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
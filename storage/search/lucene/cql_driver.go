@@ -0,0 +1,330 @@
+package lucene
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grindlemire/go-lucene/pkg/lucene/expr"
+)
+
+// CQLDriver converts Lucene queries to a CQL WHERE clause.
+//
+// CQL is far narrower than SQL: a WHERE clause is a flat conjunction of
+// relations, there is no OR, NOT or LIKE, and a column can only be
+// restricted when it is part of the primary key or has a secondary index.
+// The driver therefore accepts only the subset of Lucene that maps onto that
+// and rejects the rest with an error naming the construct, rather than
+// producing a query Cassandra would refuse or answer with a full scan:
+//
+//	field:value              field = ?
+//	field:(a OR b)           field IN (?, ?)
+//	field:a OR field:b       field IN (?, ?)
+//	field:[a TO b]           field >= ? AND field <= ?
+//	field:>a, field:<=b      field > ?, field <= ?
+//	a AND b, +a +b           a AND b
+//
+// Values are bound as the Go type of the model field (int64, float64, bool,
+// time.Time or string) because CQL, unlike SQL, does not coerce a string into
+// a numeric or timestamp column.
+type CQLDriver struct {
+	fields     map[string]FieldInfo
+	searchable map[string]bool
+}
+
+// NewCQLDriver creates a driver over the model fields. searchable lists the
+// columns that may be restricted: the table's key columns and indexed
+// columns.
+func NewCQLDriver(fields []FieldInfo, searchable []string) (*CQLDriver, error) {
+	fieldMap, err := buildFieldMap(fields)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make(map[string]bool, len(searchable))
+	for _, col := range searchable {
+		allowed[col] = true
+	}
+	return &CQLDriver{fields: fieldMap, searchable: allowed}, nil
+}
+
+// RenderCQL renders the expression as a CQL WHERE clause (without the WHERE
+// keyword) with ? placeholders.
+func (d *CQLDriver) RenderCQL(e *expr.Expression) (string, []any, error) {
+	var relations []string
+	var params []any
+	for _, term := range conjuncts(e) {
+		rel, p, err := d.renderRelation(term)
+		if err != nil {
+			return "", nil, err
+		}
+		relations = append(relations, rel)
+		params = append(params, p...)
+	}
+	return strings.Join(relations, " AND "), params, nil
+}
+
+// conjuncts flattens nested AND and Must nodes into the list of relations CQL
+// expects.
+func conjuncts(e *expr.Expression) []*expr.Expression {
+	if e == nil {
+		return nil
+	}
+	switch e.Op {
+	case expr.And:
+		left, lok := e.Left.(*expr.Expression)
+		right, rok := e.Right.(*expr.Expression)
+		if lok && rok {
+			return append(conjuncts(left), conjuncts(right)...)
+		}
+	case expr.Must:
+		if left, ok := e.Left.(*expr.Expression); ok {
+			return conjuncts(left)
+		}
+	}
+	return []*expr.Expression{e}
+}
+
+func (d *CQLDriver) renderRelation(e *expr.Expression) (string, []any, error) {
+	switch e.Op {
+	case expr.Equals:
+		col, info, err := d.column(e.Left)
+		if err != nil {
+			return "", nil, err
+		}
+		if isNullValue(e.Right) {
+			return "", nil, fmt.Errorf("null checks are not supported in CQL (%s:null)", col)
+		}
+		if group, ok := e.Right.(*expr.Expression); ok && (group.Op == expr.Or || group.Op == expr.And) {
+			if group.Op == expr.And {
+				return "", nil, fmt.Errorf("a column cannot equal several values at once (%s:(... AND ...))", col)
+			}
+			return d.renderIn(col, info, valueAlternatives(group))
+		}
+		v, err := cqlValue(info, extractLiteralValue(e.Right))
+		if err != nil {
+			return "", nil, err
+		}
+		return col + " = ?", []any{v}, nil
+
+	case expr.Or:
+		// The only disjunction CQL can express is IN over a single column.
+		col, values, ok := sameColumnEquals(e)
+		if !ok {
+			return "", nil, fmt.Errorf("OR is only supported between values of the same field in CQL, which becomes IN")
+		}
+		c, info, err := d.column(col)
+		if err != nil {
+			return "", nil, err
+		}
+		return d.renderIn(c, info, values)
+
+	case expr.Range:
+		return d.renderRange(e)
+
+	case expr.Greater, expr.GreaterEq, expr.Less, expr.LessEq:
+		col, info, err := d.column(e.Left)
+		if err != nil {
+			return "", nil, err
+		}
+		v, err := cqlValue(info, extractLiteralValue(e.Right))
+		if err != nil {
+			return "", nil, err
+		}
+		ops := map[expr.Operator]string{expr.Greater: ">", expr.GreaterEq: ">=", expr.Less: "<", expr.LessEq: "<="}
+		return fmt.Sprintf("%s %s ?", col, ops[e.Op]), []any{v}, nil
+
+	case expr.Not, expr.MustNot:
+		return "", nil, fmt.Errorf("negation is not supported in CQL")
+	case expr.Wild, expr.Like, expr.Regexp, expr.Fuzzy:
+		return "", nil, fmt.Errorf("wildcard, regular expression and fuzzy matching are not supported in CQL")
+	}
+	return "", nil, fmt.Errorf("operator %s is not supported in CQL", e.Op)
+}
+
+func (d *CQLDriver) renderIn(col string, info FieldInfo, raw []string) (string, []any, error) {
+	placeholders := make([]string, len(raw))
+	params := make([]any, len(raw))
+	for i, r := range raw {
+		v, err := cqlValue(info, r)
+		if err != nil {
+			return "", nil, err
+		}
+		placeholders[i] = "?"
+		params[i] = v
+	}
+	return fmt.Sprintf("%s IN (%s)", col, strings.Join(placeholders, ", ")), params, nil
+}
+
+// renderRange spells a range as two relations: CQL has no BETWEEN.
+func (d *CQLDriver) renderRange(e *expr.Expression) (string, []any, error) {
+	col, info, err := d.column(e.Left)
+	if err != nil {
+		return "", nil, err
+	}
+	boundary, ok := e.Right.(*expr.RangeBoundary)
+	if !ok {
+		return "", nil, fmt.Errorf("invalid range expression structure: expected *expr.RangeBoundary, got %T", e.Right)
+	}
+	minVal, maxVal := "*", "*"
+	if boundary.Min != nil {
+		minVal = extractLiteralValue(boundary.Min)
+	}
+	if boundary.Max != nil {
+		maxVal = extractLiteralValue(boundary.Max)
+	}
+	if minVal == "*" && maxVal == "*" {
+		return "", nil, fmt.Errorf("both range bounds cannot be wildcards")
+	}
+
+	lower, upper := ">", "<"
+	if boundary.Inclusive {
+		lower, upper = ">=", "<="
+	}
+	var relations []string
+	var params []any
+	if minVal != "*" {
+		v, err := cqlValue(info, minVal)
+		if err != nil {
+			return "", nil, err
+		}
+		relations = append(relations, fmt.Sprintf("%s %s ?", col, lower))
+		params = append(params, v)
+	}
+	if maxVal != "*" {
+		v, err := cqlValue(info, maxVal)
+		if err != nil {
+			return "", nil, err
+		}
+		relations = append(relations, fmt.Sprintf("%s %s ?", col, upper))
+		params = append(params, v)
+	}
+	return strings.Join(relations, " AND "), params, nil
+}
+
+// column resolves a column reference and checks that CQL can restrict it.
+func (d *CQLDriver) column(in any) (string, FieldInfo, error) {
+	name, ok := columnName(in)
+	if !ok {
+		return "", FieldInfo{}, fmt.Errorf("unexpected column reference %v", in)
+	}
+	info, exists := d.fields[name]
+	if !exists {
+		return "", FieldInfo{}, &InvalidFieldError{Field: name, ValidFields: d.searchableNames()}
+	}
+	if !d.searchable[name] {
+		return "", FieldInfo{}, fmt.Errorf(
+			"field '%s' cannot be searched: CQL can only filter on primary key and indexed columns (%s)",
+			name, strings.Join(d.searchableNames(), ", "),
+		)
+	}
+	if isArrayField(info.Type) {
+		return "", FieldInfo{}, fmt.Errorf("collection field '%s' cannot be searched in CQL", name)
+	}
+	return name, info, nil
+}
+
+func (d *CQLDriver) searchableNames() []string {
+	var names []string
+	for name := range d.fields {
+		if d.searchable[name] {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// valueAlternatives lists the literals of a grouped value list such as
+// (a OR b OR c).
+func valueAlternatives(e *expr.Expression) []string {
+	if e.Op == expr.Or {
+		l, lok := e.Left.(*expr.Expression)
+		r, rok := e.Right.(*expr.Expression)
+		if lok && rok {
+			return append(valueAlternatives(l), valueAlternatives(r)...)
+		}
+	}
+	// Depending on how the group was written the parser yields bare literals
+	// or field:value leaves.
+	if e.Op == expr.Equals {
+		return []string{extractLiteralValue(e.Right)}
+	}
+	return []string{extractLiteralValue(e)}
+}
+
+// sameColumnEquals reports whether an OR tree is only equalities on one
+// column, returning that column and the values.
+func sameColumnEquals(e *expr.Expression) (string, []string, bool) {
+	switch e.Op {
+	case expr.Or:
+		l, lok := e.Left.(*expr.Expression)
+		r, rok := e.Right.(*expr.Expression)
+		if !lok || !rok {
+			return "", nil, false
+		}
+		lc, lv, lok := sameColumnEquals(l)
+		rc, rv, rok := sameColumnEquals(r)
+		if !lok || !rok || lc != rc {
+			return "", nil, false
+		}
+		return lc, append(lv, rv...), true
+	case expr.Equals:
+		col, ok := columnName(e.Left)
+		if !ok || isNullValue(e.Right) {
+			return "", nil, false
+		}
+		if g, grouped := e.Right.(*expr.Expression); grouped && g.Op != expr.Literal {
+			if g.Op != expr.Or {
+				return "", nil, false
+			}
+			return col, valueAlternatives(g), true
+		}
+		return col, []string{extractLiteralValue(e.Right)}, true
+	}
+	return "", nil, false
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// cqlValue converts a query literal to the Go type of its field.
+func cqlValue(info FieldInfo, raw string) (any, error) {
+	t := info.Type
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil {
+		return raw, nil
+	}
+	if t == timeType {
+		v, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, fmt.Errorf("field '%s' expects an RFC 3339 timestamp, got %q", info.Name, raw)
+		}
+		return v, nil
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("field '%s' expects an integer, got %q", info.Name, raw)
+		}
+		return v, nil
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("field '%s' expects a number, got %q", info.Name, raw)
+		}
+		return v, nil
+	case reflect.Bool:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("field '%s' expects true or false, got %q", info.Name, raw)
+		}
+		return v, nil
+	}
+	return raw, nil
+}
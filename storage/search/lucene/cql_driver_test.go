@@ -0,0 +1,97 @@
+package lucene
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type cqlReading struct {
+	SensorID string    `json:"sensor_id"`
+	Day      string    `json:"day"`
+	At       time.Time `json:"at"`
+	Value    float64   `json:"value"`
+	Seq      int       `json:"seq"`
+	Status   string    `json:"status"`
+	Note     string    `json:"note"`
+	Tags     []string  `json:"tags"`
+}
+
+var cqlSearchable = []string{"sensor_id", "day", "at", "seq", "status", "tags"}
+
+func TestParseToCQL(t *testing.T) {
+	at := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query      string
+		wantCQL    string
+		wantParams []any
+	}{
+		{`sensor_id:s1`, `sensor_id = ?`, []any{"s1"}},
+		{`sensor_id:s1 AND day:2026-05-01`, `sensor_id = ? AND day = ?`, []any{"s1", "2026-05-01"}},
+		{`+sensor_id:s1 +status:ok`, `sensor_id = ? AND status = ?`, []any{"s1", "ok"}},
+		{`sensor_id:(s1 OR s2 OR s3)`, `sensor_id IN (?, ?, ?)`, []any{"s1", "s2", "s3"}},
+		{`sensor_id:s1 OR sensor_id:s2`, `sensor_id IN (?, ?)`, []any{"s1", "s2"}},
+		{`seq:[1 TO 10]`, `seq >= ? AND seq <= ?`, []any{int64(1), int64(10)}},
+		{`seq:{1 TO 10}`, `seq > ? AND seq < ?`, []any{int64(1), int64(10)}},
+		{`seq:[5 TO *]`, `seq >= ?`, []any{int64(5)}},
+		{`seq:>5`, `seq > ?`, []any{int64(5)}},
+		{`seq:<=5`, `seq <= ?`, []any{int64(5)}},
+		{`sensor_id:s1 AND at:["2026-05-01T12:00:00Z" TO *]`, `sensor_id = ? AND at >= ?`, []any{"s1", at}},
+		{
+			`sensor_id:s1 AND day:d1 AND seq:[1 TO 3]`,
+			`sensor_id = ? AND day = ? AND seq >= ? AND seq <= ?`,
+			[]any{"s1", "d1", int64(1), int64(3)},
+		},
+	}
+
+	parser, err := NewParser(cqlReading{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			cql, params, err := parser.ParseToCQL(tt.query, cqlSearchable)
+			if err != nil {
+				t.Fatalf("ParseToCQL(%q) error: %v", tt.query, err)
+			}
+			if cql != tt.wantCQL {
+				t.Errorf("cql = %q, want %q", cql, tt.wantCQL)
+			}
+			if !reflect.DeepEqual(params, tt.wantParams) {
+				t.Errorf("params = %#v, want %#v", params, tt.wantParams)
+			}
+		})
+	}
+}
+
+func TestParseToCQLRejectsWhatCQLCannotExpress(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr string
+	}{
+		{`note:hello`, "can only filter on primary key and indexed columns"},
+		{`value:1.5`, "can only filter on primary key and indexed columns"},
+		{`sensor_id:s1 OR status:ok`, "OR is only supported between values of the same field"},
+		{`NOT status:ok`, "negation is not supported"},
+		{`-status:ok`, "negation is not supported"},
+		{`status:ok*`, "not supported in CQL"},
+		{`status:null`, "null checks are not supported"},
+		{`tags:go`, "collection field 'tags'"},
+		{`seq:abc`, "expects an integer"},
+		{`at:yesterday`, "expects an RFC 3339 timestamp"},
+	}
+
+	parser, err := NewParser(cqlReading{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, _, err := parser.ParseToCQL(tt.query, cqlSearchable)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseToCQL(%q) error = %v, want it to contain %q", tt.query, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -4,9 +4,24 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 )
 
-// sqlDialect is the set of per-database differences in rendering a Lucene filter
+// DialectVersion is the revision of the Dialect contract this package
+// implements.
+//
+// Version 1 is the method set below together with the documented meaning of
+// each method: which arguments arrive already quoted or rendered, that SQL
+// uses `?` placeholders, and how NULL columns must behave. Within a version
+// the interface gains no methods and no method changes meaning, so a dialect
+// written against it keeps compiling and keeps rendering correct SQL. An
+// operation added later is offered as a separate optional interface that the
+// renderer detects with a type assertion and falls back from when a dialect
+// does not implement it. Anything that cannot be added that way bumps the
+// version, and the change is called out in the release notes.
+const DialectVersion = 1
+
+// Dialect is the set of per-database differences in rendering a Lucene filter
 // to SQL.
 //
 // These differences used to live in switch statements spread through the
@@ -20,7 +35,11 @@ import (
 //
 // Methods that return SQL emit `?` placeholders; the caller's driver
 // translates them (GORM rewrites `?` to `$N` for Postgres).
-type sqlDialect interface {
+//
+// Databases beyond the built-in ones plug in by implementing Dialect and
+// calling RegisterDialect, usually from an init function. The lucenetest
+// package checks a dialect against every query shape the renderer produces.
+type Dialect interface {
 	// Name is the provider string callers pass to NewSQLDriver.
 	Name() string
 
@@ -29,7 +48,8 @@ type sqlDialect interface {
 
 	// JSONExtract renders access to a subfield of a JSON column. base is an
 	// already-quoted column reference; subField has already been validated
-	// against jsonSubFieldPattern.
+	// to hold only letters, digits, underscores and dots, so it can be
+	// interpolated into a path literal.
 	JSONExtract(base, subField string) string
 
 	// ScalarLike renders case-insensitive pattern matching on a single-valued
@@ -43,34 +63,66 @@ type sqlDialect interface {
 	// ArrayContains renders an exact-match containment test against a
 	// multi-valued column, binding one parameter produced by EncodeElement.
 	//
-	// A NULL column must compare false rather than NULL, so that NOT over this
-	// expression is a true complement instead of silently dropping those rows.
+	// A NULL column may yield NULL or false. It must not yield true, and a
+	// negation goes through NotTrue, which treats NULL as not matching.
 	ArrayContains(col string) string
 
+	// ArrayUnnest renders a join that yields one row per element of the
+	// multi-valued column col, exposing the element as alias.value. A NULL or
+	// empty column yields no rows. Used for facet counts over array fields.
+	ArrayUnnest(col, alias string) string
+
 	// EncodeElement converts a validated element into the bound parameter this
-	// database needs for ArrayContains. The three implementations differ
-	// completely: Postgres needs a driver.Valuer array literal, MySQL needs
-	// JSON scalar text, SQLite needs the native Go value.
-	EncodeElement(v elemValue) (any, error)
+	// database needs for ArrayContains. v.Val is always an int64, float64,
+	// bool or string. The built-in implementations differ completely:
+	// Postgres needs a driver.Valuer array literal, MySQL needs JSON scalar
+	// text, SQLite needs the native Go value.
+	EncodeElement(v ElemValue) (any, error)
 
 	// Fuzzy renders approximate matching, or returns an error naming the
 	// limitation when the database has no equivalent.
 	Fuzzy(col, term string) (string, error)
+
+	// NotTrue renders a predicate that holds when predicate is false or
+	// NULL. A NOT over a subtree containing array containment renders with
+	// it, so rows whose array column is NULL are included in the complement.
+	NotTrue(predicate string) string
 }
 
 // dialects is the single enumeration of supported providers. Registration
 // happens in each dialect file's init, so adding a database is adding a file.
-var dialects = map[string]sqlDialect{}
+var (
+	dialectsMu sync.RWMutex
+	dialects   = map[string]Dialect{}
+)
 
-func registerDialect(d sqlDialect) {
-	if _, dup := dialects[d.Name()]; dup {
-		panic(fmt.Sprintf("lucene: duplicate dialect registration for %q", d.Name()))
+// RegisterDialect makes d available under d.Name() to ParseToSQL,
+// ParseFacetsToSQL and NewSQLDriver.
+//
+// Like database/sql.Register, it panics if d is nil, has an empty name, or
+// reuses a registered name; a built-in dialect cannot be replaced. Those are
+// programming errors that should stop an init function rather than surface
+// at the first query.
+func RegisterDialect(d Dialect) {
+	if d == nil {
+		panic("lucene: RegisterDialect called with a nil dialect")
+	}
+	name := d.Name()
+	if name == "" {
+		panic("lucene: RegisterDialect called with an unnamed dialect")
 	}
-	dialects[d.Name()] = d
+	dialectsMu.Lock()
+	defer dialectsMu.Unlock()
+	if _, dup := dialects[name]; dup {
+		panic(fmt.Sprintf("lucene: duplicate dialect registration for %q", name))
+	}
+	dialects[name] = d
 }
 
 // dialectNames returns the supported provider names in sorted order.
 func dialectNames() []string {
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
 	names := make([]string, 0, len(dialects))
 	for n := range dialects {
 		names = append(names, n)
@@ -79,11 +131,15 @@ func dialectNames() []string {
 	return names
 }
 
-// lookupDialect resolves a provider name. It replaces the hardcoded allowlist
-// that used to sit in validateProvider, so the registry is the only place a
-// provider name appears.
-func lookupDialect(name string) (sqlDialect, error) {
+// LookupDialect resolves a provider name to its registered dialect. The error
+// for an unknown name lists the registered ones.
+//
+// It replaces the hardcoded allowlist that used to sit in validateProvider,
+// so the registry is the only place a provider name appears.
+func LookupDialect(name string) (Dialect, error) {
+	dialectsMu.RLock()
 	d, ok := dialects[name]
+	dialectsMu.RUnlock()
 	if !ok {
 		return nil, fmt.Errorf("unsupported SQL provider: %s (supported: %s)",
 			name, strings.Join(dialectNames(), ", "))
@@ -6,7 +6,7 @@ import (
 	"strings"
 )
 
-func init() { registerDialect(mysqlDialect{}) }
+func init() { RegisterDialect(mysqlDialect{}) }
 
 type mysqlDialect struct{}
 
@@ -37,12 +37,23 @@ func (mysqlDialect) ArrayWildcard(col string) string {
 	return fmt.Sprintf("JSON_SEARCH(LOWER(CAST(%s AS CHAR)), 'one', LOWER(?)) IS NOT NULL", col)
 }
 
+// ArrayUnnest reads every element as text. JSON_TABLE needs a declared column
+// type and a JSON-typed column would group "5" and 5 apart, so text is the one
+// type that buckets every element kind consistently.
+func (mysqlDialect) ArrayUnnest(col, alias string) string {
+	return fmt.Sprintf("CROSS JOIN JSON_TABLE(%s, '$[*]' COLUMNS (value VARCHAR(512) PATH '$')) AS %s", col, alias)
+}
+
 func (mysqlDialect) Fuzzy(col, term string) (string, error) {
 	// SOUNDEX is phonetic rather than edit-distance, and is the closest
 	// built-in MySQL offers.
 	return fmt.Sprintf("SOUNDEX(%s) = SOUNDEX(%s)", col, term), nil
 }
 
+func (mysqlDialect) NotTrue(predicate string) string {
+	return fmt.Sprintf("(%s) IS NOT TRUE", predicate)
+}
+
 // ArrayContains binds JSON scalar text, which is one SQL form for every
 // element type. The older code needed two branches — CAST(? AS JSON) for
 // numerics and JSON_QUOTE(?) for strings — because it bound a bare literal.
@@ -59,7 +70,7 @@ func (mysqlDialect) ArrayContains(col string) string {
 // Binding a Go bool here would be silently WRONG: the driver sends true as 1,
 // and the JSON number 1 does not equal JSON true, so a matching row would not
 // match. json.Marshal produces the correct scalar for every Val type.
-func (mysqlDialect) EncodeElement(v elemValue) (any, error) {
+func (mysqlDialect) EncodeElement(v ElemValue) (any, error) {
 	b, err := json.Marshal(v.Val)
 	if err != nil {
 		return nil, fmt.Errorf("cannot encode array element %v: %w", v.Val, err)
@@ -6,7 +6,7 @@ import (
 	"strings"
 )
 
-func init() { registerDialect(postgresDialect{}) }
+func init() { RegisterDialect(postgresDialect{}) }
 
 type postgresDialect struct{}
 
@@ -34,6 +34,10 @@ func (postgresDialect) ArrayWildcard(col string) string {
 	return fmt.Sprintf("EXISTS (SELECT 1 FROM unnest(%s) AS elem WHERE elem ILIKE ?)", col)
 }
 
+func (postgresDialect) ArrayUnnest(col, alias string) string {
+	return fmt.Sprintf("CROSS JOIN LATERAL unnest(%s) AS %s(value)", col, alias)
+}
+
 func (postgresDialect) Fuzzy(col, term string) (string, error) {
 	// similarity() comes from the pg_trgm extension. Threshold 0.3: lower
 	// matches more.
@@ -44,6 +48,10 @@ func (postgresDialect) Fuzzy(col, term string) (string, error) {
 	return fmt.Sprintf("similarity(%s::text, %s) > %f", col, term, threshold), nil
 }
 
+func (postgresDialect) NotTrue(predicate string) string {
+	return fmt.Sprintf("(%s) IS NOT TRUE", predicate)
+}
+
 // ArrayContains binds the whole array as ONE parameter, which is what lets
 // Postgres infer the element type from the column.
 //
@@ -66,7 +74,7 @@ func (postgresDialect) ArrayContains(col string) string {
 	return fmt.Sprintf("%s @> ?", col)
 }
 
-func (postgresDialect) EncodeElement(v elemValue) (any, error) {
+func (postgresDialect) EncodeElement(v ElemValue) (any, error) {
 	return pgArrayLiteral{v.Val}, nil
 }
 
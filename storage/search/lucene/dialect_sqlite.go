@@ -5,7 +5,7 @@ import (
 	"strings"
 )
 
-func init() { registerDialect(sqliteDialect{}) }
+func init() { RegisterDialect(sqliteDialect{}) }
 
 type sqliteDialect struct{}
 
@@ -28,10 +28,18 @@ func (sqliteDialect) ArrayWildcard(col string) string {
 	return fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s) WHERE value LIKE ?)", col)
 }
 
+func (sqliteDialect) ArrayUnnest(col, alias string) string {
+	return fmt.Sprintf("CROSS JOIN json_each(%s) AS %s", col, alias)
+}
+
 func (sqliteDialect) Fuzzy(col, term string) (string, error) {
 	return "", fmt.Errorf("fuzzy search (field:term~N) is not supported with SQLite; use wildcards instead (e.g., field:term*)")
 }
 
+func (sqliteDialect) NotTrue(predicate string) string {
+	return fmt.Sprintf("(%s) IS NOT TRUE", predicate)
+}
+
 // ArrayContains compares json_each.value against a natively-bound parameter.
 // json_each yields numbers as numbers and booleans as 1/0, which is exactly
 // what the driver sends for an int64, float64 or bool, so the older
@@ -42,4 +50,4 @@ func (sqliteDialect) ArrayContains(col string) string {
 
 // EncodeElement passes the value through: int64, float64, bool and string are
 // all valid driver.Value types.
-func (sqliteDialect) EncodeElement(v elemValue) (any, error) { return v.Val, nil }
+func (sqliteDialect) EncodeElement(v ElemValue) (any, error) { return v.Val, nil }
@@ -0,0 +1,81 @@
+package lucene
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+func init() { RegisterDialect(sqlserverDialect{}) }
+
+type sqlserverDialect struct{}
+
+func (sqlserverDialect) Name() string { return "sqlserver" }
+
+func (sqlserverDialect) QuoteIdent(col string) string {
+	// Brackets work whatever QUOTED_IDENTIFIER is set to; double quotes only
+	// quote when it is ON, and are a string literal otherwise.
+	return "[" + strings.ReplaceAll(col, "]", "]]") + "]"
+}
+
+func (sqlserverDialect) JSONExtract(base, subField string) string {
+	// JSON_VALUE returns NULL for a missing path in lax mode, the default,
+	// which matches ->> and JSON_EXTRACT on the other databases.
+	return fmt.Sprintf("JSON_VALUE(%s, '$.%s')", base, subField)
+}
+
+func (sqlserverDialect) ScalarLike(left, right string) string {
+	// Case sensitivity follows the column's collation, and a database created
+	// with a _CS_ collation is common enough that the default cannot be
+	// relied on.
+	return fmt.Sprintf("LOWER(%s) LIKE LOWER(%s)", left, right)
+}
+
+func (sqlserverDialect) ArrayWildcard(col string) string {
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM OPENJSON(%s) WHERE LOWER([value]) LIKE LOWER(?))", col)
+}
+
+// ArrayUnnest exposes OPENJSON's own value column, which is nvarchar for every
+// element type, so mixed arrays bucket as text like MySQL's.
+func (sqlserverDialect) ArrayUnnest(col, alias string) string {
+	return fmt.Sprintf("CROSS APPLY OPENJSON(%s) AS %s", col, alias)
+}
+
+func (sqlserverDialect) Fuzzy(col, term string) (string, error) {
+	// DIFFERENCE compares the SOUNDEX codes of both sides and returns 0-4
+	// matching characters. 4 would be plain SOUNDEX equality; 3 also admits
+	// a one-character difference, which is closer to what ~ means. Like
+	// MySQL, the distance hint is ignored.
+	return fmt.Sprintf("DIFFERENCE(%s, %s) >= 3", col, term), nil
+}
+
+// ArrayContains compares against OPENJSON's value column, which yields every
+// scalar as nvarchar text, so on its own the number 5 and the string "5"
+// would compare equal. Prefixing the value by its JSON type (1 is a string)
+// keeps the match typed, as it is on the other databases, while still binding
+// a single parameter. A NULL or empty column yields no rows, so EXISTS is
+// false rather than NULL.
+func (sqlserverDialect) ArrayContains(col string) string {
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM OPENJSON(%s) WHERE IIF([type] = 1, 's:', 'v:') + [value] = ?)", col)
+}
+
+// EncodeElement produces the prefixed text ArrayContains compares against:
+// s:golang for a string, and v: followed by the JSON scalar otherwise. OPENJSON
+// returns strings unescaped, so only non-strings go through json.Marshal.
+func (sqlserverDialect) EncodeElement(v ElemValue) (any, error) {
+	if s, ok := v.Val.(string); ok {
+		return "s:" + s, nil
+	}
+	b, err := json.Marshal(v.Val)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode array element %v: %w", v.Val, err)
+	}
+	return "v:" + string(b), nil
+}
+
+// NotTrue emulates IS NOT TRUE, which T-SQL lacks. A predicate is not a value
+// there either, so it cannot be compared directly; CASE turns it into one, and
+// its ELSE branch covers both false and unknown.
+func (sqlserverDialect) NotTrue(predicate string) string {
+	return fmt.Sprintf("CASE WHEN (%s) THEN 0 ELSE 1 END = 1", predicate)
+}
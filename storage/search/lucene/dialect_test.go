@@ -12,18 +12,18 @@ import (
 // a provider name is enumerated: the allowlist that used to live in
 // validateProvider is gone, so an unregistered name can only fail here.
 func TestDialectRegistry(t *testing.T) {
-	for _, name := range []string{"postgresql", "mysql", "sqlite"} {
-		d, err := lookupDialect(name)
+	for _, name := range []string{"postgresql", "mysql", "sqlite", "sqlserver"} {
+		d, err := LookupDialect(name)
 		if err != nil {
-			t.Fatalf("lookupDialect(%q): %v", name, err)
+			t.Fatalf("LookupDialect(%q): %v", name, err)
 		}
 		if d.Name() != name {
 			t.Errorf("dialect %q reports Name() = %q", name, d.Name())
 		}
 	}
 
-	if _, err := lookupDialect("oracle"); err == nil {
-		t.Error("lookupDialect(oracle) returned no error")
+	if _, err := LookupDialect("oracle"); err == nil {
+		t.Error("LookupDialect(oracle) returned no error")
 	} else if !strings.Contains(err.Error(), "postgresql") {
 		t.Errorf("error should list the supported providers, got: %v", err)
 	}
@@ -50,7 +50,7 @@ func TestNewSQLDriverRejectsUnknownProvider(t *testing.T) {
 // here instead.
 func TestEveryDialectImplementsEveryOperation(t *testing.T) {
 	for _, name := range dialectNames() {
-		d, err := lookupDialect(name)
+		d, err := LookupDialect(name)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -70,10 +70,13 @@ func TestEveryDialectImplementsEveryOperation(t *testing.T) {
 			if got := d.ArrayContains("col"); !strings.Contains(got, "?") || !strings.Contains(got, "col") {
 				t.Errorf("ArrayContains(col) = %q; must reference the column and the parameter", got)
 			}
+			if got := d.ArrayUnnest("col", "elem"); !strings.Contains(got, "col") || !strings.Contains(got, "elem") {
+				t.Errorf("ArrayUnnest(col, elem) = %q; must reference the column and the alias", got)
+			}
 			// EncodeElement must produce something derived from the value for
-			// each type in elemValue's closed set. A dialect that discarded
+			// each type in ElemValue's closed set. A dialect that discarded
 			// the value would otherwise pass this guard.
-			for _, v := range []elemValue{
+			for _, v := range []ElemValue{
 				{Kind: reflect.String, Val: "golang"},
 				{Kind: reflect.Int, Val: int64(5)},
 				{Kind: reflect.Float64, Val: 1.5},
@@ -123,9 +126,11 @@ func TestDialectQuoteIdent(t *testing.T) {
 		{"sqlite", `we"ird`, `"we""ird"`},
 		{"mysql", "name", "`name`"},
 		{"mysql", "we`ird", "`we``ird`"},
+		{"sqlserver", "name", "[name]"},
+		{"sqlserver", "we]ird", "[we]]ird]"},
 	}
 	for _, tt := range tests {
-		d, err := lookupDialect(tt.provider)
+		d, err := LookupDialect(tt.provider)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -143,9 +148,10 @@ func TestDialectJSONExtract(t *testing.T) {
 		{"postgresql", `"labels"->>'category'`},
 		{"mysql", "JSON_UNQUOTE(JSON_EXTRACT(`labels`, '$.category'))"},
 		{"sqlite", `JSON_EXTRACT("labels", '$.category')`},
+		{"sqlserver", "JSON_VALUE([labels], '$.category')"},
 	}
 	for _, tt := range tests {
-		d, err := lookupDialect(tt.provider)
+		d, err := LookupDialect(tt.provider)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -240,20 +246,20 @@ func TestPGArrayLiteralEscaping(t *testing.T) {
 // the driver sends true as 1, CAST('1' AS JSON) is the JSON number 1, and
 // JSON 1 does not equal JSON true, so a matching row would not match.
 func TestMySQLEncodesJSONText(t *testing.T) {
-	d, err := lookupDialect("mysql")
+	d, err := LookupDialect("mysql")
 	if err != nil {
 		t.Fatal(err)
 	}
 	tests := []struct {
-		val  elemValue
+		val  ElemValue
 		want string
 	}{
-		{elemValue{Kind: reflect.Int, Val: int64(5)}, "5"},
-		{elemValue{Kind: reflect.Float64, Val: 1.5}, "1.5"},
-		{elemValue{Kind: reflect.Bool, Val: true}, "true"},
-		{elemValue{Kind: reflect.Bool, Val: false}, "false"},
-		{elemValue{Kind: reflect.String, Val: "golang"}, `"golang"`},
-		{elemValue{Kind: reflect.String, Val: `has"quote`}, `"has\"quote"`},
+		{ElemValue{Kind: reflect.Int, Val: int64(5)}, "5"},
+		{ElemValue{Kind: reflect.Float64, Val: 1.5}, "1.5"},
+		{ElemValue{Kind: reflect.Bool, Val: true}, "true"},
+		{ElemValue{Kind: reflect.Bool, Val: false}, "false"},
+		{ElemValue{Kind: reflect.String, Val: "golang"}, `"golang"`},
+		{ElemValue{Kind: reflect.String, Val: `has"quote`}, `"has\"quote"`},
 	}
 	for _, tt := range tests {
 		got, err := d.EncodeElement(tt.val)
@@ -269,18 +275,18 @@ func TestMySQLEncodesJSONText(t *testing.T) {
 // SQLite compares json_each.value against a natively-bound parameter, so the
 // value must arrive with its Go type intact rather than as a string.
 func TestSQLiteEncodesNativeValue(t *testing.T) {
-	d, err := lookupDialect("sqlite")
+	d, err := LookupDialect("sqlite")
 	if err != nil {
 		t.Fatal(err)
 	}
 	tests := []struct {
-		val  elemValue
+		val  ElemValue
 		want any
 	}{
-		{elemValue{Kind: reflect.Int, Val: int64(5)}, int64(5)},
-		{elemValue{Kind: reflect.Float64, Val: 1.5}, 1.5},
-		{elemValue{Kind: reflect.Bool, Val: true}, true},
-		{elemValue{Kind: reflect.String, Val: "golang"}, "golang"},
+		{ElemValue{Kind: reflect.Int, Val: int64(5)}, int64(5)},
+		{ElemValue{Kind: reflect.Float64, Val: 1.5}, 1.5},
+		{ElemValue{Kind: reflect.Bool, Val: true}, true},
+		{ElemValue{Kind: reflect.String, Val: "golang"}, "golang"},
 	}
 	for _, tt := range tests {
 		got, err := d.EncodeElement(tt.val)
@@ -293,6 +299,89 @@ func TestSQLiteEncodesNativeValue(t *testing.T) {
 	}
 }
 
+// SQL Server compares against OPENJSON's value column, which is text for every
+// element type. The s:/v: prefix stands in for the type: without it the string
+// "5" would match the number 5 and "true" would match true.
+func TestSQLServerEncodesTypedText(t *testing.T) {
+	d, err := LookupDialect("sqlserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		val  ElemValue
+		want string
+	}{
+		{ElemValue{Kind: reflect.Int, Val: int64(5)}, "v:5"},
+		{ElemValue{Kind: reflect.Float64, Val: 1.5}, "v:1.5"},
+		{ElemValue{Kind: reflect.Bool, Val: true}, "v:true"},
+		{ElemValue{Kind: reflect.String, Val: "golang"}, "s:golang"},
+		{ElemValue{Kind: reflect.String, Val: "5"}, "s:5"},
+		// OPENJSON unescapes strings, so the bound text must not be escaped.
+		{ElemValue{Kind: reflect.String, Val: `has"quote`}, `s:has"quote`},
+	}
+	for _, tt := range tests {
+		got, err := d.EncodeElement(tt.val)
+		if err != nil {
+			t.Fatalf("EncodeElement(%#v): %v", tt.val, err)
+		}
+		if got != tt.want {
+			t.Errorf("EncodeElement(%#v) = %#v, want %q", tt.val, got, tt.want)
+		}
+	}
+}
+
+// T-SQL has no boolean type: TRUE, IS NOT TRUE and a bare predicate used as a
+// value are all syntax errors there, so every construct the renderer emits
+// for the other databases has to come out in a form SQL Server accepts.
+func TestSQLServerRenderingGolden(t *testing.T) {
+	type doc struct {
+		Id     string            `json:"id"`
+		Name   string            `json:"name"`
+		Age    int               `json:"age"`
+		Tags   []string          `json:"tags"`
+		Nums   []int             `json:"nums"`
+		Labels map[string]string `json:"labels"`
+	}
+	p, err := NewParser(doc{})
+	if err != nil {
+		t.Fatalf("NewParser: %v", err)
+	}
+
+	contains := "EXISTS (SELECT 1 FROM OPENJSON([tags]) WHERE IIF([type] = 1, 's:', 'v:') + [value] = ?)"
+	tests := []struct {
+		filter, want string
+		params       []any
+	}{
+		{"name:ada", "[name] = ?", []any{"ada"}},
+		{"name:ad*", "LOWER([name]) LIKE LOWER(?)", []any{"ad%"}},
+		{"age:[1 TO 5]", "[age] BETWEEN ? AND ?", []any{1, 5}},
+		{"labels.env:prod", "JSON_VALUE([labels], '$.env') = ?", []any{"prod"}},
+		{"tags:go", contains, []any{"s:go"}},
+		{"nums:5", "EXISTS (SELECT 1 FROM OPENJSON([nums]) WHERE IIF([type] = 1, 's:', 'v:') + [value] = ?)", []any{"v:5"}},
+		{"tags:*go*", "EXISTS (SELECT 1 FROM OPENJSON([tags]) WHERE LOWER([value]) LIKE LOWER(?))", []any{"%go%"}},
+		{"-tags:go", "CASE WHEN (" + contains + ") THEN 0 ELSE 1 END = 1", []any{"s:go"}},
+		{"name:ada AND NOT (tags:go OR age:3)",
+			"([name] = ?) AND (CASE WHEN ((" + contains + ") OR ([age] = ?)) THEN 0 ELSE 1 END = 1)",
+			[]any{"ada", "s:go", 3}},
+		{"name:roam~2", "DIFFERENCE([name], ?) >= 3", []any{"roam"}},
+		{"-name:null", "[name] IS NOT NULL", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			where, params, err := p.ParseToSQL(tt.filter, "sqlserver")
+			if err != nil {
+				t.Fatalf("ParseToSQL(%q): %v", tt.filter, err)
+			}
+			if where != tt.want {
+				t.Errorf("ParseToSQL(%q) =\n  %s\nwant\n  %s", tt.filter, where, tt.want)
+			}
+			if fmt.Sprint(params) != fmt.Sprint(tt.params) {
+				t.Errorf("ParseToSQL(%q) params = %v, want %v", tt.filter, params, tt.params)
+			}
+		})
+	}
+}
+
 // The JSON base column must be quoted at the point of extraction.
 //
 // It cannot be quoted later: the rendered expression contains ->> or
@@ -324,6 +413,8 @@ func TestJSONExtractQuotesBaseColumn(t *testing.T) {
 		{"mysql", "Mixed.category:x", "JSON_UNQUOTE(JSON_EXTRACT(`Mixed`, '$.category'))"},
 		{"mysql", "order.category:x", "JSON_UNQUOTE(JSON_EXTRACT(`order`, '$.category'))"},
 		{"sqlite", "Mixed.category:x", `JSON_EXTRACT("Mixed", '$.category')`},
+		{"sqlserver", "Mixed.category:x", "JSON_VALUE([Mixed], '$.category')"},
+		{"sqlserver", "order.category:x", "JSON_VALUE([order], '$.category')"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.provider+" "+tt.filter, func(t *testing.T) {
@@ -347,12 +438,12 @@ func TestJSONExtractIsNotDoubleQuoted(t *testing.T) {
 		Labels map[string]string `json:"labels"`
 	}
 	p, _ := NewParser(doc{})
-	for _, prov := range []string{"postgresql", "mysql", "sqlite"} {
+	for _, prov := range []string{"postgresql", "mysql", "sqlite", "sqlserver"} {
 		where, _, err := p.ParseToSQL("labels.category:x", prov)
 		if err != nil {
 			t.Fatalf("%s: %v", prov, err)
 		}
-		if strings.Contains(where, `""`) || strings.Contains(where, "``") {
+		if strings.Contains(where, `""`) || strings.Contains(where, "``") || strings.Contains(where, "[[") {
 			t.Errorf("%s produced a doubled quote: %q", prov, where)
 		}
 	}
@@ -0,0 +1,101 @@
+package lucene
+
+import (
+	"fmt"
+)
+
+// Facet queries count the rows matching a Lucene filter per distinct value of
+// a field. They reuse the WHERE clause ParseToSQL renders, so a facet can never
+// disagree with the search it decorates about which rows matched.
+//
+// Every rendered query returns two columns, facet_value and facet_count,
+// ordered by count (highest first) and then by value so results are stable.
+
+// facetElemAlias names the per-element row source produced by ArrayUnnest.
+const facetElemAlias = "facet_elem"
+
+// FacetQuery is the SQL counting one facet field.
+type FacetQuery struct {
+	Field  string
+	SQL    string
+	Params []any
+}
+
+// FacetField validates that name can be faceted on and returns its metadata.
+//
+// Unknown fields return *InvalidFieldError, matching the query path, so a
+// caller can map both to a 400 the same way. Nested (JSONB, map, struct)
+// fields are rejected: their values are documents, not buckets.
+func (p *Parser) FacetField(name string) (FieldInfo, error) {
+	info, ok := p.fieldMap[name]
+	if !ok {
+		return FieldInfo{}, &InvalidFieldError{Field: name, ValidFields: p.getValidFieldNames()}
+	}
+	if canUseNestedAccess(info.Type) {
+		return FieldInfo{}, fmt.Errorf("field '%s' cannot be used as a facet: nested fields have no single value to count", name)
+	}
+	return info, nil
+}
+
+// IsArrayField reports whether info describes a multi-valued column, whose
+// facet counts are per element rather than per row.
+func IsArrayField(info FieldInfo) bool {
+	return isArrayField(info.Type)
+}
+
+// ParseFacetsToSQL renders one counting query per facet field for the rows
+// matching query (every row when query is empty).
+//
+// from is the table reference, already quoted for provider (callers using GORM
+// get it from Statement.Quote), and is interpolated as is.
+//
+// Scalar fields group by the column. Array fields are unnested first, so
+// tags:["go","db"] contributes one to each of "go" and "db"; an element that
+// repeats within one row is counted once per occurrence. The filter is applied
+// in a derived table before unnesting, which keeps the element source's own
+// column names (SQLite's json_each exposes id, key, value, ...) from shadowing
+// the model's columns in the WHERE clause.
+func (p *Parser) ParseFacetsToSQL(query string, from string, fields []string, provider string) ([]FacetQuery, error) {
+	dialect, err := LookupDialect(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	where, params := "", []any(nil)
+	if query != "" {
+		where, params, err = p.ParseToSQL(query, provider)
+		if err != nil {
+			return nil, err
+		}
+	}
+	filtered := from
+	if where != "" {
+		filtered = fmt.Sprintf("%s WHERE %s", from, where)
+	}
+
+	out := make([]FacetQuery, 0, len(fields))
+	for _, name := range fields {
+		info, err := p.FacetField(name)
+		if err != nil {
+			return nil, err
+		}
+		col := dialect.QuoteIdent(name)
+
+		var sql string
+		if isArrayField(info.Type) {
+			elem := facetElemAlias + ".value"
+			sql = fmt.Sprintf(
+				"SELECT %s AS facet_value, COUNT(*) AS facet_count FROM (SELECT %s AS facet_col FROM %s) AS facet_src %s GROUP BY %s ORDER BY facet_count DESC, facet_value",
+				elem, col, filtered, dialect.ArrayUnnest("facet_src.facet_col", facetElemAlias), elem,
+			)
+		} else {
+			sql = fmt.Sprintf(
+				"SELECT %s AS facet_value, COUNT(*) AS facet_count FROM %s GROUP BY %s ORDER BY facet_count DESC, facet_value",
+				col, filtered, col,
+			)
+		}
+		// Each query binds its own copy: callers run them independently.
+		out = append(out, FacetQuery{Field: name, SQL: sql, Params: append([]any(nil), params...)})
+	}
+	return out, nil
+}
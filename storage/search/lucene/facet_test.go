@@ -0,0 +1,142 @@
+package lucene
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseFacetsToSQLGolden(t *testing.T) {
+	p, err := NewParser(Article{})
+	if err != nil {
+		t.Fatalf("NewParser: %v", err)
+	}
+
+	tests := []struct {
+		provider, from, field, want string
+	}{
+		{
+			"postgresql", `"articles"`, "title",
+			`SELECT "title" AS facet_value, COUNT(*) AS facet_count FROM "articles" WHERE "title" = ? GROUP BY "title" ORDER BY facet_count DESC, facet_value`,
+		},
+		{
+			"postgresql", `"articles"`, "tags",
+			`SELECT facet_elem.value AS facet_value, COUNT(*) AS facet_count FROM (SELECT "tags" AS facet_col FROM "articles" WHERE "title" = ?) AS facet_src CROSS JOIN LATERAL unnest(facet_src.facet_col) AS facet_elem(value) GROUP BY facet_elem.value ORDER BY facet_count DESC, facet_value`,
+		},
+		{
+			"sqlite", `"articles"`, "tags",
+			`SELECT facet_elem.value AS facet_value, COUNT(*) AS facet_count FROM (SELECT "tags" AS facet_col FROM "articles" WHERE "title" = ?) AS facet_src CROSS JOIN json_each(facet_src.facet_col) AS facet_elem GROUP BY facet_elem.value ORDER BY facet_count DESC, facet_value`,
+		},
+		{
+			"mysql", "`articles`", "tags",
+			"SELECT facet_elem.value AS facet_value, COUNT(*) AS facet_count FROM (SELECT `tags` AS facet_col FROM `articles` WHERE `title` = ?) AS facet_src CROSS JOIN JSON_TABLE(facet_src.facet_col, '$[*]' COLUMNS (value VARCHAR(512) PATH '$')) AS facet_elem GROUP BY facet_elem.value ORDER BY facet_count DESC, facet_value",
+		},
+		{
+			"sqlserver", "[articles]", "tags",
+			"SELECT facet_elem.value AS facet_value, COUNT(*) AS facet_count FROM (SELECT [tags] AS facet_col FROM [articles] WHERE [title] = ?) AS facet_src CROSS APPLY OPENJSON(facet_src.facet_col) AS facet_elem GROUP BY facet_elem.value ORDER BY facet_count DESC, facet_value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider+"/"+tt.field, func(t *testing.T) {
+			got, err := p.ParseFacetsToSQL("title:hello", tt.from, []string{tt.field}, tt.provider)
+			if err != nil {
+				t.Fatalf("ParseFacetsToSQL: %v", err)
+			}
+			if len(got) != 1 {
+				t.Fatalf("len = %d; want 1", len(got))
+			}
+			if got[0].SQL != tt.want {
+				t.Errorf("SQL =\n  %s\nwant\n  %s", got[0].SQL, tt.want)
+			}
+			if len(got[0].Params) != 1 || got[0].Params[0] != "hello" {
+				t.Errorf("Params = %v; want [hello]", got[0].Params)
+			}
+		})
+	}
+}
+
+func TestParseFacetsToSQLEmptyQueryHasNoWhere(t *testing.T) {
+	p, err := NewParser(Article{})
+	if err != nil {
+		t.Fatalf("NewParser: %v", err)
+	}
+	got, err := p.ParseFacetsToSQL("", `"articles"`, []string{"title"}, "sqlite")
+	if err != nil {
+		t.Fatalf("ParseFacetsToSQL: %v", err)
+	}
+	want := `SELECT "title" AS facet_value, COUNT(*) AS facet_count FROM "articles" GROUP BY "title" ORDER BY facet_count DESC, facet_value`
+	if got[0].SQL != want || len(got[0].Params) != 0 {
+		t.Fatalf("got %q %v; want %q with no params", got[0].SQL, got[0].Params, want)
+	}
+}
+
+func TestParseFacetsToSQLRejectsUnknownAndNestedFields(t *testing.T) {
+	type withLabels struct {
+		Name   string         `json:"name"`
+		Labels map[string]any `json:"labels"`
+	}
+	p, err := NewParser(withLabels{})
+	if err != nil {
+		t.Fatalf("NewParser: %v", err)
+	}
+
+	_, err = p.ParseFacetsToSQL("", `"t"`, []string{"missing"}, "sqlite")
+	var invalid *InvalidFieldError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("unknown field: err = %v; want *InvalidFieldError", err)
+	}
+	if _, err := p.ParseFacetsToSQL("", `"t"`, []string{"labels"}, "sqlite"); err == nil {
+		t.Fatalf("nested field: expected an error")
+	}
+}
+
+// Facet counts must execute, and the array case must count per element while
+// honouring the filter. Fixture rows are described on newExecDB.
+func TestExecuted_FacetCounts(t *testing.T) {
+	db := newExecDB(t)
+	p, err := NewParser(Article{})
+	if err != nil {
+		t.Fatalf("NewParser: %v", err)
+	}
+
+	run := func(query, field string) map[string]int {
+		t.Helper()
+		qs, err := p.ParseFacetsToSQL(query, "articles", []string{field}, "sqlite")
+		if err != nil {
+			t.Fatalf("ParseFacetsToSQL: %v", err)
+		}
+		rows, err := db.Query(qs[0].SQL, qs[0].Params...)
+		if err != nil {
+			t.Fatalf("executing %q: %v", qs[0].SQL, err)
+		}
+		defer rows.Close()
+		counts := map[string]int{}
+		for rows.Next() {
+			var v *string
+			var n int
+			if err := rows.Scan(&v, &n); err != nil {
+				t.Fatalf("scan: %v", err)
+			}
+			key := "<nil>"
+			if v != nil {
+				key = *v
+			}
+			counts[key] = n
+		}
+		return counts
+	}
+
+	tags := run("", "tags")
+	if len(tags) != 3 || tags["golang"] != 1 || tags["gopher"] != 1 || tags["rust"] != 1 {
+		t.Errorf("tags facet = %v; want golang, gopher and rust once each", tags)
+	}
+
+	filtered := run("title:hello", "tags")
+	if len(filtered) != 2 || filtered["rust"] != 0 {
+		t.Errorf("filtered tags facet = %v; want only golang and gopher", filtered)
+	}
+
+	titles := run("tags:*", "title")
+	if len(titles) != 3 || titles["nulltag"] != 0 {
+		t.Errorf("title facet = %v; want the three rows with a tags value", titles)
+	}
+}
@@ -0,0 +1,304 @@
+// Package lucenetest checks a lucene.Dialect against every query shape the
+// SQL renderer produces.
+//
+// A third-party dialect registers itself with lucene.RegisterDialect and then
+// calls Run from a test:
+//
+//	func TestDuckDBDialect(t *testing.T) {
+//		lucenetest.Run(t, duckdb.Dialect{}, lucenetest.Options{})
+//	}
+//
+// Without a database, Run checks what can be checked from the SQL text: every
+// shape renders, placeholders and parameters agree, identifiers are quoted
+// and array elements are encoded. That catches a dialect that ignores an
+// argument, but not one that renders valid SQL meaning the wrong thing. For
+// that, create a table holding Fixture and pass its *sql.DB in Options; Run
+// then executes every query and compares the rows it returns.
+package lucenetest
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tink3rlabs/magic/storage/search/lucene"
+)
+
+// Doc is the model every conformance query is parsed against. Its fields
+// cover each kind the renderer treats differently: scalar text and numbers,
+// string, numeric and boolean arrays, and a JSON document.
+type Doc struct {
+	Id     string            `json:"id"`
+	Title  string            `json:"title"`
+	Rank   int               `json:"rank"`
+	Tags   []string          `json:"tags"`
+	Nums   []int             `json:"nums"`
+	Flags  []bool            `json:"flags"`
+	Labels map[string]string `json:"labels"`
+}
+
+// Fixture is the content Options.Table must hold for a database-backed run.
+// Arrays and Labels are stored however the database stores them (a native
+// array on Postgres, JSON text elsewhere); a nil slice or map is SQL NULL.
+var Fixture = []Doc{
+	{Id: "1", Title: "hello", Rank: 1, Tags: []string{"golang", "gopher"}, Nums: []int{5, 7}, Flags: []bool{true}, Labels: map[string]string{"env": "prod"}},
+	{Id: "2", Title: "world", Rank: 2, Tags: []string{"rust"}, Nums: []int{9}, Flags: []bool{false}, Labels: map[string]string{"env": "dev"}},
+	{Id: "3", Title: "Hello Again", Rank: 3, Tags: []string{}, Nums: []int{}, Flags: []bool{}, Labels: map[string]string{}},
+	{Id: "4", Title: "gopher", Rank: 4},
+}
+
+// Case is one query shape and the Fixture ids it must match.
+type Case struct {
+	Name  string
+	Query string
+	Want  []string
+
+	// Fuzzy marks the fuzzy case. A dialect may reject it, and databases
+	// disagree on what approximate means, so its rows are not compared.
+	Fuzzy bool
+}
+
+// Cases lists every query shape SQLDriver renders, one per Dialect method
+// path and per way the renderer combines them.
+var Cases = []Case{
+	{Name: "equality", Query: `title:hello`, Want: []string{"1"}},
+	{Name: "phrase", Query: `title:"Hello Again"`, Want: []string{"3"}},
+	{Name: "prefix wildcard is case-insensitive", Query: `title:hel*`, Want: []string{"1", "3"}},
+	{Name: "infix wildcard", Query: `title:*orl*`, Want: []string{"2"}},
+	{Name: "single-character wildcard", Query: `title:w?rld`, Want: []string{"2"}},
+	{Name: "implicit field", Query: `hello`, Want: []string{"1", "3"}},
+	{Name: "inclusive range", Query: `rank:[2 TO 3]`, Want: []string{"2", "3"}},
+	{Name: "exclusive range", Query: `rank:{1 TO 3}`, Want: []string{"2"}},
+	{Name: "open range", Query: `rank:[3 TO *]`, Want: []string{"3", "4"}},
+	{Name: "comparison", Query: `rank:>2`, Want: []string{"3", "4"}},
+	{Name: "json subfield", Query: `labels.env:prod`, Want: []string{"1"}},
+	{Name: "json subfield wildcard", Query: `labels.env:d*`, Want: []string{"2"}},
+	{Name: "json subfield null", Query: `labels.env:null`, Want: []string{"3", "4"}},
+	{Name: "json subfield not null", Query: `-labels.env:null`, Want: []string{"1", "2"}},
+	{Name: "array containment", Query: `tags:golang`, Want: []string{"1"}},
+	{Name: "numeric array containment", Query: `nums:7`, Want: []string{"1"}},
+	{Name: "boolean array containment", Query: `flags:false`, Want: []string{"2"}},
+	{Name: "array wildcard", Query: `tags:*UST`, Want: []string{"2"}},
+	{Name: "array has value", Query: `tags:*`, Want: []string{"1", "2", "3"}},
+	{Name: "array null", Query: `tags:null`, Want: []string{"4"}},
+	{Name: "grouped array values", Query: `tags:(golang OR rust)`, Want: []string{"1", "2"}},
+	{Name: "negated containment includes null arrays", Query: `-tags:golang`, Want: []string{"2", "3", "4"}},
+	{Name: "NOT containment includes null arrays", Query: `NOT tags:rust`, Want: []string{"1", "3", "4"}},
+	{Name: "NOT over mixed subtree", Query: `title:hello AND NOT (tags:rust OR rank:3)`, Want: []string{"1"}},
+	{Name: "NOT scalar", Query: `NOT title:hello`, Want: []string{"2", "3", "4"}},
+	{Name: "conjunction", Query: `title:hello AND tags:golang`, Want: []string{"1"}},
+	{Name: "disjunction", Query: `title:hello OR rank:4`, Want: []string{"1", "4"}},
+	{Name: "fuzzy", Query: `title:wrold~1`, Fuzzy: true},
+}
+
+// FacetCase is a facet count over the rows matching Query.
+type FacetCase struct {
+	Name  string
+	Query string
+	Field string
+	Want  map[string]int64
+}
+
+// FacetCases cover a scalar facet and an array facet, which goes through
+// Dialect.ArrayUnnest.
+var FacetCases = []FacetCase{
+	{Name: "scalar facet", Query: `rank:[1 TO 2]`, Field: "title", Want: map[string]int64{"hello": 1, "world": 1}},
+	{Name: "array facet", Query: ``, Field: "tags", Want: map[string]int64{"golang": 1, "gopher": 1, "rust": 1}},
+}
+
+// Options configures Run.
+type Options struct {
+	// DB, when set, is used to execute every case against Table, which the
+	// caller has created and filled with Fixture.
+	DB *sql.DB
+
+	// Table names the fixture table. It is quoted with the dialect.
+	Table string
+
+	// Rebind rewrites the `?` placeholders of a rendered query into the
+	// driver's own syntax, such as $1 for pgx. Nil leaves them as they are.
+	Rebind func(query string) string
+}
+
+// Run checks d against Cases and FacetCases. d must already be registered
+// under its name: Run renders through ParseToSQL and ParseFacetsToSQL, which
+// find dialects by name, so it tests the path real callers take.
+func Run(t *testing.T, d lucene.Dialect, opts Options) {
+	t.Helper()
+	registered, err := lucene.LookupDialect(d.Name())
+	if err != nil {
+		t.Fatalf("dialect %q is not registered; call lucene.RegisterDialect from an init function: %v", d.Name(), err)
+	}
+	if reflect.TypeOf(registered) != reflect.TypeOf(d) {
+		t.Fatalf("the name %q is registered to %T, not %T", d.Name(), registered, d)
+	}
+	if opts.DB != nil && opts.Table == "" {
+		t.Fatal("Options.Table is required with Options.DB")
+	}
+
+	parser, err := lucene.NewParser(Doc{})
+	if err != nil {
+		t.Fatalf("NewParser: %v", err)
+	}
+
+	t.Run("contract", func(t *testing.T) { checkContract(t, d) })
+	for _, c := range Cases {
+		t.Run(c.Name, func(t *testing.T) { runCase(t, d, parser, opts, c) })
+	}
+	for _, c := range FacetCases {
+		t.Run(c.Name, func(t *testing.T) { runFacetCase(t, d, parser, opts, c) })
+	}
+}
+
+// checkContract calls each method directly and checks that its output is
+// built from its arguments.
+func checkContract(t *testing.T, d lucene.Dialect) {
+	quoted := d.QuoteIdent("col")
+	if quoted == "col" || !strings.Contains(quoted, "col") {
+		t.Errorf("QuoteIdent(col) = %q; must quote the identifier", quoted)
+	}
+	if got := d.JSONExtract(quoted, "sub"); !strings.Contains(got, "sub") || !strings.Contains(got, quoted) {
+		t.Errorf("JSONExtract(%s, sub) = %q; must reference the quoted base and the subfield", quoted, got)
+	}
+	if got := d.ScalarLike("a", "?"); !strings.Contains(got, "a") || strings.Count(got, "?") != 1 {
+		t.Errorf("ScalarLike(a, ?) = %q; must reference both sides once", got)
+	}
+	for name, got := range map[string]string{
+		"ArrayWildcard": d.ArrayWildcard("col"),
+		"ArrayContains": d.ArrayContains("col"),
+	} {
+		if !strings.Contains(got, "col") || strings.Count(got, "?") != 1 {
+			t.Errorf("%s(col) = %q; must reference the column and bind exactly one parameter", name, got)
+		}
+	}
+	if got := d.ArrayUnnest("col", "elem"); !strings.Contains(got, "col") || !strings.Contains(got, "elem") {
+		t.Errorf("ArrayUnnest(col, elem) = %q; must reference the column and the alias", got)
+	}
+	if got := d.NotTrue("p = ?"); !strings.Contains(got, "p = ?") {
+		t.Errorf("NotTrue(p = ?) = %q; must contain the predicate", got)
+	}
+	if sql, err := d.Fuzzy("col", "?"); err == nil && !strings.Contains(sql, "?") {
+		t.Errorf("Fuzzy returned %q and no error, which ignores the term", sql)
+	}
+	for _, v := range []lucene.ElemValue{
+		{Kind: reflect.String, Val: "golang"},
+		{Kind: reflect.Int, Val: int64(5)},
+		{Kind: reflect.Float64, Val: 1.5},
+		{Kind: reflect.Bool, Val: true},
+	} {
+		got, err := d.EncodeElement(v)
+		if err != nil {
+			t.Errorf("EncodeElement(%v): %v", v, err)
+			continue
+		}
+		if got == nil {
+			t.Errorf("EncodeElement(%v) returned nil", v)
+		}
+	}
+}
+
+func runCase(t *testing.T, d lucene.Dialect, parser *lucene.Parser, opts Options, c Case) {
+	where, params, err := parser.ParseToSQL(c.Query, d.Name())
+	if err != nil {
+		if c.Fuzzy {
+			t.Skipf("fuzzy search is not supported: %v", err)
+		}
+		t.Fatalf("ParseToSQL(%q): %v", c.Query, err)
+	}
+	if n := strings.Count(where, "?"); n != len(params) {
+		t.Fatalf("ParseToSQL(%q) = %q has %d placeholders for %d parameters", c.Query, where, n, len(params))
+	}
+	for _, field := range []string{"title", "rank", "tags", "nums", "flags", "labels"} {
+		if strings.Contains(c.Query, field+":") || strings.Contains(c.Query, field+".") {
+			if !strings.Contains(where, d.QuoteIdent(field)) {
+				t.Errorf("ParseToSQL(%q) = %q; does not reference %s", c.Query, where, d.QuoteIdent(field))
+			}
+		}
+	}
+	if opts.DB == nil {
+		return
+	}
+	got := queryIDs(t, d, opts, where, params)
+	if c.Fuzzy {
+		// Executed, so a rendering that is not valid SQL still fails.
+		return
+	}
+	if !slices.Equal(got, c.Want) {
+		t.Errorf("%q matched ids %v, want %v\n  where: %s\n  params: %v", c.Query, got, c.Want, where, params)
+	}
+}
+
+func queryIDs(t *testing.T, d lucene.Dialect, opts Options, where string, params []any) []string {
+	t.Helper()
+	id := d.QuoteIdent("id")
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s", id, d.QuoteIdent(opts.Table), where, id)
+	rows, err := opts.DB.Query(rebind(opts, query), params...)
+	if err != nil {
+		t.Fatalf("executing %s: %v", query, err)
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, v)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func runFacetCase(t *testing.T, d lucene.Dialect, parser *lucene.Parser, opts Options, c FacetCase) {
+	table := d.QuoteIdent(opts.Table)
+	if opts.Table == "" {
+		table = d.QuoteIdent("docs")
+	}
+	queries, err := parser.ParseFacetsToSQL(c.Query, table, []string{c.Field}, d.Name())
+	if err != nil {
+		t.Fatalf("ParseFacetsToSQL(%q, %s): %v", c.Query, c.Field, err)
+	}
+	fq := queries[0]
+	if n := strings.Count(fq.SQL, "?"); n != len(fq.Params) {
+		t.Fatalf("facet query %q has %d placeholders for %d parameters", fq.SQL, n, len(fq.Params))
+	}
+	if opts.DB == nil {
+		return
+	}
+
+	rows, err := opts.DB.Query(rebind(opts, fq.SQL), fq.Params...)
+	if err != nil {
+		t.Fatalf("executing %s: %v", fq.SQL, err)
+	}
+	defer rows.Close()
+	got := map[string]int64{}
+	for rows.Next() {
+		var value any
+		var count int64
+		if err := rows.Scan(&value, &count); err != nil {
+			t.Fatal(err)
+		}
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		got[fmt.Sprint(value)] = count
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, c.Want) {
+		t.Errorf("facet %s over %q = %v, want %v\n  sql: %s", c.Field, c.Query, got, c.Want, fq.SQL)
+	}
+}
+
+func rebind(opts Options, query string) string {
+	if opts.Rebind == nil {
+		return query
+	}
+	return opts.Rebind(query)
+}
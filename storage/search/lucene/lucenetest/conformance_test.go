@@ -0,0 +1,140 @@
+package lucenetest_test
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/tink3rlabs/magic/storage/search/lucene"
+	"github.com/tink3rlabs/magic/storage/search/lucene/lucenetest"
+)
+
+// jsonText stores a fixture array or document the way SQLite and MySQL hold
+// them, with a nil value as SQL NULL.
+func jsonText(t *testing.T, v any, isNil bool) any {
+	t.Helper()
+	if isNil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func newFixtureDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if _, err := db.Exec(`CREATE TABLE docs (id TEXT, title TEXT, rank INTEGER, tags TEXT, nums TEXT, flags TEXT, labels TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range lucenetest.Fixture {
+		_, err := db.Exec(`INSERT INTO docs VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			d.Id, d.Title, d.Rank,
+			jsonText(t, d.Tags, d.Tags == nil), jsonText(t, d.Nums, d.Nums == nil),
+			jsonText(t, d.Flags, d.Flags == nil), jsonText(t, d.Labels, d.Labels == nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestBuiltinDialectsRender(t *testing.T) {
+	for _, name := range []string{"postgresql", "mysql", "sqlite", "sqlserver"} {
+		d, err := lucene.LookupDialect(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(name, func(t *testing.T) { lucenetest.Run(t, d, lucenetest.Options{}) })
+	}
+}
+
+func TestSQLiteDialectExecutes(t *testing.T) {
+	d, err := lucene.LookupDialect("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lucenetest.Run(t, d, lucenetest.Options{DB: newFixtureDB(t), Table: "docs"})
+}
+
+// lowerSQLite stands in for a third-party dialect. It renders SQLite with
+// lowercase JSON functions, which the renderer's built-in recognition of
+// JSON_EXTRACT does not match, so it proves a plugin's JSON access is not
+// quoted as if it were a column name.
+type lowerSQLite struct{}
+
+func init() { lucene.RegisterDialect(lowerSQLite{}) }
+
+func (lowerSQLite) Name() string { return "sqlite-lower" }
+
+func (lowerSQLite) QuoteIdent(col string) string {
+	return `"` + strings.ReplaceAll(col, `"`, `""`) + `"`
+}
+
+func (lowerSQLite) JSONExtract(base, subField string) string {
+	return fmt.Sprintf("json_extract(%s, '$.%s')", base, subField)
+}
+
+func (lowerSQLite) ScalarLike(left, right string) string {
+	return fmt.Sprintf("%s LIKE %s", left, right)
+}
+
+func (lowerSQLite) ArrayWildcard(col string) string {
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s) WHERE value LIKE ?)", col)
+}
+
+func (lowerSQLite) ArrayContains(col string) string {
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s) WHERE value = ?)", col)
+}
+
+func (lowerSQLite) ArrayUnnest(col, alias string) string {
+	return fmt.Sprintf("CROSS JOIN json_each(%s) AS %s", col, alias)
+}
+
+func (lowerSQLite) EncodeElement(v lucene.ElemValue) (any, error) { return v.Val, nil }
+
+func (lowerSQLite) Fuzzy(col, term string) (string, error) {
+	return "", fmt.Errorf("fuzzy search is not supported")
+}
+
+func (lowerSQLite) NotTrue(predicate string) string {
+	return fmt.Sprintf("(%s) IS NOT TRUE", predicate)
+}
+
+func TestRegisteredPluginDialectExecutes(t *testing.T) {
+	lucenetest.Run(t, lowerSQLite{}, lucenetest.Options{DB: newFixtureDB(t), Table: "docs"})
+}
+
+func TestRegisterDialectRejectsDuplicatesAndBlankNames(t *testing.T) {
+	for name, d := range map[string]lucene.Dialect{
+		"duplicate of a built-in": renamed{lowerSQLite{}, "sqlite"},
+		"duplicate plugin":        lowerSQLite{},
+		"blank name":              renamed{lowerSQLite{}, ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("RegisterDialect did not panic")
+				}
+			}()
+			lucene.RegisterDialect(d)
+		})
+	}
+}
+
+type renamed struct {
+	lowerSQLite
+	name string
+}
+
+func (r renamed) Name() string { return r.name }
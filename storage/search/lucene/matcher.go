@@ -0,0 +1,429 @@
+package lucene
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grindlemire/go-lucene/pkg/lucene/expr"
+)
+
+// Matcher evaluates a Lucene query against items in process. It serves stores
+// that have no query language to translate to, such as embedded key-value
+// engines, where Search has to read items and test each one.
+//
+// Items are the JSON form of the model: a map[string]any as produced by
+// encoding/json, with or without UseNumber. The semantics follow SQLDriver so
+// that a query selects the same items on every adapter:
+//
+//   - field:value is exact equality, compared as the item value's type: a
+//     number numerically, a boolean as true/false (or 1/0), a time.Time field
+//     as an instant and anything else as text.
+//   - field:va* and field:v?lue are case-insensitive wildcards; field:* means
+//     "has a value".
+//   - field:/re/ is a regular expression that must match the whole value.
+//   - field:null matches a missing or null value.
+//   - Ranges and comparisons order numbers numerically, time.Time fields
+//     chronologically and strings lexically.
+//   - An array field matches when any element does. Ranges on arrays and
+//     wildcards on non-string arrays are rejected, as they are in SQL.
+//   - Fuzzy matching and boosting are rejected, as they are on SQLite.
+//
+// A Matcher is safe for concurrent use.
+type Matcher struct {
+	expr   *expr.Expression
+	fields map[string]FieldInfo
+
+	patternsLock sync.Mutex
+	patterns     map[string]*regexp.Regexp
+}
+
+// NewMatcher creates a matcher for a parsed expression over the model fields.
+// A nil expression matches every item. Constructs that cannot be evaluated
+// are reported here rather than on the first item, so an invalid query fails
+// the same way on an empty store as on a full one.
+func NewMatcher(fields []FieldInfo, e *expr.Expression) (*Matcher, error) {
+	fieldMap, err := buildFieldMap(fields)
+	if err != nil {
+		return nil, err
+	}
+	m := &Matcher{expr: e, fields: fieldMap, patterns: map[string]*regexp.Regexp{}}
+	if err := m.validate(e); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Match reports whether item satisfies the query.
+func (m *Matcher) Match(item map[string]any) bool {
+	if m.expr == nil {
+		return true
+	}
+	return m.eval(m.expr, item)
+}
+
+func (m *Matcher) validate(e *expr.Expression) error {
+	if e == nil {
+		return nil
+	}
+	switch e.Op {
+	case expr.Fuzzy:
+		return fmt.Errorf("fuzzy search (field:term~N) is not supported by in-process search; use wildcards instead (e.g., field:term*)")
+	case expr.Boost:
+		return fmt.Errorf("boost operator (^) is not supported in filtering; it only affects ranking/scoring")
+	case expr.Range, expr.Greater, expr.GreaterEq, expr.Less, expr.LessEq:
+		if name, ok := columnName(e.Left); ok && isArrayField(m.fields[name].Type) {
+			return fmt.Errorf(
+				"operator %s is not supported on array field '%s'; array fields support containment (%s:value), wildcards (%s:*value*) and null checks",
+				e.Op, name, name, name,
+			)
+		}
+	case expr.Like:
+		if name, ok := columnName(e.Left); ok {
+			info := m.fields[name]
+			if isArrayField(info.Type) && !isStringArray(info.Type) && literalText(e.Right) != "*" {
+				return fmt.Errorf(
+					"wildcard matching is not supported on non-string array field '%s'; use containment (%s:value)",
+					name, name,
+				)
+			}
+		}
+	}
+	for _, side := range []any{e.Left, e.Right} {
+		if sub, ok := side.(*expr.Expression); ok {
+			if err := m.validate(sub); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Matcher) eval(e *expr.Expression, item map[string]any) bool {
+	switch e.Op {
+	case expr.And:
+		return m.evalOperand(e.Left, item) && m.evalOperand(e.Right, item)
+	case expr.Or:
+		return m.evalOperand(e.Left, item) || m.evalOperand(e.Right, item)
+	case expr.Not, expr.MustNot:
+		return !m.evalOperand(e.Left, item)
+	case expr.Must:
+		return m.evalOperand(e.Left, item)
+	case expr.Equals, expr.Like:
+		name, ok := columnName(e.Left)
+		if !ok {
+			return false
+		}
+		// A grouped value list, field:(a OR b), arrives as a tree whose leaves
+		// name the default field rather than this one; evaluate each leaf
+		// against the outer field, as SQLDriver does.
+		if group, ok := e.Right.(*expr.Expression); ok && (group.Op == expr.Or || group.Op == expr.And) {
+			return m.evalGroup(name, group, item)
+		}
+		return m.matchValue(name, e.Right, item)
+	case expr.Range:
+		return m.evalRange(e, item)
+	case expr.Greater, expr.GreaterEq, expr.Less, expr.LessEq:
+		name, ok := columnName(e.Left)
+		if !ok {
+			return false
+		}
+		c, ok := m.compare(name, item, literalText(e.Right))
+		if !ok {
+			return false
+		}
+		switch e.Op {
+		case expr.Greater:
+			return c > 0
+		case expr.GreaterEq:
+			return c >= 0
+		case expr.Less:
+			return c < 0
+		default:
+			return c <= 0
+		}
+	}
+	return false
+}
+
+func (m *Matcher) evalOperand(v any, item map[string]any) bool {
+	e, ok := v.(*expr.Expression)
+	return ok && m.eval(e, item)
+}
+
+func (m *Matcher) evalGroup(name string, group *expr.Expression, item map[string]any) bool {
+	leaf := func(v any) bool {
+		if e, ok := v.(*expr.Expression); ok {
+			switch e.Op {
+			case expr.Or, expr.And:
+				return m.evalGroup(name, e, item)
+			case expr.Equals, expr.Like:
+				return m.matchValue(name, e.Right, item)
+			}
+		}
+		return m.matchValue(name, v, item)
+	}
+	if group.Op == expr.And {
+		return leaf(group.Left) && (group.Right == nil || leaf(group.Right))
+	}
+	return leaf(group.Left) || (group.Right != nil && leaf(group.Right))
+}
+
+// matchValue tests one field against the value side of field:value, which
+// may be a literal, a wildcard pattern, a regular expression or null.
+func (m *Matcher) matchValue(name string, value any, item map[string]any) bool {
+	v, present := lookupPath(item, name)
+	if isNullValue(value) {
+		return !present || v == nil
+	}
+	if !present || v == nil {
+		return false
+	}
+
+	var test func(elem any) bool
+	if e, ok := value.(*expr.Expression); ok && e.Op == expr.Wild {
+		pattern := literalText(e)
+		if pattern == "*" {
+			// "Has a value": true even for an empty array, as in SQL.
+			return true
+		}
+		re := m.pattern(pattern, wildcardRegexp)
+		test = func(elem any) bool { return elem != nil && re.MatchString(textOf(elem)) }
+	} else if ok && e.Op == expr.Regexp {
+		re := m.pattern(fmt.Sprintf("%v", e.Left), anchoredRegexp)
+		test = func(elem any) bool { return elem != nil && re.MatchString(textOf(elem)) }
+	} else {
+		raw := literalText(value)
+		info := m.fields[name]
+		test = func(elem any) bool { return equalValue(elem, raw, info) }
+	}
+
+	if elems, ok := v.([]any); ok {
+		for _, elem := range elems {
+			if test(elem) {
+				return true
+			}
+		}
+		return false
+	}
+	return test(v)
+}
+
+func (m *Matcher) evalRange(e *expr.Expression, item map[string]any) bool {
+	name, ok := columnName(e.Left)
+	if !ok {
+		return false
+	}
+	boundary, ok := e.Right.(*expr.RangeBoundary)
+	if !ok {
+		return false
+	}
+	if boundary.Min != nil {
+		if lower := literalText(boundary.Min); lower != "*" {
+			c, ok := m.compare(name, item, lower)
+			if !ok || c < 0 || (c == 0 && !boundary.Inclusive) {
+				return false
+			}
+		}
+	}
+	if boundary.Max != nil {
+		if upper := literalText(boundary.Max); upper != "*" {
+			c, ok := m.compare(name, item, upper)
+			if !ok || c > 0 || (c == 0 && !boundary.Inclusive) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// compare orders the item's value for name against raw. ok is false when the
+// value is missing or the two cannot be ordered against each other, in which
+// case the comparison does not match, like a comparison with NULL in SQL.
+func (m *Matcher) compare(name string, item map[string]any, raw string) (int, bool) {
+	v, present := lookupPath(item, name)
+	if !present || v == nil {
+		return 0, false
+	}
+	info := m.fields[name]
+	if isTimeField(info) {
+		if s, ok := v.(string); ok {
+			return compareTimes(s, raw)
+		}
+	}
+	if n, ok := numberOf(v); ok {
+		r, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return 0, false
+		}
+		return compareFloats(n, r), true
+	}
+	if s, ok := v.(string); ok {
+		return strings.Compare(s, raw), true
+	}
+	return 0, false
+}
+
+func (m *Matcher) pattern(source string, build func(string) string) *regexp.Regexp {
+	key := build(source)
+	m.patternsLock.Lock()
+	defer m.patternsLock.Unlock()
+	re, ok := m.patterns[key]
+	if !ok {
+		var err error
+		if re, err = regexp.Compile(key); err != nil {
+			// A pattern that does not compile matches nothing.
+			re = regexp.MustCompile(`[^\s\S]`)
+		}
+		m.patterns[key] = re
+	}
+	return re
+}
+
+// wildcardRegexp translates a Lucene wildcard pattern to an anchored,
+// case-insensitive regular expression.
+func wildcardRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString(`(?is)^`)
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(`.*`)
+		case '?':
+			b.WriteString(`.`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString(`$`)
+	return b.String()
+}
+
+// anchoredRegexp turns /re/ into a regular expression that must match the
+// whole value, which is how Lucene interprets one.
+func anchoredRegexp(pattern string) string {
+	pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "/"), "/")
+	return `^(?:` + pattern + `)$`
+}
+
+// literalText returns the text of a literal or wildcard value node.
+func literalText(v any) string {
+	if e, ok := v.(*expr.Expression); ok {
+		if s, ok := extractLiteralString(e); ok {
+			return s
+		}
+	}
+	return extractLiteralValue(v)
+}
+
+// lookupPath resolves a field name, following dots into nested objects for
+// field.subfield access.
+func lookupPath(item map[string]any, name string) (any, bool) {
+	var current any = item
+	for _, part := range strings.Split(name, ".") {
+		obj, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if current, ok = obj[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func equalValue(v any, raw string, info FieldInfo) bool {
+	switch t := v.(type) {
+	case string:
+		if isTimeField(info) {
+			c, ok := compareTimes(t, raw)
+			return ok && c == 0
+		}
+		return t == raw
+	case bool:
+		switch raw {
+		case "1":
+			return t
+		case "0":
+			return !t
+		}
+		b, err := strconv.ParseBool(raw)
+		return err == nil && b == t
+	}
+	if n, ok := numberOf(v); ok {
+		if i, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			// Compare integers exactly when the item holds one, so ids above
+			// 2^53 do not collide through float64.
+			if num, isNum := v.(json.Number); isNum {
+				if j, err := num.Int64(); err == nil {
+					return i == j
+				}
+			}
+		}
+		r, err := strconv.ParseFloat(raw, 64)
+		return err == nil && n == r
+	}
+	return false
+}
+
+func numberOf(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareTimes(a, b string) (int, bool) {
+	ta, err := time.Parse(time.RFC3339Nano, a)
+	if err != nil {
+		return 0, false
+	}
+	tb, err := time.Parse(time.RFC3339Nano, b)
+	if err != nil {
+		return 0, false
+	}
+	return ta.Compare(tb), true
+}
+
+// textOf renders a scalar as the text a wildcard or regular expression is
+// matched against.
+func textOf(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func isTimeField(info FieldInfo) bool {
+	t := info.Type
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t == timeType
+}
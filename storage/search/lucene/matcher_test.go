@@ -0,0 +1,138 @@
+package lucene
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type matchTask struct {
+	ID       string            `json:"id"`
+	Title    string            `json:"title"`
+	Priority int               `json:"priority"`
+	Done     bool              `json:"done"`
+	Due      time.Time         `json:"due"`
+	Owner    *string           `json:"owner"`
+	Tags     []string          `json:"tags"`
+	Scores   []int             `json:"scores"`
+	Labels   map[string]string `json:"labels"`
+}
+
+func matchTasks(t *testing.T) []map[string]any {
+	t.Helper()
+	alice := "alice"
+	tasks := []matchTask{
+		{ID: "1", Title: "Paint the fence", Priority: 3, Done: false, Due: time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC),
+			Owner: &alice, Tags: []string{"home", "outdoor"}, Scores: []int{1, 2}, Labels: map[string]string{"area": "garden"}},
+		{ID: "2", Title: "Write report", Priority: 1, Done: true, Due: time.Date(2026, 5, 1, 9, 0, 0, 500_000_000, time.UTC),
+			Tags: []string{"work"}, Labels: map[string]string{"area": "office"}},
+		{ID: "3", Title: "paint Kitchen", Priority: 10, Done: false, Due: time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC),
+			Tags: []string{}},
+	}
+	var items []map[string]any
+	for _, task := range tasks {
+		data, err := json.Marshal(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var item map[string]any
+		if err := dec.Decode(&item); err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func TestParseToMatcher(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{``, []string{"1", "2", "3"}},
+		{`id:2`, []string{"2"}},
+		{`title:"Write report"`, []string{"2"}},
+		{`title:paint*`, []string{"1", "3"}},
+		{`title:*KITCHEN`, []string{"3"}},
+		{`title:/[Pp]aint.*/`, []string{"1", "3"}},
+		{`paint`, []string{"1", "3"}},
+		{`priority:10`, []string{"3"}},
+		{`priority:[2 TO 10]`, []string{"1", "3"}},
+		{`priority:{1 TO 10}`, []string{"1"}},
+		{`priority:>=3`, []string{"1", "3"}},
+		{`priority:[* TO 2]`, []string{"2"}},
+		{`done:true`, []string{"2"}},
+		{`done:0`, []string{"1", "3"}},
+		{`owner:null`, []string{"2", "3"}},
+		{`NOT owner:null`, []string{"1"}},
+		{`owner:*`, []string{"1"}},
+		{`tags:work`, []string{"2"}},
+		{`tags:(home OR work)`, []string{"1", "2"}},
+		{`tags:out*`, []string{"1"}},
+		{`tags:*`, []string{"1", "2", "3"}},
+		{`scores:2`, []string{"1"}},
+		{`labels.area:garden`, []string{"1"}},
+		{`id:(1 OR 3) AND -title:paint*`, nil},
+		{`+done:false -priority:3`, []string{"3"}},
+		{`title:paint* OR done:true`, []string{"1", "2", "3"}},
+		// Time fields compare as instants, not as RFC 3339 text: the fraction
+		// on task 2 would otherwise sort it before task 1.
+		{`due:["2026-05-01T09:00:00.1Z" TO *]`, []string{"2", "3"}},
+		{`due:"2026-05-01T09:00:00Z"`, []string{"1"}},
+		{`due:<"2026-05-01T09:00:00.5Z"`, []string{"1"}},
+	}
+
+	parser, err := NewParser(matchTask{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := matchTasks(t)
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			matcher, err := parser.ParseToMatcher(tt.query)
+			if err != nil {
+				t.Fatalf("ParseToMatcher(%q) error: %v", tt.query, err)
+			}
+			var got []string
+			for _, item := range items {
+				if matcher.Match(item) {
+					got = append(got, item["id"].(string))
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("matched %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseToMatcherRejects(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr string
+	}{
+		{`title:paint~2`, "fuzzy search"},
+		{`title:paint^2`, "boost operator"},
+		{`tags:[a TO c]`, "not supported on array field 'tags'"},
+		{`scores:*1*`, "non-string array field 'scores'"},
+		{`missing:x`, "invalid field 'missing'"},
+	}
+
+	parser, err := NewParser(matchTask{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := parser.ParseToMatcher(tt.query)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseToMatcher(%q) error = %v, want it to contain %q", tt.query, err, tt.wantErr)
+			}
+		})
+	}
+}
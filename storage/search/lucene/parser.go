@@ -283,7 +283,7 @@ func (p *Parser) parseQueryCommon(query string, queryType string) (*expr.Express
 
 // ParseToSQL parses a Lucene query and converts it to SQL with parameters for the specified provider.
 // Creates a SQL driver on-demand for rendering with provider-specific syntax.
-// Provider should be one of: "postgresql", "mysql", "sqlite"
+// Provider should be one of: "postgresql", "mysql", "sqlite", "sqlserver"
 func (p *Parser) ParseToSQL(query string, provider string) (string, []any, error) {
 	e, err := p.parseQueryCommon(query, "SQL")
 	if err != nil {
@@ -878,3 +878,29 @@ func (p *Parser) valueToAny(v any) any {
 		return fmt.Sprintf("%v", v)
 	}
 }
+
+// ParseToCQL parses a Lucene query and converts it to a CQL WHERE clause.
+// searchable lists the columns CQL may restrict (key and indexed columns);
+// see CQLDriver for the supported subset of Lucene.
+func (p *Parser) ParseToCQL(query string, searchable []string) (string, []any, error) {
+	e, err := p.parseQueryCommon(query, "CQL")
+	if err != nil {
+		return "", nil, err
+	}
+
+	driver, err := NewCQLDriver(p.Fields, searchable)
+	if err != nil {
+		return "", nil, err
+	}
+	return driver.RenderCQL(e)
+}
+
+// ParseToMatcher parses a Lucene query into a Matcher that evaluates it
+// against items in process, for stores with no query language to render to.
+func (p *Parser) ParseToMatcher(query string) (*Matcher, error) {
+	e, err := p.parseQueryCommon(query, "in-process matcher")
+	if err != nil {
+		return nil, err
+	}
+	return NewMatcher(p.Fields, e)
+}
@@ -15,19 +15,24 @@ type SQLDriver struct {
 	driver.Base
 	fields   map[string]FieldInfo // Map of field names to their metadata
 	provider string               // SQL provider name, as given by the caller
-	dialect  sqlDialect           // Per-database rendering, resolved from provider
+	dialect  Dialect              // Per-database rendering, resolved from provider
+
+	// extracted holds the JSONExtract output of every sub-field reference,
+	// which quoteColumn must pass through as is. isJSONSyntax only recognizes
+	// the built-in dialects' syntax.
+	extracted map[string]bool
 }
 
 // NewSQLDriver creates a new SQL driver for the specified provider.
 //
-// Provider must be one of: "postgresql", "mysql", "sqlite". That list is the
+// Provider must be one of: "postgresql", "mysql", "sqlite", "sqlserver". That list is the
 // set of registered dialects (see dialect.go); the error returned for an
 // unknown provider names the supported ones, so it stays accurate if the set
 // changes.
 //
 // Returns an error if duplicate field names are found or the provider is unknown.
 func NewSQLDriver(fields []FieldInfo, provider string) (*SQLDriver, error) {
-	dialect, err := lookupDialect(provider)
+	dialect, err := LookupDialect(provider)
 	if err != nil {
 		return nil, err
 	}
@@ -63,9 +68,10 @@ func NewSQLDriver(fields []FieldInfo, provider string) (*SQLDriver, error) {
 		Base: driver.Base{
 			RenderFNs: fns,
 		},
-		fields:   fieldMap,
-		provider: provider,
-		dialect:  dialect,
+		fields:    fieldMap,
+		provider:  provider,
+		dialect:   dialect,
+		extracted: map[string]bool{},
 	}, nil
 }
 
@@ -426,7 +432,7 @@ func (s *SQLDriver) renderBinary(e *expr.Expression) (string, []any, error) {
 			if err != nil {
 				return "", nil, err
 			}
-			return fmt.Sprintf("(%s) IS NOT TRUE", inner), params, nil
+			return s.dialect.NotTrue(inner), params, nil
 		}
 	}
 
@@ -474,7 +480,7 @@ func (s *SQLDriver) renderBinary(e *expr.Expression) (string, []any, error) {
 // silently run against the constant text instead of the column. MySQL uses
 // backticks; Postgres and SQLite use double quotes.
 func (s *SQLDriver) quoteColumn(colStr string) string {
-	if isJSONSyntax(colStr) {
+	if isJSONSyntax(colStr) || s.extracted[colStr] {
 		return colStr
 	}
 	return s.dialect.QuoteIdent(colStr)
@@ -646,7 +652,9 @@ func (s *SQLDriver) formatFieldName(fieldName string) expr.Column {
 			// through untouched — this is the only chance to quote it. Left
 			// bare, a mixed-case column folds on Postgres ("column \"mixed\"
 			// does not exist") and a reserved word is a syntax error on MySQL.
-			return expr.Column(s.dialect.JSONExtract(s.dialect.QuoteIdent(baseField), subField))
+			extracted := s.dialect.JSONExtract(s.dialect.QuoteIdent(baseField), subField)
+			s.extracted[extracted] = true
+			return expr.Column(extracted)
 		}
 	}
 	return expr.Column(fieldName)
@@ -686,8 +694,8 @@ func isJSONSyntax(col string) bool {
 	if strings.Contains(col, "->>") {
 		return true
 	}
-	// Check for MySQL/SQLite JSON_EXTRACT
-	if strings.Contains(col, "JSON_EXTRACT") || strings.Contains(col, "JSON_UNQUOTE") {
+	// Check for MySQL/SQLite JSON_EXTRACT and SQL Server JSON_VALUE
+	if strings.Contains(col, "JSON_EXTRACT") || strings.Contains(col, "JSON_UNQUOTE") || strings.Contains(col, "JSON_VALUE") {
 		return true
 	}
 	return false
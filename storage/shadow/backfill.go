@@ -0,0 +1,92 @@
+package shadow
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/tink3rlabs/magic/storage"
+)
+
+// BackfillOptions configures Backfill.
+type BackfillOptions struct {
+	// SortKey orders the pages read from the source. Defaults to "id".
+	SortKey string
+	// Filter limits the items copied.
+	Filter map[string]any
+	// PageSize is the number of items read per page. Defaults to 100.
+	PageSize int
+	// Cursor resumes a backfill from a checkpoint that OnPage was given.
+	Cursor string
+	// Write copies one item, a pointer to an element of the page. Defaults
+	// to Create on the destination. Pass a write that tolerates existing
+	// items, such as one that updates on a conflict, when dual writes have
+	// already reached the destination or a page is copied again on resume.
+	Write func(ctx context.Context, dst storage.StorageAdapter, item any) error
+	// OnPage, when set, is called after each page is copied with the cursor
+	// to resume from and the number of items copied so far. An error from it
+	// stops the backfill.
+	OnPage func(cursor string, copied int) error
+}
+
+// BackfillResult is how far a backfill got.
+type BackfillResult struct {
+	// Copied is the number of items written to the destination.
+	Copied int
+	// Cursor resumes the backfill after the last page copied in full; it is
+	// empty once every page is.
+	Cursor string
+}
+
+// Backfill copies the items of one model from src to dst, a page at a time,
+// following the source's List cursors. page is a pointer to a slice of the
+// model, such as &[]Order{}, and holds each page as it is copied.
+//
+// When Backfill fails, the result's Cursor resumes it through
+// BackfillOptions.Cursor; items of the failed page may already have been
+// written, so a resumed backfill writes them again.
+func Backfill(ctx context.Context, src, dst storage.StorageAdapter, page any, opts BackfillOptions) (BackfillResult, error) {
+	if opts.SortKey == "" {
+		opts.SortKey = "id"
+	}
+	if opts.PageSize <= 0 {
+		opts.PageSize = 100
+	}
+	if opts.Write == nil {
+		opts.Write = func(ctx context.Context, dst storage.StorageAdapter, item any) error {
+			return newSide(dst).create(ctx, item)
+		}
+	}
+	v := reflect.ValueOf(page)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Slice {
+		return BackfillResult{}, fmt.Errorf("shadow: backfill page must be a pointer to a slice, got %T", page)
+	}
+	source := newSide(src)
+	result := BackfillResult{Cursor: opts.Cursor}
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		v.Elem().SetLen(0)
+		next, err := source.list(ctx, page, opts.SortKey, opts.Filter, opts.PageSize, result.Cursor)
+		if err != nil {
+			return result, fmt.Errorf("shadow: backfill failed to read a page: %w", err)
+		}
+		items := v.Elem()
+		for i := range items.Len() {
+			if err := opts.Write(ctx, dst, items.Index(i).Addr().Interface()); err != nil {
+				return result, fmt.Errorf("shadow: backfill failed to write item %d of the page: %w", i, err)
+			}
+			result.Copied++
+		}
+		result.Cursor = next
+		if opts.OnPage != nil {
+			if err := opts.OnPage(next, result.Copied); err != nil {
+				return result, err
+			}
+		}
+		if next == "" {
+			return result, nil
+		}
+	}
+}
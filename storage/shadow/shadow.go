@@ -0,0 +1,447 @@
+// Package shadow moves a service from one storage backend to another without
+// downtime. The Adapter decorator composes the current store, the primary,
+// with the one being moved to, the secondary, and its Mode decides which of
+// the two serves reads and which receive writes:
+//
+//	adapter := shadow.New(storage.Instrument(pg), storage.Instrument(dynamo), shadow.Options{
+//		Mode: shadow.DualWrite,
+//	})
+//
+// A migration usually walks the modes in order. DualWrite keeps the secondary
+// up to date while Backfill copies the existing data across; CompareReads then
+// checks the secondary answers as the primary does; SecondaryPrimary makes the
+// secondary the store of record while the primary still receives writes, so a
+// rollback loses nothing. SetMode moves between them on a running adapter.
+//
+// Divergences between the two stores are logged, counted in
+// magic_storage_shadow_divergences_total and passed to Options.OnDivergence.
+// Failed writes to the shadowed store are divergences too; they are not
+// returned to the caller.
+package shadow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/tink3rlabs/magic/storage"
+	"github.com/tink3rlabs/magic/telemetry"
+)
+
+// Metric and label names, kept in sync with observability/builtins.go, which
+// registers the metric at Init.
+const (
+	metricDivergencesTotal = "magic_storage_shadow_divergences_total"
+
+	labelOperation = "operation"
+	labelKind      = "kind"
+)
+
+const (
+	opCreate = "create"
+	opGet    = "get"
+	opUpdate = "update"
+	opDelete = "delete"
+	opList   = "list"
+)
+
+// Mode decides where an Adapter sends reads and writes.
+type Mode string
+
+const (
+	// PrimaryOnly sends everything to the primary. It is the default.
+	PrimaryOnly Mode = "primary-only"
+	// DualWrite writes to the primary and then to the secondary, and reads
+	// from the primary.
+	DualWrite Mode = "dual-write"
+	// CompareReads writes as DualWrite does and reads from the primary, and
+	// repeats Get and List against the secondary to compare the results.
+	CompareReads Mode = "compare-reads"
+	// SecondaryPrimary swaps the roles: the secondary is written first and
+	// serves reads, which are compared against the primary.
+	SecondaryPrimary Mode = "secondary-primary"
+)
+
+// ParseMode returns the Mode named s.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case PrimaryOnly, DualWrite, CompareReads, SecondaryPrimary:
+		return m, nil
+	}
+	return "", fmt.Errorf("shadow: unknown mode %q", s)
+}
+
+// Kinds of Divergence.
+const (
+	// KindMissing is an item the store of record returned and the shadowed
+	// store did not.
+	KindMissing = "missing"
+	// KindExtra is an item the shadowed store returned and the store of
+	// record did not.
+	KindExtra = "extra"
+	// KindMismatch is an item the two stores returned differently.
+	KindMismatch = "mismatch"
+	// KindReadFailed is a read that failed on the shadowed store only.
+	KindReadFailed = "read_failed"
+	// KindWriteFailed is a write that failed on the shadowed store after it
+	// succeeded on the store of record.
+	KindWriteFailed = "write_failed"
+)
+
+// Divergence is a difference between the two stores seen by one call.
+type Divergence struct {
+	Mode      Mode
+	Operation string
+	Kind      string
+	// Model is the type name of the item or items involved.
+	Model string
+	// Detail describes the difference, for logs.
+	Detail string
+	// Err is the shadowed store's error, for the failed kinds.
+	Err error
+}
+
+// Options configures an Adapter.
+type Options struct {
+	// Mode is the starting mode. Defaults to PrimaryOnly.
+	Mode Mode
+	// Equal reports whether the two stores returned the same item. Defaults
+	// to comparing the items' JSON encodings, which ignores differences in
+	// how each store represents the same value in Go.
+	Equal func(recorded, shadowed any) bool
+	// OnDivergence, when set, is called for every divergence after it is
+	// logged and counted.
+	OnDivergence func(ctx context.Context, d Divergence)
+}
+
+// Adapter is a storage.ContextualStorageAdapter that composes two adapters.
+// Search, Count, Query, Execute, Aggregate and SearchWithFacets go to the store
+// of record only, since their statements and cursors are not portable between
+// stores. Schema and migration methods go to the primary in every mode; run
+// migrations against each store directly.
+type Adapter struct {
+	primary   side
+	secondary side
+	opts      Options
+	mode      atomic.Value
+	counter   telemetry.Counter
+}
+
+var (
+	_ storage.ContextualStorageAdapter = (*Adapter)(nil)
+	_ storage.Aggregator               = (*Adapter)(nil)
+	_ storage.Faceter                  = (*Adapter)(nil)
+)
+
+// New composes primary and secondary.
+func New(primary, secondary storage.StorageAdapter, opts Options) *Adapter {
+	if opts.Mode == "" {
+		opts.Mode = PrimaryOnly
+	}
+	if opts.Equal == nil {
+		opts.Equal = jsonEqual
+	}
+	a := &Adapter{primary: newSide(primary), secondary: newSide(secondary), opts: opts}
+	a.mode.Store(opts.Mode)
+	counter, err := telemetry.Global().Metrics.Counter(telemetry.MetricDefinition{
+		Name:   metricDivergencesTotal,
+		Help:   "Total divergences between the stores of a shadow storage adapter, labeled by operation and kind.",
+		Kind:   telemetry.KindCounter,
+		Labels: []string{labelOperation, labelKind},
+	})
+	if err != nil {
+		slog.Warn("storage: failed to register shadow divergences counter", "error", err)
+	} else {
+		a.counter = counter
+	}
+	return a
+}
+
+// Mode returns the current mode.
+func (a *Adapter) Mode() Mode {
+	return a.mode.Load().(Mode)
+}
+
+// SetMode switches modes. Calls already under way finish in the old mode.
+func (a *Adapter) SetMode(m Mode) {
+	a.mode.Store(m)
+}
+
+// UnwrapStorageAdapter returns the primary.
+func (a *Adapter) UnwrapStorageAdapter() storage.StorageAdapter {
+	return a.primary.StorageAdapter
+}
+
+// roles returns the store of record and the shadowed store for mode, with
+// whether the shadowed store receives writes and whether reads are compared.
+func (a *Adapter) roles(mode Mode) (record, shadowed side, write, compare bool) {
+	switch mode {
+	case DualWrite:
+		return a.primary, a.secondary, true, false
+	case CompareReads:
+		return a.primary, a.secondary, true, true
+	case SecondaryPrimary:
+		return a.secondary, a.primary, true, true
+	}
+	return a.primary, a.secondary, false, false
+}
+
+func (a *Adapter) diverged(ctx context.Context, d Divergence) {
+	slog.WarnContext(ctx, "storage: shadow stores diverged",
+		"mode", d.Mode, "operation", d.Operation, "kind", d.Kind, "model", d.Model,
+		"detail", d.Detail, "error", d.Err)
+	if a.counter != nil {
+		a.counter.Add(1,
+			telemetry.Label{Key: labelOperation, Value: d.Operation},
+			telemetry.Label{Key: labelKind, Value: d.Kind},
+		)
+	}
+	if a.opts.OnDivergence != nil {
+		a.opts.OnDivergence(ctx, d)
+	}
+}
+
+// write runs a write on the store of record and, when the mode shadows writes
+// and the first succeeded, on the shadowed store.
+func (a *Adapter) write(ctx context.Context, op string, item any, call func(side) error) error {
+	mode := a.Mode()
+	record, shadowed, shadow, _ := a.roles(mode)
+	if err := call(record); err != nil || !shadow {
+		return err
+	}
+	if err := call(shadowed); err != nil && !(op == opDelete && errors.Is(err, storage.ErrNotFound)) {
+		a.diverged(ctx, Divergence{
+			Mode: mode, Operation: op, Kind: KindWriteFailed, Model: typeName(item),
+			Detail: err.Error(), Err: err,
+		})
+	}
+	return nil
+}
+
+func (a *Adapter) GetType() storage.StorageAdapterType   { return a.primary.GetType() }
+func (a *Adapter) GetProvider() storage.StorageProviders { return a.primary.GetProvider() }
+func (a *Adapter) GetSchemaName() string                 { return a.primary.GetSchemaName() }
+func (a *Adapter) CreateSchema() error                   { return a.primary.CreateSchema() }
+func (a *Adapter) CreateMigrationTable() error           { return a.primary.CreateMigrationTable() }
+func (a *Adapter) GetLatestMigration() (int, error)      { return a.primary.GetLatestMigration() }
+
+func (a *Adapter) UpdateMigrationTable(id int, name string, desc string) error {
+	return a.primary.UpdateMigrationTable(id, name, desc)
+}
+
+func (a *Adapter) Execute(statement string) error {
+	return a.ExecuteContext(context.Background(), statement)
+}
+
+func (a *Adapter) ExecuteContext(ctx context.Context, statement string) error {
+	record, _, _, _ := a.roles(a.Mode())
+	return record.execute(ctx, statement)
+}
+
+func (a *Adapter) Ping() error {
+	return a.PingContext(context.Background())
+}
+
+// PingContext pings both stores whenever the shadowed one receives writes,
+// since a write to it would then fail.
+func (a *Adapter) PingContext(ctx context.Context) error {
+	record, shadowed, shadow, _ := a.roles(a.Mode())
+	if err := record.ping(ctx); err != nil || !shadow {
+		return err
+	}
+	return shadowed.ping(ctx)
+}
+
+func (a *Adapter) Create(item any, params ...map[string]any) error {
+	return a.CreateContext(context.Background(), item, params...)
+}
+
+func (a *Adapter) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	return a.write(ctx, opCreate, item, func(s side) error { return s.create(ctx, item, params...) })
+}
+
+func (a *Adapter) Update(item any, filter map[string]any, params ...map[string]any) error {
+	return a.UpdateContext(context.Background(), item, filter, params...)
+}
+
+func (a *Adapter) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return a.write(ctx, opUpdate, item, func(s side) error { return s.update(ctx, item, filter, params...) })
+}
+
+func (a *Adapter) Delete(item any, filter map[string]any, params ...map[string]any) error {
+	return a.DeleteContext(context.Background(), item, filter, params...)
+}
+
+// DeleteContext treats an item already missing from the shadowed store as
+// deleted there.
+func (a *Adapter) DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return a.write(ctx, opDelete, item, func(s side) error { return s.delete(ctx, item, filter, params...) })
+}
+
+func (a *Adapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
+	return a.GetContext(context.Background(), dest, filter, params...)
+}
+
+func (a *Adapter) GetContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error {
+	mode := a.Mode()
+	record, shadowed, _, compare := a.roles(mode)
+	err := record.get(ctx, dest, filter, params...)
+	if !compare || (err != nil && !errors.Is(err, storage.ErrNotFound)) {
+		return err
+	}
+	found := err == nil
+	other := reflect.New(reflect.TypeOf(dest).Elem()).Interface()
+	shadowErr := shadowed.get(ctx, other, filter, params...)
+	d := Divergence{Mode: mode, Operation: opGet, Model: typeName(dest)}
+	switch {
+	case shadowErr != nil && !errors.Is(shadowErr, storage.ErrNotFound):
+		d.Kind, d.Detail, d.Err = KindReadFailed, shadowErr.Error(), shadowErr
+	case found && shadowErr != nil:
+		d.Kind, d.Detail = KindMissing, fmt.Sprintf("no item matches %v", filter)
+	case !found && shadowErr == nil:
+		d.Kind, d.Detail = KindExtra, fmt.Sprintf("an item matches %v", filter)
+	case found && !a.opts.Equal(dest, other):
+		d.Kind, d.Detail = KindMismatch, fmt.Sprintf("the items matching %v differ", filter)
+	}
+	if d.Kind != "" {
+		a.diverged(ctx, d)
+	}
+	return err
+}
+
+func (a *Adapter) List(dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.ListContext(context.Background(), dest, sortKey, filter, limit, cursor, params...)
+}
+
+// ListContext compares first pages only: a cursor from one store means
+// nothing to the other.
+func (a *Adapter) ListContext(ctx context.Context, dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	mode := a.Mode()
+	record, shadowed, _, compare := a.roles(mode)
+	next, err := record.list(ctx, dest, sortKey, filter, limit, cursor, params...)
+	if err != nil || !compare || cursor != "" {
+		return next, err
+	}
+	other := reflect.New(reflect.TypeOf(dest).Elem())
+	d := Divergence{Mode: mode, Operation: opList, Model: typeName(dest)}
+	if _, shadowErr := shadowed.list(ctx, other.Interface(), sortKey, filter, limit, "", params...); shadowErr != nil {
+		d.Kind, d.Detail, d.Err = KindReadFailed, shadowErr.Error(), shadowErr
+		a.diverged(ctx, d)
+		return next, err
+	}
+	got, want := other.Elem(), reflect.ValueOf(dest).Elem()
+	for i := range min(got.Len(), want.Len()) {
+		if !a.opts.Equal(want.Index(i).Interface(), got.Index(i).Interface()) {
+			d.Kind, d.Detail = KindMismatch, fmt.Sprintf("item %d of the page differs", i)
+			a.diverged(ctx, d)
+			return next, err
+		}
+	}
+	switch {
+	case got.Len() < want.Len():
+		d.Kind = KindMissing
+	case got.Len() > want.Len():
+		d.Kind = KindExtra
+	default:
+		return next, err
+	}
+	d.Detail = fmt.Sprintf("the page has %d items, and %d in the shadowed store", want.Len(), got.Len())
+	a.diverged(ctx, d)
+	return next, err
+}
+
+func (a *Adapter) Search(dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.SearchContext(context.Background(), dest, sortKey, query, limit, cursor, params...)
+}
+
+func (a *Adapter) SearchContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	record, _, _, _ := a.roles(a.Mode())
+	if record.ctx != nil {
+		return record.ctx.SearchContext(ctx, dest, sortKey, query, limit, cursor, params...)
+	}
+	return record.Search(dest, sortKey, query, limit, cursor, params...)
+}
+
+func (a *Adapter) Count(dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	return a.CountContext(context.Background(), dest, filter, params...)
+}
+
+func (a *Adapter) CountContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	record, _, _, _ := a.roles(a.Mode())
+	if record.ctx != nil {
+		return record.ctx.CountContext(ctx, dest, filter, params...)
+	}
+	return record.Count(dest, filter, params...)
+}
+
+func (a *Adapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.QueryContext(context.Background(), dest, statement, limit, cursor, params...)
+}
+
+func (a *Adapter) QueryContext(ctx context.Context, dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	record, _, _, _ := a.roles(a.Mode())
+	if record.ctx != nil {
+		return record.ctx.QueryContext(ctx, dest, statement, limit, cursor, params...)
+	}
+	return record.Query(dest, statement, limit, cursor, params...)
+}
+
+func (a *Adapter) Aggregate(model any, filter any, groupBy []string, metrics []storage.AggSpec, params ...map[string]any) ([]storage.AggregateRow, error) {
+	return a.AggregateContext(context.Background(), model, filter, groupBy, metrics, params...)
+}
+
+// AggregateContext reports storage.ErrNotSupported when the store of record
+// is not a storage.Aggregator.
+func (a *Adapter) AggregateContext(ctx context.Context, model any, filter any, groupBy []string, metrics []storage.AggSpec, params ...map[string]any) ([]storage.AggregateRow, error) {
+	record, _, _, _ := a.roles(a.Mode())
+	agg, ok := record.StorageAdapter.(storage.Aggregator)
+	if !ok {
+		return nil, storage.ErrNotSupported
+	}
+	return agg.AggregateContext(ctx, model, filter, groupBy, metrics, params...)
+}
+
+func (a *Adapter) SearchWithFacets(dest any, sortKey string, query string, limit int, cursor string, facets []string, params ...map[string]any) (string, storage.Facets, error) {
+	return a.SearchWithFacetsContext(context.Background(), dest, sortKey, query, limit, cursor, facets, params...)
+}
+
+// SearchWithFacetsContext reports storage.ErrNotSupported when the store of
+// record is not a storage.Faceter.
+func (a *Adapter) SearchWithFacetsContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, facets []string, params ...map[string]any) (string, storage.Facets, error) {
+	record, _, _, _ := a.roles(a.Mode())
+	f, ok := record.StorageAdapter.(storage.Faceter)
+	if !ok {
+		return "", nil, storage.ErrNotSupported
+	}
+	return f.SearchWithFacetsContext(ctx, dest, sortKey, query, limit, cursor, facets, params...)
+}
+
+// jsonEqual compares a and b by their JSON encodings, decoded again so that
+// key order and number formatting do not matter.
+func jsonEqual(a, b any) bool {
+	var x, y any
+	if ja, err := json.Marshal(a); err != nil || json.Unmarshal(ja, &x) != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	if jb, err := json.Marshal(b); err != nil || json.Unmarshal(jb, &y) != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// typeName returns the name of the model type behind v, looking through
+// pointers and slices.
+func typeName(v any) string {
+	t := reflect.TypeOf(v)
+	for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	if t == nil {
+		return ""
+	}
+	return t.Name()
+}
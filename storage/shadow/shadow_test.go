@@ -0,0 +1,240 @@
+package shadow_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/tink3rlabs/magic/observability/obstest"
+	"github.com/tink3rlabs/magic/storage"
+	"github.com/tink3rlabs/magic/storage/shadow"
+	"github.com/tink3rlabs/magic/telemetry"
+)
+
+// ShadowAccount is stored in shadow_accounts by both the memory adapter and
+// the Bolt adapter.
+type ShadowAccount struct {
+	Id      string `json:"id" gorm:"primaryKey;column:id"`
+	Owner   string `json:"owner" gorm:"column:owner"`
+	Balance int    `json:"balance" gorm:"column:balance"`
+}
+
+// stores returns the memory adapter as the primary and a fresh Bolt adapter
+// as the secondary, both with an empty shadow_accounts table.
+func stores(t *testing.T) (*storage.MemoryAdapter, *storage.BoltAdapter) {
+	t.Helper()
+	primary := storage.GetMemoryAdapterInstance()
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS shadow_accounts (id TEXT PRIMARY KEY, owner TEXT, balance INTEGER)`,
+		`DELETE FROM shadow_accounts`,
+	} {
+		if err := primary.Execute(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	secondary, err := storage.NewBoltAdapter(map[string]string{"path": filepath.Join(t.TempDir(), "shadow.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = secondary.Close() })
+	if err := secondary.Execute("CREATE TABLE shadow_accounts"); err != nil {
+		t.Fatal(err)
+	}
+	return primary, secondary
+}
+
+func seed(t *testing.T, a storage.StorageAdapter, accounts ...ShadowAccount) {
+	t.Helper()
+	for _, acct := range accounts {
+		if err := a.Create(&acct); err != nil {
+			t.Fatalf("Create(%s): %v", acct.Id, err)
+		}
+	}
+}
+
+// recorder collects the divergences an Adapter reports.
+type recorder struct{ seen []shadow.Divergence }
+
+func (r *recorder) record(_ context.Context, d shadow.Divergence) { r.seen = append(r.seen, d) }
+
+func (r *recorder) kinds() []string {
+	var kinds []string
+	for _, d := range r.seen {
+		kinds = append(kinds, d.Operation+":"+d.Kind)
+	}
+	return kinds
+}
+
+func TestDualWriteKeepsTheSecondaryInStepAndReadsThePrimary(t *testing.T) {
+	primary, secondary := stores(t)
+	var r recorder
+	a := shadow.New(primary, secondary, shadow.Options{Mode: shadow.DualWrite, OnDivergence: r.record})
+
+	seed(t, a, ShadowAccount{Id: "a1", Owner: "ada", Balance: 10})
+	if err := a.Update(&ShadowAccount{Id: "a1", Owner: "ada", Balance: 20}, map[string]any{"id": "a1"}); err != nil {
+		t.Fatal(err)
+	}
+	var got ShadowAccount
+	if err := secondary.Get(&got, map[string]any{"id": "a1"}); err != nil || got.Balance != 20 {
+		t.Fatalf("secondary has %+v, %v; want the updated account", got, err)
+	}
+
+	// Reads come from the primary, so an item the secondary lost goes
+	// unnoticed until reads are compared.
+	if err := secondary.Delete(&ShadowAccount{}, map[string]any{"id": "a1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Get(&got, map[string]any{"id": "a1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Delete(&ShadowAccount{}, map[string]any{"id": "a1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.seen) != 0 {
+		t.Fatalf("divergences %v; a delete of an item the secondary lacks is not one", r.kinds())
+	}
+}
+
+func TestFailedShadowWritesAreReportedNotReturned(t *testing.T) {
+	obs := obstest.NewTestObserver(t)
+	defer obs.Close()
+
+	primary, secondary := stores(t)
+	if err := secondary.Execute("DROP TABLE shadow_accounts"); err != nil {
+		t.Fatal(err)
+	}
+	var r recorder
+	a := shadow.New(primary, secondary, shadow.Options{Mode: shadow.DualWrite, OnDivergence: r.record})
+
+	if err := a.Create(&ShadowAccount{Id: "a1"}); err != nil {
+		t.Fatalf("Create = %v; the primary took the write", err)
+	}
+	if got := r.kinds(); !slices.Equal(got, []string{"create:write_failed"}) || r.seen[0].Err == nil {
+		t.Fatalf("divergences %v, want the failed create with its error", got)
+	}
+	obs.AssertCounter(t, "magic_storage_shadow_divergences_total", 1,
+		telemetry.Label{Key: "operation", Value: "create"},
+		telemetry.Label{Key: "kind", Value: "write_failed"},
+	)
+}
+
+func TestCompareReadsReportsDivergences(t *testing.T) {
+	primary, secondary := stores(t)
+	seed(t, primary,
+		ShadowAccount{Id: "a1", Owner: "ada", Balance: 10},
+		ShadowAccount{Id: "a2", Owner: "alan", Balance: 20},
+		ShadowAccount{Id: "a3", Owner: "grace", Balance: 30},
+	)
+	seed(t, secondary,
+		ShadowAccount{Id: "a1", Owner: "ada", Balance: 10},
+		ShadowAccount{Id: "a2", Owner: "alan", Balance: 25},
+		ShadowAccount{Id: "a4", Owner: "edsger", Balance: 40},
+	)
+	var r recorder
+	a := shadow.New(primary, secondary, shadow.Options{Mode: shadow.CompareReads, OnDivergence: r.record})
+
+	for _, id := range []string{"a1", "a2", "a3", "a4"} {
+		var got ShadowAccount
+		err := a.Get(&got, map[string]any{"id": id})
+		if id == "a4" {
+			if !errors.Is(err, storage.ErrNotFound) {
+				t.Fatalf("Get(a4) = %v; reads are served from the primary", err)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var page []ShadowAccount
+	if _, err := a.List(&page, "id", nil, 2, ""); err != nil || len(page) != 2 {
+		t.Fatalf("List = %v, %v", page, err)
+	}
+
+	want := []string{"get:mismatch", "get:missing", "get:extra", "list:mismatch"}
+	if got := r.kinds(); !slices.Equal(got, want) {
+		t.Fatalf("divergences %v, want %v", got, want)
+	}
+	if d := r.seen[0]; d.Model != "ShadowAccount" || d.Mode != shadow.CompareReads {
+		t.Fatalf("divergence %+v", d)
+	}
+}
+
+func TestSecondaryPrimaryServesReadsFromTheSecondary(t *testing.T) {
+	primary, secondary := stores(t)
+	var r recorder
+	a := shadow.New(primary, secondary, shadow.Options{Mode: shadow.DualWrite, OnDivergence: r.record})
+	seed(t, a, ShadowAccount{Id: "a1", Owner: "ada", Balance: 10})
+
+	a.SetMode(shadow.SecondaryPrimary)
+	if err := secondary.Update(&ShadowAccount{Id: "a1", Owner: "ada", Balance: 99}, map[string]any{"id": "a1"}); err != nil {
+		t.Fatal(err)
+	}
+	var got ShadowAccount
+	if err := a.Get(&got, map[string]any{"id": "a1"}); err != nil || got.Balance != 99 {
+		t.Fatalf("Get = %+v, %v; want the secondary's account", got, err)
+	}
+	if got := r.kinds(); !slices.Equal(got, []string{"get:mismatch"}) {
+		t.Fatalf("divergences %v, want the primary's stale copy reported", got)
+	}
+
+	// The primary still receives writes, so a rollback loses nothing.
+	seed(t, a, ShadowAccount{Id: "a2", Owner: "alan"})
+	a.SetMode(shadow.PrimaryOnly)
+	if err := a.Get(&ShadowAccount{}, map[string]any{"id": "a2"}); err != nil {
+		t.Fatalf("Get after rolling back = %v", err)
+	}
+}
+
+func TestBackfillCopiesEveryPageAndResumes(t *testing.T) {
+	primary, secondary := stores(t)
+	for i := 1; i <= 7; i++ {
+		seed(t, primary, ShadowAccount{Id: fmt.Sprintf("a%d", i), Owner: "owner", Balance: i})
+	}
+
+	// The fourth write fails, in the second page.
+	writes := 0
+	failing := func(ctx context.Context, dst storage.StorageAdapter, item any) error {
+		if writes++; writes == 4 {
+			return errors.New("destination unavailable")
+		}
+		return dst.Create(item)
+	}
+	var checkpoints []string
+	opts := shadow.BackfillOptions{
+		PageSize: 2,
+		Write:    failing,
+		OnPage: func(cursor string, copied int) error {
+			checkpoints = append(checkpoints, cursor)
+			return nil
+		},
+	}
+	result, err := shadow.Backfill(context.Background(), primary, secondary, &[]ShadowAccount{}, opts)
+	if err == nil || result.Copied != 3 || len(checkpoints) != 1 || result.Cursor != checkpoints[0] {
+		t.Fatalf("Backfill = %+v, %v after checkpoints %q; want it stopped in the second page", result, err, checkpoints)
+	}
+
+	// The item written before the failure is written again on resume.
+	opts.Cursor = result.Cursor
+	opts.Write = func(ctx context.Context, dst storage.StorageAdapter, item any) error {
+		if err := dst.Create(item); err != nil {
+			return dst.Update(item, map[string]any{"id": item.(*ShadowAccount).Id})
+		}
+		return nil
+	}
+	result, err = shadow.Backfill(context.Background(), primary, secondary, &[]ShadowAccount{}, opts)
+	if err != nil || result.Copied != 5 || result.Cursor != "" {
+		t.Fatalf("resumed Backfill = %+v, %v", result, err)
+	}
+
+	var r recorder
+	a := shadow.New(primary, secondary, shadow.Options{Mode: shadow.CompareReads, OnDivergence: r.record})
+	var page []ShadowAccount
+	if _, err := a.List(&page, "id", nil, 10, ""); err != nil || len(page) != 7 {
+		t.Fatalf("List = %v, %v", page, err)
+	}
+	if len(r.seen) != 0 {
+		t.Fatalf("divergences after the backfill: %v", r.kinds())
+	}
+}
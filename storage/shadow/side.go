@@ -0,0 +1,68 @@
+package shadow
+
+import (
+	"context"
+
+	"github.com/tink3rlabs/magic/storage"
+)
+
+// side is one of the two stores, with its context-aware methods when it has
+// them.
+type side struct {
+	storage.StorageAdapter
+	ctx storage.ContextualStorageAdapter
+}
+
+func newSide(a storage.StorageAdapter) side {
+	c, _ := a.(storage.ContextualStorageAdapter)
+	return side{StorageAdapter: a, ctx: c}
+}
+
+func (s side) execute(ctx context.Context, statement string) error {
+	if s.ctx != nil {
+		return s.ctx.ExecuteContext(ctx, statement)
+	}
+	return s.Execute(statement)
+}
+
+func (s side) ping(ctx context.Context) error {
+	if s.ctx != nil {
+		return s.ctx.PingContext(ctx)
+	}
+	return s.Ping()
+}
+
+func (s side) create(ctx context.Context, item any, params ...map[string]any) error {
+	if s.ctx != nil {
+		return s.ctx.CreateContext(ctx, item, params...)
+	}
+	return s.Create(item, params...)
+}
+
+func (s side) get(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error {
+	if s.ctx != nil {
+		return s.ctx.GetContext(ctx, dest, filter, params...)
+	}
+	return s.Get(dest, filter, params...)
+}
+
+func (s side) update(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	if s.ctx != nil {
+		return s.ctx.UpdateContext(ctx, item, filter, params...)
+	}
+	return s.Update(item, filter, params...)
+}
+
+func (s side) delete(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	if s.ctx != nil {
+		return s.ctx.DeleteContext(ctx, item, filter, params...)
+	}
+	return s.Delete(item, filter, params...)
+}
+
+func (s side) list(ctx context.Context, dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	if s.ctx != nil {
+		return s.ctx.ListContext(ctx, dest, sortKey, filter, limit, cursor, params...)
+	}
+	return s.List(dest, sortKey, filter, limit, cursor, params...)
+}
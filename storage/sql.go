@@ -6,8 +6,11 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"log/slog"
+	"net/url"
 	"reflect"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -15,7 +18,9 @@ import (
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
+	"gorm.io/driver/sqlserver"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
 
@@ -30,6 +35,8 @@ type SQLAdapter struct {
 	DB       *gorm.DB
 	config   map[string]string
 	provider StorageProviders
+	// migrationNamespace names the migrations table, see MigrationNamespace.
+	migrationNamespace string
 }
 
 var sqlAdapterLock = &sync.Mutex{}
@@ -80,8 +87,10 @@ func (s *SQLAdapter) OpenConnection() {
 			path = s.config["path"]
 		}
 		s.DB, err = gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	case MSSQL:
+		s.DB, err = gorm.Open(sqlserver.Open(sqlserverDSN(s.config)), &gormConf)
 	default:
-		slogger.Fatal("this SQL provider is not supported, supported providers are: postgresql, mysql, and sqlite")
+		slogger.Fatal("this SQL provider is not supported, supported providers are: postgresql, mysql, sqlite, and sqlserver")
 
 	}
 
@@ -90,6 +99,33 @@ func (s *SQLAdapter) OpenConnection() {
 	}
 }
 
+// sqlserverDSN builds a sqlserver:// URL from host, port, user, password and
+// dbname. Any other key except schema becomes a query parameter, which is how
+// go-mssqldb takes options such as encrypt or TrustServerCertificate.
+func sqlserverDSN(config map[string]string) string {
+	query := url.Values{}
+	for key, value := range config {
+		switch key {
+		case "host", "port", "user", "password", "schema":
+		case "dbname":
+			query.Set("database", value)
+		default:
+			query.Set(key, value)
+		}
+	}
+	host := config["host"]
+	if config["port"] != "" {
+		host = fmt.Sprintf("%s:%s", host, config["port"])
+	}
+	dsn := url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(config["user"], config["password"]),
+		Host:     host,
+		RawQuery: query.Encode(),
+	}
+	return dsn.String()
+}
+
 // dbWithCtx returns s.DB bound to ctx when non-nil, letting gorm
 // propagate cancellation and deadlines into the underlying driver
 // as well as making the context available to gorm callbacks (used
@@ -139,49 +175,188 @@ func (s *SQLAdapter) GetSchemaName() string {
 }
 
 func (s *SQLAdapter) CreateSchema() error {
-	if s.GetProvider() != SQLITE {
+	switch s.GetProvider() {
+	case SQLITE:
+		return nil
+	case MSSQL:
+		// T-SQL has no CREATE SCHEMA IF NOT EXISTS, and CREATE SCHEMA must be
+		// the only statement in its batch, hence the EXEC.
+		statement := fmt.Sprintf("IF SCHEMA_ID(N'%s') IS NULL EXEC('CREATE SCHEMA [%s]')", s.GetSchemaName(), s.GetSchemaName())
+		return s.Execute(statement)
+	default:
 		statement := fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", s.GetSchemaName())
 		return s.Execute(statement)
 	}
-	return nil
 }
 
 func (s *SQLAdapter) CreateMigrationTable() error {
 	var statement string
+	table := s.migrationsTable()
 	switch s.GetProvider() {
 	case POSTGRESQL:
 		statement = fmt.Sprintf(
-			"CREATE TABLE IF NOT EXISTS %s.migrations (id NUMERIC PRIMARY KEY, name TEXT, description TEXT, timestamp NUMERIC)",
-			s.GetSchemaName())
+			"CREATE TABLE IF NOT EXISTS %s (id NUMERIC PRIMARY KEY, name TEXT, description TEXT, timestamp NUMERIC, checksum TEXT)",
+			table)
 	case MYSQL:
-		statement = "CREATE TABLE IF NOT EXISTS migrations (id INT PRIMARY KEY, name TEXT, description TEXT, timestamp BIGINT)"
+		statement = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INT PRIMARY KEY, name TEXT, description TEXT, timestamp BIGINT, checksum TEXT)",
+			s.migrationTableName())
 	case SQLITE:
-		statement = "CREATE TABLE IF NOT EXISTS migrations (id INTEGER PRIMARY KEY, name TEXT, description TEXT, timestamp INTEGER)"
+		statement = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INTEGER PRIMARY KEY, name TEXT, description TEXT, timestamp INTEGER, checksum TEXT)",
+			table)
+	case MSSQL:
+		statement = fmt.Sprintf(
+			"IF OBJECT_ID(N'%s', N'U') IS NULL CREATE TABLE %s (id INT PRIMARY KEY, name NVARCHAR(MAX), description NVARCHAR(MAX), [timestamp] BIGINT, checksum NVARCHAR(64))",
+			table, table)
 	}
-	return s.Execute(statement)
+	if err := s.Execute(statement); err != nil {
+		return err
+	}
+	return s.addMigrationChecksumColumn()
 }
 
-func (s *SQLAdapter) UpdateMigrationTable(id int, name string, desc string) error {
-	var statement string
+// addMigrationChecksumColumn adds the checksum column to a migrations table
+// created before migrations were checksummed.
+func (s *SQLAdapter) addMigrationChecksumColumn() error {
+	table := s.migrationsTable()
 	switch s.GetProvider() {
+	case POSTGRESQL:
+		return s.Execute(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS checksum TEXT", table))
+	case MSSQL:
+		return s.Execute(fmt.Sprintf(
+			"IF COL_LENGTH(N'%s', 'checksum') IS NULL ALTER TABLE %s ADD checksum NVARCHAR(64)",
+			table, table))
+	}
+	// MySQL and SQLite have no ADD COLUMN IF NOT EXISTS.
+	columns, err := s.migrationColumns()
+	if err != nil {
+		return err
+	}
+	if slices.Contains(columns, "checksum") {
+		return nil
+	}
+	return s.Execute(fmt.Sprintf("ALTER TABLE %s ADD COLUMN checksum TEXT", s.migrationTableName()))
+}
+
+// migrationColumns returns the columns of the migrations table, none when
+// the table does not exist.
+func (s *SQLAdapter) migrationColumns() ([]string, error) {
+	var columns []string
+	var err error
+	switch s.GetProvider() {
+	case MYSQL:
+		err = s.DB.Raw("SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?",
+			s.migrationTableName()).Scan(&columns).Error
 	case SQLITE:
-		statement = fmt.Sprintf(`INSERT INTO migrations VALUES(%v, '%v', '%v', %v)`, id, name, desc, time.Now().UnixMilli())
+		err = s.DB.Raw("SELECT name FROM pragma_table_info(?)", s.migrationTableName()).Scan(&columns).Error
 	default:
-		statement = fmt.Sprintf(`INSERT INTO %s.migrations VALUES(%v, '%v', '%v', %v)`, s.GetSchemaName(), id, name, desc, time.Now().UnixMilli())
+		err = s.DB.Raw("SELECT column_name FROM information_schema.columns WHERE table_schema = ? AND table_name = ?",
+			s.GetSchemaName(), s.migrationTableName()).Scan(&columns).Error
 	}
-	return s.Execute(statement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the migrations table's columns: %v", err)
+	}
+	for i, column := range columns {
+		columns[i] = strings.ToLower(column)
+	}
+	return columns, nil
+}
+
+// migrationTableName is the unqualified name of the migrations table.
+func (s *SQLAdapter) migrationTableName() string {
+	return namespacedMigrationTable("migrations", s.migrationNamespace)
+}
+
+// migrationsTable is the migrations table, qualified by the schema where the
+// provider has schemas.
+func (s *SQLAdapter) migrationsTable() string {
+	if s.GetProvider() == SQLITE {
+		return s.migrationTableName()
+	}
+	return fmt.Sprintf("%s.%s", s.GetSchemaName(), s.migrationTableName())
+}
+
+// MigrationNamespace returns a copy of the adapter that records migrations in
+// the namespace's migrations table.
+func (s *SQLAdapter) MigrationNamespace(namespace string) StorageAdapter {
+	return &SQLAdapter{DB: s.DB, config: s.config, provider: s.provider, migrationNamespace: namespace}
+}
+
+// migrationTimestampColumn is the timestamp column, which T-SQL needs quoted.
+func (s *SQLAdapter) migrationTimestampColumn() string {
+	if s.GetProvider() == MSSQL {
+		return "[timestamp]"
+	}
+	return "timestamp"
+}
+
+func (s *SQLAdapter) UpdateMigrationTable(id int, name string, desc string) error {
+	return s.RecordMigration(AppliedMigration{ID: id, Name: name, Description: desc})
+}
+
+func (s *SQLAdapter) RecordMigration(applied AppliedMigration) error {
+	if applied.AppliedAt.IsZero() {
+		applied.AppliedAt = time.Now()
+	}
+	statement := fmt.Sprintf("INSERT INTO %s (id, name, description, %s, checksum) VALUES (?, ?, ?, ?, ?)",
+		s.migrationsTable(), s.migrationTimestampColumn())
+	result := s.DB.Exec(statement, applied.ID, applied.Name, applied.Description, applied.AppliedAt.UnixMilli(), applied.Checksum)
+	if result.Error != nil {
+		return fmt.Errorf("failed to record migration %s: %v", applied.Name, result.Error)
+	}
+	return nil
+}
+
+// AppliedMigrations reads the migrations table. A database that was never
+// migrated has no table and no applied migrations, and rows recorded before
+// migrations were checksummed have an empty checksum, so Status and Plan work
+// on databases Migrate has not run on yet.
+func (s *SQLAdapter) AppliedMigrations() ([]AppliedMigration, error) {
+	var rows []struct {
+		ID          int
+		Name        string
+		Description string
+		Checksum    *string
+		Timestamp   int64
+	}
+	columns, err := s.migrationColumns()
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return []AppliedMigration{}, nil
+	}
+	checksum := "checksum"
+	if !slices.Contains(columns, "checksum") {
+		checksum = "NULL AS checksum"
+	}
+	statement := fmt.Sprintf("SELECT id, name, description, %s, %s FROM %s ORDER BY id",
+		checksum, s.migrationTimestampColumn(), s.migrationsTable())
+	if err := s.DB.Raw(statement).Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to read the migrations table: %v", err)
+	}
+	applied := make([]AppliedMigration, len(rows))
+	for i, row := range rows {
+		applied[i] = AppliedMigration{
+			ID:          row.ID,
+			Name:        row.Name,
+			Description: row.Description,
+			AppliedAt:   time.UnixMilli(row.Timestamp),
+		}
+		if row.Checksum != nil {
+			applied[i].Checksum = *row.Checksum
+		}
+	}
+	return applied, nil
+}
+
+func (s *SQLAdapter) DeleteMigration(id int) error {
+	return s.Execute(fmt.Sprintf(`DELETE FROM %s WHERE id = %v`, s.migrationsTable(), id))
 }
 
 func (s *SQLAdapter) GetLatestMigration() (int, error) {
 	var statement string
 	var latestMigration int
-	var fromSource string
-	switch s.GetProvider() {
-	case SQLITE:
-		fromSource = "migrations"
-	default:
-		fromSource = fmt.Sprintf("%s.migrations", s.GetSchemaName())
-	}
+	fromSource := s.migrationsTable()
 
 	statement = fmt.Sprintf("SELECT max(id) from %s", fromSource)
 	result := s.DB.Raw(statement).Scan(&latestMigration)
@@ -197,6 +372,172 @@ func (s *SQLAdapter) GetLatestMigration() (int, error) {
 	return latestMigration, nil
 }
 
+// InMigrationTransaction runs fn with an adapter whose statements run in one
+// transaction. MySQL commits DDL implicitly, so there only data changes are
+// rolled back.
+func (s *SQLAdapter) InMigrationTransaction(ctx context.Context, fn func(tx StorageAdapter) error) error {
+	return s.dbWithCtx(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&SQLAdapter{DB: tx, config: s.config, provider: s.provider, migrationNamespace: s.migrationNamespace})
+	})
+}
+
+// TransactionalDDL reports whether the provider rolls DDL back with the
+// transaction. PostgreSQL and SQLite do; MySQL commits DDL implicitly, and SQL
+// Server cannot run every DDL statement in a transaction.
+func (s *SQLAdapter) TransactionalDDL() bool {
+	provider := s.GetProvider()
+	return provider == POSTGRESQL || provider == SQLITE
+}
+
+// DryRunMigrations executes the plan's statements in one transaction, so that
+// each migration sees the ones before it, and rolls it back. It returns
+// ErrNotSupported on providers without transactional DDL.
+func (s *SQLAdapter) DryRunMigrations(ctx context.Context, plan MigrationPlan) error {
+	if !s.TransactionalDDL() {
+		return fmt.Errorf("dry-running migrations on %s is %w", s.GetProvider(), ErrNotSupported)
+	}
+	err := s.dbWithCtx(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, planned := range plan.Migrations {
+			for i, stmt := range planned.Statements {
+				if err := tx.Exec(stmt).Error; err != nil {
+					return fmt.Errorf("migration %s statement %d: %w", planned.Name, i+1, err)
+				}
+			}
+		}
+		return errDryRunRollback
+	})
+	if errors.Is(err, errDryRunRollback) {
+		return nil
+	}
+	return err
+}
+
+// SnapshotSchema returns the statements SQLite keeps for the tables, indexes,
+// views and triggers of the database, in the order they were created. It
+// returns ErrNotSupported on other providers, whose schemas are best dumped
+// with their own tools.
+func (s *SQLAdapter) SnapshotSchema(ctx context.Context) ([]Migration, error) {
+	if s.GetProvider() != SQLITE {
+		return nil, fmt.Errorf("snapshotting the schema of %s is %w", s.GetProvider(), ErrNotSupported)
+	}
+	var objects []struct {
+		Type      string `gorm:"column:type"`
+		Name      string `gorm:"column:name"`
+		Table     string `gorm:"column:tbl_name"`
+		Statement string `gorm:"column:sql"`
+	}
+	err := s.dbWithCtx(ctx).Raw(`SELECT type, name, tbl_name, sql FROM sqlite_master
+		WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite\_%' ESCAPE '\' ORDER BY rowid`).Scan(&objects).Error
+	if err != nil {
+		return nil, err
+	}
+	var statements []Migration
+	for _, o := range objects {
+		if o.Table == "migration_lock" || o.Table == "migrations" || strings.HasPrefix(o.Table, "migrations_") {
+			continue
+		}
+		statements = append(statements, Migration{
+			Migrate:  o.Statement,
+			Rollback: fmt.Sprintf(`DROP %s "%s"`, strings.ToUpper(o.Type), o.Name),
+		})
+	}
+	return statements, nil
+}
+
+// migrationLockName names the migration lock of the adapter's schema, for the
+// server-wide locks of PostgreSQL, MySQL and SQL Server.
+func (s *SQLAdapter) migrationLockName() string {
+	name := "magic_migrations"
+	if schema := s.GetSchemaName(); schema != "" {
+		name += "." + schema
+	}
+	// MySQL lock names are limited to 64 characters.
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// TryLockMigrations takes a session-level lock on a connection of its own:
+// an advisory lock on PostgreSQL, GET_LOCK on MySQL and an application lock on
+// SQL Server. The server releases it if the connection drops, so the lease
+// is the connection's, and Refresh pings it. SQLite, which has no such locks,
+// keeps a lock row instead.
+func (s *SQLAdapter) TryLockMigrations(ctx context.Context, owner string, lease time.Duration) (MigrationLock, error) {
+	var acquire, release string
+	name := s.migrationLockName()
+	var key any = name
+	switch s.GetProvider() {
+	case SQLITE:
+		return s.tryLockMigrationRow(ctx, owner, lease)
+	case POSTGRESQL:
+		h := fnv.New64a()
+		h.Write([]byte(name))
+		key = int64(h.Sum64())
+		acquire, release = "SELECT pg_try_advisory_lock($1)", "SELECT pg_advisory_unlock($1)"
+	case MYSQL:
+		acquire, release = "SELECT COALESCE(GET_LOCK(?, 0), 0) = 1", "SELECT RELEASE_LOCK(?)"
+	case MSSQL:
+		acquire = "DECLARE @r INT; EXEC @r = sp_getapplock @Resource = @p1, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = 0; SELECT CAST(CASE WHEN @r >= 0 THEN 1 ELSE 0 END AS BIT)"
+		release = "EXEC sp_releaseapplock @Resource = @p1, @LockOwner = 'Session'"
+	default:
+		return nil, fmt.Errorf("migration locks on %s: %w", s.GetProvider(), ErrNotSupported)
+	}
+
+	db, err := s.DB.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var held bool
+	if err := conn.QueryRowContext(ctx, acquire, key).Scan(&held); err != nil || !held {
+		_ = conn.Close()
+		return nil, err
+	}
+	return funcMigrationLock{
+		refresh: conn.PingContext,
+		release: func(ctx context.Context) error {
+			defer conn.Close()
+			_, err := conn.ExecContext(ctx, release, key)
+			return err
+		},
+	}, nil
+}
+
+// tryLockMigrationRow takes the lock row of migration_lock, which holds at
+// most one row: inserted by the first owner, and taken over by another only
+// once its lease has expired.
+func (s *SQLAdapter) tryLockMigrationRow(ctx context.Context, owner string, lease time.Duration) (MigrationLock, error) {
+	db := s.DB.WithContext(ctx)
+	err := db.Exec("CREATE TABLE IF NOT EXISTS migration_lock (id INTEGER PRIMARY KEY CHECK (id = 1), owner TEXT NOT NULL, expires_at INTEGER NOT NULL)").Error
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	result := db.Exec(`INSERT INTO migration_lock (id, owner, expires_at) VALUES (1, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at
+		WHERE migration_lock.expires_at < ?`, owner, now.Add(lease).UnixMilli(), now.UnixMilli())
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return funcMigrationLock{
+		refresh: func(ctx context.Context) error {
+			result := s.DB.WithContext(ctx).Exec("UPDATE migration_lock SET expires_at = ? WHERE id = 1 AND owner = ?",
+				time.Now().Add(lease).UnixMilli(), owner)
+			if result.Error == nil && result.RowsAffected == 0 {
+				return fmt.Errorf("migration lock of %s was taken over", owner)
+			}
+			return result.Error
+		},
+		release: func(ctx context.Context) error {
+			return s.DB.WithContext(ctx).Exec("DELETE FROM migration_lock WHERE id = 1 AND owner = ?", owner).Error
+		},
+	}, nil
+}
+
 func (s *SQLAdapter) Create(item any, params ...map[string]any) error {
 	return s.CreateContext(context.Background(), item, params...)
 }
@@ -206,6 +547,19 @@ func (s *SQLAdapter) CreateContext(ctx context.Context, item any, params ...map[
 	return result.Error
 }
 
+// CreateIfAbsentContext inserts item unless its primary key is taken, with
+// ON CONFLICT DO NOTHING or the provider's equivalent.
+func (s *SQLAdapter) CreateIfAbsentContext(ctx context.Context, item any, params ...map[string]any) error {
+	result := s.dbWithCtx(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(reflect.ValueOf(item).Interface())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
 func (s *SQLAdapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
 	return s.GetContext(context.Background(), dest, filter, params...)
 }
@@ -216,23 +570,67 @@ func (s *SQLAdapter) GetContext(ctx context.Context, dest any, filter map[string
 	}
 	query, bindings := s.buildQuery(filter)
 	result := s.dbWithCtx(ctx).Where(query, bindings).Find(dest)
+	if result.Error != nil {
+		return result.Error
+	}
 	if result.RowsAffected == 0 {
 		return ErrNotFound
 	}
-	return result.Error
+	return nil
 }
 
 func (s *SQLAdapter) Update(item any, filter map[string]any, params ...map[string]any) error {
 	return s.UpdateContext(context.Background(), item, filter, params...)
 }
 
+// UpdateContext replaces the row with item's primary key. It fails with
+// ErrNotFound, and writes nothing, unless that row exists and matches filter.
 func (s *SQLAdapter) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
 	if len(filter) == 0 {
 		return errors.New("filtering is required when updating a resource")
 	}
 	query, bindings := s.buildQuery(filter)
-	result := s.dbWithCtx(ctx).Where(query, bindings).Save(item)
-	return result.Error
+	return s.dbWithCtx(ctx).Transaction(func(tx *gorm.DB) error {
+		// Reading the row first, rather than only RowsAffected, also finds
+		// rows MySQL reports as unaffected because the update changed
+		// nothing. FOR UPDATE keeps the row until the update, so it cannot
+		// be deleted in between; SQLite, which has no row locks, ignores it.
+		key, err := primaryKey(tx, item)
+		if err != nil {
+			return err
+		}
+		var matched []map[string]any
+		err = tx.Model(item).Clauses(clause.Locking{Strength: "UPDATE"}).Where(key).Where(query, bindings).Limit(1).Find(&matched).Error
+		if err != nil {
+			return err
+		}
+		if len(matched) == 0 {
+			return ErrNotFound
+		}
+		result := tx.Model(item).Where(query, bindings).Select("*").Updates(item)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 && s.provider != MYSQL {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// primaryKey returns a condition on item's primary key columns.
+func primaryKey(db *gorm.DB, item any) (clause.AndConditions, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(item); err != nil {
+		return clause.AndConditions{}, err
+	}
+	value := reflect.Indirect(reflect.ValueOf(item))
+	var key clause.AndConditions
+	for _, field := range stmt.Schema.PrimaryFields {
+		v, _ := field.ValueOf(db.Statement.Context, value)
+		key.Exprs = append(key.Exprs, clause.Eq{Column: clause.Column{Name: field.DBName}, Value: v})
+	}
+	return key, nil
 }
 
 func (s *SQLAdapter) Delete(item any, filter map[string]any, params ...map[string]any) error {
@@ -364,13 +762,97 @@ func (s *SQLAdapter) SearchContext(ctx context.Context, dest any, sortKey string
 		})
 	}
 
-	destType := reflect.TypeOf(dest).Elem().Elem()
-	model := reflect.New(destType).Elem().Interface()
+	whereClause, queryParams, err := s.parseLuceneQuery(dest, query)
+	if err != nil {
+		return "", err
+	}
+
+	return s.executePaginatedQuery(ctx, dest, sortKey, sortDirection, limit, cursor, func(q *gorm.DB) *gorm.DB {
+		if whereClause != "" {
+			return q.Where(whereClause, queryParams...)
+		}
+		return q
+	})
+}
+
+func (s *SQLAdapter) SearchWithFacets(dest any, sortKey string, query string, limit int, cursor string, facets []string, params ...map[string]any) (string, Facets, error) {
+	return s.SearchWithFacetsContext(context.Background(), dest, sortKey, query, limit, cursor, facets, params...)
+}
+
+// SearchWithFacetsContext runs the search, then one GROUP BY count per facet
+// over the same Lucene WHERE clause. Array fields are unnested by the dialect
+// (unnest on Postgres, json_each on SQLite, JSON_TABLE on MySQL).
+func (s *SQLAdapter) SearchWithFacetsContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, facets []string, params ...map[string]any) (string, Facets, error) {
+	nextCursor, err := s.SearchContext(ctx, dest, sortKey, query, limit, cursor, params...)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(facets) == 0 {
+		return nextCursor, Facets{}, nil
+	}
+
+	parser, err := lucene.NewParser(reflect.New(modelType(dest)).Elem().Interface())
+	if err != nil {
+		return "", nil, err
+	}
+	stmt := &gorm.Statement{DB: s.DB}
+	if err := stmt.Parse(dest); err != nil {
+		return "", nil, fmt.Errorf("failed to resolve table for facets: %w", err)
+	}
+	facetQueries, err := parser.ParseFacetsToSQL(query, s.DB.Statement.Quote(stmt.Table), facets, string(s.provider))
+	if err != nil {
+		if _, ok := err.(*lucene.InvalidFieldError); ok {
+			return "", nil, &serviceErrors.BadRequest{Message: err.Error()}
+		}
+		return "", nil, err
+	}
+
+	result := make(Facets, len(facetQueries))
+	for _, fq := range facetQueries {
+		counts, err := s.scanFacetCounts(ctx, fq.SQL, fq.Params)
+		if err != nil {
+			slog.Error("Facet query failed", "field", fq.Field, "error", err)
+			return "", nil, fmt.Errorf("failed to count facet %s: %w", fq.Field, err)
+		}
+		// Databases disagree on where NULL sorts; re-sort for one order everywhere.
+		sortFacetCounts(counts)
+		result[fq.Field] = counts
+	}
+	return nextCursor, result, nil
+}
+
+// scanFacetCounts runs one rendered facet query. Values are scanned into any
+// so each driver reports its native type; MySQL's []byte is turned into text.
+func (s *SQLAdapter) scanFacetCounts(ctx context.Context, statement string, params []any) ([]FacetCount, error) {
+	rows, err := s.dbWithCtx(ctx).Raw(statement, params...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	parser, err := lucene.NewParser(model)
+	counts := []FacetCount{}
+	for rows.Next() {
+		var fc FacetCount
+		if err := rows.Scan(&fc.Value, &fc.Count); err != nil {
+			return nil, err
+		}
+		if b, ok := fc.Value.([]byte); ok {
+			fc.Value = string(b)
+		}
+		counts = append(counts, fc)
+	}
+	return counts, rows.Err()
+}
+
+// parseLuceneQuery renders a Lucene query to a provider-specific WHERE clause
+// for the struct type behind model (&T, &[]T, ...).
+func (s *SQLAdapter) parseLuceneQuery(model any, query string) (string, []any, error) {
+	instance := reflect.New(modelType(model)).Elem().Interface()
+
+	parser, err := lucene.NewParser(instance)
 	if err != nil {
 		slog.Error("Parser creation failed", "error", err)
-		return "", err
+		return "", nil, err
 	}
 
 	// Pass the SQL provider to generate provider-specific SQL syntax
@@ -379,19 +861,13 @@ func (s *SQLAdapter) SearchContext(ctx context.Context, dest any, sortKey string
 		slog.Error("Filter parsing failed", "error", err)
 		// Wrap InvalidFieldError as BadRequest for proper HTTP 400 response
 		if _, ok := err.(*lucene.InvalidFieldError); ok {
-			return "", &serviceErrors.BadRequest{Message: err.Error()}
+			return "", nil, &serviceErrors.BadRequest{Message: err.Error()}
 		}
-		return "", err
+		return "", nil, err
 	}
 
 	slog.Debug(fmt.Sprintf(`Where clause: %s, with params %s`, whereClause, queryParams))
-
-	return s.executePaginatedQuery(ctx, dest, sortKey, sortDirection, limit, cursor, func(q *gorm.DB) *gorm.DB {
-		if whereClause != "" {
-			return q.Where(whereClause, queryParams...)
-		}
-		return q
-	})
+	return whereClause, queryParams, nil
 }
 
 func (s *SQLAdapter) Count(dest any, filter map[string]any, params ...map[string]any) (int64, error) {
@@ -414,6 +890,61 @@ func (s *SQLAdapter) CountContext(ctx context.Context, dest any, filter map[stri
 	return total, nil
 }
 
+func (s *SQLAdapter) Aggregate(model any, filter any, groupBy []string, metrics []AggSpec, params ...map[string]any) ([]AggregateRow, error) {
+	return s.AggregateContext(context.Background(), model, filter, groupBy, metrics, params...)
+}
+
+// AggregateContext translates the request into a single GROUP BY query.
+// Lucene filters go through the same parser as Search, so the two accept
+// identical query strings.
+func (s *SQLAdapter) AggregateContext(ctx context.Context, model any, filter any, groupBy []string, metrics []AggSpec, params ...map[string]any) ([]AggregateRow, error) {
+	if err := validateAggregate(groupBy, metrics); err != nil {
+		return nil, &serviceErrors.BadRequest{Message: err.Error()}
+	}
+	filterMap, query, err := aggregateFilter(filter)
+	if err != nil {
+		return nil, &serviceErrors.BadRequest{Message: err.Error()}
+	}
+
+	selects := make([]string, 0, len(groupBy)+len(metrics))
+	selects = append(selects, groupBy...)
+	for _, m := range metrics {
+		arg := "*"
+		if m.Field != "" {
+			arg = m.Field
+		}
+		selects = append(selects, fmt.Sprintf("%s(%s) AS %s", strings.ToUpper(string(m.Func)), arg, m.alias()))
+	}
+
+	q := s.dbWithCtx(ctx).Model(model).Select(strings.Join(selects, ", "))
+	switch {
+	case len(filterMap) > 0:
+		where, bindings := s.buildQuery(filterMap)
+		q = q.Where(where, bindings)
+	case query != "":
+		where, queryParams, err := s.parseLuceneQuery(model, query)
+		if err != nil {
+			return nil, err
+		}
+		if where != "" {
+			q = q.Where(where, queryParams...)
+		}
+	}
+	for _, g := range groupBy {
+		q = q.Group(g)
+	}
+	if len(groupBy) > 0 {
+		q = q.Order(strings.Join(groupBy, ", "))
+	}
+
+	var rows []map[string]any
+	if err := q.Find(&rows).Error; err != nil {
+		slog.Error("Aggregate query failed", "error", err)
+		return nil, err
+	}
+	return splitAggregateRows(rows, groupBy), nil
+}
+
 func (s *SQLAdapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
 	return s.QueryContext(context.Background(), dest, statement, limit, cursor, params...)
 }
@@ -422,7 +953,6 @@ func (s *SQLAdapter) QueryContext(ctx context.Context, dest any, statement strin
 	return "", fmt.Errorf("not implemented yet")
 }
 
-
 func (s *SQLAdapter) buildQuery(filter map[string]any) (string, map[string]any) {
 	clauses := []string{}
 	bindings := make(map[string]any)
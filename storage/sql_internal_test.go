@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/driver/sqlserver"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+func TestSQLServerDSN(t *testing.T) {
+	dsn := sqlserverDSN(map[string]string{
+		"host":                   "db.internal",
+		"port":                   "1433",
+		"user":                   "magic",
+		"password":               "p@ss:word/",
+		"dbname":                 "orders",
+		"schema":                 "sales",
+		"encrypt":                "true",
+		"TrustServerCertificate": "false",
+	})
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("DSN %q does not parse: %v", dsn, err)
+	}
+	if u.Scheme != "sqlserver" || u.Host != "db.internal:1433" {
+		t.Errorf("DSN %q has scheme %q and host %q", dsn, u.Scheme, u.Host)
+	}
+	if password, _ := u.User.Password(); u.User.Username() != "magic" || password != "p@ss:word/" {
+		t.Errorf("DSN %q does not round-trip the credentials", dsn)
+	}
+	want := url.Values{"database": {"orders"}, "encrypt": {"true"}, "TrustServerCertificate": {"false"}}
+	if got := u.Query(); got.Encode() != want.Encode() {
+		t.Errorf("DSN query = %v, want %v; schema must not be passed to the driver", got, want)
+	}
+}
+
+// newDryRunSQLServerAdapter returns an adapter whose statements are recorded
+// rather than sent, so the T-SQL DDL can be checked without a server.
+func newDryRunSQLServerAdapter(t *testing.T) (*SQLAdapter, *[]string) {
+	t.Helper()
+	db, err := gorm.Open(sqlserver.Open("sqlserver://u:p@localhost:1433"), &gorm.Config{
+		NamingStrategy:       schema.NamingStrategy{TablePrefix: "sales."},
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statements []string
+	record := func(tx *gorm.DB) { statements = append(statements, tx.Statement.SQL.String()) }
+	if err := db.Callback().Raw().After("gorm:raw").Register("test:record", record); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Callback().Query().After("gorm:query").Register("test:record", record); err != nil {
+		t.Fatal(err)
+	}
+	return &SQLAdapter{DB: db, config: map[string]string{"schema": "sales"}, provider: MSSQL}, &statements
+}
+
+func TestSQLServerDDL(t *testing.T) {
+	adapter, statements := newDryRunSQLServerAdapter(t)
+	if err := adapter.CreateSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.CreateMigrationTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.UpdateMigrationTable(3, "3__orders.yaml", "orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"IF SCHEMA_ID(N'sales') IS NULL EXEC('CREATE SCHEMA [sales]')",
+		"IF OBJECT_ID(N'sales.migrations', N'U') IS NULL CREATE TABLE sales.migrations (id INT PRIMARY KEY, name NVARCHAR(MAX), description NVARCHAR(MAX), [timestamp] BIGINT, checksum NVARCHAR(64))",
+		"IF COL_LENGTH(N'sales.migrations', 'checksum') IS NULL ALTER TABLE sales.migrations ADD checksum NVARCHAR(64)",
+		"INSERT INTO sales.migrations (id, name, description, [timestamp], checksum) VALUES (@p1, @p2, @p3, @p4, @p5)",
+	}
+	if len(*statements) != len(want) {
+		t.Fatalf("recorded %d statements, want %d: %q", len(*statements), len(want), *statements)
+	}
+	for i, stmt := range *statements {
+		if !strings.HasPrefix(stmt, want[i]) {
+			t.Errorf("statement %d = %q, want %q", i, stmt, want[i])
+		}
+	}
+}
+
+// Cursor pagination always orders, which is what SQL Server's OFFSET ... FETCH
+// requires; without an ORDER BY the driver would have to invent one.
+func TestSQLServerPaginationUsesOffsetFetch(t *testing.T) {
+	adapter, statements := newDryRunSQLServerAdapter(t)
+	type order struct {
+		Id     string `json:"id"`
+		Status string `json:"status"`
+	}
+	cursor := base64.StdEncoding.EncodeToString([]byte("o-42"))
+	var dest []order
+	_, err := adapter.List(&dest, "id", map[string]any{"status": "open"}, 10, cursor,
+		map[string]any{SortDirectionKey: "DESC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `SELECT * FROM "sales"."orders" WHERE id < @p1 AND status = @p2 ORDER BY id DESC OFFSET 0 ROW FETCH NEXT 11 ROWS ONLY`
+	if len(*statements) != 1 || (*statements)[0] != want {
+		t.Errorf("paginated query = %q, want %q", *statements, want)
+	}
+}
+
+type lockedWidget struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Update reads the row it replaces FOR UPDATE, so a concurrent delete cannot
+// slip between the check and the write.
+func TestSQLUpdateLocksTheRow(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SQLite has no row locks and drops FOR UPDATE; render it anyway.
+	delete(db.ClauseBuilders, "FOR")
+	var statements []string
+	record := func(tx *gorm.DB) { statements = append(statements, tx.Statement.SQL.String()) }
+	if err := db.Callback().Query().After("gorm:query").Register("test:record", record); err != nil {
+		t.Fatal(err)
+	}
+	adapter := &SQLAdapter{DB: db, provider: SQLITE}
+	err = adapter.Update(&lockedWidget{Id: "w1", Name: "b"}, map[string]any{"name": "a"})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Update = %v; want ErrNotFound from the dry run's empty read", err)
+	}
+	if len(statements) != 1 || !strings.HasSuffix(statements[0], "FOR UPDATE") {
+		t.Fatalf("statements = %q; want a read FOR UPDATE", statements)
+	}
+}
+
+func TestSQLUpdateFailsWhenNothingIsWritten(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&lockedWidget{}); err != nil {
+		t.Fatal(err)
+	}
+	adapter := &SQLAdapter{DB: db, provider: SQLITE}
+	if err := db.Create(&lockedWidget{Id: "w1", Name: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	// The row goes after it was read, as on a store without row locks.
+	err = db.Callback().Update().Before("gorm:update").Register("test:delete", func(tx *gorm.DB) {
+		tx.Session(&gorm.Session{NewDB: true}).Exec("DELETE FROM locked_widgets")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.Update(&lockedWidget{Id: "w1", Name: "b"}, map[string]any{"name": "a"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Update = %v; want ErrNotFound", err)
+	}
+}
@@ -124,6 +124,13 @@ func TestSQLAdapterGetLatestMigrationReturnsMaxId(t *testing.T) {
 	if latest != 20 {
 		t.Fatalf("GetLatestMigration = %d; want 20", latest)
 	}
+
+	if err := sql.DeleteMigration(20); err != nil {
+		t.Fatalf("DeleteMigration 20: %v", err)
+	}
+	if latest, err = sql.GetLatestMigration(); err != nil || latest != 10 {
+		t.Fatalf("GetLatestMigration after DeleteMigration = %d, %v; want 10", latest, err)
+	}
 }
 
 func TestSQLAdapterQueryReturnsNotImplemented(t *testing.T) {
@@ -12,6 +12,11 @@ import (
 var ConfigFs embed.FS
 var ErrNotFound = errors.New("the requested resource was not found")
 
+// ErrNotSupported is returned by optional operations (see the extension
+// interfaces such as Aggregator) when the underlying adapter cannot perform
+// them.
+var ErrNotSupported = errors.New("the requested operation is not supported by this storage adapter")
+
 type StorageAdapter interface {
 	Execute(statement string) error
 	Ping() error
@@ -45,18 +50,27 @@ type StorageProviders string
 type StorageAdapterFactory struct{}
 
 const (
-	// CASSANDRA StorageAdapterType = "cassandra"
-	COSMOSDB StorageAdapterType = "cosmosdb"
-	DYNAMODB StorageAdapterType = "dynamodb"
-	MEMORY   StorageAdapterType = "memory"
-	SQL      StorageAdapterType = "sql"
+	BBOLT     StorageAdapterType = "bbolt"
+	CASSANDRA StorageAdapterType = "cassandra"
+	COSMOSDB  StorageAdapterType = "cosmosdb"
+	DYNAMODB  StorageAdapterType = "dynamodb"
+	MEMORY    StorageAdapterType = "memory"
+	REDIS     StorageAdapterType = "redis"
+	SQL       StorageAdapterType = "sql"
 )
 
 const (
 	POSTGRESQL        StorageProviders = "postgresql"
 	MYSQL             StorageProviders = "mysql"
 	SQLITE            StorageProviders = "sqlite"
+	MSSQL             StorageProviders = "sqlserver"
 	COSMOSDB_PROVIDER StorageProviders = "cosmosdb"
+	// CASSANDRA_PROVIDER and SCYLLADB select the migrations directory of a
+	// CASSANDRA adapter; the adapter itself speaks CQL to either.
+	CASSANDRA_PROVIDER StorageProviders = "cassandra"
+	SCYLLADB           StorageProviders = "scylladb"
+	BBOLT_PROVIDER     StorageProviders = "bbolt"
+	REDIS_PROVIDER     StorageProviders = "redis"
 )
 
 type SortingDirection string
@@ -123,10 +137,14 @@ func (s StorageAdapterFactory) GetInstance(adapterType StorageAdapterType, confi
 		err   error
 	)
 	switch adapterType {
-	// case CASSANDRA:
-	// 	return GetCassandraAdapter(config.(map[string]string))
+	case BBOLT:
+		inner = GetBoltAdapterInstance(config.(map[string]string))
+	case CASSANDRA:
+		inner = GetCassandraAdapterInstance(config.(map[string]string))
 	case MEMORY:
 		inner = GetMemoryAdapterInstance()
+	case REDIS:
+		inner = GetRedisAdapterInstance(config.(map[string]string))
 	case SQL:
 		inner = GetSQLAdapterInstance(config.(map[string]string))
 	case DYNAMODB:
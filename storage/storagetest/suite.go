@@ -0,0 +1,352 @@
+// Package storagetest is a conformance suite for storage.StorageAdapter
+// implementations.
+//
+// The built-in adapters grew separately and disagree in places that callers
+// notice only in production: which error a missing item produces, whether
+// Update inserts, whether a cancelled context is honoured, how cursors end. Run pins the behaviour the
+// storage package documents, so an adapter that passes it can be swapped for
+// another that does.
+//
+//	func TestMyAdapter(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) storage.StorageAdapter {
+//			adapter := mystore.New(...)
+//			// create an empty conformance_items table, or clear it
+//			return adapter
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/tink3rlabs/magic/storage"
+)
+
+// Table is the table, bucket or collection the suite reads and writes.
+const Table = "conformance_items"
+
+// ConformanceItem is the model the suite stores. Its table is Table, so an
+// adapter that names tables after the model type finds it there. Every field
+// except Rank is text, and Id and Name are unique, so the suite only sorts by
+// keys every adapter can paginate on.
+type ConformanceItem struct {
+	Id     string `json:"id" gorm:"primaryKey"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Rank   int    `json:"rank"`
+}
+
+// Items is the data set the listing, search and count cases start from.
+var Items = []ConformanceItem{
+	{Id: "a", Name: "echo", Status: "open", Rank: 1},
+	{Id: "b", Name: "delta", Status: "closed", Rank: 2},
+	{Id: "c", Name: "charlie", Status: "open", Rank: 3},
+	{Id: "d", Name: "bravo", Status: "closed", Rank: 4},
+	{Id: "e", Name: "alpha", Status: "open", Rank: 5},
+}
+
+// NewAdapter returns an adapter whose Table exists and is empty. It is called
+// once per case, so cases never see each other's writes.
+type NewAdapter func(t *testing.T) storage.StorageAdapter
+
+// Run runs every case against adapters from newAdapter.
+func Run(t *testing.T, newAdapter NewAdapter) {
+	t.Run("CRUD", func(t *testing.T) { testCRUD(t, newAdapter) })
+	t.Run("NotFound", func(t *testing.T) { testNotFound(t, newAdapter) })
+	t.Run("Update", func(t *testing.T) { testUpdate(t, newAdapter) })
+	t.Run("List", func(t *testing.T) { testList(t, newAdapter) })
+	t.Run("Search", func(t *testing.T) { testSearch(t, newAdapter) })
+	t.Run("Count", func(t *testing.T) { testCount(t, newAdapter) })
+	t.Run("Context", func(t *testing.T) { testContext(t, newAdapter) })
+}
+
+func seed(t *testing.T, newAdapter NewAdapter) storage.StorageAdapter {
+	t.Helper()
+	adapter := newAdapter(t)
+	for _, item := range Items {
+		if err := adapter.Create(&item); err != nil {
+			t.Fatalf("Create(%s): %v", item.Id, err)
+		}
+	}
+	return adapter
+}
+
+func ids(items []ConformanceItem) []string {
+	out := []string{}
+	for _, item := range items {
+		out = append(out, item.Id)
+	}
+	return out
+}
+
+func testCRUD(t *testing.T, newAdapter NewAdapter) {
+	adapter := newAdapter(t)
+	item := ConformanceItem{Id: "x", Name: "xray", Status: "open", Rank: 7}
+	if err := adapter.Create(&item); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := adapter.Create(&ConformanceItem{Id: "x", Name: "again"}); err == nil {
+		t.Error("Create with an existing key succeeded; it must fail")
+	}
+
+	var got ConformanceItem
+	if err := adapter.Get(&got, map[string]any{"id": "x"}); err != nil {
+		t.Fatalf("Get by key: %v", err)
+	}
+	if got != item {
+		t.Errorf("Get by key = %+v, want %+v", got, item)
+	}
+	got = ConformanceItem{}
+	if err := adapter.Get(&got, map[string]any{"name": "xray"}); err != nil || got.Id != "x" {
+		t.Errorf("Get by another field = %+v, %v", got, err)
+	}
+
+	updated := ConformanceItem{Id: "x", Name: "xylophone", Status: "closed", Rank: 8}
+	if err := adapter.Update(&updated, map[string]any{"id": "x"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	got = ConformanceItem{}
+	if err := adapter.Get(&got, map[string]any{"id": "x"}); err != nil || got != updated {
+		t.Errorf("Get after Update = %+v, %v; want %+v", got, err, updated)
+	}
+
+	if err := adapter.Delete(&ConformanceItem{}, map[string]any{"id": "x"}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := adapter.Get(&got, map[string]any{"id": "x"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Get after Delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func testNotFound(t *testing.T, newAdapter NewAdapter) {
+	adapter := seed(t, newAdapter)
+	var got ConformanceItem
+	if err := adapter.Get(&got, map[string]any{"id": "missing"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Get of a missing key error = %v, want ErrNotFound", err)
+	}
+	if err := adapter.Get(&got, map[string]any{"status": "archived"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Get with an unmatched filter error = %v, want ErrNotFound", err)
+	}
+	err := adapter.Get(&got, map[string]any{})
+	if err == nil || errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Get without a filter error = %v; it must be refused, not reported as not found", err)
+	}
+	if err := adapter.Delete(&ConformanceItem{}, map[string]any{"id": "missing"}); err != nil {
+		t.Errorf("Delete of a missing key error = %v; deleting nothing is not an error", err)
+	}
+}
+
+// testUpdate pins what Update does when nothing matches: it replaces only a
+// stored item with the item's key that also matches the filter, and otherwise
+// fails with ErrNotFound and writes nothing. It never inserts.
+func testUpdate(t *testing.T, newAdapter NewAdapter) {
+	adapter := seed(t, newAdapter)
+	original := Items[0]
+
+	missing := ConformanceItem{Id: "missing", Name: "mike", Status: "open"}
+	if err := adapter.Update(&missing, map[string]any{"id": "missing"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Update of a missing key error = %v, want ErrNotFound", err)
+	}
+	var got ConformanceItem
+	if err := adapter.Get(&got, map[string]any{"id": "missing"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Update of a missing key inserted it: %+v, %v", got, err)
+	}
+
+	changed := ConformanceItem{Id: original.Id, Name: "changed", Status: "closed", Rank: 9}
+	if err := adapter.Update(&changed, map[string]any{"id": original.Id, "status": "closed"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Update with an unmatched filter error = %v, want ErrNotFound", err)
+	}
+	got = ConformanceItem{}
+	if err := adapter.Get(&got, map[string]any{"id": original.Id}); err != nil || got != original {
+		t.Errorf("Get after an unmatched Update = %+v, %v; want %+v", got, err, original)
+	}
+
+	if err := adapter.Update(&changed, map[string]any{"id": original.Id, "status": original.Status}); err != nil {
+		t.Fatalf("Update with a matching filter: %v", err)
+	}
+	got = ConformanceItem{}
+	if err := adapter.Get(&got, map[string]any{"id": original.Id}); err != nil || got != changed {
+		t.Errorf("Get after Update = %+v, %v; want %+v", got, err, changed)
+	}
+
+	if err := adapter.Update(&changed, map[string]any{}); err == nil {
+		t.Error("Update without a filter succeeded; it must be refused")
+	}
+}
+
+// listAll follows cursors until the last page and reports every page it
+// read, so a case can check both the rows and where the pages broke.
+func listAll(t *testing.T, fetch func(cursor string) ([]ConformanceItem, string, error)) [][]string {
+	t.Helper()
+	var pages [][]string
+	cursor := ""
+	for {
+		if len(pages) > len(Items) {
+			t.Fatalf("pagination did not terminate after %d pages: %v", len(pages), pages)
+		}
+		page, next, err := fetch(cursor)
+		if err != nil {
+			t.Fatalf("page %d: %v", len(pages)+1, err)
+		}
+		pages = append(pages, ids(page))
+		if next == "" {
+			return pages
+		}
+		cursor = next
+	}
+}
+
+func testList(t *testing.T, newAdapter NewAdapter) {
+	adapter := seed(t, newAdapter)
+	desc := map[string]any{storage.SortDirectionKey: "DESC"}
+	tests := []struct {
+		name    string
+		sortKey string
+		filter  map[string]any
+		params  map[string]any
+		limit   int
+		want    [][]string
+	}{
+		{"by key", "id", nil, nil, 2, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}},
+		{"by key descending", "id", nil, desc, 2, [][]string{{"e", "d"}, {"c", "b"}, {"a"}}},
+		{"by another field", "name", nil, nil, 2, [][]string{{"e", "d"}, {"c", "b"}, {"a"}}},
+		{"by another field descending", "name", nil, desc, 3, [][]string{{"a", "b", "c"}, {"d", "e"}}},
+		{"filtered", "id", map[string]any{"status": "open"}, nil, 2, [][]string{{"a", "c"}, {"e"}}},
+		{"one page", "id", nil, nil, 10, [][]string{{"a", "b", "c", "d", "e"}}},
+		{"no match", "id", map[string]any{"status": "archived"}, nil, 2, [][]string{{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := listAll(t, func(cursor string) ([]ConformanceItem, string, error) {
+				var page []ConformanceItem
+				next, err := adapter.List(&page, tt.sortKey, tt.filter, tt.limit, cursor, tt.params)
+				return page, next, err
+			})
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("pages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	var page []ConformanceItem
+	if _, err := adapter.List(&page, "id", nil, 2, "", map[string]any{storage.SortDirectionKey: "sideways"}); err == nil {
+		t.Error("List with an invalid sort direction succeeded")
+	}
+}
+
+func testSearch(t *testing.T, newAdapter NewAdapter) {
+	adapter := seed(t, newAdapter)
+	var probe []ConformanceItem
+	if _, err := adapter.Search(&probe, "id", "status:open", 1, ""); errors.Is(err, storage.ErrNotSupported) {
+		t.Skip("Search returns ErrNotSupported")
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		limit int
+		want  [][]string
+	}{
+		{"empty query lists everything", "", 10, [][]string{{"a", "b", "c", "d", "e"}}},
+		{"term", "status:open", 2, [][]string{{"a", "c"}, {"e"}}},
+		{"wildcard", "name:*a", 10, [][]string{{"b", "e"}}},
+		{"range", "rank:[2 TO 4]", 10, [][]string{{"b", "c", "d"}}},
+		{"conjunction", "status:open AND rank:[2 TO 5]", 1, [][]string{{"c"}, {"e"}}},
+		{"negation", "-status:open", 10, [][]string{{"b", "d"}}},
+		{"no match", "name:zulu", 10, [][]string{{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := listAll(t, func(cursor string) ([]ConformanceItem, string, error) {
+				var page []ConformanceItem
+				next, err := adapter.Search(&page, "id", tt.query, tt.limit, cursor)
+				return page, next, err
+			})
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("pages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	var page []ConformanceItem
+	if _, err := adapter.Search(&page, "id", "nosuchfield:x", 10, ""); err == nil {
+		t.Error("Search on an unknown field succeeded")
+	}
+}
+
+func testCount(t *testing.T, newAdapter NewAdapter) {
+	adapter := seed(t, newAdapter)
+	tests := []struct {
+		filter map[string]any
+		want   int64
+	}{
+		{nil, 5},
+		{map[string]any{"status": "open"}, 3},
+		{map[string]any{"status": "closed", "rank": 4}, 1},
+		{map[string]any{"status": "archived"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.filter), func(t *testing.T) {
+			got, err := adapter.Count(&ConformanceItem{}, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Count(%v) = %d, want %d", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func testContext(t *testing.T, newAdapter NewAdapter) {
+	adapter, ok := seed(t, newAdapter).(storage.ContextualStorageAdapter)
+	if !ok {
+		t.Skip("adapter does not implement ContextualStorageAdapter")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var item ConformanceItem
+	var page []ConformanceItem
+	calls := map[string]func() error{
+		"Create": func() error {
+			return adapter.CreateContext(ctx, &ConformanceItem{Id: "z", Name: "zulu"})
+		},
+		"Get": func() error {
+			return adapter.GetContext(ctx, &item, map[string]any{"id": "a"})
+		},
+		"Update": func() error {
+			return adapter.UpdateContext(ctx, &ConformanceItem{Id: "a", Name: "echo"}, map[string]any{"id": "a"})
+		},
+		"Delete": func() error {
+			return adapter.DeleteContext(ctx, &ConformanceItem{}, map[string]any{"id": "a"})
+		},
+		"List": func() error {
+			_, err := adapter.ListContext(ctx, &page, "id", nil, 10, "")
+			return err
+		},
+		"Count": func() error {
+			_, err := adapter.CountContext(ctx, &ConformanceItem{}, nil)
+			return err
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			if err := call(); !errors.Is(err, context.Canceled) {
+				t.Errorf("%sContext with a cancelled context error = %v, want context.Canceled", name, err)
+			}
+		})
+	}
+
+	// Nothing the cancelled calls attempted may have happened.
+	if err := adapter.Get(&item, map[string]any{"id": "a"}); err != nil {
+		t.Errorf("item deleted under a cancelled context: %v", err)
+	}
+	if err := adapter.Get(&item, map[string]any{"id": "z"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("item created under a cancelled context: %v", err)
+	}
+}
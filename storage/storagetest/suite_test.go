@@ -0,0 +1,50 @@
+package storagetest_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/tink3rlabs/magic/storage"
+	"github.com/tink3rlabs/magic/storage/storagetest"
+)
+
+func TestMemoryAdapter(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.StorageAdapter {
+		adapter := storage.GetMemoryAdapterInstance()
+		for _, statement := range []string{
+			"CREATE TABLE IF NOT EXISTS conformance_items (id TEXT PRIMARY KEY, name TEXT, status TEXT, rank INTEGER)",
+			"DELETE FROM conformance_items",
+		} {
+			if err := adapter.Execute(statement); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return adapter
+	})
+}
+
+func TestBoltAdapter(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.StorageAdapter {
+		adapter, err := storage.NewBoltAdapter(map[string]string{"path": filepath.Join(t.TempDir(), "conformance.db")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = adapter.Close() })
+		if err := adapter.Execute("CREATE TABLE conformance_items; CREATE INDEX ON conformance_items (status)"); err != nil {
+			t.Fatal(err)
+		}
+		return adapter
+	})
+}
+
+func TestRedisAdapter(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.StorageAdapter {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { _ = client.Close() })
+		return storage.NewRedisAdapter(client, map[string]string{})
+	})
+}
@@ -63,16 +63,18 @@ const (
 	statusOK    = "ok"
 	statusError = "error"
 
-	opCreate  = "create"
-	opGet     = "get"
-	opUpdate  = "update"
-	opDelete  = "delete"
-	opList    = "list"
-	opSearch  = "search"
-	opCount   = "count"
-	opQuery   = "query"
-	opExecute = "execute"
-	opPing    = "ping"
+	opCreate    = "create"
+	opGet       = "get"
+	opUpdate    = "update"
+	opDelete    = "delete"
+	opList      = "list"
+	opSearch    = "search"
+	opCount     = "count"
+	opQuery     = "query"
+	opExecute   = "execute"
+	opPing      = "ping"
+	opAggregate = "aggregate"
+	opFacets    = "search_facets"
 )
 
 // storageDurationBuckets mirrors observability.storageDurationBuckets.
@@ -141,6 +143,19 @@ func wrapForTelemetry(inner StorageAdapter) StorageAdapter {
 	return w
 }
 
+// Instrument wraps an adapter with the tracing and metrics that
+// StorageAdapterFactory.GetInstance applies. Use it to put a
+// decorator beneath the instrumentation, so that what the decorator
+// does shows up on the storage span:
+//
+//	adapter := storage.Instrument(faulty.New(storage.UnwrapAdapter(base), opts))
+//
+// Like GetInstance, it does not wrap an adapter that is already
+// instrumented.
+func Instrument(adapter StorageAdapter) StorageAdapter {
+	return wrapForTelemetry(adapter)
+}
+
 // UnwrapStorageAdapter implements TelemetryUnwrapper by returning the delegate
 // adapter without telemetry wrapping.
 func (w *instrumentedAdapter) UnwrapStorageAdapter() StorageAdapter {
@@ -439,6 +454,42 @@ func (w *instrumentedAdapter) CountContext(ctx context.Context, dest any, filter
 	return w.inner.Count(dest, filter, params...)
 }
 
+func (w *instrumentedAdapter) Aggregate(model any, filter any, groupBy []string, metrics []AggSpec, params ...map[string]any) ([]AggregateRow, error) {
+	return w.AggregateContext(context.Background(), model, filter, groupBy, metrics, params...)
+}
+
+// AggregateContext forwards to the inner adapter when it implements
+// Aggregator and reports ErrNotSupported otherwise, so callers can always
+// type-assert the wrapper and branch on the error.
+func (w *instrumentedAdapter) AggregateContext(ctx context.Context, model any, filter any, groupBy []string, metrics []AggSpec, params ...map[string]any) (rows []AggregateRow, err error) {
+	agg, ok := w.inner.(Aggregator)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	ctx, obs := w.begin(ctx, opAggregate, attribute.String("magic.storage.model", modelName(model)))
+	defer func() { w.end(obs, err) }()
+	return agg.AggregateContext(ctx, model, filter, groupBy, metrics, params...)
+}
+
+func (w *instrumentedAdapter) SearchWithFacets(dest any, sortKey string, query string, limit int, cursor string, facets []string, params ...map[string]any) (string, Facets, error) {
+	return w.SearchWithFacetsContext(context.Background(), dest, sortKey, query, limit, cursor, facets, params...)
+}
+
+func (w *instrumentedAdapter) SearchWithFacetsContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, facets []string, params ...map[string]any) (next string, counts Facets, err error) {
+	f, ok := w.inner.(Faceter)
+	if !ok {
+		return "", nil, ErrNotSupported
+	}
+	ctx, obs := w.begin(ctx, opFacets,
+		attribute.String("magic.storage.model", modelName(dest)),
+		attribute.String("magic.storage.sort_field", sortKey),
+		attribute.Int("magic.storage.limit", limit),
+		attribute.StringSlice("magic.storage.facets", facets),
+	)
+	defer func() { w.end(obs, err) }()
+	return f.SearchWithFacetsContext(ctx, dest, sortKey, query, limit, cursor, facets, params...)
+}
+
 func (w *instrumentedAdapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
 	return w.QueryContext(context.Background(), dest, statement, limit, cursor, params...)
 }
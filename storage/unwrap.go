@@ -22,9 +22,10 @@ type TelemetryUnwrapper interface {
 	UnwrapStorageAdapter() StorageAdapter
 }
 
-// UnwrapAdapter returns the inner StorageAdapter when s is (directly or
-// transitively) the telemetry instrumented wrapper used by magic; otherwise it
-// returns s unchanged.
+// UnwrapAdapter returns the innermost StorageAdapter, peeling the telemetry
+// instrumented wrapper used by magic and any other decorator that implements
+// TelemetryUnwrapper (for example encryption.Adapter); otherwise it returns s
+// unchanged.
 //
 // Call this before type assertions to concrete adapter implementations (for
 // example *SQLAdapter, *MemoryAdapter, *DynamoDBAdapter), including before
@@ -33,10 +34,35 @@ type TelemetryUnwrapper interface {
 func UnwrapAdapter(s StorageAdapter) StorageAdapter {
 	var cur = s
 	for {
-		w, ok := cur.(*instrumentedAdapter)
+		w, ok := cur.(TelemetryUnwrapper)
 		if !ok {
 			return cur
 		}
-		cur = w.inner
+		cur = w.UnwrapStorageAdapter()
+	}
+}
+
+// AdapterAs returns the first adapter in the chain of wrappers around s, s
+// included, that implements T, so that packages outside storage can find an
+// extension interface such as ConditionalCreator behind decorators.
+func AdapterAs[T any](s StorageAdapter) (T, bool) {
+	return unwrapAs[T](s)
+}
+
+// unwrapAs returns the first adapter in the chain of wrappers around s, s
+// included, that implements T. Decorators pass the StorageAdapter methods
+// through but not every extension interface, so extensions that only concern
+// the underlying store, such as the migration bookkeeping, are looked up here.
+func unwrapAs[T any](s StorageAdapter) (T, bool) {
+	for {
+		if t, ok := s.(T); ok {
+			return t, true
+		}
+		w, ok := s.(TelemetryUnwrapper)
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		s = w.UnwrapStorageAdapter()
 	}
 }
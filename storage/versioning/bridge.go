@@ -0,0 +1,53 @@
+package versioning
+
+import (
+	"context"
+
+	"github.com/tink3rlabs/magic/storage"
+)
+
+// legacyBridge gives an adapter without Context variants the
+// ContextualStorageAdapter shape by dropping the context.
+type legacyBridge struct {
+	storage.StorageAdapter
+}
+
+func (b legacyBridge) ExecuteContext(_ context.Context, statement string) error {
+	return b.Execute(statement)
+}
+
+func (b legacyBridge) PingContext(context.Context) error {
+	return b.Ping()
+}
+
+func (b legacyBridge) CreateContext(_ context.Context, item any, params ...map[string]any) error {
+	return b.StorageAdapter.Create(item, params...)
+}
+
+func (b legacyBridge) GetContext(_ context.Context, dest any, filter map[string]any, params ...map[string]any) error {
+	return b.Get(dest, filter, params...)
+}
+
+func (b legacyBridge) UpdateContext(_ context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return b.StorageAdapter.Update(item, filter, params...)
+}
+
+func (b legacyBridge) DeleteContext(_ context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return b.StorageAdapter.Delete(item, filter, params...)
+}
+
+func (b legacyBridge) ListContext(_ context.Context, dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	return b.List(dest, sortKey, filter, limit, cursor, params...)
+}
+
+func (b legacyBridge) SearchContext(_ context.Context, dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return b.Search(dest, sortKey, query, limit, cursor, params...)
+}
+
+func (b legacyBridge) CountContext(_ context.Context, dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	return b.Count(dest, filter, params...)
+}
+
+func (b legacyBridge) QueryContext(_ context.Context, dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return b.Query(dest, statement, limit, cursor, params...)
+}
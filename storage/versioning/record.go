@@ -0,0 +1,38 @@
+package versioning
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// entityVersion is the stored shape of a Version. The type name maps to the
+// entity_versions table or container on every adapter, in the configured
+// schema on SQL. Id is
+// "<entity_key>#<zero-padded version>": unique per document as CosmosDB
+// requires, and the sort key for listing versions.
+type entityVersion struct {
+	Id           string `json:"id" gorm:"primaryKey;column:id"`
+	EntityKey    string `json:"entity_key" gorm:"column:entity_key"`
+	Entity       string `json:"entity" gorm:"column:entity"`
+	EntityId     string `json:"entity_id" gorm:"column:entity_id"`
+	Version      int    `json:"version" gorm:"column:version"`
+	CreatedAt    int64  `json:"created_at" gorm:"column:created_at"`
+	Deleted      bool   `json:"deleted" gorm:"column:deleted"`
+	RestoredFrom int    `json:"restored_from" gorm:"column:restored_from"`
+	Data         string `json:"data" gorm:"column:data"`
+}
+
+func (row entityVersion) toVersion() Version {
+	v := Version{
+		Number:       row.Version,
+		Entity:       row.Entity,
+		EntityId:     row.EntityId,
+		At:           time.UnixMilli(row.CreatedAt).UTC(),
+		Deleted:      row.Deleted,
+		RestoredFrom: row.RestoredFrom,
+	}
+	if row.Data != "" {
+		v.Data = json.RawMessage(row.Data)
+	}
+	return v
+}
@@ -0,0 +1,373 @@
+// Package versioning keeps every state an entity has been in. The Adapter
+// decorator writes an immutable version record after each Create, Update and
+// Delete, and can list those versions, read an entity as it was at a version
+// or a point in time, and restore an earlier version.
+//
+//	adapter := versioning.New(storage.StorageAdapterFactory{}.GetInstance(storage.SQL, cfg), versioning.Options{})
+//
+//	var doc Document
+//	err := adapter.GetAt(ctx, &doc, "doc-1", lastTuesday)
+//	err = adapter.Restore(ctx, &doc, "doc-1", 7)
+//
+// Version records live next to the entities, in the entity_versions table
+// (SQL), table (DynamoDB) or container (CosmosDB) of the wrapped adapter; see
+// Options for the layout each one needs.
+package versioning
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/tink3rlabs/magic/storage"
+)
+
+// Version is one recorded state of an entity. Versions are numbered from 1
+// per entity and never change once written.
+type Version struct {
+	Number   int       `json:"version"`
+	Entity   string    `json:"entity"`
+	EntityId string    `json:"entity_id"`
+	At       time.Time `json:"at"`
+	// Deleted marks the version written by a Delete. It carries no data.
+	Deleted bool `json:"deleted,omitempty"`
+	// RestoredFrom is the version a Restore copied, or 0.
+	RestoredFrom int `json:"restored_from,omitempty"`
+	// Data is the entity as JSON.
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Decode unmarshals the version's data into dest. It returns
+// storage.ErrNotFound for a deletion marker.
+func (v Version) Decode(dest any) error {
+	if v.Deleted {
+		return storage.ErrNotFound
+	}
+	if err := json.Unmarshal(v.Data, dest); err != nil {
+		return fmt.Errorf("failed to decode version %d of %s %s: %w", v.Number, v.Entity, v.EntityId, err)
+	}
+	return nil
+}
+
+// Options configures an Adapter.
+//
+// The version store needs one table or container per adapter. On SQL it
+// lives in the adapter's configured schema, like the entities:
+//
+//	-- SQL
+//	CREATE TABLE entity_versions (
+//	    id TEXT PRIMARY KEY, entity_key TEXT, entity TEXT, entity_id TEXT,
+//	    version INTEGER, created_at BIGINT, deleted BOOLEAN,
+//	    restored_from INTEGER, data TEXT
+//	);
+//	CREATE INDEX entity_versions_key ON entity_versions (entity_key, id);
+//
+// On DynamoDB, create entity_versions with entity_key as the partition key
+// and id (string) as the sort key; ids embed the zero-padded version number,
+// so an entity's versions are one partition read in version order. On
+// CosmosDB, create the entity_versions container with /entity_key as the
+// partition key; the adapter passes pk_field/pk_value so version queries stay
+// within one partition.
+type Options struct {
+	// IDField is the JSON name of the entity identifier, read from the filter
+	// (Update, Delete) or the item (Create). Defaults to "id".
+	IDField string
+	// Now overrides the clock, for tests.
+	Now func() time.Time
+}
+
+// Adapter is a storage.ContextualStorageAdapter decorator that versions
+// every mutation. Reads of the current state pass through untouched.
+//
+// The version record is written after the mutation succeeds, and the two are
+// not atomic: if the version write fails the mutation stands and the error is
+// returned. A version's number is one past the newest stored, and it is
+// written only if no other writer took that number first, retrying with the
+// next one otherwise, on adapters that implement storage.ConditionalCreator.
+// Elsewhere a Create that fails on a taken number is retried the same way.
+type Adapter struct {
+	storage.ContextualStorageAdapter
+	inner storage.StorageAdapter
+	opts  Options
+}
+
+// New wraps inner. Adapters that do not implement
+// storage.ContextualStorageAdapter are bridged with context.Background.
+func New(inner storage.StorageAdapter, opts Options) *Adapter {
+	if opts.IDField == "" {
+		opts.IDField = "id"
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	ctxInner, ok := inner.(storage.ContextualStorageAdapter)
+	if !ok {
+		ctxInner = legacyBridge{inner}
+	}
+	return &Adapter{ContextualStorageAdapter: ctxInner, inner: inner, opts: opts}
+}
+
+// UnwrapStorageAdapter returns the wrapped adapter.
+func (a *Adapter) UnwrapStorageAdapter() storage.StorageAdapter {
+	return a.inner
+}
+
+func (a *Adapter) Create(item any, params ...map[string]any) error {
+	return a.CreateContext(context.Background(), item, params...)
+}
+
+func (a *Adapter) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	if err := a.ContextualStorageAdapter.CreateContext(ctx, item, params...); err != nil {
+		return err
+	}
+	return a.record(ctx, item, a.entityID(nil, item), item, 0)
+}
+
+func (a *Adapter) Update(item any, filter map[string]any, params ...map[string]any) error {
+	return a.UpdateContext(context.Background(), item, filter, params...)
+}
+
+// UpdateContext versions item as written, so item should carry the full
+// entity rather than only the changed fields.
+func (a *Adapter) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	if err := a.ContextualStorageAdapter.UpdateContext(ctx, item, filter, params...); err != nil {
+		return err
+	}
+	return a.record(ctx, item, a.entityID(filter, item), item, 0)
+}
+
+func (a *Adapter) Delete(item any, filter map[string]any, params ...map[string]any) error {
+	return a.DeleteContext(context.Background(), item, filter, params...)
+}
+
+func (a *Adapter) DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	if err := a.ContextualStorageAdapter.DeleteContext(ctx, item, filter, params...); err != nil {
+		return err
+	}
+	return a.record(ctx, item, a.entityID(filter, item), nil, 0)
+}
+
+// Versions lists the versions of one entity, oldest first, paginated with the
+// same cursor conventions as List. model identifies the entity type the same
+// way it does for Get.
+func (a *Adapter) Versions(ctx context.Context, model any, entityID string, limit int, cursor string) ([]Version, string, error) {
+	key := entityKey(entityName(model), entityID)
+	var rows []entityVersion
+	next, err := a.ListContext(ctx, &rows, "id", map[string]any{"entity_key": key}, limit, cursor, partition(key))
+	if err != nil {
+		return nil, "", err
+	}
+	versions := make([]Version, len(rows))
+	for i, row := range rows {
+		versions[i] = row.toVersion()
+	}
+	return versions, next, nil
+}
+
+// GetVersion reads version number of an entity into dest. It returns
+// storage.ErrNotFound when the version does not exist or records a delete.
+func (a *Adapter) GetVersion(ctx context.Context, dest any, entityID string, number int) error {
+	v, err := a.version(ctx, dest, entityID, number)
+	if err != nil {
+		return err
+	}
+	return v.Decode(dest)
+}
+
+// GetAt reads an entity into dest as it was at time at: the latest version
+// written at or before it. It returns storage.ErrNotFound when the entity did
+// not exist at that time.
+func (a *Adapter) GetAt(ctx context.Context, dest any, entityID string, at time.Time) error {
+	var found *Version
+	cursor := ""
+	for {
+		page, next, err := a.Versions(ctx, dest, entityID, scanPageSize, cursor)
+		if err != nil {
+			return err
+		}
+		for i := range page {
+			if page[i].At.After(at) {
+				next = ""
+				break
+			}
+			found = &page[i]
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if found == nil {
+		return storage.ErrNotFound
+	}
+	return found.Decode(dest)
+}
+
+// Restore makes version number the current state of an entity again,
+// recreating it if it has since been deleted, and records the result as a new
+// version. On return dest holds the restored entity.
+func (a *Adapter) Restore(ctx context.Context, dest any, entityID string, number int) error {
+	if err := a.GetVersion(ctx, dest, entityID, number); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("cannot restore version %d of %s %s: %w", number, entityName(dest), entityID, err)
+		}
+		return err
+	}
+	// Update fails with ErrNotFound on every adapter once the entity is
+	// gone, so a deleted entity is created again instead.
+	filter := map[string]any{a.opts.IDField: entityID}
+	current := reflect.New(structType(dest)).Interface()
+	err := a.GetContext(ctx, current, filter)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		err = a.ContextualStorageAdapter.CreateContext(ctx, dest)
+	case err == nil:
+		err = a.ContextualStorageAdapter.UpdateContext(ctx, dest, filter)
+	}
+	if err != nil {
+		return err
+	}
+	return a.record(ctx, dest, entityID, dest, number)
+}
+
+// scanPageSize is the page size GetAt walks the version list with.
+const scanPageSize = 100
+
+func (a *Adapter) version(ctx context.Context, model any, entityID string, number int) (Version, error) {
+	key := entityKey(entityName(model), entityID)
+	var row entityVersion
+	if err := a.GetContext(ctx, &row, map[string]any{"entity_key": key, "id": versionID(key, number)}, partition(key)); err != nil {
+		return Version{}, err
+	}
+	return row.toVersion(), nil
+}
+
+// record writes the next version of an entity. state is nil for a delete.
+func (a *Adapter) record(ctx context.Context, model any, entityID string, state any, restoredFrom int) error {
+	if entityID == "" {
+		return fmt.Errorf("versioning: %s has no %s to version by", entityName(model), a.opts.IDField)
+	}
+	entity := entityName(model)
+	key := entityKey(entity, entityID)
+	row := &entityVersion{
+		EntityKey:    key,
+		Entity:       entity,
+		EntityId:     entityID,
+		CreatedAt:    a.opts.Now().UnixMilli(),
+		Deleted:      state == nil,
+		RestoredFrom: restoredFrom,
+	}
+	if state != nil {
+		data, err := json.Marshal(state)
+		if err != nil {
+			return fmt.Errorf("versioning: failed to encode %s %s: %w", entity, entityID, err)
+		}
+		row.Data = string(data)
+	}
+	for attempt := 1; ; attempt++ {
+		latest, err := a.latest(ctx, key)
+		if err != nil {
+			return fmt.Errorf("versioning: failed to read latest version of %s %s: %w", entity, entityID, err)
+		}
+		row.Version = latest + 1
+		row.Id = versionID(key, row.Version)
+		err = a.create(ctx, row)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, storage.ErrAlreadyExists) || attempt == maxVersionAttempts {
+			return fmt.Errorf("versioning: failed to write version %d of %s %s: %w", row.Version, entity, entityID, err)
+		}
+	}
+}
+
+// maxVersionAttempts bounds how often record retries a version number that
+// another writer took first.
+const maxVersionAttempts = 10
+
+// latest returns the newest version number of an entity, or 0.
+func (a *Adapter) latest(ctx context.Context, key string) (int, error) {
+	params := partition(key)
+	params[storage.SortDirectionKey] = string(storage.Descending)
+	var rows []entityVersion
+	if _, err := a.ListContext(ctx, &rows, "id", map[string]any{"entity_key": key}, 1, "", params); err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return rows[0].Version, nil
+}
+
+// create writes a version row unless its number is taken, and then fails
+// with storage.ErrAlreadyExists. Adapters that are not
+// storage.ConditionalCreator get a Create, and a version found under the
+// number after it fails counts as taken.
+func (a *Adapter) create(ctx context.Context, row *entityVersion) error {
+	if creator, ok := storage.AdapterAs[storage.ConditionalCreator](a.inner); ok {
+		return creator.CreateIfAbsentContext(ctx, row, partition(row.EntityKey))
+	}
+	err := a.ContextualStorageAdapter.CreateContext(ctx, row, partition(row.EntityKey))
+	if err == nil || errors.Is(err, storage.ErrAlreadyExists) {
+		return err
+	}
+	var existing entityVersion
+	if a.GetContext(ctx, &existing, map[string]any{"entity_key": row.EntityKey, "id": row.Id}, partition(row.EntityKey)) == nil {
+		return fmt.Errorf("%w: %v", storage.ErrAlreadyExists, err)
+	}
+	return err
+}
+
+// entityID prefers the filter and falls back to the item's ID field.
+func (a *Adapter) entityID(filter map[string]any, item any) string {
+	if v, ok := filter[a.opts.IDField]; ok && v != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return ""
+	}
+	var m map[string]any
+	if json.Unmarshal(data, &m) != nil {
+		return ""
+	}
+	if v, ok := m[a.opts.IDField]; ok && v != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return ""
+}
+
+func structType(model any) reflect.Type {
+	t := reflect.TypeOf(model)
+	for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	return t
+}
+
+// entityName is the model's struct type name, e.g. "Document".
+func entityName(model any) string {
+	if t := structType(model); t != nil {
+		return t.Name()
+	}
+	return ""
+}
+
+func entityKey(entity, entityID string) string {
+	return entity + "#" + entityID
+}
+
+// versionID is zero-padded so that ordering by id is ordering by version, on
+// every adapter and with string cursors.
+func versionID(key string, number int) string {
+	return fmt.Sprintf("%s#%010d", key, number)
+}
+
+// partition routes CosmosDB operations to the entity's partition. The other
+// adapters ignore these params.
+func partition(key string) map[string]any {
+	return map[string]any{"pk_field": "entity_key", "pk_value": key}
+}
@@ -0,0 +1,318 @@
+package versioning_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+
+	"github.com/tink3rlabs/magic/storage"
+	"github.com/tink3rlabs/magic/storage/versioning"
+)
+
+type document struct {
+	Id    string `json:"id" gorm:"primaryKey;column:id"`
+	Title string `json:"title" gorm:"column:title"`
+	Body  string `json:"body" gorm:"column:body"`
+}
+
+func (document) TableName() string { return "versioned_documents" }
+
+// clock advances one minute per reading, so every version gets a distinct
+// timestamp and tests can name the time between two versions.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time {
+	c.t = c.t.Add(time.Minute)
+	return c.t
+}
+
+func setup(t *testing.T) (*versioning.Adapter, *clock) {
+	t.Helper()
+	m := storage.GetMemoryAdapterInstance()
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS versioned_documents (id TEXT PRIMARY KEY, title TEXT, body TEXT)`,
+		`CREATE TABLE IF NOT EXISTS entity_versions (id TEXT PRIMARY KEY, entity_key TEXT, entity TEXT, entity_id TEXT, version INTEGER, created_at BIGINT, deleted BOOLEAN, restored_from INTEGER, data TEXT)`,
+		`DELETE FROM versioned_documents`,
+		`DELETE FROM entity_versions`,
+	} {
+		if err := m.Execute(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	c := &clock{t: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)}
+	return versioning.New(m, versioning.Options{Now: c.now}), c
+}
+
+// edit creates a document and updates its title n times, returning the time
+// each version was written.
+func edit(t *testing.T, a *versioning.Adapter, c *clock, id string, titles ...string) []time.Time {
+	t.Helper()
+	ctx := context.Background()
+	var at []time.Time
+	doc := &document{Id: id, Title: titles[0]}
+	if err := a.CreateContext(ctx, doc); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	at = append(at, c.t)
+	for _, title := range titles[1:] {
+		doc.Title = title
+		if err := a.UpdateContext(ctx, doc, map[string]any{"id": id}); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		at = append(at, c.t)
+	}
+	return at
+}
+
+func TestEveryMutationWritesAVersion(t *testing.T) {
+	a, c := setup(t)
+	ctx := context.Background()
+	edit(t, a, c, "d1", "draft", "review", "final")
+	if err := a.DeleteContext(ctx, &document{}, map[string]any{"id": "d1"}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	versions, next, err := a.Versions(ctx, &document{}, "d1", 10, "")
+	if err != nil {
+		t.Fatalf("Versions: %v", err)
+	}
+	if next != "" || len(versions) != 4 {
+		t.Fatalf("got %d versions (next %q), want 4", len(versions), next)
+	}
+	for i, v := range versions {
+		if v.Number != i+1 || v.Entity != "document" || v.EntityId != "d1" {
+			t.Errorf("version %d = %+v", i, v)
+		}
+	}
+	var doc document
+	if err := versions[1].Decode(&doc); err != nil || doc.Title != "review" {
+		t.Errorf("version 2 = %+v, %v", doc, err)
+	}
+	if !versions[3].Deleted || versions[3].Decode(&doc) != storage.ErrNotFound {
+		t.Errorf("version 4 should be a deletion marker, got %+v", versions[3])
+	}
+}
+
+func TestVersionsPaginate(t *testing.T) {
+	a, c := setup(t)
+	edit(t, a, c, "d1", "a", "b", "c", "d", "e")
+	edit(t, a, c, "d2", "other")
+
+	var numbers []int
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		versions, next, err := a.Versions(context.Background(), &document{}, "d1", 2, cursor)
+		if err != nil {
+			t.Fatalf("Versions: %v", err)
+		}
+		for _, v := range versions {
+			numbers = append(numbers, v.Number)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	want := []int{1, 2, 3, 4, 5}
+	if len(numbers) != len(want) {
+		t.Fatalf("versions across pages = %v, want %v", numbers, want)
+	}
+	for i := range want {
+		if numbers[i] != want[i] {
+			t.Fatalf("versions across pages = %v, want %v", numbers, want)
+		}
+	}
+}
+
+func TestGetVersion(t *testing.T) {
+	a, c := setup(t)
+	edit(t, a, c, "d1", "draft", "final")
+
+	var doc document
+	if err := a.GetVersion(context.Background(), &doc, "d1", 1); err != nil || doc.Title != "draft" {
+		t.Errorf("GetVersion(1) = %+v, %v", doc, err)
+	}
+	if err := a.GetVersion(context.Background(), &doc, "d1", 9); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetVersion(9) = %v, want ErrNotFound", err)
+	}
+}
+
+func TestGetAt(t *testing.T) {
+	a, c := setup(t)
+	ctx := context.Background()
+	at := edit(t, a, c, "d1", "draft", "review", "final")
+
+	cases := []struct {
+		name string
+		at   time.Time
+		want string
+	}{
+		{"exactly at version 1", at[0], "draft"},
+		{"between versions 2 and 3", at[1].Add(30 * time.Second), "review"},
+		{"after the last version", at[2].Add(time.Hour), "final"},
+	}
+	for _, tc := range cases {
+		var doc document
+		if err := a.GetAt(ctx, &doc, "d1", tc.at); err != nil || doc.Title != tc.want {
+			t.Errorf("%s: got %+v, %v; want %q", tc.name, doc, err, tc.want)
+		}
+	}
+
+	var doc document
+	if err := a.GetAt(ctx, &doc, "d1", at[0].Add(-time.Second)); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("before creation = %v, want ErrNotFound", err)
+	}
+
+	if err := a.DeleteContext(ctx, &document{}, map[string]any{"id": "d1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.GetAt(ctx, &doc, "d1", c.t.Add(time.Second)); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("after deletion = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	a, c := setup(t)
+	ctx := context.Background()
+	edit(t, a, c, "d1", "draft", "final")
+
+	var doc document
+	if err := a.Restore(ctx, &doc, "d1", 1); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	var current document
+	if err := a.GetContext(ctx, &current, map[string]any{"id": "d1"}); err != nil || current.Title != "draft" {
+		t.Errorf("current = %+v, %v", current, err)
+	}
+	versions, _, err := a.Versions(ctx, &document{}, "d1", 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := versions[len(versions)-1]
+	if last.Number != 3 || last.RestoredFrom != 1 {
+		t.Errorf("restore version = %+v", last)
+	}
+
+	// A deleted entity can be brought back.
+	if err := a.DeleteContext(ctx, &document{}, map[string]any{"id": "d1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Restore(ctx, &doc, "d1", 2); err != nil {
+		t.Fatalf("Restore after delete: %v", err)
+	}
+	if err := a.GetContext(ctx, &current, map[string]any{"id": "d1"}); err != nil || current.Title != "final" {
+		t.Errorf("current after restore = %+v, %v", current, err)
+	}
+
+	if err := a.Restore(ctx, &doc, "d1", 4); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("restoring a deletion marker = %v, want ErrNotFound", err)
+	}
+}
+
+// countless reports no rows from Count, like the DynamoDB and Cosmos DB
+// adapters, so that version numbers cannot come from counting.
+type countless struct{ *storage.BoltAdapter }
+
+func (countless) Count(any, map[string]any, ...map[string]any) (int64, error) { return 0, nil }
+
+func (countless) CountContext(context.Context, any, map[string]any, ...map[string]any) (int64, error) {
+	return 0, nil
+}
+
+func TestConcurrentUpdatesOnBoltKeepEveryVersion(t *testing.T) {
+	b, err := storage.NewBoltAdapter(map[string]string{"path": filepath.Join(t.TempDir(), "versions.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	for _, stmt := range []string{"CREATE TABLE documents", "CREATE TABLE entity_versions; CREATE INDEX ON entity_versions (entity_key)"} {
+		if err := b.Execute(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	a := versioning.New(countless{b}, versioning.Options{})
+	ctx := context.Background()
+	if err := a.CreateContext(ctx, &document{Id: "d1", Title: "draft"}); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- a.UpdateContext(ctx, &document{Id: "d1", Title: fmt.Sprintf("edit %d", i)}, map[string]any{"id": "d1"})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+
+	versions, _, err := a.Versions(ctx, &document{}, "d1", 100, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := map[string]bool{}
+	for i, v := range versions {
+		if v.Number != i+1 {
+			t.Fatalf("version %d is numbered %d", i+1, v.Number)
+		}
+		var doc document
+		if err := v.Decode(&doc); err != nil {
+			t.Fatal(err)
+		}
+		titles[doc.Title] = true
+	}
+	if len(versions) != writers+1 || len(titles) != writers+1 {
+		t.Fatalf("got %d versions with %d distinct titles; want %d of each", len(versions), len(titles), writers+1)
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	a, _ := setup(t)
+	if storage.UnwrapAdapter(a) != storage.StorageAdapter(storage.GetMemoryAdapterInstance()) {
+		t.Error("UnwrapAdapter should reach the memory adapter")
+	}
+}
+
+func TestVersionsAreStoredInTheAdaptersSchema(t *testing.T) {
+	// A dry run on PostgreSQL, whose tables the SQL adapter prefixes with the
+	// configured schema.
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		NamingStrategy:         schema.NamingStrategy{TablePrefix: "app."},
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inserts []string
+	record := func(tx *gorm.DB) { inserts = append(inserts, tx.Statement.SQL.String()) }
+	if err := db.Callback().Create().After("gorm:create").Register("test:record", record); err != nil {
+		t.Fatal(err)
+	}
+
+	a := versioning.New(&storage.SQLAdapter{DB: db}, versioning.Options{})
+	// Nothing is written in a dry run, so every insert-if-absent of the
+	// version reports a conflict; only the statements matter here.
+	_ = a.CreateContext(context.Background(), &document{Id: "d1", Title: "draft"})
+	if len(inserts) < 2 || !strings.HasPrefix(inserts[1], `INSERT INTO "app"."entity_versions"`) {
+		t.Fatalf("inserts = %q; want the version in app.entity_versions", inserts)
+	}
+}